@@ -0,0 +1,262 @@
+package rubyext
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BuildFromArchive builds the extensions of a packaged .gem file using
+// the standard builders. See BuilderFactory.BuildFromArchive.
+func BuildFromArchive(ctx context.Context, config *BuildConfig, archivePath string) ([]*BuildResult, error) {
+	return NewBuilderFactory().BuildFromArchive(ctx, config, archivePath)
+}
+
+// BuildFromArchive builds the extensions of a packaged .gem file.
+//
+// A .gem is a tar archive containing metadata.gz (the gemspec as YAML) and
+// data.tar.gz (the gem's files). This method:
+//  1. Extracts data.tar.gz into a temporary directory
+//  2. Reads the gem name and extensions declared in metadata.gz, falling back to DetectExtensions
+//  3. Builds them with BuildAllExtensions, using the temporary directory as GemDir
+//  4. Removes the temporary directory unless config.KeepWorkDir is set
+//
+// Since the gem's own directory is thrown away, config.DestPath or
+// config.LibDir must be set so the artifacts are installed somewhere that
+// outlives the build. Relative paths are resolved against the current
+// working directory. config.GemDir is ignored, and config.GemName is set
+// from the metadata unless already set.
+//
+// When config.KeepWorkDir is set, each result's output starts with the
+// location of the extracted gem.
+func (f *BuilderFactory) BuildFromArchive(ctx context.Context, config *BuildConfig, archivePath string) ([]*BuildResult, error) {
+	if config.DestPath == "" && config.LibDir == "" {
+		return nil, fmt.Errorf("building from archive %s requires DestPath or LibDir", archivePath)
+	}
+
+	archiveConfig := *config
+	for _, dir := range []*string{&archiveConfig.DestPath, &archiveConfig.LibDir, &archiveConfig.BuildDir} {
+		if *dir == "" {
+			continue
+		}
+		absDir, err := filepath.Abs(*dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", *dir, err)
+		}
+		*dir = absDir
+	}
+
+	workDir, err := newTempDir(config, "rubyext-gem-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create work directory: %w", err)
+	}
+	if !config.KeepWorkDir {
+		defer os.RemoveAll(workDir)
+	}
+
+	metadata, err := extractGem(archivePath, workDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if archiveConfig.GemName == "" {
+		archiveConfig.GemName = metadata.name
+	}
+
+	extensions := metadata.extensions
+	if len(extensions) == 0 {
+		extensions, err = DetectExtensions(workDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to detect extensions in %s: %w", archivePath, err)
+		}
+	}
+
+	archiveConfig.GemDir = workDir
+	results, err := f.BuildAllExtensions(ctx, &archiveConfig, extensions)
+
+	if config.KeepWorkDir {
+		for _, result := range results {
+			result.Output = append([]string{fmt.Sprintf("Gem extracted to: %s", workDir)}, result.Output...)
+		}
+	}
+
+	return results, err
+}
+
+// gemMetadata is the part of a gem's specification used for building
+type gemMetadata struct {
+	name       string
+	extensions []string
+}
+
+// extractGem unpacks the data.tar.gz of a .gem archive into destDir and
+// returns its metadata.
+func extractGem(archivePath, destDir string) (gemMetadata, error) {
+	var metadata gemMetadata
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return metadata, fmt.Errorf("failed to open gem archive: %w", err)
+	}
+	defer file.Close()
+
+	var foundData bool
+
+	reader := tar.NewReader(file)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return metadata, fmt.Errorf("failed to read gem archive %s: %w", archivePath, err)
+		}
+
+		switch header.Name {
+		case "data.tar.gz":
+			gz, err := gzip.NewReader(reader)
+			if err != nil {
+				return metadata, fmt.Errorf("failed to decompress data.tar.gz: %w", err)
+			}
+			if err := extractTar(tar.NewReader(gz), destDir); err != nil {
+				return metadata, err
+			}
+			foundData = true
+		case "metadata.gz":
+			gz, err := gzip.NewReader(reader)
+			if err != nil {
+				return metadata, fmt.Errorf("failed to decompress metadata.gz: %w", err)
+			}
+			content, err := io.ReadAll(gz)
+			if err != nil {
+				return metadata, fmt.Errorf("failed to read metadata.gz: %w", err)
+			}
+			metadata = parseGemMetadata(content)
+		}
+	}
+
+	if !foundData {
+		return metadata, fmt.Errorf("%s is not a gem archive: data.tar.gz not found", archivePath)
+	}
+
+	return metadata, nil
+}
+
+// extractTar writes the contents of a tar stream into destDir.
+//
+// Entries are written through an os.Root opened on destDir, so no entry
+// can land outside it, even by way of symlinks extracted earlier (e.g.
+// "d -> .", "d/e -> ..", then "e/file"). Entries whose names escape
+// destDir fail the extraction, and symlinks pointing outside it are
+// skipped.
+func extractTar(reader *tar.Reader, destDir string) error {
+	root, err := os.OpenRoot(destDir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read data.tar.gz: %w", err)
+		}
+
+		name := filepath.Clean(filepath.FromSlash(header.Name))
+		if !isWithinDir(destDir, filepath.Join(destDir, name)) {
+			return fmt.Errorf("archive entry %s escapes the extraction directory", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = root.MkdirAll(name, 0o755)
+		case tar.TypeReg:
+			err = writeArchiveFile(root, reader, name, os.FileMode(header.Mode).Perm())
+		case tar.TypeSymlink:
+			linkTarget := filepath.Join(destDir, filepath.Dir(name), filepath.FromSlash(header.Linkname))
+			if filepath.IsAbs(header.Linkname) || !isWithinDir(destDir, linkTarget) {
+				continue // Skip links pointing outside the gem
+			}
+			if err = root.MkdirAll(filepath.Dir(name), 0o755); err == nil {
+				err = root.Symlink(header.Linkname, name)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to extract archive entry %s: %w", header.Name, err)
+		}
+	}
+}
+
+// writeArchiveFile writes a single file from an archive below root
+func writeArchiveFile(root *os.Root, reader io.Reader, name string, mode os.FileMode) error {
+	if err := root.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	out, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode|0o600)
+	if err != nil {
+		return err
+	}
+
+	//nolint:gosec // Size isn't limited: gems are chosen by the caller. Paths are confined by root
+	if _, err := io.Copy(out, reader); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// isWithinDir reports whether path is dir or a path below it
+func isWithinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// parseGemMetadata reads the name and extensions list from a gem's YAML
+// metadata without a full YAML parser. Gem metadata is generated by
+// RubyGems, so the name is a top-level scalar and the list is always in
+// block form:
+//
+//	name: mygem
+//	extensions:
+//	- ext/myext/extconf.rb
+func parseGemMetadata(content []byte) gemMetadata {
+	var metadata gemMetadata
+	inExtensions := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if inExtensions {
+			item, isItem := strings.CutPrefix(strings.TrimSpace(line), "- ")
+			if isItem {
+				metadata.extensions = append(metadata.extensions, strings.Trim(strings.TrimSpace(item), `"'`))
+				continue
+			}
+			inExtensions = false
+		}
+
+		if name, isName := strings.CutPrefix(line, "name:"); isName && metadata.name == "" {
+			metadata.name = strings.Trim(strings.TrimSpace(name), `"'`)
+		}
+		inExtensions = strings.TrimSpace(line) == "extensions:"
+	}
+
+	return metadata
+}
@@ -0,0 +1,244 @@
+package rubyext
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+type archiveEntry struct {
+	name string
+	body string
+	link string // Symlink target; the entry is a symlink when set
+}
+
+func tarBytes(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0o644, Size: int64(len(entry.body)), Typeflag: tar.TypeReg}
+		if entry.link != "" {
+			header = &tar.Header{Name: entry.name, Mode: 0o777, Linkname: entry.link, Typeflag: tar.TypeSymlink}
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := writer.Write([]byte(entry.body)); err != nil {
+			t.Fatalf("failed to write tar body: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatalf("failed to gzip data: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close gzip writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func writeTestGem(t *testing.T, metadata string, files []archiveEntry) string {
+	t.Helper()
+
+	data := gzipBytes(t, tarBytes(t, files))
+	gem := tarBytes(t, []archiveEntry{
+		{name: "metadata.gz", body: string(gzipBytes(t, []byte(metadata)))},
+		{name: "data.tar.gz", body: string(data)},
+	})
+
+	gemPath := filepath.Join(t.TempDir(), "mygem-1.0.0.gem")
+	if err := os.WriteFile(gemPath, gem, 0o600); err != nil {
+		t.Fatalf("failed to write gem: %v", err)
+	}
+	return gemPath
+}
+
+func TestBuildFromArchive(t *testing.T) {
+	metadata := "--- !ruby/object:Gem::Specification\nname: mygem\nextensions:\n- ext/myext/extconf.rb\nfiles:\n- lib/mygem.rb\n"
+	gemPath := writeTestGem(t, metadata, []archiveEntry{
+		{name: "lib/mygem.rb", body: "require 'myext'\n"},
+		{name: "ext/myext/extconf.rb", body: "create_makefile 'myext'\n"},
+	})
+
+	factory := &BuilderFactory{}
+	factory.Register(&mockBuilder{
+		name:       "mock",
+		canBuildFn: func(ext string) bool { return ext == "extconf.rb" },
+		buildFn: func(ctx context.Context, config *BuildConfig, extensionFile string) (*BuildResult, error) {
+			return runCommonBuild(ctx, config, extensionFile, CommonBuildSteps{
+				ConfigureFunc: func(context.Context, *BuildConfig, string, *BuildResult) error { return nil },
+				BuildFunc: func(_ context.Context, _ *BuildConfig, dir string, _ *BuildResult) error {
+					return os.WriteFile(filepath.Join(dir, "myext.so"), []byte("binary"), 0o600)
+				},
+				FindFunc: func(string) ([]string, error) { return []string{"myext.so"}, nil },
+			})
+		},
+	})
+
+	destDir := t.TempDir()
+	config := &BuildConfig{DestPath: destDir, KeepWorkDir: true}
+
+	results, err := factory.BuildFromArchive(context.Background(), config, gemPath)
+	if err != nil {
+		t.Fatalf("BuildFromArchive returned error: %v", err)
+	}
+
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("expected one successful result, got %+v", results)
+	}
+
+	installed := filepath.Join(destDir, "myext.so")
+	if _, err := os.Stat(installed); err != nil {
+		t.Fatalf("expected extension installed to %s: %v", installed, err)
+	}
+	if !reflect.DeepEqual(results[0].Extensions, []string{filepath.ToSlash(installed)}) {
+		t.Fatalf("expected absolute installed path, got %v", results[0].Extensions)
+	}
+
+	workDir, found := strings.CutPrefix(results[0].Output[0], "Gem extracted to: ")
+	if !found {
+		t.Fatalf("expected work directory in output, got %q", results[0].Output[0])
+	}
+	defer os.RemoveAll(workDir)
+
+	if _, err := os.Stat(filepath.Join(workDir, "lib", "mygem.rb")); err != nil {
+		t.Fatalf("expected gem files extracted into kept work directory: %v", err)
+	}
+}
+
+func TestBuildFromArchiveRequiresDestination(t *testing.T) {
+	if _, err := BuildFromArchive(context.Background(), &BuildConfig{}, "mygem.gem"); err == nil {
+		t.Fatal("expected error without DestPath or LibDir")
+	}
+}
+
+func TestExtractGemRejectsPathTraversal(t *testing.T) {
+	gemPath := writeTestGem(t, "extensions: []\n", []archiveEntry{
+		{name: "../escape.rb", body: "puts 'owned'\n"},
+	})
+
+	if _, err := extractGem(gemPath, t.TempDir()); err == nil {
+		t.Fatal("expected error for entry escaping the extraction directory")
+	}
+}
+
+func TestExtractGemRejectsChainedSymlinkEscape(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("symlinks need extra privileges on Windows")
+	}
+
+	// Each entry stays within the extraction directory on its own, but
+	// together they point e at the directory's parent
+	gemPath := writeTestGem(t, "extensions: []\n", []archiveEntry{
+		{name: "d", link: "."},
+		{name: "d/e", link: ".."},
+		{name: "e/evil.txt", body: "owned\n"},
+	})
+
+	parent := t.TempDir()
+	destDir := filepath.Join(parent, "gem")
+	if err := os.Mkdir(destDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := extractGem(gemPath, destDir); err == nil {
+		t.Fatal("expected error for entry escaping through symlinks")
+	}
+	if _, err := os.Lstat(filepath.Join(parent, "evil.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing written outside the extraction directory, got %v", err)
+	}
+}
+
+func TestDetectExtensionsScansExtDirectory(t *testing.T) {
+	gemDir := t.TempDir()
+
+	for _, rel := range []string{
+		"ext/rusty/extconf.rb",
+		"ext/rusty/Cargo.toml",
+		"ext/plain/CMakeLists.txt",
+		"ext/plain/vendor/libfoo/configure",
+	} {
+		path := filepath.Join(gemDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(""), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	extensions, err := DetectExtensions(gemDir)
+	if err != nil {
+		t.Fatalf("DetectExtensions returned error: %v", err)
+	}
+
+	expected := []string{"ext/plain/CMakeLists.txt", "ext/rusty/extconf.rb"}
+	if !reflect.DeepEqual(extensions, expected) {
+		t.Fatalf("expected %v, got %v", expected, extensions)
+	}
+}
+
+func TestHasExtensions(t *testing.T) {
+	gemDir := t.TempDir()
+	for _, rel := range []string{
+		"lib/mygem.rb",
+		"ext/node_modules/addon/extconf.rb",
+		"ext/spec/fixtures/extconf.rb",
+		"ext/vendor/bundle/ruby/3.4.0/gems/json-2.7.0/ext/json/extconf.rb",
+	} {
+		path := filepath.Join(gemDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(""), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	if has, err := HasExtensions(gemDir); err != nil || has {
+		t.Fatalf("expected a pure-Ruby gem with vendored dependencies to need no compilation, got %v (%v)", has, err)
+	}
+
+	extconf := filepath.Join(gemDir, "ext", "mygem", "extconf.rb")
+	if err := os.MkdirAll(filepath.Dir(extconf), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(extconf, []byte(""), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if has, err := HasExtensions(gemDir); err != nil || !has {
+		t.Fatalf("expected ext/mygem/extconf.rb to need compilation, got %v (%v)", has, err)
+	}
+}
+
+func TestParseGemMetadata(t *testing.T) {
+	content := "--- !ruby/object:Gem::Specification\nname: mygem\nversion: !ruby/object:Gem::Version\n  version: 1.0.0\n" +
+		"extensions:\n- ext/a/extconf.rb\n- \"ext/b/Cargo.toml\"\nfiles:\n- lib/mygem.rb\n"
+
+	metadata := parseGemMetadata([]byte(content))
+	if metadata.name != "mygem" {
+		t.Errorf("name = %q, want mygem", metadata.name)
+	}
+	if want := []string{"ext/a/extconf.rb", "ext/b/Cargo.toml"}; !reflect.DeepEqual(metadata.extensions, want) {
+		t.Errorf("extensions = %v, want %v", metadata.extensions, want)
+	}
+}
@@ -0,0 +1,154 @@
+package rubyext
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// extensionBuildDir returns the directory an extension is built in.
+//
+// Without config.BuildDir this is the directory containing the extension
+// file inside the gem. With config.BuildDir set, it is the matching path
+// below BuildDir, e.g. BuildDir/ext/myext for ext/myext/extconf.rb.
+func extensionBuildDir(config *BuildConfig, extensionFile string) string {
+	extensionFile = filepath.FromSlash(normalizeExtensionPath(extensionFile))
+	sourceDir := filepath.Dir(filepath.Join(config.GemDir, extensionFile))
+	if config.BuildDir == "" {
+		return sourceDir
+	}
+
+	relDir := safeRelativePath(filepath.Dir(extensionFile))
+	if relDir == "" || relDir == "." {
+		relDir = filepath.Base(sourceDir)
+	}
+
+	return filepath.Join(config.BuildDir, relDir)
+}
+
+// validateExtensionPath checks that the extension file's directory is
+// within config.GemDir, so a path such as "../../etc/extconf.rb" can't make
+// a build run or copy files elsewhere. It returns an *ExtensionPathError
+// otherwise.
+func validateExtensionPath(config *BuildConfig, extensionFile string) error {
+	gemDir := filepath.Clean(config.GemDir)
+	sourceDir := filepath.Dir(filepath.Join(gemDir, filepath.FromSlash(normalizeExtensionPath(extensionFile))))
+
+	rel, err := filepath.Rel(gemDir, sourceDir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return &ExtensionPathError{ExtensionFile: extensionFile, GemDir: config.GemDir}
+	}
+
+	return nil
+}
+
+// makeDir returns the directory make runs in: config.MakeDir below the
+// extension directory, or the extension directory itself. A MakeDir that
+// is absolute or leads outside the extension directory is rejected with an
+// error matching ErrOutsideGemDir.
+func makeDir(config *BuildConfig, extensionDir string) (string, error) {
+	if config.MakeDir == "" {
+		return extensionDir, nil
+	}
+
+	rel := filepath.FromSlash(config.MakeDir)
+	dir := filepath.Join(extensionDir, rel)
+	if filepath.IsAbs(rel) || filepath.VolumeName(rel) != "" || strings.HasPrefix(rel, string(filepath.Separator)) ||
+		!isWithinDir(extensionDir, dir) {
+		return "", fmt.Errorf("%w: MakeDir %s escapes %s", ErrOutsideGemDir, config.MakeDir, extensionDir)
+	}
+
+	return dir, nil
+}
+
+// prepareBuildDir returns the directory to build an extension in, creating
+// a working copy of the extension directory when config.BuildDir is set.
+//
+// The working copy keeps generated files (Makefile, *.o, target/) out of
+// the gem's source tree, so read-only gem caches can be built. Files are
+// copied rather than hardlinked so build steps that modify files in place
+// can't alter the source. Existing build products in the working copy are
+// kept, allowing incremental rebuilds.
+//
+// Extension files outside config.GemDir are rejected; see
+// validateExtensionPath.
+//
+// # Limitations
+//
+// Only the extension's own directory is copied. Build systems that reach
+// outside it will not find those files in the working copy, for example:
+//   - extconf.rb scripts using relative paths like ../../vendor
+//   - Cargo workspace members with path dependencies on sibling crates
+//   - CMakeLists.txt files that add_subdirectory() a parent directory
+func prepareBuildDir(config *BuildConfig, extensionFile string) (string, error) {
+	if err := validateExtensionPath(config, extensionFile); err != nil {
+		return "", err
+	}
+
+	buildDir := extensionBuildDir(config, extensionFile)
+	if config.BuildDir == "" {
+		return buildDir, nil
+	}
+
+	sourceDir := filepath.Dir(filepath.Join(config.GemDir, filepath.FromSlash(normalizeExtensionPath(extensionFile))))
+	if err := copyTree(sourceDir, buildDir); err != nil {
+		return "", fmt.Errorf("failed to prepare build directory %s: %w", buildDir, err)
+	}
+
+	return buildDir, nil
+}
+
+// copyTree recursively copies the contents of srcDir into destDir,
+// preserving file modes and symlinks
+func copyTree(srcDir, destDir string) error {
+	return filepath.WalkDir(srcDir, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destDir, rel)
+
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case entry.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			_ = os.Remove(target)
+			return os.Symlink(link, target)
+		case entry.Type().IsRegular():
+			return copyFile(path, target)
+		default:
+			return nil // Skip sockets, devices and other special files
+		}
+	})
+}
+
+// newTempDir creates a temporary directory for intermediate files in
+// config.TempDir, or the system temp directory when that is empty.
+// TempDir is created if it doesn't exist. The caller removes the
+// directory when done.
+func newTempDir(config *BuildConfig, pattern string) (string, error) {
+	parent := config.TempDir
+	if parent == "" {
+		parent = os.TempDir()
+	}
+
+	parent, err := filepath.Abs(parent)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve temp directory %s: %w", config.TempDir, err)
+	}
+	if err = os.MkdirAll(parent, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create temp directory %s: %w", parent, err)
+	}
+
+	return os.MkdirTemp(parent, pattern)
+}
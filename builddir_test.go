@@ -0,0 +1,118 @@
+package rubyext
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunCommonBuildUsesBuildDir(t *testing.T) {
+	gemDir := t.TempDir()
+	buildDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "myext")
+
+	if err := os.MkdirAll(filepath.Join(extDir, "include"), 0o755); err != nil {
+		t.Fatalf("failed to create extension directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(extDir, "extconf.rb"), []byte("create_makefile 'myext'\n"), 0o600); err != nil {
+		t.Fatalf("failed to write extconf.rb: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(extDir, "include", "myext.h"), []byte("#pragma once\n"), 0o600); err != nil {
+		t.Fatalf("failed to write header: %v", err)
+	}
+
+	config := &BuildConfig{GemDir: gemDir, BuildDir: buildDir}
+	expectedDir := filepath.Join(buildDir, "ext", "myext")
+
+	result, err := runCommonBuild(context.Background(), config, "ext/myext/extconf.rb", CommonBuildSteps{
+		ConfigureFunc: func(_ context.Context, _ *BuildConfig, dir string, _ *BuildResult) error {
+			if dir != expectedDir {
+				t.Errorf("expected build in %s, got %s", expectedDir, dir)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "include", "myext.h")); err != nil {
+				t.Errorf("expected sources copied into build directory: %v", err)
+			}
+			return os.WriteFile(filepath.Join(dir, "Makefile"), []byte("all:\n"), 0o600)
+		},
+		BuildFunc: func(_ context.Context, _ *BuildConfig, dir string, _ *BuildResult) error {
+			return os.WriteFile(filepath.Join(dir, "myext.so"), []byte("binary"), 0o600)
+		},
+		FindFunc: func(string) ([]string, error) {
+			return []string{"myext.so"}, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("runCommonBuild returned error: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(extDir, "Makefile")); !os.IsNotExist(err) {
+		t.Fatalf("expected source tree to stay clean, Makefile stat returned %v", err)
+	}
+
+	expected := "lib/myext.so"
+	if len(result.Extensions) != 1 || result.Extensions[0] != expected {
+		t.Fatalf("expected installed extension %s, got %v", expected, result.Extensions)
+	}
+}
+
+func TestNewTempDirUsesConfiguredTempDir(t *testing.T) {
+	tempDir := filepath.Join(t.TempDir(), "scratch")
+
+	dir, err := newTempDir(&BuildConfig{TempDir: tempDir}, "rubyext-test-")
+	if err != nil {
+		t.Fatalf("newTempDir returned error: %v", err)
+	}
+
+	if filepath.Dir(dir) != tempDir {
+		t.Fatalf("expected directory created in %s, got %s", tempDir, dir)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		t.Fatalf("expected temp directory to exist: %v", err)
+	}
+
+	dir, err = newTempDir(&BuildConfig{}, "rubyext-test-")
+	if err != nil {
+		t.Fatalf("newTempDir returned error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if systemTemp, _ := filepath.Abs(os.TempDir()); filepath.Dir(dir) != systemTemp {
+		t.Fatalf("expected directory created in %s, got %s", systemTemp, dir)
+	}
+}
+
+func TestExtensionPathOutsideGemDirIsRejected(t *testing.T) {
+	gemDir := t.TempDir()
+	config := &BuildConfig{GemDir: gemDir}
+
+	for _, extensionFile := range []string{"ext/myext/extconf.rb", "extconf.rb", "ext/../ext/myext/extconf.rb"} {
+		if err := validateExtensionPath(config, extensionFile); err != nil {
+			t.Errorf("%s: unexpected error %v", extensionFile, err)
+		}
+	}
+
+	for _, extensionFile := range []string{"../extconf.rb", "../../etc/extconf.rb", `ext\..\..\extconf.rb`} {
+		if err := validateExtensionPath(config, extensionFile); !errors.Is(err, ErrOutsideGemDir) {
+			t.Errorf("%s: expected ErrOutsideGemDir, got %v", extensionFile, err)
+		}
+	}
+
+	builder := &mockBuilder{name: "Mock", canBuildFn: func(string) bool { return true }}
+	factory := &BuilderFactory{}
+	factory.Register(builder)
+
+	results, err := factory.BuildAllExtensions(context.Background(), config, []string{"../../etc/extconf.rb"})
+	var pathErr *ExtensionPathError
+	if !errors.As(err, &pathErr) || pathErr.ExtensionFile != "../../etc/extconf.rb" {
+		t.Fatalf("expected ExtensionPathError, got %v", err)
+	}
+	if builder.buildCalls != 0 || len(results) != 1 || results[0].Success {
+		t.Fatalf("expected a failed result without building, got %d calls, %+v", builder.buildCalls, results)
+	}
+
+	if _, err := (&MakefileBuilder{}).Build(context.Background(), config, "../Makefile"); !errors.Is(err, ErrOutsideGemDir) {
+		t.Fatalf("expected Build to reject the path, got %v", err)
+	}
+}
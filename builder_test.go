@@ -3,7 +3,15 @@ package rubyext
 import (
 	"context"
 	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 const (
@@ -16,7 +24,7 @@ func TestBuilderFactory(t *testing.T) {
 
 	// Test that all expected builders are registered
 	builders := factory.ListBuilders()
-	expectedCount := 11 // 5 original + 3 new specific + 3 generic language builders
+	expectedCount := 13 // 5 original + 4 new specific + 4 generic language builders
 	if len(builders) != expectedCount {
 		t.Errorf("Expected %d builders, got %d", expectedCount, len(builders))
 	}
@@ -34,6 +42,8 @@ func TestBuilderFactory(t *testing.T) {
 		{"ext/mkrf_conf.rb", "Rake"},
 		{"ext/CMakeLists.txt", "CMake"},
 		{"ext/Cargo.toml", "Cargo"},
+		{"ext/wscript", "Waf"},
+		{"ext/setup.py", "Python"},
 	}
 
 	for _, tc := range testCases {
@@ -310,6 +320,53 @@ func (m *mockBuilder) Clean(ctx context.Context, config *BuildConfig, extensionF
 	return m.cleanFn(ctx, config, extensionFile)
 }
 
+func TestBuildAllExtensionsExpectExtensions(t *testing.T) {
+	factory := NewBuilderFactory()
+
+	results, err := factory.BuildAllExtensions(context.Background(), &BuildConfig{}, nil)
+	if err != nil || results != nil {
+		t.Fatalf("expected no results and no error for a pure-Ruby gem, got %v, %v", results, err)
+	}
+
+	if _, err := factory.BuildAllExtensions(context.Background(), &BuildConfig{ExpectExtensions: true}, nil); err == nil {
+		t.Fatal("expected error when extensions were expected")
+	}
+}
+
+func TestBuildAllExtensionsBuildOptions(t *testing.T) {
+	buildArgs := map[string][]string{}
+	factory := &BuilderFactory{}
+	factory.Register(&mockBuilder{
+		name:       "Mock",
+		canBuildFn: func(ext string) bool { return strings.HasSuffix(ext, "extconf.rb") },
+		buildFn: func(_ context.Context, config *BuildConfig, ext string) (*BuildResult, error) {
+			buildArgs[ext] = config.BuildArgs
+			return &BuildResult{Success: true}, nil
+		},
+	})
+
+	config := &BuildConfig{GemDir: t.TempDir(), BuildArgs: []string{"--global"}}
+	extensions := []string{"ext/foo/extconf.rb -- --with-foo-dir=/opt/foo  --enable-bar", "ext/baz/extconf.rb"}
+	results, err := factory.BuildAllExtensions(context.Background(), config, extensions)
+	if err != nil {
+		t.Fatalf("BuildAllExtensions returned error: %v", err)
+	}
+
+	if results[0].ExtensionFile != "ext/foo/extconf.rb" {
+		t.Errorf("expected the file part as ExtensionFile, got %q", results[0].ExtensionFile)
+	}
+	expected := map[string][]string{
+		"ext/foo/extconf.rb": {"--global", "--with-foo-dir=/opt/foo", "--enable-bar"},
+		"ext/baz/extconf.rb": {"--global"},
+	}
+	if !reflect.DeepEqual(buildArgs, expected) {
+		t.Fatalf("expected per-extension build args %v, got %v", expected, buildArgs)
+	}
+	if len(config.BuildArgs) != 1 {
+		t.Fatalf("expected the caller's BuildArgs untouched, got %v", config.BuildArgs)
+	}
+}
+
 func TestBuildAllExtensionsStopsAfterMissingBuilder(t *testing.T) {
 	factory := &BuilderFactory{}
 	trackingBuilder := &mockBuilder{
@@ -441,3 +498,664 @@ func TestBuildAllExtensionsContinuesAfterFailure(t *testing.T) {
 		t.Fatal("expected second result to succeed")
 	}
 }
+
+func TestRegisterFirstTakesPrecedence(t *testing.T) {
+	factory := NewBuilderFactory()
+	custom := &mockBuilder{
+		name: "custom",
+		canBuildFn: func(ext string) bool {
+			return ext == "extconf.rb"
+		},
+	}
+	factory.RegisterFirst(custom)
+
+	builder, err := factory.BuilderFor("ext/myext/extconf.rb")
+	if err != nil {
+		t.Fatalf("expected builder, got error: %v", err)
+	}
+	if builder.Name() != "custom" {
+		t.Fatalf("expected custom builder to win, got %s", builder.Name())
+	}
+
+	builders := factory.ListBuilders()
+	if builders[len(builders)-1].Name() != "Python" {
+		t.Fatalf("expected standard builders to keep their order after the custom builder")
+	}
+}
+
+func TestStripANSI(t *testing.T) {
+	input := "\x1b[1m\x1b[32m   Compiling\x1b[0m magnus v0.7.1\n\x1b]8;;https://example.com\x07link\x1b]8;;\x07 \x1b[2Kdone"
+	expected := "   Compiling magnus v0.7.1\nlink done"
+
+	if got := stripANSI(input); got != expected {
+		t.Fatalf("stripANSI(%q) = %q, expected %q", input, got, expected)
+	}
+
+	result := &BuildResult{}
+	appendCommandOutput(&BuildConfig{StripANSI: true}, result, []byte(input))
+	if len(result.Output) != 2 || result.Output[0] != "   Compiling magnus v0.7.1" {
+		t.Fatalf("expected stripped output lines, got %q", result.Output)
+	}
+
+	result = &BuildResult{}
+	appendCommandOutput(&BuildConfig{}, result, []byte(input))
+	if result.Output[0] != "\x1b[1m\x1b[32m   Compiling\x1b[0m magnus v0.7.1" {
+		t.Fatalf("expected output to be kept as-is without StripANSI, got %q", result.Output)
+	}
+}
+
+func TestAppendCommandOutputMaxOutputLines(t *testing.T) {
+	config := &BuildConfig{MaxOutputLines: 4}
+	result := &BuildResult{}
+
+	appendCommandOutput(config, result, []byte("1\n2\n3"))
+	if !reflect.DeepEqual(result.Output, []string{"1", "2", "3"}) {
+		t.Fatalf("expected output under the limit to be kept, got %q", result.Output)
+	}
+
+	appendCommandOutput(config, result, []byte("4\n5\n6"))
+	expected := []string{"1", "2", "...<truncated 2 lines>...", "5", "6"}
+	if !reflect.DeepEqual(result.Output, expected) {
+		t.Fatalf("expected %q, got %q", expected, result.Output)
+	}
+
+	appendCommandOutput(config, result, []byte("7\n8\n9"))
+	expected = []string{"1", "2", "...<truncated 5 lines>...", "8", "9"}
+	if !reflect.DeepEqual(result.Output, expected) {
+		t.Fatalf("expected %q, got %q", expected, result.Output)
+	}
+}
+
+func TestBuildWithPrefixOutput(t *testing.T) {
+	builder := &mockBuilder{
+		name: "Mock",
+		buildFn: func(_ context.Context, _ *BuildConfig, _ string) (*BuildResult, error) {
+			return &BuildResult{Success: true, Output: []string{"checking for ruby.h... yes", "linking"}}, nil
+		},
+	}
+	factory := &BuilderFactory{}
+
+	result, err := factory.BuildWith(context.Background(), &BuildConfig{PrefixOutput: true}, builder, "ext/myext/extconf.rb")
+	if err != nil {
+		t.Fatalf("BuildWith returned error: %v", err)
+	}
+	expected := []string{"[Mock:myext] checking for ruby.h... yes", "[Mock:myext] linking"}
+	if !reflect.DeepEqual(result.Output, expected) {
+		t.Fatalf("expected %q, got %q", expected, result.Output)
+	}
+
+	result, _ = factory.BuildWith(context.Background(), &BuildConfig{}, builder, "ext/myext/extconf.rb")
+	if result.Output[0] != "checking for ruby.h... yes" {
+		t.Fatalf("expected unprefixed output without PrefixOutput, got %q", result.Output)
+	}
+}
+
+func TestBuildErrorIncludesExitCode(t *testing.T) {
+	cmd := helperCommand(2)(context.Background(), "make")
+	runErr := cmd.Run()
+	if runErr == nil {
+		t.Fatal("expected helper process to fail")
+	}
+
+	if code := ExitCode(runErr); code != 2 {
+		t.Fatalf("expected exit code 2, got %d", code)
+	}
+
+	err := BuildError("Make", nil, runErr)
+	expected := "Make build failed (exit 2): exit status 2"
+	if err.Error() != expected {
+		t.Fatalf("expected %q, got %q", expected, err.Error())
+	}
+
+	result, returned := failBuild(&BuildResult{}, err)
+	if returned != err || result.ExitCode != 2 || result.Error != err {
+		t.Fatalf("expected failBuild to record error and exit code, got %+v", result)
+	}
+
+	if ExitCode(errors.New("plain")) != 0 {
+		t.Fatal("expected exit code 0 for errors without an exit status")
+	}
+}
+
+func TestRequirementsFor(t *testing.T) {
+	factory := NewBuilderFactory()
+
+	for _, builder := range factory.ListBuilders() {
+		checker, ok := builder.(ToolChecker)
+		if !ok {
+			t.Errorf("%s builder does not implement ToolChecker", builder.Name())
+			continue
+		}
+		if len(checker.RequiredTools()) == 0 {
+			t.Errorf("%s builder declares no required tools", builder.Name())
+		}
+	}
+
+	requirements, err := factory.RequirementsFor("ext/rust/Cargo.toml")
+	if err != nil {
+		t.Fatalf("expected requirements for Cargo.toml, got error: %v", err)
+	}
+	if len(requirements) == 0 || requirements[0].Name != "cargo" {
+		t.Fatalf("expected cargo requirement first, got %+v", requirements)
+	}
+
+	if _, err := factory.RequirementsFor("unknown.file"); err == nil {
+		t.Fatal("expected error for unsupported extension file")
+	}
+
+	custom := &BuilderFactory{}
+	custom.Register(&mockBuilder{name: "plain", canBuildFn: func(string) bool { return true }})
+	requirements, err = custom.RequirementsFor("anything")
+	if err != nil || requirements != nil {
+		t.Fatalf("expected no requirements for builder without ToolChecker, got %v, %v", requirements, err)
+	}
+}
+
+func TestPythonBuilderFindsDistutilsOutputs(t *testing.T) {
+	extDir := t.TempDir()
+	libDir := filepath.Join(extDir, "build", "lib.linux-x86_64-cpython-312")
+	if err := os.MkdirAll(libDir, 0o755); err != nil {
+		t.Fatalf("failed to create build dir: %v", err)
+	}
+	for _, path := range []string{filepath.Join(extDir, "inplace.so"), filepath.Join(libDir, "native.so")} {
+		if err := os.WriteFile(path, []byte("binary"), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	extensions, err := NewPythonBuilder().findBuiltExtensions(extDir)
+	if err != nil {
+		t.Fatalf("findBuiltExtensions returned error: %v", err)
+	}
+
+	expected := []string{"inplace.so", filepath.Join("build", "lib.linux-x86_64-cpython-312", "native.so")}
+	if !reflect.DeepEqual(extensions, expected) {
+		t.Fatalf("expected %v, got %v", expected, extensions)
+	}
+}
+
+func TestRunCommonBuildRequireArtifacts(t *testing.T) {
+	steps := CommonBuildSteps{
+		ConfigureFunc: func(context.Context, *BuildConfig, string, *BuildResult) error { return nil },
+		BuildFunc:     func(context.Context, *BuildConfig, string, *BuildResult) error { return nil },
+		FindFunc:      func(string) ([]string, error) { return nil, nil },
+	}
+
+	config := &BuildConfig{GemDir: t.TempDir()}
+	result, err := runCommonBuild(context.Background(), config, "ext/myext/extconf.rb", steps)
+	if err != nil || !result.Success {
+		t.Fatalf("expected empty build to succeed by default, got %v", err)
+	}
+
+	config.RequireArtifacts = true
+	result, err = runCommonBuild(context.Background(), config, "ext/myext/extconf.rb", steps)
+	if err == nil || result.Success {
+		t.Fatal("expected empty build to fail with RequireArtifacts")
+	}
+	if !strings.Contains(err.Error(), "produced no loadable extension") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestBuildersForAndBuildWith(t *testing.T) {
+	isRakefile := func(ext string) bool { return ext == "Rakefile" }
+	first := &mockBuilder{name: "first", canBuildFn: isRakefile}
+	second := &mockBuilder{name: "second", canBuildFn: isRakefile}
+	other := &mockBuilder{name: "other", canBuildFn: func(ext string) bool { return ext == "extconf.rb" }}
+
+	factory := &BuilderFactory{}
+	factory.Register(first)
+	factory.Register(other)
+	factory.Register(second)
+
+	builders := factory.BuildersFor("ext/myext/Rakefile")
+	if len(builders) != 2 || builders[0] != first || builders[1] != second {
+		t.Fatalf("expected [first second], got %v", builders)
+	}
+	if builders := factory.BuildersFor("unknown.file"); builders != nil {
+		t.Fatalf("expected no builders for unknown file, got %v", builders)
+	}
+
+	result, err := factory.BuildWith(context.Background(), &BuildConfig{}, second, "ext/myext/Rakefile")
+	if err != nil || !result.Success {
+		t.Fatalf("expected BuildWith to succeed, got %v", err)
+	}
+	if first.buildCalls != 0 || second.buildCalls != 1 {
+		t.Fatalf("expected only the chosen builder to run, got first=%d second=%d", first.buildCalls, second.buildCalls)
+	}
+	if result.BuilderName != "second" || result.ExtensionFile != "ext/myext/Rakefile" {
+		t.Fatalf("expected result attributed to second builder, got %s/%s", result.BuilderName, result.ExtensionFile)
+	}
+}
+
+type toolCheckingBuilder struct {
+	mockBuilder
+	tools []ToolRequirement
+}
+
+func (b *toolCheckingBuilder) RequiredTools() []ToolRequirement { return b.tools }
+
+func (b *toolCheckingBuilder) CheckTools() error { return CheckRequiredTools(b.tools) }
+
+func TestBuildAllExtensionsReportsMissingDependencies(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	t.Setenv("CARGO", "")
+
+	builder := &toolCheckingBuilder{
+		mockBuilder: mockBuilder{name: "Cargo", canBuildFn: func(ext string) bool { return ext == "Cargo.toml" }},
+		tools: []ToolRequirement{
+			{Name: "cargo", Purpose: "Rust package manager"},
+			{Name: "rustc", Purpose: "Rust compiler"},
+			{Name: "sccache", Optional: true},
+		},
+	}
+	factory := &BuilderFactory{}
+	factory.Register(builder)
+
+	results, err := factory.BuildAllExtensions(context.Background(), &BuildConfig{}, []string{"ext/myext/Cargo.toml"})
+	if err == nil {
+		t.Fatal("expected error for missing tools")
+	}
+	if builder.buildCalls != 0 {
+		t.Fatal("expected Build not to be called when tools are missing")
+	}
+	if !reflect.DeepEqual(results[0].MissingDependencies, []string{"cargo", "rustc"}) {
+		t.Fatalf("expected missing cargo and rustc, got %v", results[0].MissingDependencies)
+	}
+
+	t.Setenv("CARGO", "/opt/rust/bin/cargo")
+	builder.tools = builder.tools[:1]
+	if _, err := factory.BuildAllExtensions(context.Background(), &BuildConfig{}, []string{"ext/myext/Cargo.toml"}); err != nil {
+		t.Fatalf("expected CARGO to satisfy the cargo requirement, got %v", err)
+	}
+}
+
+func TestMakefileBuilderKeepsOutputOnCancellation(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("shell script make requires a POSIX shell")
+	}
+
+	// The step named by the config hangs after writing its output; the
+	// others succeed
+	makePath := filepath.Join(t.TempDir(), "make")
+	writeTestScript(t, makePath, `#!/bin/sh
+step=${1:-all}
+[ "$step" = "$HANG_STEP" ] || { touch myext.so; exit 0; }
+echo "$step: compiling myext.c"
+echo "$step: warning: slow" >&2
+exec sleep 10
+`)
+	t.Setenv("MAKE", makePath)
+
+	gemDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "myext")
+	if err := os.MkdirAll(extDir, 0o755); err != nil {
+		t.Fatalf("failed to create extension dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(extDir, "Makefile"), []byte("all:\n"), 0o600); err != nil {
+		t.Fatalf("failed to write Makefile: %v", err)
+	}
+
+	testCases := []struct {
+		step   string
+		config BuildConfig
+	}{
+		{"all", BuildConfig{GemDir: gemDir}},
+		{"clean", BuildConfig{GemDir: gemDir, CleanFirst: true}},
+		{"install", BuildConfig{GemDir: gemDir, DestPath: t.TempDir()}},
+	}
+
+	for _, tc := range testCases {
+		t.Setenv("HANG_STEP", tc.step)
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+
+		result, err := (&MakefileBuilder{}).Build(ctx, &tc.config, "ext/myext/Makefile")
+		cancel()
+		if err == nil {
+			t.Fatalf("%s: expected canceled build to fail", tc.step)
+		}
+
+		output := strings.Join(result.Output, "\n")
+		if !strings.Contains(output, tc.step+": compiling myext.c") || !strings.Contains(output, tc.step+": warning: slow") {
+			t.Fatalf("%s: expected output written before cancellation, got %q", tc.step, output)
+		}
+	}
+}
+
+func TestIgnoreInstallErrors(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("shell script make requires a POSIX shell")
+	}
+
+	makePath := filepath.Join(t.TempDir(), "make")
+	writeTestScript(t, makePath, `#!/bin/sh
+if [ "$1" = install ]; then echo 'install: permission denied' >&2; exit 2; fi
+touch myext.so
+`)
+	t.Setenv("MAKE", makePath)
+
+	testCases := []struct {
+		builder       Builder
+		extensionFile string
+		content       string
+	}{
+		{&MakefileBuilder{}, "Makefile", "all:\n"},
+		{&ConfigureBuilder{}, "configure", "#!/bin/sh\necho 'all:' > Makefile\n"},
+	}
+
+	for _, tc := range testCases {
+		gemDir := t.TempDir()
+		extDir := filepath.Join(gemDir, "ext", "myext")
+		if err := os.MkdirAll(extDir, 0o755); err != nil {
+			t.Fatalf("failed to create extension dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(extDir, tc.extensionFile), []byte(tc.content), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", tc.extensionFile, err)
+		}
+
+		destDir := t.TempDir()
+		config := &BuildConfig{GemDir: gemDir, DestPath: destDir}
+		extensionFile := "ext/myext/" + tc.extensionFile
+
+		result, err := tc.builder.Build(context.Background(), config, extensionFile)
+		if err == nil || result.ExitCode != 2 {
+			t.Fatalf("%s: expected install failure to fail the build by default, got %v (exit %d)", tc.builder.Name(), err, result.ExitCode)
+		}
+
+		config.IgnoreInstallErrors = true
+		result, err = tc.builder.Build(context.Background(), config, extensionFile)
+		if err != nil || !result.Success {
+			t.Fatalf("%s: expected build to succeed past the install failure, got %v", tc.builder.Name(), err)
+		}
+
+		expected := []string{filepath.ToSlash(filepath.Join(destDir, "myext.so"))}
+		if !reflect.DeepEqual(result.Extensions, expected) {
+			t.Fatalf("%s: expected compiled extension to be installed, got %v", tc.builder.Name(), result.Extensions)
+		}
+
+		output := strings.Join(result.Output, "\n")
+		if !strings.Contains(output, "permission denied") || !strings.Contains(output, "Note: Make Install failed") {
+			t.Fatalf("%s: expected install failure recorded in output, got %q", tc.builder.Name(), output)
+		}
+	}
+}
+
+func TestCleanReturnsContextError(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "Makefile"), []byte("clean:\n\t@true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	config := &BuildConfig{GemDir: tmpDir}
+	builders := []Builder{
+		&MakefileBuilder{},
+		&ConfigureBuilder{},
+		&CmakeBuilder{},
+		&GoBuilder{},
+		&JavaBuilder{},
+		NewGenericBuilder(&GenericBuilderConfig{Name: "Generic", CleanCommand: []string{"true"}}),
+	}
+	for _, builder := range builders {
+		if err := builder.Clean(ctx, config, "Makefile"); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: Clean() error = %v, want context.Canceled", builder.Name(), err)
+		}
+	}
+
+	if err := (&MakefileBuilder{}).Clean(context.Background(), config, "Makefile"); err != nil {
+		t.Errorf("Clean() with live context error = %v", err)
+	}
+}
+
+func TestCleanArtifactsOnly(t *testing.T) {
+	gemDir := t.TempDir()
+	files := []string{"ext/a/extconf.rb", "ext/a/a.c", "ext/a/a.so", "ext/b/extconf.rb", "ext/b/b.so"}
+	for _, file := range files {
+		path := filepath.Join(gemDir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// No Makefile: make clean would fail, but only artifacts are removed
+	config := &BuildConfig{GemDir: gemDir, CleanArtifactsOnly: true}
+	if err := (&ExtConfBuilder{}).Clean(context.Background(), config, "ext/a/extconf.rb"); err != nil {
+		t.Fatalf("Clean() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(gemDir, "ext", "a", "a.so")); !os.IsNotExist(err) {
+		t.Error("expected a.so to be removed")
+	}
+	for _, kept := range []string{"ext/a/a.c", "ext/a/extconf.rb", "ext/b/b.so"} {
+		if _, err := os.Stat(filepath.Join(gemDir, filepath.FromSlash(kept))); err != nil {
+			t.Errorf("expected %s to be kept: %v", kept, err)
+		}
+	}
+}
+
+func TestGoBuilderBuildStatic(t *testing.T) {
+	args := (&GoBuilder{}).goBuildArgs(&BuildConfig{BuildStatic: true}, staticExtensionName)
+	if !slices.Contains(args, "-buildmode=c-archive") {
+		t.Errorf("expected go build -buildmode=c-archive with BuildStatic, got %v", args)
+	}
+	if args := (&GoBuilder{}).goBuildArgs(&BuildConfig{}, defaultExtensionName); !slices.Contains(args, "-buildmode=c-shared") {
+		t.Errorf("expected go build -buildmode=c-shared by default, got %v", args)
+	}
+}
+
+func TestParallelJobsFlags(t *testing.T) {
+	config := &BuildConfig{Parallel: 2}
+
+	if args := (&GoBuilder{}).goBuildArgs(config, "extension.so"); !strings.Contains(strings.Join(args, " "), "-p 2") {
+		t.Errorf("expected go build -p 2, got %v", args)
+	}
+	if args := (&JavaBuilder{}).mavenArgs(config); !strings.Contains(strings.Join(args, " "), "-T 2") {
+		t.Errorf("expected mvn -T 2, got %v", args)
+	}
+	if args := (&CmakeBuilder{}).buildArgs(config); !strings.Contains(strings.Join(args, " "), "--parallel 2") {
+		t.Errorf("expected cmake --parallel 2, got %v", args)
+	}
+	if args := (&ExtConfBuilder{}).makeArgs(config, ""); !slices.Contains(args, "-j2") {
+		t.Errorf("expected make -j2, got %v", args)
+	}
+
+	if args := (&GoBuilder{}).goBuildArgs(&BuildConfig{}, "extension.so"); slices.Contains(args, "-p") {
+		t.Errorf("expected no -p without Parallel, got %v", args)
+	}
+
+	if jobs := parallelJobs(&BuildConfig{Parallel: runtime.NumCPU()*maxParallelPerCPU + 1}); jobs != runtime.NumCPU() {
+		t.Errorf("expected absurd Parallel to be clamped to %d CPUs, got %d", runtime.NumCPU(), jobs)
+	}
+}
+
+func TestBuilderFactoryConcurrentRegister(t *testing.T) {
+	factory := &BuilderFactory{}
+	factory.Register(&mockBuilder{name: "Fallback", canBuildFn: func(string) bool { return true }})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			factory.RegisterFirst(&mockBuilder{name: "Lazy", canBuildFn: func(string) bool { return false }})
+			factory.Register(&mockBuilder{name: "Lazy", canBuildFn: func(string) bool { return false }})
+		}()
+		go func() {
+			defer wg.Done()
+			if builder, err := factory.BuilderFor("ext/myext/extconf.rb"); err != nil || builder.Name() != "Fallback" {
+				t.Errorf("expected Fallback builder, got %v (%v)", builder, err)
+			}
+			_ = factory.ListBuilders()
+		}()
+	}
+	wg.Wait()
+
+	if count := len(factory.ListBuilders()); count != 17 {
+		t.Fatalf("expected 17 registered builders, got %d", count)
+	}
+}
+
+func TestBuildCommandEnvCleanEnv(t *testing.T) {
+	t.Setenv("MAKEFLAGS", "-j64")
+	t.Setenv("RUBYOPT", "-W0")
+	t.Setenv("PKG_CONFIG_PATH", "/opt/lib/pkgconfig")
+
+	config := &BuildConfig{Env: map[string]string{"CFLAGS": "-O2"}}
+	if env := buildCommandEnv(config); !slices.Contains(env, "MAKEFLAGS=-j64") {
+		t.Fatal("expected the parent environment to be inherited by default")
+	}
+
+	config.CleanEnv = true
+	config.EnvAllowlist = []string{"PKG_CONFIG_PATH"}
+	env := buildCommandEnv(config)
+	for _, entry := range env {
+		if strings.HasPrefix(entry, "MAKEFLAGS=") || strings.HasPrefix(entry, "RUBYOPT=") {
+			t.Fatalf("expected %s to be dropped with CleanEnv, got %v", entry, env)
+		}
+	}
+	for _, expected := range []string{"PATH=" + os.Getenv("PATH"), "PKG_CONFIG_PATH=/opt/lib/pkgconfig", "CFLAGS=-O2"} {
+		if !slices.Contains(env, expected) {
+			t.Fatalf("expected %s in the clean environment, got %v", expected, env)
+		}
+	}
+
+	if value := envValue(config, "MAKEFLAGS"); value != "" {
+		t.Fatalf("expected envValue to ignore variables CleanEnv drops, got %q", value)
+	}
+	if value := envValue(config, "PKG_CONFIG_PATH"); value != "/opt/lib/pkgconfig" {
+		t.Fatalf("expected envValue to see allowlisted variables, got %q", value)
+	}
+}
+
+func TestBuildExtensionBypassesCanBuild(t *testing.T) {
+	builder := &mockBuilder{
+		name:       "Rake",
+		canBuildFn: func(string) bool { return false },
+		buildFn: func(_ context.Context, _ *BuildConfig, _ string) (*BuildResult, error) {
+			return &BuildResult{Success: true}, nil
+		},
+	}
+
+	result, err := BuildExtension(context.Background(), &BuildConfig{}, builder, "ext/myext/extconf.rb")
+	if err != nil || !result.Success {
+		t.Fatalf("expected the given builder to build, got %+v (%v)", result, err)
+	}
+	if builder.buildCalls != 1 || result.BuilderName != "Rake" || result.ExtensionFile != "ext/myext/extconf.rb" {
+		t.Fatalf("expected one Rake build of ext/myext/extconf.rb, got %d calls, %+v", builder.buildCalls, result)
+	}
+}
+
+func TestBuildWithDividesTotalJobs(t *testing.T) {
+	started := make(chan int)
+	finish := make(chan struct{})
+	builder := &mockBuilder{
+		name: "Mock",
+		buildFn: func(_ context.Context, config *BuildConfig, _ string) (*BuildResult, error) {
+			started <- config.Parallel
+			<-finish
+			return &BuildResult{Success: true}, nil
+		},
+	}
+	factory := &BuilderFactory{}
+	config := &BuildConfig{TotalJobs: 8}
+
+	var wg sync.WaitGroup
+	build := func(config *BuildConfig, builder Builder) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = factory.BuildWith(context.Background(), config, builder, "ext/myext/build")
+		}()
+	}
+
+	build(config, builder)
+	if jobs := <-started; jobs != 8 {
+		t.Fatalf("expected a lone build to get the whole budget, got %d", jobs)
+	}
+	if config.Parallel != 0 {
+		t.Fatalf("expected the caller's config untouched, got Parallel %d", config.Parallel)
+	}
+
+	// Builds with their own Parallel run outside the budget
+	build(&BuildConfig{TotalJobs: 8, Parallel: 3}, builder)
+	if jobs := <-started; jobs != 3 {
+		t.Fatalf("expected Parallel to take precedence, got %d", jobs)
+	}
+
+	// With the budget spent, builds wait for jobs until cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := factory.BuildWith(ctx, config, builder, "ext/myext/build"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a build waiting for jobs to be cancelled, got %v", err)
+	}
+	close(finish)
+
+	// Concurrent builds never run more jobs than the budget together
+	var mu sync.Mutex
+	running, peak := 0, 0
+	measure := func(_ context.Context, config *BuildConfig, _ string) (*BuildResult, error) {
+		mu.Lock()
+		running += config.Parallel
+		peak = max(peak, running)
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		running -= config.Parallel
+		mu.Unlock()
+		return &BuildResult{Success: true}, nil
+	}
+
+	for range 20 {
+		build(config, &mockBuilder{name: "Mock", buildFn: measure})
+	}
+	wg.Wait()
+
+	if peak > config.TotalJobs || peak < 1 {
+		t.Fatalf("expected at most %d jobs running at once, got %d", config.TotalJobs, peak)
+	}
+	if factory.jobsInUse != 0 || factory.jobsActive != 0 {
+		t.Fatalf("expected all jobs returned, got %d in use by %d builds", factory.jobsInUse, factory.jobsActive)
+	}
+}
+
+func TestBuildWithSplitsTotalJobsAmongConcurrentBuilds(t *testing.T) {
+	started := make(chan int)
+	finish := make(chan struct{})
+	builder := func() Builder {
+		return &mockBuilder{
+			name: "Mock",
+			buildFn: func(_ context.Context, config *BuildConfig, _ string) (*BuildResult, error) {
+				started <- config.Parallel
+				<-finish
+				return &BuildResult{Success: true}, nil
+			},
+		}
+	}
+	factory := &BuilderFactory{}
+	config := &BuildConfig{TotalJobs: 8, ConcurrentBuilds: 2}
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = factory.BuildWith(context.Background(), config, builder(), "ext/myext/build")
+		}()
+	}
+
+	// Both builds run at once, each with half the budget
+	first, second := <-started, <-started
+	close(finish)
+	wg.Wait()
+
+	if first != 4 || second != 4 {
+		t.Fatalf("expected each build to get %d jobs, got %d and %d", config.TotalJobs/2, first, second)
+	}
+}
@@ -4,9 +4,10 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 )
 
@@ -16,6 +17,45 @@ const (
 	platformDarwin  = "darwin"
 )
 
+// CargoLockPolicy controls how Cargo builds treat Cargo.lock.
+type CargoLockPolicy int
+
+// Supported Cargo.lock policies.
+const (
+	// CargoLockAuto passes --locked when the extension directory has a
+	// Cargo.lock, so a committed lockfile is used as is, and lets Cargo
+	// resolve dependencies and write one otherwise. This is the default.
+	CargoLockAuto CargoLockPolicy = iota
+
+	// CargoLockLocked always passes --locked: Cargo fails if Cargo.lock
+	// is missing or out of date with Cargo.toml instead of updating it.
+	CargoLockLocked
+
+	// CargoLockFrozen passes --frozen, which is --locked plus --offline:
+	// the lockfile can't change and nothing is downloaded.
+	CargoLockFrozen
+
+	// CargoLockUpdate passes neither flag, letting Cargo update a stale
+	// Cargo.lock and resolve new dependency versions.
+	CargoLockUpdate
+)
+
+// String returns the name of the Cargo.lock policy
+func (p CargoLockPolicy) String() string {
+	switch p {
+	case CargoLockAuto:
+		return "auto"
+	case CargoLockLocked:
+		return "locked"
+	case CargoLockFrozen:
+		return "frozen"
+	case CargoLockUpdate:
+		return "update"
+	default:
+		return fmt.Sprintf("CargoLockPolicy(%d)", int(p))
+	}
+}
+
 // CargoBuilder handles Rust-based builds using Cargo
 type CargoBuilder struct{}
 
@@ -55,72 +95,220 @@ func (b *CargoBuilder) Build(ctx context.Context, config *BuildConfig, extension
 		Output:  []string{},
 	}
 
-	extensionPath := filepath.Join(config.GemDir, extensionFile)
-	extensionDir := filepath.Dir(extensionPath)
+	// Calculate extension directory, using a working copy if BuildDir is set
+	extensionDir, err := prepareBuildDir(config, extensionFile)
+	if err != nil {
+		return failBuild(result, err)
+	}
+
+	if err := runPreBuildCommands(ctx, config, extensionDir, result); err != nil {
+		return failBuild(result, err)
+	}
 
 	// Step 1: Run cargo to build the Rust extension
 	if err := b.runCargo(ctx, config, extensionDir, result); err != nil {
-		result.Error = err
-		return result, err
+		return failBuild(result, err)
 	}
 
 	// Step 2: Find and rename built extensions to Ruby's expected format
 	if err := b.processBuiltExtensions(ctx, config, extensionDir, result); err != nil {
-		result.Error = err
-		return result, err
+		return failBuild(result, err)
+	}
+	if result.Extensions, err = selectPrimaryExtension(config, result.Extensions); err != nil {
+		return failBuild(result, err)
+	}
+
+	if err = runPostBuildCommands(ctx, config, extensionDir, result); err != nil {
+		return failBuild(result, err)
+	}
+
+	if err = postProcessExtensions(ctx, config, result, extensionDir, result.Extensions); err != nil {
+		return failBuild(result, err)
 	}
 
-	finalized, err := finalizeNativeExtensions(config, extensionFile, extensionDir, result.Extensions)
+	result.BuiltArtifacts = buildOutputPaths(config, extensionFile, extensionDir, result.Extensions)
+	finalized, err := finalizeNativeExtensions(config, result, extensionFile, extensionDir, result.Extensions)
 	if err != nil {
-		result.Error = err
-		return result, err
+		return failBuild(result, err)
 	}
 
 	result.Extensions = finalized
+
+	if err = recordChecksums(config, result); err != nil {
+		return failBuild(result, err)
+	}
+
 	result.Success = true
 	return result, nil
 }
 
 // Clean removes build artifacts
 func (b *CargoBuilder) Clean(ctx context.Context, config *BuildConfig, extensionFile string) error {
-	extensionPath := filepath.Join(config.GemDir, extensionFile)
-	extensionDir := filepath.Dir(extensionPath)
+	if config.CleanArtifactsOnly {
+		return cleanArtifacts(ctx, config, extensionFile, findNativeLibraries)
+	}
 
-	cmd := exec.CommandContext(ctx, "cargo", "clean")
+	extensionDir := extensionBuildDir(config, extensionFile)
+
+	// Only clean this extension's package, not the whole workspace target
+	args := []string{"clean"}
+	if pkg := b.cleanPackage(config, extensionDir); pkg != "" {
+		args = append(args, "-p", pkg)
+	}
+
+	cmd := execCommandContext(ctx, "cargo", args...)
 	cmd.Dir = extensionDir
 
-	return cmd.Run()
+	return cleanResult(ctx, cmd.Run())
+}
+
+// cleanPackage returns the package cargo clean is limited to:
+// config.CargoPackage, or the package named in Cargo.toml
+func (b *CargoBuilder) cleanPackage(config *BuildConfig, extensionDir string) string {
+	if config.CargoPackage != "" {
+		return config.CargoPackage
+	}
+	return cargoManifestValue(filepath.Join(extensionDir, "Cargo.toml"), "package", "name")
 }
 
 // runCargo executes cargo to build the Rust extension
 func (b *CargoBuilder) runCargo(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
-	cargoPath := b.getCargoPath()
+	// Honor a toolchain pinned with rust-toolchain.toml
+	if err := b.checkRustToolchain(ctx, config, extensionDir, result); err != nil {
+		return err
+	}
+	cargoPath, cargoPrefix := b.cargoCommand(config, extensionDir)
+	appendRustSanitizerNote(config, result)
+	appendUniversalNote(config, result)
+
+	// Fail before compiling anything if the slices can't be combined
+	var lipoPath string
+	if buildsUniversal(config) {
+		var err error
+		if lipoPath, err = checkLipo(result); err != nil {
+			return err
+		}
+	}
+
+	// Clean first if requested
+	if config.CleanFirst {
+		cleanCmd := execCommandContext(ctx, cargoPath, append(cargoPrefix, "clean")...)
+		cleanCmd.Dir = extensionDir
+		cleanOutput, _ := combinedOutput(cleanCmd)
+		appendCommandOutput(config, result, cleanOutput)
+	}
+
+	for _, targetConfig := range b.targetConfigs(config) {
+		if err := b.runCargoBuild(ctx, targetConfig, extensionDir, cargoPath, cargoPrefix, result); err != nil {
+			return err
+		}
+	}
+
+	if buildsUniversal(config) {
+		return b.combineUniversal(ctx, config, extensionDir, lipoPath, result)
+	}
+	return nil
+}
+
+// targetConfigs returns the configs to run cargo with: config itself, or
+// for a universal binary one per architecture with CargoTarget set
+func (b *CargoBuilder) targetConfigs(config *BuildConfig) []*BuildConfig {
+	if !buildsUniversal(config) {
+		return []*BuildConfig{config}
+	}
+
+	configs := make([]*BuildConfig, 0, len(universalTargets))
+	for _, target := range universalTargets {
+		targetConfig := *config
+		targetConfig.CargoTarget = target
+		targetConfig.UniversalBinary = false
+		configs = append(configs, &targetConfig)
+	}
+	return configs
+}
+
+// runCargoBuild runs the cargo build for a single target
+func (b *CargoBuilder) runCargoBuild(
+	ctx context.Context, config *BuildConfig, extensionDir, cargoPath string, cargoPrefix []string, result *BuildResult,
+) error {
+	cmd := execCommandContext(ctx, cargoPath, slices.Concat(cargoPrefix, b.cargoArgs(config, extensionDir))...)
+	cmd.Dir = extensionDir
+
+	// Set environment variables for Rust/Ruby integration
+	cmd.Env = buildCommandEnv(config)
+
+	// Set Ruby-specific environment variables
+	cmd.Env = append(cmd.Env, b.getRubyEnv(config)...)
+
+	// Wrap rustc with the compiler cache if configured
+	if cachePath := compilerCachePath(config, result); cachePath != "" {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("RUSTC_WRAPPER=%s", cachePath))
+	}
+
+	output, err := combinedOutput(cmd)
+	appendCommandOutput(config, result, output)
+
+	appendCommandLog(config, result, cmd, err)
+
+	if err != nil {
+		if config.Offline && strings.Contains(string(output), "offline") {
+			result.Output = append(result.Output,
+				"Note: building offline and Cargo needs dependencies that are not vendored or cached; "+
+					"run cargo vendor or cargo fetch with network access first")
+		}
+		return BuildError("Cargo", result.Output, err)
+	}
+
+	return nil
+}
+
+// Explain returns the commands Build would run, without running anything
+func (b *CargoBuilder) Explain(config *BuildConfig, extensionFile string) ([]string, error) {
+	extensionDir := extensionBuildDir(config, extensionFile)
+	cargoPath, cargoPrefix := b.cargoCommand(config, extensionDir)
+
+	var commands []string
+	if config.CleanFirst {
+		commands = append(commands, formatCommand(cargoPath, append(cargoPrefix, "clean")))
+	}
+	for _, targetConfig := range b.targetConfigs(config) {
+		commands = append(commands, formatCommand(cargoPath, slices.Concat(cargoPrefix, b.cargoArgs(targetConfig, extensionDir))))
+	}
+
+	return commands, nil
+}
 
+// cargoArgs returns the arguments for the cargo rustc invocation
+func (b *CargoBuilder) cargoArgs(config *BuildConfig, extensionDir string) []string {
 	// Build cargo arguments
-	args := []string{"rustc", "--release", "--crate-type", "cdylib"}
+	args := []string{"rustc"}
+
+	// Select a single workspace member if requested
+	if config.CargoPackage != "" {
+		args = append(args, "-p", config.CargoPackage)
+	}
+
+	crateType := "cdylib"
+	if config.BuildStatic {
+		crateType = "staticlib"
+	}
+	args = append(args, "--release", "--crate-type", crateType)
 
 	// Add target if specified
-	if target := os.Getenv("CARGO_BUILD_TARGET"); target != "" {
+	if target := b.cargoTarget(config); target != "" {
 		args = append(args, "--target", target)
 	}
 
-	// Use locked dependencies if Cargo.lock exists
-	lockPath := filepath.Join(extensionDir, "Cargo.lock")
-	if _, err := os.Stat(lockPath); err == nil {
-		args = append(args, "--locked")
-	}
+	args = append(args, b.lockArgs(config, extensionDir)...)
 
-	// Add parallel jobs if specified
-	if config.Parallel > 0 {
-		args = append(args, "--jobs", fmt.Sprintf("%d", config.Parallel))
+	// Only use vendored or already downloaded dependencies
+	if config.Offline {
+		args = append(args, "--offline")
 	}
 
-	// Clean first if requested
-	if config.CleanFirst {
-		cleanCmd := exec.CommandContext(ctx, cargoPath, "clean")
-		cleanCmd.Dir = extensionDir
-		cleanOutput, _ := cleanCmd.CombinedOutput()
-		result.Output = append(result.Output, strings.Split(string(cleanOutput), "\n")...)
+	// Add parallel jobs if specified
+	if jobs := parallelJobs(config); jobs > 0 {
+		args = append(args, "--jobs", fmt.Sprintf("%d", jobs))
 	}
 
 	// Add any custom build args
@@ -128,32 +316,75 @@ func (b *CargoBuilder) runCargo(ctx context.Context, config *BuildConfig, extens
 
 	// Add rustc-specific arguments for Ruby integration
 	args = append(args, "--")
-	args = append(args, b.getRustcArgs(config)...)
-
-	cmd := exec.CommandContext(ctx, cargoPath, args...)
-	cmd.Dir = extensionDir
+	return append(args, b.getRustcArgs(config)...)
+}
 
-	// Set environment variables for Rust/Ruby integration
-	cmd.Env = os.Environ()
-	for key, value := range config.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
+// lockArgs returns the Cargo.lock flags for config.CargoLockPolicy
+func (b *CargoBuilder) lockArgs(config *BuildConfig, extensionDir string) []string {
+	switch config.CargoLockPolicy {
+	case CargoLockLocked:
+		return []string{"--locked"}
+	case CargoLockFrozen:
+		return []string{"--frozen"}
+	case CargoLockUpdate:
+		return nil
+	default:
+		// Use locked dependencies if Cargo.lock exists
+		if _, err := osStat(filepath.Join(extensionDir, "Cargo.lock")); err == nil {
+			return []string{"--locked"}
+		}
+		return nil
 	}
+}
 
-	// Set Ruby-specific environment variables
-	cmd.Env = append(cmd.Env, b.getRubyEnv(config)...)
+// cargoTarget returns the target triple to build for: config.CargoTarget,
+// or CARGO_BUILD_TARGET from config.Env or the process environment.
+// Empty means the host target.
+func (b *CargoBuilder) cargoTarget(config *BuildConfig) string {
+	if config.CargoTarget != "" {
+		return config.CargoTarget
+	}
+	return envValue(config, "CARGO_BUILD_TARGET")
+}
 
-	output, err := cmd.CombinedOutput()
-	outputLines := strings.Split(string(output), "\n")
-	result.Output = append(result.Output, outputLines...)
+// releaseDir returns the directory cargo writes release builds for the
+// config's target to, or the one universal libraries are combined into
+func (b *CargoBuilder) releaseDir(config *BuildConfig, extensionDir string) string {
+	targetDir := filepath.Join(extensionDir, "target")
+	if buildsUniversal(config) {
+		return filepath.Join(targetDir, universalTargetDir, "release")
+	}
+	if target := b.cargoTarget(config); target != "" {
+		targetDir = filepath.Join(targetDir, target)
+	}
+	return filepath.Join(targetDir, "release")
+}
 
-	if config.Verbose {
-		result.Output = append(result.Output,
-			fmt.Sprintf("Running: %s %s", cargoPath, strings.Join(args, " ")),
-			fmt.Sprintf("Working directory: %s", extensionDir))
+// combineUniversal runs lipo on each library built for the first
+// universal target and its counterparts for the others, writing the
+// universal libraries to releaseDir
+func (b *CargoBuilder) combineUniversal(ctx context.Context, config *BuildConfig, extensionDir, lipoPath string, result *BuildResult) error {
+	var sliceDirs []string
+	for _, targetConfig := range b.targetConfigs(config) {
+		sliceDirs = append(sliceDirs, b.releaseDir(targetConfig, extensionDir))
 	}
 
+	libs, err := b.findCargoOutputs(config, sliceDirs[0])
 	if err != nil {
-		return BuildError("Cargo", result.Output, err)
+		return BuildError("Lipo", result.Output, fmt.Errorf("failed to find cargo outputs: %v", err))
+	}
+
+	universalDir := b.releaseDir(config, extensionDir)
+	for _, lib := range uniqueStrings(libs) {
+		name := filepath.Base(lib)
+		inputs := make([]string, 0, len(sliceDirs))
+		for _, dir := range sliceDirs {
+			inputs = append(inputs, filepath.Join(dir, name))
+		}
+
+		if err := runLipo(ctx, config, result, lipoPath, filepath.Join(universalDir, name), inputs); err != nil {
+			return BuildError("Lipo", result.Output, err)
+		}
 	}
 
 	return nil
@@ -161,27 +392,41 @@ func (b *CargoBuilder) runCargo(ctx context.Context, config *BuildConfig, extens
 
 // processBuiltExtensions finds built Rust libraries and renames them for Ruby
 func (b *CargoBuilder) processBuiltExtensions(_ context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
-	// Find the target directory
-	targetDir := filepath.Join(extensionDir, "target")
-	if target := os.Getenv("CARGO_BUILD_TARGET"); target != "" {
-		targetDir = filepath.Join(targetDir, target)
-	}
-	targetDir = filepath.Join(targetDir, "release")
+	targetDir := b.releaseDir(config, extensionDir)
 
 	// Find built dynamic libraries
-	builtLibs, err := b.findCargoOutputs(targetDir)
+	builtLibs, err := b.findCargoOutputs(config, targetDir)
 	if err != nil {
 		return BuildError("Cargo", result.Output, fmt.Errorf("failed to find cargo outputs: %v", err))
 	}
 
 	if len(builtLibs) == 0 {
-		return BuildError("Cargo", result.Output, fmt.Errorf("no dynamic libraries found in %s", targetDir))
+		return BuildError("Cargo", result.Output, fmt.Errorf("%w: no dynamic libraries found in %s", ErrNoArtifacts, targetDir))
+	}
+
+	// Narrow the outputs down to the crate being built so dependency
+	// artifacts in the shared target directory are not picked up
+	libName := b.getCargoLibName(config, extensionDir)
+	if matched := b.filterCargoOutputs(builtLibs, libName); len(matched) > 0 {
+		builtLibs = matched
+	} else if config.CargoPackage != "" {
+		return BuildError("Cargo", result.Output,
+			fmt.Errorf("%w: no cdylib for package %s found in %s", ErrNoArtifacts, config.CargoPackage, targetDir))
+	}
+
+	// The Ruby-facing name comes from create_rust_makefile when available
+	module := moduleFromExtconf(filepath.Join(extensionDir, "extconf.rb"))
+	if module == "" {
+		module = config.GemModule
 	}
 
 	// Process each built library
 	for _, lib := range builtLibs {
 		// Convert Rust library name to Ruby extension name
-		rubyExtName := b.getRubyExtensionName(lib)
+		rubyExtName, err := b.rubyExtensionName(config, lib, libName, module)
+		if err != nil {
+			return BuildError("Cargo", result.Output, err)
+		}
 		rubyExtPath := filepath.Join(extensionDir, rubyExtName)
 
 		// Copy the library to the expected location
@@ -193,7 +438,7 @@ func (b *CargoBuilder) processBuiltExtensions(_ context.Context, config *BuildCo
 		relPath, _ := filepath.Rel(extensionDir, rubyExtPath)
 		result.Extensions = append(result.Extensions, relPath)
 
-		if config.Verbose {
+		if config.effectiveLogLevel() >= LogLevelVerbose {
 			result.Output = append(result.Output, fmt.Sprintf("Copied %s -> %s", lib, rubyExtPath))
 		}
 	}
@@ -201,23 +446,28 @@ func (b *CargoBuilder) processBuiltExtensions(_ context.Context, config *BuildCo
 	return nil
 }
 
-// findCargoOutputs locates built dynamic libraries
-func (b *CargoBuilder) findCargoOutputs(targetDir string) ([]string, error) {
+// findCargoOutputs locates built dynamic libraries, or static libraries
+// when config.BuildStatic is set
+func (b *CargoBuilder) findCargoOutputs(config *BuildConfig, targetDir string) ([]string, error) {
 	var outputs []string
 
 	// Platform-specific library patterns
 	var patterns []string
-	switch runtime.GOOS {
-	case platformWindows:
+	switch {
+	case config.BuildStatic && runtime.GOOS == platformWindows:
+		patterns = []string{"*.lib"}
+	case config.BuildStatic:
+		patterns = []string{"lib*.a"}
+	case runtime.GOOS == platformWindows:
 		patterns = []string{"*.dll"}
-	case platformDarwin:
+	case runtime.GOOS == platformDarwin:
 		patterns = []string{"*.dylib", "lib*.dylib"}
 	default:
 		patterns = []string{"*.so", "lib*.so"}
 	}
 
 	for _, pattern := range patterns {
-		matches, err := filepath.Glob(filepath.Join(targetDir, pattern))
+		matches, err := filepathGlob(filepath.Join(targetDir, pattern))
 		if err != nil {
 			return nil, fmt.Errorf("failed to glob pattern %s: %v", pattern, err)
 		}
@@ -227,8 +477,44 @@ func (b *CargoBuilder) findCargoOutputs(targetDir string) ([]string, error) {
 	return outputs, nil
 }
 
-// getRubyExtensionName converts a Rust library name to Ruby extension format
-func (b *CargoBuilder) getRubyExtensionName(libPath string) string {
+// getCargoLibName returns the library name cargo produces for the crate being built
+func (b *CargoBuilder) getCargoLibName(config *BuildConfig, extensionDir string) string {
+	name := config.CargoPackage
+	if name == "" {
+		manifestPath := filepath.Join(extensionDir, "Cargo.toml")
+		name = cargoManifestValue(manifestPath, "lib", "name")
+		if name == "" {
+			name = cargoManifestValue(manifestPath, "package", "name")
+		}
+	}
+
+	// Cargo replaces dashes with underscores in library file names
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+// filterCargoOutputs keeps only the libraries whose name matches libName
+func (b *CargoBuilder) filterCargoOutputs(outputs []string, libName string) []string {
+	if libName == "" {
+		return nil
+	}
+
+	var matched []string
+	for _, output := range outputs {
+		filename := strings.TrimPrefix(filepath.Base(output), "lib")
+		if strings.TrimSuffix(filename, filepath.Ext(filename)) == libName {
+			matched = append(matched, output)
+		}
+	}
+
+	return matched
+}
+
+// getRubyExtensionName converts a Rust library name to Ruby extension format.
+//
+// When the library is the crate's own cdylib and the gem declares a module
+// via create_rust_makefile, the module's base name is used so the result
+// can be required under the name Ruby expects.
+func (b *CargoBuilder) getRubyExtensionName(config *BuildConfig, libPath, libName, module string) string {
 	filename := filepath.Base(libPath)
 	ext := filepath.Ext(filename)
 
@@ -237,16 +523,65 @@ func (b *CargoBuilder) getRubyExtensionName(libPath string) string {
 
 	// Remove original extension and add Ruby's expected extension
 	name := strings.TrimSuffix(filename, ext)
+	if module != "" && name == libName {
+		name = path.Base(module)
+	}
 
-	// Ruby expects specific extensions based on platform
-	switch runtime.GOOS {
-	case platformDarwin:
-		return name + ".bundle"
-	case platformWindows:
-		return name + ".dll"
-	default:
-		return name + ".so"
+	return name + b.outputSuffix(config, libPath)
+}
+
+// outputSuffix returns the extension a built library is given: the
+// target Ruby's DLEXT, or the archive's own extension for static builds
+func (b *CargoBuilder) outputSuffix(config *BuildConfig, libPath string) string {
+	if config.BuildStatic {
+		return filepath.Ext(libPath)
 	}
+	return rubyExtensionSuffix(config)
+}
+
+// rubyExtensionName returns the path, relative to the extension
+// directory, that a built library is copied to.
+//
+// Without config.ExtNameTemplate this is getRubyExtensionName. A template
+// can use these placeholders:
+//   - {{crate}}: the library's name without the lib prefix and extension
+//   - {{name}}: the name getRubyExtensionName would use, without extension
+//   - {{module}}: the create_rust_makefile module path, or {{name}} if none
+//   - {{ext}}: the target Ruby's extension without the dot (so, bundle, dll),
+//     or the archive's (a, lib) with config.BuildStatic
+//
+// For example "{{module}}.{{ext}}" places the library at the module path.
+func (b *CargoBuilder) rubyExtensionName(config *BuildConfig, libPath, libName, module string) (string, error) {
+	defaultName := b.getRubyExtensionName(config, libPath, libName, module)
+	if config.ExtNameTemplate == "" {
+		return defaultName, nil
+	}
+
+	filename := filepath.Base(libPath)
+	crate := strings.TrimSuffix(strings.TrimPrefix(filename, "lib"), filepath.Ext(filename))
+	suffix := b.outputSuffix(config, libPath)
+	name := strings.TrimSuffix(defaultName, suffix)
+	if module == "" {
+		module = name
+	}
+
+	expanded := strings.NewReplacer(
+		"{{crate}}", crate,
+		"{{name}}", name,
+		"{{module}}", module,
+		"{{ext}}", strings.TrimPrefix(suffix, "."),
+	).Replace(config.ExtNameTemplate)
+
+	if strings.Contains(expanded, "{{") {
+		return "", fmt.Errorf("unknown placeholder in ExtNameTemplate %q", config.ExtNameTemplate)
+	}
+
+	clean := filepath.Clean(filepath.FromSlash(expanded))
+	if clean == "." || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("ExtNameTemplate %q must produce a path inside the extension directory, got %q", config.ExtNameTemplate, expanded)
+	}
+
+	return clean, nil
 }
 
 // getRustcArgs returns rustc arguments for Ruby integration
@@ -265,21 +600,29 @@ func (b *CargoBuilder) getRustcArgs(_ *BuildConfig) []string {
 	return args
 }
 
-// getRubyEnv returns Ruby-specific environment variables for Cargo
+// getRubyEnv returns Ruby-specific environment variables for Cargo.
+//
+// RUSTFLAGS is assembled from these sources, in this order:
+//  1. Existing RUSTFLAGS (config.Env takes precedence over the process environment)
+//  2. The rb-sys cfgs Ruby gems expect (--cfg=rb_sys_gem --cfg=rubygems)
+//  3. -D warnings when config.WarningsAsErrors is set
+//  4. -Z sanitizer= for config.Sanitizers (see rustSanitizerFlags)
+//  5. config.RustFlags
+//
+// rustc lets later flags override earlier ones, so config.RustFlags wins
+// any conflict while the existing flags are never dropped.
 func (b *CargoBuilder) getRubyEnv(config *BuildConfig) []string {
 	var env []string
 
-	// Set RUSTFLAGS for Ruby gem configuration
-	rustFlags := os.Getenv("RUSTFLAGS")
-	rubyFlags := "--cfg=rb_sys_gem --cfg=rubygems"
-
-	if rustFlags != "" {
-		rustFlags = fmt.Sprintf("%s %s", rustFlags, rubyFlags)
-	} else {
-		rustFlags = rubyFlags
+	flags := strings.Fields(envValue(config, "RUSTFLAGS"))
+	flags = append(flags, "--cfg=rb_sys_gem", "--cfg=rubygems")
+	if config.WarningsAsErrors {
+		flags = append(flags, "-D", "warnings")
 	}
+	flags = append(flags, rustSanitizerFlags(config)...)
+	flags = append(flags, config.RustFlags...)
 
-	env = append(env, fmt.Sprintf("RUSTFLAGS=%s", rustFlags))
+	env = append(env, fmt.Sprintf("RUSTFLAGS=%s", strings.Join(flags, " ")))
 
 	// Set Ruby-specific variables if available
 	if config.RubyPath != "" {
@@ -325,3 +668,48 @@ func (b *CargoBuilder) copyFile(src, dst string) error {
 	_, err = destFile.ReadFrom(sourceFile)
 	return err
 }
+
+// cargoManifestValue reads a string value from a table in a Cargo.toml file.
+//
+// Only the subset of TOML needed to read simple keys like [package] name
+// is understood. Returns an empty string if the file, table, or key is missing.
+func cargoManifestValue(manifestPath, table, key string) string {
+	content, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return ""
+	}
+
+	currentTable := ""
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			header, _, _ := strings.Cut(line, "]")
+			currentTable = strings.TrimSpace(strings.TrimLeft(header, "["))
+			continue
+		}
+
+		if currentTable != table {
+			continue
+		}
+
+		name, value, found := strings.Cut(line, "=")
+		if !found || strings.TrimSpace(name) != key {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) < 2 || (value[0] != '"' && value[0] != '\'') {
+			return ""
+		}
+		if end := strings.IndexByte(value[1:], value[0]); end >= 0 {
+			return value[1 : end+1]
+		}
+		return ""
+	}
+
+	return ""
+}
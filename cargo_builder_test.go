@@ -0,0 +1,328 @@
+package rubyext
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeCargoManifest(t *testing.T, dir, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("failed to create manifest directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Cargo.toml"), []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write Cargo.toml: %v", err)
+	}
+}
+
+func TestCargoManifestValue(t *testing.T) {
+	dir := t.TempDir()
+	writeCargoManifest(t, dir, `# workspace member
+[package]
+name = "my-ext" # trailing comment
+version = "0.1.0"
+
+[lib]
+name = 'my_ext_native'
+crate-type = ["cdylib"]
+
+[dependencies]
+name = "not-this-one"
+`)
+
+	manifest := filepath.Join(dir, "Cargo.toml")
+
+	if got := cargoManifestValue(manifest, "package", "name"); got != "my-ext" {
+		t.Fatalf("expected package name my-ext, got %q", got)
+	}
+	if got := cargoManifestValue(manifest, "lib", "name"); got != "my_ext_native" {
+		t.Fatalf("expected lib name my_ext_native, got %q", got)
+	}
+	if got := cargoManifestValue(manifest, "lib", "path"); got != "" {
+		t.Fatalf("expected empty value for missing key, got %q", got)
+	}
+	if got := cargoManifestValue(filepath.Join(dir, "missing.toml"), "package", "name"); got != "" {
+		t.Fatalf("expected empty value for missing manifest, got %q", got)
+	}
+}
+
+func TestCargoBuilderSelectsPackageOutputs(t *testing.T) {
+	dir := t.TempDir()
+	writeCargoManifest(t, dir, "[package]\nname = \"my-ext\"\n")
+
+	builder := &CargoBuilder{}
+	outputs := []string{
+		"/target/release/libmy_ext.so",
+		"/target/release/libdependency.so",
+	}
+
+	libName := builder.getCargoLibName(&BuildConfig{}, dir)
+	if libName != "my_ext" {
+		t.Fatalf("expected lib name from manifest, got %q", libName)
+	}
+
+	matched := builder.filterCargoOutputs(outputs, libName)
+	if !reflect.DeepEqual(matched, []string{"/target/release/libmy_ext.so"}) {
+		t.Fatalf("unexpected outputs for manifest crate: %v", matched)
+	}
+
+	libName = builder.getCargoLibName(&BuildConfig{CargoPackage: "dependency"}, dir)
+	matched = builder.filterCargoOutputs(outputs, libName)
+	if !reflect.DeepEqual(matched, []string{"/target/release/libdependency.so"}) {
+		t.Fatalf("unexpected outputs for CargoPackage: %v", matched)
+	}
+}
+
+func TestCargoBuilderUsesRustMakefileModule(t *testing.T) {
+	gemDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "fast_parser")
+	writeCargoManifest(t, extDir, "[package]\nname = \"fast-parser\"\n\n[lib]\nname = \"fast_parser_rs\"\n")
+
+	extconf := "require 'rb_sys/mkmf'\ncreate_rust_makefile(\"fast_parser/fast_parser\")\n"
+	if err := os.WriteFile(filepath.Join(extDir, "extconf.rb"), []byte(extconf), 0o600); err != nil {
+		t.Fatalf("failed to write extconf.rb: %v", err)
+	}
+
+	builder := &CargoBuilder{}
+	libName := builder.getCargoLibName(&BuildConfig{}, extDir)
+	if libName != "fast_parser_rs" {
+		t.Fatalf("expected [lib] name to win over [package] name, got %q", libName)
+	}
+
+	module := moduleFromExtconf(filepath.Join(extDir, "extconf.rb"))
+	name := builder.getRubyExtensionName(&BuildConfig{}, "/target/release/libfast_parser_rs.so", libName, module)
+	if name != "fast_parser"+filepath.Ext(name) {
+		t.Fatalf("expected module base name, got %q", name)
+	}
+
+	relPath := determineInstallRelativePath(&BuildConfig{GemDir: gemDir}, "ext/fast_parser/Cargo.toml", name)
+	expected := filepath.Join("fast_parser", name)
+	if relPath != expected {
+		t.Fatalf("expected install path %q, got %q", expected, relPath)
+	}
+}
+
+func TestCargoBuilderMergesRustFlags(t *testing.T) {
+	t.Setenv("RUSTFLAGS", "-C debuginfo=1")
+
+	builder := &CargoBuilder{}
+	config := &BuildConfig{RustFlags: []string{"-C", "target-cpu=native"}}
+
+	expected := "RUSTFLAGS=-C debuginfo=1 --cfg=rb_sys_gem --cfg=rubygems -C target-cpu=native"
+	env := builder.getRubyEnv(config)
+	if env[0] != expected {
+		t.Fatalf("expected %q, got %q", expected, env[0])
+	}
+
+	config.Env = map[string]string{"RUSTFLAGS": "-D warnings"}
+	expected = "RUSTFLAGS=-D warnings --cfg=rb_sys_gem --cfg=rubygems -C target-cpu=native"
+	env = builder.getRubyEnv(config)
+	if env[0] != expected {
+		t.Fatalf("expected config.Env RUSTFLAGS to take precedence, got %q", env[0])
+	}
+}
+
+func TestCargoBuilderWarningsAsErrors(t *testing.T) {
+	t.Setenv("RUSTFLAGS", "")
+
+	builder := &CargoBuilder{}
+	config := &BuildConfig{WarningsAsErrors: true, RustFlags: []string{"-A", "dead_code"}}
+
+	expected := "RUSTFLAGS=--cfg=rb_sys_gem --cfg=rubygems -D warnings -A dead_code"
+	env := builder.getRubyEnv(config)
+	if env[0] != expected {
+		t.Fatalf("expected %q, got %q", expected, env[0])
+	}
+}
+
+func TestCargoBuilderExtNameTemplate(t *testing.T) {
+	builder := &CargoBuilder{}
+	suffix := rubyExtensionSuffix(&BuildConfig{})
+	ext := strings.TrimPrefix(suffix, ".")
+
+	testCases := []struct {
+		template string
+		module   string
+		expected string
+	}{
+		{"", "fast_parser/fast_parser", "fast_parser" + suffix},
+		{"{{module}}.{{ext}}", "fast_parser/fast_parser", filepath.Join("fast_parser", "fast_parser"+suffix)},
+		{"{{module}}.{{ext}}", "", "fast_parser_rs" + suffix},
+		{"lib/{{crate}}_native.{{ext}}", "fast_parser/fast_parser", filepath.Join("lib", "fast_parser_rs_native"+suffix)},
+		{"{{name}}-" + ext + ".{{ext}}", "fast_parser/fast_parser", "fast_parser-" + ext + suffix},
+	}
+
+	for _, tc := range testCases {
+		config := &BuildConfig{ExtNameTemplate: tc.template}
+		name, err := builder.rubyExtensionName(config, "/target/release/libfast_parser_rs.so", "fast_parser_rs", tc.module)
+		if err != nil {
+			t.Fatalf("template %q returned error: %v", tc.template, err)
+		}
+		if name != tc.expected {
+			t.Errorf("template %q: expected %q, got %q", tc.template, tc.expected, name)
+		}
+	}
+
+	for _, template := range []string{"{{crate}}.{{suffix}}", "../{{crate}}.{{ext}}", "/tmp/{{crate}}.{{ext}}"} {
+		config := &BuildConfig{ExtNameTemplate: template}
+		if _, err := builder.rubyExtensionName(config, "/target/release/libfast_parser_rs.so", "fast_parser_rs", ""); err == nil {
+			t.Errorf("expected template %q to be rejected", template)
+		}
+	}
+}
+
+func TestCargoBuilderUsesConfiguredTarget(t *testing.T) {
+	t.Setenv("CARGO_BUILD_TARGET", "x86_64-unknown-linux-gnu")
+
+	dir := t.TempDir()
+	writeCargoManifest(t, dir, "[package]\nname = \"my-ext\"\n")
+
+	builder := &CargoBuilder{}
+	config := &BuildConfig{CargoTarget: "aarch64-apple-darwin"}
+
+	args := strings.Join(builder.cargoArgs(config, dir), " ")
+	if !strings.Contains(args, "--target aarch64-apple-darwin") {
+		t.Fatalf("expected CargoTarget to override CARGO_BUILD_TARGET, got %q", args)
+	}
+	if args = strings.Join(builder.cargoArgs(&BuildConfig{}, dir), " "); !strings.Contains(args, "--target x86_64-unknown-linux-gnu") {
+		t.Fatalf("expected CARGO_BUILD_TARGET fallback, got %q", args)
+	}
+
+	libName := "libmy_ext.so"
+	switch runtime.GOOS {
+	case platformDarwin:
+		libName = "libmy_ext.dylib"
+	case platformWindows:
+		libName = "my_ext.dll"
+	}
+	releaseDir := filepath.Join(dir, "target", "aarch64-apple-darwin", "release")
+	if err := os.MkdirAll(releaseDir, 0o755); err != nil {
+		t.Fatalf("failed to create release dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(releaseDir, libName), []byte("binary"), 0o600); err != nil {
+		t.Fatalf("failed to write library: %v", err)
+	}
+
+	result := &BuildResult{}
+	if err := builder.processBuiltExtensions(context.Background(), config, dir, result); err != nil {
+		t.Fatalf("expected library in the configured target's directory to be found: %v", err)
+	}
+	if len(result.Extensions) == 0 || !strings.HasPrefix(result.Extensions[0], "my_ext.") {
+		t.Fatalf("expected my_ext extension, got %v", result.Extensions)
+	}
+}
+
+func TestCargoBuilderOffline(t *testing.T) {
+	dir := t.TempDir()
+	writeCargoManifest(t, dir, "[package]\nname = \"my-ext\"\n")
+
+	builder := &CargoBuilder{}
+	if args := builder.cargoArgs(&BuildConfig{}, dir); slices.Contains(args, "--offline") {
+		t.Fatalf("expected no --offline by default, got %v", args)
+	}
+
+	config := &BuildConfig{Offline: true}
+	args := builder.cargoArgs(config, dir)
+	separator := slices.Index(args, "--")
+	if index := slices.Index(args, "--offline"); index < 0 || index > separator {
+		t.Fatalf("expected --offline among the cargo arguments, got %v", args)
+	}
+
+	if env := buildCommandEnv(config); !slices.Contains(env, "CARGO_NET_OFFLINE=true") {
+		t.Fatal("expected CARGO_NET_OFFLINE=true in the build environment")
+	}
+}
+
+func TestCargoBuilderLockPolicy(t *testing.T) {
+	dir := t.TempDir()
+	writeCargoManifest(t, dir, "[package]\nname = \"my-ext\"\n")
+
+	builder := &CargoBuilder{}
+	lockFlags := func(policy CargoLockPolicy) []string {
+		var flags []string
+		for _, arg := range builder.cargoArgs(&BuildConfig{CargoLockPolicy: policy}, dir) {
+			if arg == "--locked" || arg == "--frozen" {
+				flags = append(flags, arg)
+			}
+		}
+		return flags
+	}
+
+	// Without Cargo.lock only the explicit policies pass a flag
+	if flags := lockFlags(CargoLockAuto); len(flags) != 0 {
+		t.Fatalf("expected no lock flags without Cargo.lock, got %v", flags)
+	}
+	if flags := lockFlags(CargoLockLocked); !reflect.DeepEqual(flags, []string{"--locked"}) {
+		t.Fatalf("expected --locked for CargoLockLocked, got %v", flags)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "Cargo.lock"), []byte("version = 3\n"), 0o600); err != nil {
+		t.Fatalf("failed to write Cargo.lock: %v", err)
+	}
+
+	testCases := []struct {
+		policy   CargoLockPolicy
+		expected []string
+	}{
+		{CargoLockAuto, []string{"--locked"}},
+		{CargoLockLocked, []string{"--locked"}},
+		{CargoLockFrozen, []string{"--frozen"}},
+		{CargoLockUpdate, nil},
+	}
+	for _, tc := range testCases {
+		if flags := lockFlags(tc.policy); !reflect.DeepEqual(flags, tc.expected) {
+			t.Errorf("policy %s: expected %v, got %v", tc.policy, tc.expected, flags)
+		}
+	}
+}
+
+func TestCargoCleanIsScopedToPackage(t *testing.T) {
+	dir := t.TempDir()
+	writeCargoManifest(t, dir, "[package]\nname = \"my-ext\"\n")
+
+	builder := &CargoBuilder{}
+	if pkg := builder.cleanPackage(&BuildConfig{}, dir); pkg != "my-ext" {
+		t.Errorf("expected package from Cargo.toml, got %q", pkg)
+	}
+	if pkg := builder.cleanPackage(&BuildConfig{CargoPackage: "other"}, dir); pkg != "other" {
+		t.Errorf("expected CargoPackage to win, got %q", pkg)
+	}
+}
+
+func TestCargoBuilderBuildStatic(t *testing.T) {
+	dir := t.TempDir()
+	writeCargoManifest(t, dir, "[package]\nname = \"my-ext\"\n")
+
+	builder := &CargoBuilder{}
+	config := &BuildConfig{BuildStatic: true}
+	if args := strings.Join(builder.cargoArgs(config, dir), " "); !strings.Contains(args, "--crate-type staticlib") {
+		t.Fatalf("expected staticlib crate type, got %q", args)
+	}
+
+	libName := "libmy_ext.a"
+	if runtime.GOOS == platformWindows {
+		libName = "my_ext.lib"
+	}
+	releaseDir := filepath.Join(dir, "target", "release")
+	if err := os.MkdirAll(releaseDir, 0o755); err != nil {
+		t.Fatalf("failed to create release dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(releaseDir, libName), []byte("archive"), 0o600); err != nil {
+		t.Fatalf("failed to write library: %v", err)
+	}
+
+	result := &BuildResult{}
+	if err := builder.processBuiltExtensions(context.Background(), config, dir, result); err != nil {
+		t.Fatalf("expected static library to be found: %v", err)
+	}
+	if expected := []string{"my_ext" + filepath.Ext(libName)}; !reflect.DeepEqual(result.Extensions, expected) {
+		t.Fatalf("expected %v, got %v", expected, result.Extensions)
+	}
+}
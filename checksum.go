@@ -0,0 +1,54 @@
+package rubyext
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// recordChecksums stores the SHA-256 of each built extension on the result
+// when config.Checksum is enabled.
+//
+// Checksums are keyed by the paths in result.Extensions. Relative paths are
+// resolved against config.GemDir.
+func recordChecksums(config *BuildConfig, result *BuildResult) error {
+	if !config.Checksum || len(result.Extensions) == 0 {
+		return nil
+	}
+
+	checksums := make(map[string]string, len(result.Extensions))
+	for _, extension := range result.Extensions {
+		path := filepath.FromSlash(extension)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(config.GemDir, path)
+		}
+
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return fmt.Errorf("failed to checksum %s: %w", extension, err)
+		}
+		checksums[extension] = sum
+	}
+
+	result.Checksums = checksums
+	return nil
+}
+
+// fileSHA256 returns the hex-encoded SHA-256 of a file, streaming its contents
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
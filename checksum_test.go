@@ -0,0 +1,41 @@
+package rubyext
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordChecksums(t *testing.T) {
+	gemDir := t.TempDir()
+	libDir := filepath.Join(gemDir, "lib")
+	if err := os.MkdirAll(libDir, 0o755); err != nil {
+		t.Fatalf("failed to create lib directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(libDir, "myext.so"), []byte("hello"), 0o600); err != nil {
+		t.Fatalf("failed to write extension: %v", err)
+	}
+
+	result := &BuildResult{Extensions: []string{"lib/myext.so"}}
+
+	if err := recordChecksums(&BuildConfig{GemDir: gemDir}, result); err != nil {
+		t.Fatalf("recordChecksums returned error: %v", err)
+	}
+	if result.Checksums != nil {
+		t.Fatalf("expected no checksums when disabled, got %v", result.Checksums)
+	}
+
+	if err := recordChecksums(&BuildConfig{GemDir: gemDir, Checksum: true}, result); err != nil {
+		t.Fatalf("recordChecksums returned error: %v", err)
+	}
+
+	expected := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if result.Checksums["lib/myext.so"] != expected {
+		t.Fatalf("expected checksum %s, got %v", expected, result.Checksums)
+	}
+
+	result = &BuildResult{Extensions: []string{"lib/missing.so"}}
+	if err := recordChecksums(&BuildConfig{GemDir: gemDir, Checksum: true}, result); err == nil {
+		t.Fatal("expected error for missing extension file")
+	}
+}
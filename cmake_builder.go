@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -67,29 +66,45 @@ func (b *CmakeBuilder) Build(ctx context.Context, config *BuildConfig, extension
 
 // Clean removes build artifacts
 func (b *CmakeBuilder) Clean(ctx context.Context, config *BuildConfig, extensionFile string) error {
-	extensionPath := filepath.Join(config.GemDir, extensionFile)
-	extensionDir := filepath.Dir(extensionPath)
+	if config.CleanArtifactsOnly {
+		return cleanArtifacts(ctx, config, extensionFile, b.findBuiltExtensions)
+	}
+
+	extensionDir := extensionBuildDir(config, extensionFile)
 
 	// Try cmake --build . --target clean first
-	cleanCmd := exec.CommandContext(ctx, "cmake", "--build", ".", "--target", "clean")
+	cleanCmd := execCommandContext(ctx, "cmake", "--build", ".", "--target", "clean")
 	cleanCmd.Dir = extensionDir
-	if err := cleanCmd.Run(); err != nil {
+	if err := cleanCmd.Run(); err != nil && ctx.Err() == nil {
 		// Fall back to make clean if available
 		makefilePath := filepath.Join(extensionDir, "Makefile")
-		if _, err := os.Stat(makefilePath); err == nil {
+		if _, err := osStat(makefilePath); err == nil {
 			makeProgram := b.getMakeProgram()
-			makeCmd := exec.CommandContext(ctx, makeProgram, "clean")
+			makeCmd := execCommandContext(ctx, makeProgram, "clean")
 			makeCmd.Dir = extensionDir
-			return makeCmd.Run()
+			return cleanResult(ctx, makeCmd.Run())
 		}
 	}
 
-	return nil
+	return cleanResult(ctx, nil)
 }
 
-// runCmake executes cmake to configure the build
-func (b *CmakeBuilder) runCmake(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
-	// Build cmake arguments
+// Explain returns the commands Build would run, without running anything
+func (b *CmakeBuilder) Explain(config *BuildConfig, _ string) ([]string, error) {
+	commands := []string{formatCommand("cmake", b.configureArgs(config))}
+	if config.CleanFirst {
+		commands = append(commands, formatCommand("cmake", []string{"--build", ".", "--target", "clean"}))
+	}
+	commands = append(commands, formatCommand("cmake", b.buildArgs(config)))
+	if config.DestPath != "" {
+		commands = append(commands, formatCommand("cmake", b.installArgs()))
+	}
+
+	return commands, nil
+}
+
+// configureArgs returns the arguments for the cmake configure step
+func (b *CmakeBuilder) configureArgs(config *BuildConfig) []string {
 	args := []string{"."}
 
 	// Set install prefix if dest path is specified
@@ -106,98 +121,195 @@ func (b *CmakeBuilder) runCmake(ctx context.Context, config *BuildConfig, extens
 		args = append(args, "-G", generator)
 	}
 
+	args = append(args, cmakeStandardArgs("C", config.CStandard)...)
+	args = append(args, cmakeStandardArgs("CXX", config.CXXStandard)...)
+	args = append(args, cmakeSanitizerArgs(config)...)
+
+	if config.ExportCompileCommands {
+		args = append(args, "-DCMAKE_EXPORT_COMPILE_COMMANDS=ON")
+	}
+
+	// Build x86_64 and arm64 slices into each macOS binary
+	if buildsUniversal(config) {
+		args = append(args, "-DCMAKE_OSX_ARCHITECTURES=x86_64;arm64")
+	}
+
 	// Add any custom build args
 	args = append(args, config.BuildArgs...)
 
-	cmd := exec.CommandContext(ctx, "cmake", args...)
+	return args
+}
+
+// cmakeStandardArgs translates a compiler -std= value such as "c11",
+// "gnu11" or "c++17" into CMAKE_<lang>_STANDARD and CMAKE_<lang>_EXTENSIONS
+// defines. The standard is required so CMake doesn't quietly fall back to
+// an older one.
+func cmakeStandardArgs(lang, standard string) []string {
+	if standard == "" {
+		return nil
+	}
+
+	extensions := "OFF"
+	version := standard
+	for _, prefix := range []string{"gnu++", "c++", "gnu", "c"} {
+		if rest, ok := strings.CutPrefix(standard, prefix); ok {
+			if strings.HasPrefix(prefix, "gnu") {
+				extensions = "ON"
+			}
+			version = rest
+			break
+		}
+	}
+
+	return []string{
+		fmt.Sprintf("-DCMAKE_%s_STANDARD=%s", lang, version),
+		fmt.Sprintf("-DCMAKE_%s_STANDARD_REQUIRED=ON", lang),
+		fmt.Sprintf("-DCMAKE_%s_EXTENSIONS=%s", lang, extensions),
+	}
+}
+
+// cmakeSanitizerArgs returns defines adding -fsanitize= for
+// config.Sanitizers to the compile flags (on top of CFLAGS/CXXFLAGS, which
+// CMake would otherwise initialize them from) and to the linker flags for
+// shared libraries and modules, which Ruby extensions are built as
+func cmakeSanitizerArgs(config *BuildConfig) []string {
+	var args []string
+	for _, define := range []struct{ name, env string }{
+		{"CMAKE_C_FLAGS", "CFLAGS"},
+		{"CMAKE_CXX_FLAGS", "CXXFLAGS"},
+		{"CMAKE_SHARED_LINKER_FLAGS", "LDFLAGS"},
+		{"CMAKE_MODULE_LINKER_FLAGS", "LDFLAGS"},
+	} {
+		if flags, ok := withSanitizerFlag(config, define.env); ok {
+			args = append(args, fmt.Sprintf("-D%s=%s", define.name, flags))
+		}
+	}
+	return args
+}
+
+// buildArgs returns the arguments for the cmake --build step
+func (b *CmakeBuilder) buildArgs(config *BuildConfig) []string {
+	// Use cmake --build for cross-platform building
+	args := []string{"--build", "."}
+
+	// Add parallel jobs if specified
+	if jobs := parallelJobs(config); jobs > 0 {
+		args = append(args, "--parallel", fmt.Sprintf("%d", jobs))
+	}
+
+	// Build configuration (Release by default)
+	return append(args, "--config", "Release")
+}
+
+// installArgs returns the arguments for the cmake --install step.
+//
+// The install is staged with DESTDIR (see installEnv) under a "/" prefix,
+// so relative destinations land in DestPath as they would with
+// CMAKE_INSTALL_PREFIX=DestPath, and absolute destinations such as
+// /usr/lib are redirected into DestPath instead of needing root.
+// Windows has no DESTDIR equivalent for drive-letter paths, so there the
+// install relies on CMAKE_INSTALL_PREFIX alone.
+func (b *CmakeBuilder) installArgs() []string {
+	args := []string{"--install", "."}
+	if runtime.GOOS != platformWindows {
+		args = append(args, "--prefix", "/")
+	}
+	return args
+}
+
+// installEnv returns the DESTDIR staging the install under config.DestPath
+func (b *CmakeBuilder) installEnv(config *BuildConfig) []string {
+	if runtime.GOOS == platformWindows {
+		return nil
+	}
+	return []string{fmt.Sprintf("DESTDIR=%s", config.DestPath)}
+}
+
+// runCmake executes cmake to configure the build
+func (b *CmakeBuilder) runCmake(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
+	appendWarningsAsErrorsNote(config, result, b.Name())
+	appendUniversalNote(config, result)
+
+	args := b.configureArgs(config)
+	cacheKey := configCacheKey(config, args)
+	if reuseConfigCache(config, result, extensionDir, "CMakeCache.txt", "CMakeLists.txt", cacheKey) {
+		return nil
+	}
+	invalidateConfigCache(extensionDir)
+
+	cmd := execCommandContext(ctx, "cmake", args...)
 	cmd.Dir = extensionDir
 
 	// Set environment variables
-	cmd.Env = os.Environ()
-	for key, value := range config.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
-	}
+	cmd.Env = buildCommandEnv(config)
 
 	// Set Ruby-related CMake variables
 	if config.RubyPath != "" {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("Ruby_EXECUTABLE=%s", config.RubyPath))
 	}
 
-	output, err := cmd.CombinedOutput()
-	outputLines := strings.Split(string(output), "\n")
-	result.Output = append(result.Output, outputLines...)
-
-	if config.Verbose {
-		result.Output = append(result.Output,
-			fmt.Sprintf("Running: cmake %s", strings.Join(args, " ")),
-			fmt.Sprintf("Working directory: %s", extensionDir))
+	// Compile through the compiler cache if configured (CMake 3.17+)
+	if cachePath := compilerCachePath(config, result); cachePath != "" {
+		cmd.Env = append(cmd.Env,
+			fmt.Sprintf("CMAKE_C_COMPILER_LAUNCHER=%s", cachePath),
+			fmt.Sprintf("CMAKE_CXX_COMPILER_LAUNCHER=%s", cachePath))
 	}
 
+	output, err := combinedOutput(cmd)
+	appendCommandOutput(config, result, output)
+
+	appendCommandLog(config, result, cmd, err)
+
 	if err != nil {
 		return BuildError("CMake", result.Output, err)
 	}
 
+	saveConfigCache(config, extensionDir, cacheKey)
 	return nil
 }
 
 // runBuild executes the build command
 func (b *CmakeBuilder) runBuild(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
-	// Use cmake --build for cross-platform building
-	args := []string{"--build", "."}
-
-	// Add parallel jobs if specified
-	if config.Parallel > 0 {
-		args = append(args, "--parallel", fmt.Sprintf("%d", config.Parallel))
-	}
-
 	// Clean first if requested
 	if config.CleanFirst {
 		cleanArgs := []string{"--build", ".", "--target", "clean"}
-		cleanCmd := exec.CommandContext(ctx, "cmake", cleanArgs...)
+		cleanCmd := execCommandContext(ctx, "cmake", cleanArgs...)
 		cleanCmd.Dir = extensionDir
-		cleanOutput, _ := cleanCmd.CombinedOutput()
-		result.Output = append(result.Output, strings.Split(string(cleanOutput), "\n")...)
+		cleanOutput, _ := combinedOutput(cleanCmd)
+		appendCommandOutput(config, result, cleanOutput)
 	}
 
-	// Build configuration (Release by default)
-	args = append(args, "--config", "Release")
-
-	cmd := exec.CommandContext(ctx, "cmake", args...)
+	cmd := execCommandContext(ctx, "cmake", b.buildArgs(config)...)
 	cmd.Dir = extensionDir
 
 	// Set environment variables
-	cmd.Env = os.Environ()
-	for key, value := range config.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
-	}
+	cmd.Env = buildCommandEnv(config)
 
-	output, err := cmd.CombinedOutput()
-	outputLines := strings.Split(string(output), "\n")
-	result.Output = append(result.Output, outputLines...)
+	output, err := combinedOutput(cmd)
+	appendCommandOutput(config, result, output)
 
-	if config.Verbose {
-		result.Output = append(result.Output,
-			fmt.Sprintf("Running: cmake %s", strings.Join(args, " ")),
-			fmt.Sprintf("Working directory: %s", extensionDir))
-	}
+	appendCommandLog(config, result, cmd, err)
 
 	if err != nil {
 		return BuildError("CMake Build", result.Output, err)
 	}
 
+	if err = exportCompileCommands(config, result, extensionDir); err != nil {
+		return BuildError("CMake Build", result.Output, err)
+	}
+
 	// Run install if dest path is specified
 	if config.DestPath != "" {
-		installArgs := []string{"--install", "."}
-		installCmd := exec.CommandContext(ctx, "cmake", installArgs...)
+		installCmd := execCommandContext(ctx, "cmake", b.installArgs()...)
 		installCmd.Dir = extensionDir
 		installCmd.Env = cmd.Env
+		installCmd.Env = append(installCmd.Env, b.installEnv(config)...)
 
-		installOutput, err := installCmd.CombinedOutput()
-		installLines := strings.Split(string(installOutput), "\n")
-		result.Output = append(result.Output, installLines...)
+		installOutput, err := combinedOutput(installCmd)
+		appendCommandOutput(config, result, installOutput)
 
 		if err != nil {
-			return BuildError("CMake Install", result.Output, err)
+			return installStepError(config, result, "CMake Install", err)
 		}
 	}
 
@@ -229,12 +341,12 @@ func (b *CmakeBuilder) findBuiltExtensions(extensionDir string) ([]string, error
 
 	for _, searchDir := range searchDirs {
 		fullSearchDir := filepath.Join(extensionDir, searchDir)
-		if _, err := os.Stat(fullSearchDir); os.IsNotExist(err) {
+		if _, err := osStat(fullSearchDir); os.IsNotExist(err) {
 			continue
 		}
 
 		for _, pattern := range patterns {
-			matches, err := filepath.Glob(filepath.Join(fullSearchDir, pattern))
+			matches, err := filepathGlob(filepath.Join(fullSearchDir, pattern))
 			if err != nil {
 				return nil, fmt.Errorf("failed to glob pattern %s in %s: %v", pattern, fullSearchDir, err)
 			}
@@ -283,6 +395,6 @@ func (b *CmakeBuilder) getMakeProgram() string {
 	case platformWindows:
 		return nmakeProgram
 	default:
-		return makeProgram
+		return unixMakeProgram(runtime.GOOS)
 	}
 }
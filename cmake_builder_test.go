@@ -0,0 +1,152 @@
+package rubyext
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCmakeBuilderStagesInstallWithDestDir(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("DESTDIR staging is not used on Windows")
+	}
+
+	toolDir := t.TempDir()
+	writeTestScript(t, filepath.Join(toolDir, "cmake"), `#!/bin/sh
+if [ "$1" = "--install" ]; then
+  echo "$DESTDIR|$*" > install.txt
+fi
+`)
+	t.Setenv("PATH", toolDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	gemDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "myext")
+	if err := os.MkdirAll(extDir, 0o755); err != nil {
+		t.Fatalf("failed to create extension dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(extDir, "CMakeLists.txt"), []byte(""), 0o600); err != nil {
+		t.Fatalf("failed to write CMakeLists.txt: %v", err)
+	}
+
+	destDir := t.TempDir()
+	config := &BuildConfig{GemDir: gemDir, DestPath: destDir}
+
+	result, err := (&CmakeBuilder{}).Build(context.Background(), config, "ext/myext/CMakeLists.txt")
+	if err != nil || !result.Success {
+		t.Fatalf("expected build to succeed, got %v", err)
+	}
+
+	install, err := os.ReadFile(filepath.Join(extDir, "install.txt"))
+	if err != nil {
+		t.Fatalf("expected cmake --install to run: %v", err)
+	}
+
+	expected := destDir + "|--install . --prefix /"
+	if strings.TrimSpace(string(install)) != expected {
+		t.Fatalf("expected install %q, got %q", expected, strings.TrimSpace(string(install)))
+	}
+}
+
+func TestCmakeBuilderRunsThroughCommandHooks(t *testing.T) {
+	origLookPath, origCmdCtx, origGlob := execLookPath, execCommandContext, filepathGlob
+	defer func() {
+		execLookPath, execCommandContext, filepathGlob = origLookPath, origCmdCtx, origGlob
+	}()
+
+	// No cmake is installed: every command and lookup goes through the hooks
+	t.Setenv("PATH", "")
+	execLookPath = func(name string) (string, error) { return "/usr/bin/" + name, nil }
+	var commands []string
+	helper := helperCommand(0)
+	execCommandContext = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		return helper(ctx, name, args...)
+	}
+	var globbed bool
+	filepathGlob = func(string) ([]string, error) {
+		globbed = true
+		return nil, nil
+	}
+
+	gemDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "myext")
+	if err := os.MkdirAll(extDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	config := &BuildConfig{GemDir: gemDir, Env: map[string]string{"GO_WANT_HELPER_PROCESS": "1"}}
+
+	result, err := (&CmakeBuilder{}).Build(context.Background(), config, "ext/myext/CMakeLists.txt")
+	if err != nil || len(result.Extensions) != 0 {
+		t.Fatalf("expected the faked build to succeed without extensions, got %v, %v", result.Extensions, err)
+	}
+	if len(commands) != 2 || !strings.HasPrefix(commands[0], "cmake ") || !strings.HasPrefix(commands[1], "cmake --build") {
+		t.Fatalf("expected cmake configure and build through execCommandContext, got %q", commands)
+	}
+	if !globbed {
+		t.Fatal("expected artifacts to be searched through filepathGlob")
+	}
+}
+
+func TestCmakeBuilderCompilerCacheLauncher(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("shell script toolchain requires a POSIX shell")
+	}
+
+	toolDir := t.TempDir()
+	writeTestScript(t, filepath.Join(toolDir, "cmake"), `#!/bin/sh
+if [ "$1" = "." ]; then
+  echo "$CMAKE_C_COMPILER_LAUNCHER|$CMAKE_CXX_COMPILER_LAUNCHER" > launcher.txt
+fi
+`)
+	writeTestScript(t, filepath.Join(toolDir, "ccache"), "#!/bin/sh\nexec \"$@\"\n")
+	t.Setenv("PATH", toolDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	gemDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "myext")
+	if err := os.MkdirAll(extDir, 0o755); err != nil {
+		t.Fatalf("failed to create extension dir: %v", err)
+	}
+
+	config := &BuildConfig{GemDir: gemDir, CompilerCache: "ccache"}
+	result, err := (&CmakeBuilder{}).Build(context.Background(), config, "ext/myext/CMakeLists.txt")
+	if err != nil || !result.Success {
+		t.Fatalf("expected build to succeed, got %v", err)
+	}
+
+	launcher, err := os.ReadFile(filepath.Join(extDir, "launcher.txt"))
+	if err != nil {
+		t.Fatalf("expected cmake configure to run: %v", err)
+	}
+
+	ccache := filepath.Join(toolDir, "ccache")
+	if strings.TrimSpace(string(launcher)) != ccache+"|"+ccache {
+		t.Fatalf("expected compiler launchers set to %s, got %q", ccache, strings.TrimSpace(string(launcher)))
+	}
+}
+
+func TestCmakeBuilderLanguageStandards(t *testing.T) {
+	config := &BuildConfig{CStandard: "gnu11", CXXStandard: "c++17", BuildArgs: []string{"-DCMAKE_CXX_STANDARD=20"}}
+	args := (&CmakeBuilder{}).configureArgs(config)
+
+	for _, define := range []string{
+		"-DCMAKE_C_STANDARD=11",
+		"-DCMAKE_C_EXTENSIONS=ON",
+		"-DCMAKE_CXX_STANDARD=17",
+		"-DCMAKE_CXX_STANDARD_REQUIRED=ON",
+		"-DCMAKE_CXX_EXTENSIONS=OFF",
+	} {
+		if !slices.Contains(args, define) {
+			t.Errorf("expected %s in %v", define, args)
+		}
+	}
+
+	// BuildArgs come last so they can override the configured standard
+	if args[len(args)-1] != "-DCMAKE_CXX_STANDARD=20" {
+		t.Fatalf("expected BuildArgs after the standard defines, got %v", args)
+	}
+}
@@ -1,8 +1,18 @@
 package rubyext
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
 )
 
 // runCommonBuild executes the standard 3-step build process.
@@ -19,11 +29,14 @@ import (
 // # Process Flow
 //
 //  1. Create empty BuildResult
-//  2. Calculate extension directory from extensionFile path
-//  3. Call ConfigureFunc to prepare the build
-//  4. Call BuildFunc to compile the extension
-//  5. Call FindFunc to locate compiled files
-//  6. Return BuildResult with Success=true
+//  2. Calculate extension directory (a working copy if config.BuildDir is set)
+//  3. Run config.PreBuildCommands
+//  4. Call ConfigureFunc to prepare the build
+//  5. Call BuildFunc to compile the extension
+//  6. Call FindFunc to locate compiled files
+//  7. Run config.PostBuildCommands
+//  8. Install native libraries into DestPath or LibDir, if set
+//  9. Return BuildResult with Success=true
 //
 // If any step fails, processing stops and the error is returned
 // with Success=false.
@@ -91,37 +104,431 @@ func runCommonBuild(ctx context.Context, config *BuildConfig, extensionFile stri
 		Output:  []string{},
 	}
 
-	// Calculate extension directory
-	extensionPath := filepath.Join(config.GemDir, extensionFile)
-	extensionDir := filepath.Dir(extensionPath)
+	// Calculate extension directory, using a working copy if BuildDir is set
+	extensionDir, err := prepareBuildDir(config, extensionFile)
+	if err != nil {
+		return failBuild(result, err)
+	}
+
+	if err := runPreBuildCommands(ctx, config, extensionDir, result); err != nil {
+		return failBuild(result, err)
+	}
 
 	// Step 1: Configure/prepare the build
 	if err := steps.ConfigureFunc(ctx, config, extensionDir, result); err != nil {
-		result.Error = err
-		return result, err
+		return failBuild(result, err)
 	}
 
 	// Step 2: Build/compile the extension
 	if err := steps.BuildFunc(ctx, config, extensionDir, result); err != nil {
-		result.Error = err
-		return result, err
+		return failBuild(result, err)
 	}
 
 	// Step 3: Find the built extension files
-	extensions, err := steps.FindFunc(extensionDir)
+	extensions, err := findExtensions(config, extensionDir, steps.FindFunc)
 	if err != nil {
-		result.Error = err
-		return result, err
+		return failBuild(result, err)
 	}
 
-	finalized, err := finalizeNativeExtensions(config, extensionFile, extensionDir, extensions)
+	if err = runPostBuildCommands(ctx, config, extensionDir, result); err != nil {
+		return failBuild(result, err)
+	}
+
+	if err = checkArtifacts(config, extensionDir, extensions); err != nil {
+		return failBuild(result, err)
+	}
+
+	if err = postProcessExtensions(ctx, config, result, extensionDir, extensions); err != nil {
+		return failBuild(result, err)
+	}
+
+	result.BuiltArtifacts = buildOutputPaths(config, extensionFile, extensionDir, extensions)
+	finalized, err := finalizeNativeExtensions(config, result, extensionFile, extensionDir, extensions)
 	if err != nil {
-		result.Error = err
-		return result, err
+		return failBuild(result, err)
 	}
 
 	// Success!
 	result.Extensions = finalized
+
+	if err = recordChecksums(config, result); err != nil {
+		return failBuild(result, err)
+	}
+
 	result.Success = true
 	return result, nil
 }
+
+// maxParallelPerCPU is how many jobs per CPU config.Parallel may ask for
+// before it is treated as a mistake
+const maxParallelPerCPU = 4
+
+// parallelJobs returns the number of parallel jobs to pass to the build
+// tool, or 0 to use its default. Values of config.Parallel beyond
+// maxParallelPerCPU jobs per CPU, which would only exhaust memory and
+// process limits, are clamped to runtime.NumCPU().
+func parallelJobs(config *BuildConfig) int {
+	if config.Parallel <= 0 {
+		return 0
+	}
+	if cpus := runtime.NumCPU(); config.Parallel > cpus*maxParallelPerCPU {
+		return cpus
+	}
+	return config.Parallel
+}
+
+// checkArtifacts fails a build that found no extensions when
+// config.RequireArtifacts is set. Without it, such a build succeeds with
+// an empty Extensions list.
+func checkArtifacts(config *BuildConfig, extensionDir string, extensions []string) error {
+	if config.RequireArtifacts && len(extensions) == 0 {
+		return fmt.Errorf("%w: build reported success but produced no loadable extension in %s", ErrNoArtifacts, extensionDir)
+	}
+	return nil
+}
+
+// postProcessExtensions runs the optional steps applied to built libraries
+// before they are installed: install name fixes, stripping, then
+// config.PostProcess
+func postProcessExtensions(ctx context.Context, config *BuildConfig, result *BuildResult, extensionDir string, extensions []string) error {
+	if err := fixMachOInstallNames(ctx, config, result, extensionDir, extensions); err != nil {
+		return err
+	}
+	if err := stripExtensions(ctx, config, result, extensionDir, extensions); err != nil {
+		return err
+	}
+	return runPostProcess(ctx, config, result, extensionDir, extensions)
+}
+
+// defaultInstallTarget is the make target run when config.DestPath is set
+const defaultInstallTarget = "install"
+
+// installTarget returns the make target to run when config.DestPath is
+// set, or "" when config.SkipInstallTarget skips the install step
+func installTarget(config *BuildConfig) string {
+	switch {
+	case config.SkipInstallTarget:
+		return ""
+	case config.InstallTarget != "":
+		return config.InstallTarget
+	default:
+		return defaultInstallTarget
+	}
+}
+
+// installStepError returns the error for a failed install step. With
+// config.IgnoreInstallErrors set, the failure is noted in the output
+// instead and nil is returned, so the build goes on to collect the
+// compiled extensions.
+func installStepError(config *BuildConfig, result *BuildResult, step string, err error) error {
+	if !config.IgnoreInstallErrors {
+		return BuildError(step, result.Output, err)
+	}
+
+	result.Output = append(result.Output,
+		fmt.Sprintf("Note: %s failed, continuing because IgnoreInstallErrors is set: %v", step, err))
+	return nil
+}
+
+// cleanArtifacts removes the extension files find locates in the
+// extension's build directory, plus any matching config.ExtensionPatterns,
+// without running the build system's clean target. Builders use it for
+// config.CleanArtifactsOnly, so it works when the Makefile is gone.
+func cleanArtifacts(ctx context.Context, config *BuildConfig, extensionFile string, find func(string) ([]string, error)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	extensionDir := extensionBuildDir(config, extensionFile)
+	if _, err := osStat(extensionDir); os.IsNotExist(err) {
+		return nil // Nothing to clean
+	}
+
+	extensions, err := findExtensions(config, extensionDir, find)
+	if err != nil {
+		return err
+	}
+
+	for _, ext := range extensions {
+		if err := os.Remove(filepath.Join(extensionDir, ext)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", ext, err)
+		}
+	}
+
+	return nil
+}
+
+// cleanResult returns the error for a Clean method: the context's error
+// if it ended, so a deadline or cancellation isn't reported as success by
+// clean steps that ignore failures, otherwise err.
+func cleanResult(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// failBuild records err on the result, along with the exit code of the
+// failed command if there was one, and returns both for the caller.
+func failBuild(result *BuildResult, err error) (*BuildResult, error) {
+	result.Error = err
+	result.ExitCode = ExitCode(err)
+	return result, err
+}
+
+// buildCommandEnv returns the environment for a build command.
+//
+// The parent environment (all of it, or the allowlisted part with
+// config.CleanEnv set) is extended with config.Env. When config.StripANSI
+// is set, variables asking tools not to emit color codes are added as well.
+// When config.Offline is set, CARGO_NET_OFFLINE keeps Cargo from fetching
+// dependencies, including when rb-sys runs it from extconf.rb or rake.
+func buildCommandEnv(config *BuildConfig) []string {
+	env := inheritedEnv(config)
+	for key, value := range config.Env {
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	}
+
+	if config.StripANSI {
+		env = append(env, "TERM=dumb", "NO_COLOR=1", "CARGO_TERM_COLOR=never")
+	}
+
+	if config.Offline {
+		env = append(env, "CARGO_NET_OFFLINE=true")
+	}
+
+	return env
+}
+
+// commandStdin returns the input for commands that may prompt:
+// config.Stdin if set, otherwise an empty reader so a prompt sees end of
+// input and fails instead of waiting for an answer.
+func commandStdin(config *BuildConfig) io.Reader {
+	if config.Stdin != nil {
+		return config.Stdin
+	}
+	return strings.NewReader("")
+}
+
+// envValue returns the value of an environment variable as build commands
+// will see it: config.Env takes precedence over the process environment,
+// which with config.CleanEnv set only counts for allowlisted variables.
+func envValue(config *BuildConfig, key string) string {
+	if value, ok := config.Env[key]; ok {
+		return value
+	}
+	if !isEnvInherited(config, key) {
+		return ""
+	}
+	return os.Getenv(key)
+}
+
+// cleanEnvAllowlist names the variables build commands inherit when
+// config.CleanEnv is set, in addition to config.EnvAllowlist
+var cleanEnvAllowlist = []string{"PATH", "HOME", "LANG", "TMPDIR"}
+
+// windowsEnvAllowlist names the variables programs need to start on
+// Windows, inherited with config.CleanEnv set there
+var windowsEnvAllowlist = []string{"SystemRoot", "ComSpec", "PATHEXT", "TEMP", "TMP", "USERPROFILE"}
+
+// inheritedEnv returns the part of the process environment build commands
+// start from: all of it, or with config.CleanEnv set only the allowlisted
+// variables
+func inheritedEnv(config *BuildConfig) []string {
+	if !config.CleanEnv {
+		return os.Environ()
+	}
+
+	var env []string
+	for _, entry := range os.Environ() {
+		key, _, _ := strings.Cut(entry, "=")
+		if isEnvInherited(config, key) {
+			env = append(env, entry)
+		}
+	}
+	return env
+}
+
+// isEnvInherited reports whether build commands inherit the process
+// environment variable key. Names are compared case-insensitively on
+// Windows, where the environment is.
+func isEnvInherited(config *BuildConfig, key string) bool {
+	if !config.CleanEnv {
+		return true
+	}
+
+	allowlists := [][]string{cleanEnvAllowlist, config.EnvAllowlist}
+	if runtime.GOOS == platformWindows {
+		allowlists = append(allowlists, windowsEnvAllowlist)
+	}
+	for _, allowlist := range allowlists {
+		for _, name := range allowlist {
+			if name == key || (runtime.GOOS == platformWindows && strings.EqualFold(name, key)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// appendWarningsAsErrorsNote records that config.WarningsAsErrors has no
+// effect for a builder whose build system can't express it.
+func appendWarningsAsErrorsNote(config *BuildConfig, result *BuildResult, builder string) {
+	if config.WarningsAsErrors {
+		result.Output = append(result.Output,
+			fmt.Sprintf("Note: WarningsAsErrors is not supported by the %s builder, warnings will not fail the build", builder))
+	}
+}
+
+// compilerCachePath resolves config.CompilerCache to an executable.
+//
+// Returns "" when no cache is configured. A configured cache that can't
+// be found is noted in the output rather than failing the build, since
+// the cache only affects speed.
+func compilerCachePath(config *BuildConfig, result *BuildResult) string {
+	if config.CompilerCache == "" {
+		return ""
+	}
+
+	path, err := execLookPath(config.CompilerCache)
+	if err != nil {
+		result.Output = append(result.Output,
+			fmt.Sprintf("Note: compiler cache %s not found, building without it", config.CompilerCache))
+		return ""
+	}
+
+	return path
+}
+
+// combinedOutput runs cmd and returns its stdout and stderr interleaved,
+// like cmd.CombinedOutput.
+//
+// Both streams are read line by line while the command runs, so when the
+// context cancels a long build everything written up to the kill is
+// still returned along with the error. The command runs in its own
+// process group (a job object on Windows) that is killed as a whole on
+// cancellation, see startProcessGroup.
+func combinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := startProcessGroup(cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var mu sync.Mutex
+	var output bytes.Buffer
+	drain := func(pipe io.Reader) {
+		reader := bufio.NewReader(pipe)
+		for {
+			line, readErr := reader.ReadBytes('\n')
+			mu.Lock()
+			output.Write(line)
+			mu.Unlock()
+			if readErr != nil {
+				return
+			}
+		}
+	}
+
+	// All reads must finish before Wait closes the pipes
+	var wg sync.WaitGroup
+	wg.Go(func() { drain(stdout) })
+	wg.Go(func() { drain(stderr) })
+	wg.Wait()
+
+	err = cmd.Wait()
+	return output.Bytes(), err
+}
+
+// appendCommandOutput splits captured command output into lines and
+// appends them to the result, stripping ANSI escape codes if configured.
+// With config.CollectWarnings set, warning lines are also added to
+// result.Warnings. The output is then capped to config.MaxOutputLines.
+func appendCommandOutput(config *BuildConfig, result *BuildResult, output []byte) {
+	text := string(output)
+	if config.StripANSI {
+		text = stripANSI(text)
+	}
+
+	lines := strings.Split(text, "\n")
+	result.Output = append(result.Output, lines...)
+
+	if config.CollectWarnings {
+		collectWarnings(result, lines)
+	}
+
+	capOutput(config, result)
+}
+
+// capOutput keeps result.Output within config.MaxOutputLines by keeping
+// the first and last half of the lines and replacing the ones in between
+// with a marker. The count in the marker grows as later output is capped.
+func capOutput(config *BuildConfig, result *BuildResult) {
+	limit := config.MaxOutputLines
+	if limit <= 0 {
+		return
+	}
+
+	head := limit / 2
+	tail := limit - head
+
+	kept := len(result.Output)
+	if result.truncatedLines > 0 {
+		kept-- // The marker left by an earlier cap
+	}
+	if kept <= limit {
+		return
+	}
+
+	rest := result.Output[head:]
+	if result.truncatedLines > 0 {
+		rest = result.Output[head+1:]
+	}
+
+	dropped := len(rest) - tail
+	result.truncatedLines += dropped
+
+	capped := make([]string, 0, limit+1)
+	capped = append(capped, result.Output[:head]...)
+	capped = append(capped, fmt.Sprintf("...<truncated %d lines>...", result.truncatedLines))
+	capped = append(capped, rest[dropped:]...)
+	result.Output = capped
+}
+
+// prefixOutput prefixes each line of result.Output with
+// "[<builder>:<extension>]" when config.PrefixOutput is set, naming the
+// extension by its directory. Only the human-facing Output is prefixed;
+// Warnings and Commands are left as captured.
+func prefixOutput(config *BuildConfig, result *BuildResult) {
+	if !config.PrefixOutput {
+		return
+	}
+
+	extension := filepath.Base(filepath.Dir(result.ExtensionFile))
+	if extension == "." || extension == string(filepath.Separator) {
+		extension = result.ExtensionFile
+	}
+	prefix := fmt.Sprintf("[%s:%s] ", result.BuilderName, extension)
+
+	for i, line := range result.Output {
+		result.Output[i] = prefix + line
+	}
+}
+
+// ansiEscapePattern matches CSI sequences (colors, cursor movement) and
+// OSC sequences (hyperlinks, window titles) emitted by terminal-aware tools.
+var ansiEscapePattern = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)`)
+
+// stripANSI removes ANSI escape sequences from s
+func stripANSI(s string) string {
+	return ansiEscapePattern.ReplaceAllString(s, "")
+}
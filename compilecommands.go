@@ -0,0 +1,56 @@
+package rubyext
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// compileCommandsFile is the compilation database read by clangd,
+// clang-tidy and other C tooling
+const compileCommandsFile = "compile_commands.json"
+
+// withBear wraps a make command in bear (bear -- make ...), which records
+// the compiler invocations in compile_commands.json, when
+// config.ExportCompileCommands is set. Without bear the command is
+// returned unchanged and the output notes that no database is generated.
+func withBear(config *BuildConfig, result *BuildResult, name string, args []string) (string, []string) {
+	if !config.ExportCompileCommands {
+		return name, args
+	}
+
+	bearPath, err := execLookPath("bear")
+	if err != nil {
+		result.Output = append(result.Output,
+			fmt.Sprintf("Note: bear not found, %s is not generated for this build", compileCommandsFile))
+		return name, args
+	}
+	return bearPath, append([]string{"--", name}, args...)
+}
+
+// exportCompileCommands records the compile_commands.json a build left in
+// extensionDir on result.CompileCommands when config.ExportCompileCommands
+// is set. With config.DestPath set, the file is copied there and the copy
+// is recorded instead.
+func exportCompileCommands(config *BuildConfig, result *BuildResult, extensionDir string) error {
+	if !config.ExportCompileCommands {
+		return nil
+	}
+
+	generated := filepath.Join(extensionDir, compileCommandsFile)
+	if _, err := osStat(generated); os.IsNotExist(err) {
+		return nil
+	}
+
+	if config.DestPath == "" {
+		result.CompileCommands = generated
+		return nil
+	}
+
+	exported := filepath.Join(config.DestPath, compileCommandsFile)
+	if err := copyFile(generated, exported); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", compileCommandsFile, config.DestPath, err)
+	}
+	result.CompileCommands = exported
+	return nil
+}
@@ -0,0 +1,89 @@
+package rubyext
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExtConfBuilderExportsCompileCommands(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("shell script toolchain requires a POSIX shell")
+	}
+
+	toolDir := t.TempDir()
+	makePath := filepath.Join(toolDir, "make")
+	writeTestScript(t, makePath, "#!/bin/sh\necho \"make $*\"\n")
+	t.Setenv("MAKE", makePath)
+	bearPath := filepath.Join(toolDir, "bear")
+	writeTestScript(t, bearPath, `#!/bin/sh
+echo '[]' > compile_commands.json
+shift
+exec "$@"
+`)
+
+	origLookPath := execLookPath
+	defer func() { execLookPath = origLookPath }()
+	execLookPath = func(string) (string, error) { return "", errors.New("not found") }
+
+	extDir := t.TempDir()
+	destDir := t.TempDir()
+	config := &BuildConfig{ExportCompileCommands: true, DestPath: destDir}
+
+	// Without bear the build goes ahead without a database
+	result := &BuildResult{}
+	if err := (&ExtConfBuilder{}).runMake(context.Background(), config, extDir, result); err != nil {
+		t.Fatalf("runMake returned error: %v", err)
+	}
+	if result.CompileCommands != "" || !strings.Contains(strings.Join(result.Output, "\n"), "bear not found") {
+		t.Fatalf("expected a note about missing bear, got %q / %v", result.CompileCommands, result.Output)
+	}
+
+	execLookPath = func(name string) (string, error) {
+		if name == "bear" {
+			return bearPath, nil
+		}
+		return "", errors.New("not found")
+	}
+	result = &BuildResult{}
+	if err := (&ExtConfBuilder{}).runMake(context.Background(), config, extDir, result); err != nil {
+		t.Fatalf("runMake returned error: %v", err)
+	}
+	expected := filepath.Join(destDir, compileCommandsFile)
+	if result.CompileCommands != expected {
+		t.Fatalf("expected compile commands copied to %s, got %q", expected, result.CompileCommands)
+	}
+	if _, err := os.Stat(expected); err != nil {
+		t.Fatalf("expected %s to exist: %v", expected, err)
+	}
+	if !strings.Contains(strings.Join(result.Output, "\n"), "make ") {
+		t.Fatalf("expected make to run under bear, got %v", result.Output)
+	}
+}
+
+func TestCmakeBuilderExportCompileCommands(t *testing.T) {
+	builder := &CmakeBuilder{}
+	if args := builder.configureArgs(&BuildConfig{}); slices.Contains(args, "-DCMAKE_EXPORT_COMPILE_COMMANDS=ON") {
+		t.Fatalf("expected no compile commands export by default, got %v", args)
+	}
+
+	args := builder.configureArgs(&BuildConfig{ExportCompileCommands: true})
+	if !slices.Contains(args, "-DCMAKE_EXPORT_COMPILE_COMMANDS=ON") {
+		t.Fatalf("expected CMAKE_EXPORT_COMPILE_COMMANDS, got %v", args)
+	}
+
+	extDir := t.TempDir()
+	generated := filepath.Join(extDir, compileCommandsFile)
+	if err := os.WriteFile(generated, []byte("[]"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	result := &BuildResult{}
+	if err := exportCompileCommands(&BuildConfig{ExportCompileCommands: true}, result, extDir); err != nil || result.CompileCommands != generated {
+		t.Fatalf("expected the generated file to be recorded in place, got %q (%v)", result.CompileCommands, err)
+	}
+}
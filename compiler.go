@@ -0,0 +1,110 @@
+package rubyext
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// compilerRequirement is the ToolRequirement name builders use for the C
+// compiler; config.RequireCompiler is checked for builders declaring it
+const compilerRequirement = "gcc"
+
+// compilerIdentityTimeout bounds how long the compiler's --version may take
+const compilerIdentityTimeout = 30 * time.Second
+
+// compilerWrappers are launchers that may prefix CC in front of the compiler
+var compilerWrappers = map[string]bool{"ccache": true, "sccache": true}
+
+// effectiveCompiler returns the C compiler a build will use: the compiler
+// named by CC (skipping a ccache or sccache prefix and any flags), or the
+// first of gcc, clang, cc and cl found on PATH. It returns "" if there is
+// none.
+func effectiveCompiler(config *BuildConfig) string {
+	for _, field := range strings.Fields(envValue(config, "CC")) {
+		if !compilerWrappers[filepath.Base(field)] {
+			return field
+		}
+	}
+
+	for _, candidate := range []string{"gcc", "clang", "cc", "cl"} {
+		if path, err := execLookPath(candidate); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// compilerIdentity runs the compiler with --version and returns which
+// compiler it is: "clang", "gcc" or "msvc". Clang is checked first because
+// Apple ships it as gcc.
+func compilerIdentity(ctx context.Context, compiler string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, compilerIdentityTimeout)
+	defer cancel()
+
+	// cl rejects --version but still prints its banner, so the exit
+	// status is only reported when nothing can be identified
+	output, err := execCommandContext(ctx, compiler, "--version").CombinedOutput()
+	text := strings.ToLower(string(output))
+
+	switch {
+	case strings.Contains(text, "clang"):
+		return "clang", nil
+	case strings.Contains(text, "gcc") || strings.Contains(text, "free software foundation"):
+		return "gcc", nil
+	case strings.Contains(text, "microsoft"):
+		return "msvc", nil
+	case err != nil:
+		return "", fmt.Errorf("failed to run %s --version: %w", compiler, err)
+	default:
+		firstLine, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+		return "", fmt.Errorf("unrecognized compiler %s: %q", compiler, firstLine)
+	}
+}
+
+// checkRequiredCompiler verifies that the effective C compiler is the one
+// named by config.RequireCompiler ("gcc", "clang" or "msvc", with "cl" as
+// an alias for msvc). It returns a *CompilerMismatchError otherwise.
+func checkRequiredCompiler(ctx context.Context, config *BuildConfig) error {
+	required := strings.ToLower(config.RequireCompiler)
+	if required == "" {
+		return nil
+	}
+	if required == "cl" {
+		required = "msvc"
+	}
+
+	compiler := effectiveCompiler(config)
+	if compiler == "" {
+		return &CompilerMismatchError{Required: config.RequireCompiler, Err: fmt.Errorf("no C compiler found")}
+	}
+
+	identity, err := compilerIdentity(ctx, compiler)
+	if err != nil {
+		return &CompilerMismatchError{Required: config.RequireCompiler, Compiler: compiler, Err: err}
+	}
+	if identity != required {
+		return &CompilerMismatchError{Required: config.RequireCompiler, Compiler: compiler, Identity: identity}
+	}
+
+	return nil
+}
+
+// needsCompilerCheck reports whether the builder compiles C and so is
+// subject to config.RequireCompiler
+func needsCompilerCheck(config *BuildConfig, builder Builder) bool {
+	checker, ok := builder.(ToolChecker)
+	if config.RequireCompiler == "" || !ok {
+		return false
+	}
+
+	for _, req := range checker.RequiredTools() {
+		if req.Name == compilerRequirement {
+			return true
+		}
+	}
+	return false
+}
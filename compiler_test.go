@@ -0,0 +1,84 @@
+package rubyext
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestCheckRequiredCompiler(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("fake compilers require a POSIX shell")
+	}
+
+	toolDir := t.TempDir()
+	writeTestScript(t, filepath.Join(toolDir, "gcc"),
+		"#!/bin/sh\necho 'gcc (GCC) 13.2.0'\necho 'This is free software; see the source for copying conditions.'\n")
+	writeTestScript(t, filepath.Join(toolDir, "clang"), "#!/bin/sh\necho 'Apple clang version 15.0.0 (clang-1500.3.9.4)'\n")
+	writeTestScript(t, filepath.Join(toolDir, "broken"), "#!/bin/sh\nexit 1\n")
+	t.Setenv("PATH", toolDir)
+	t.Setenv("CC", "")
+
+	ctx := context.Background()
+	if err := checkRequiredCompiler(ctx, &BuildConfig{RequireCompiler: "gcc"}); err != nil {
+		t.Fatalf("expected gcc from PATH to satisfy the requirement, got %v", err)
+	}
+
+	config := &BuildConfig{RequireCompiler: "gcc", Env: map[string]string{"CC": "ccache clang -m64"}}
+	err := checkRequiredCompiler(ctx, config)
+	var mismatch *CompilerMismatchError
+	if !errors.Is(err, ErrCompilerMismatch) || !errors.As(err, &mismatch) {
+		t.Fatalf("expected CompilerMismatchError for clang, got %v", err)
+	}
+	if mismatch.Compiler != "clang" || mismatch.Identity != "clang" {
+		t.Fatalf("expected clang identified from CC, got %+v", mismatch)
+	}
+
+	config.RequireCompiler = "clang"
+	if err := checkRequiredCompiler(ctx, config); err != nil {
+		t.Fatalf("expected clang to satisfy the requirement, got %v", err)
+	}
+
+	config.Env["CC"] = "broken"
+	if err := checkRequiredCompiler(ctx, config); !errors.Is(err, ErrCompilerMismatch) {
+		t.Fatalf("expected unidentifiable compiler to fail the check, got %v", err)
+	}
+}
+
+func TestBuildWithRequireCompiler(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("fake compilers require a POSIX shell")
+	}
+
+	toolDir := t.TempDir()
+	writeTestScript(t, filepath.Join(toolDir, "gcc"), "#!/bin/sh\necho 'clang version 18.1.8'\n")
+	t.Setenv("PATH", toolDir)
+	t.Setenv("CC", "")
+
+	builder := &toolCheckingBuilder{
+		mockBuilder: mockBuilder{name: "ExtConf"},
+		tools:       []ToolRequirement{{Name: "gcc", Alternatives: []string{"clang", "cc", "cl"}}},
+	}
+	factory := &BuilderFactory{}
+
+	config := &BuildConfig{RequireCompiler: "gcc"}
+	result, err := factory.BuildWith(context.Background(), config, builder, "ext/myext/extconf.rb")
+	if !errors.Is(err, ErrCompilerMismatch) {
+		t.Fatalf("expected compiler mismatch, got %v", err)
+	}
+	if builder.buildCalls != 0 {
+		t.Fatal("expected Build not to be called with the wrong compiler")
+	}
+	if !reflect.DeepEqual(result.MissingDependencies, []string{"gcc"}) {
+		t.Fatalf("expected gcc reported as missing, got %v", result.MissingDependencies)
+	}
+
+	// Builders that don't compile C are not checked
+	builder.tools = []ToolRequirement{{Name: "sh", Optional: true}}
+	if _, err := factory.BuildWith(context.Background(), config, builder, "ext/myext/extconf.rb"); err != nil {
+		t.Fatalf("expected builder without a C compiler requirement to build, got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package rubyext
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// configCacheFile is written next to a configure step's output with a
+// hash of the inputs that produced it, so config.ReuseConfigCache can tell
+// whether the output still matches the current config
+const configCacheFile = ".rubyext-config-cache"
+
+// configCacheKey hashes the inputs of a configure step: its arguments,
+// extra values the builder derives from the config, and the config's Ruby,
+// compiler cache and environment
+func configCacheKey(config *BuildConfig, args []string, extra ...string) string {
+	envNames := make([]string, 0, len(config.Env))
+	for name := range config.Env {
+		envNames = append(envNames, name)
+	}
+	sort.Strings(envNames)
+
+	parts := append([]string{}, args...)
+	parts = append(parts, "\x01")
+	parts = append(parts, extra...)
+	parts = append(parts, "\x01", config.RubyPath, config.CompilerCache)
+	for _, name := range envNames {
+		parts = append(parts, name+"="+config.Env[name])
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// reuseConfigCache reports whether a configure step can be skipped: with
+// config.ReuseConfigCache set and CleanFirst unset, its output (such as
+// CMakeCache.txt or Makefile) must be newer than its input (CMakeLists.txt,
+// extconf.rb) and have been produced from inputs hashing to key. Reuse is
+// noted in the output.
+func reuseConfigCache(config *BuildConfig, result *BuildResult, extensionDir, output, input, key string) bool {
+	if !config.ReuseConfigCache || config.CleanFirst {
+		return false
+	}
+
+	outputInfo, err := osStat(filepath.Join(extensionDir, output))
+	if err != nil {
+		return false
+	}
+	inputInfo, err := osStat(filepath.Join(extensionDir, input))
+	if err != nil || !outputInfo.ModTime().After(inputInfo.ModTime()) {
+		return false
+	}
+
+	saved, err := os.ReadFile(filepath.Join(extensionDir, configCacheFile))
+	if err != nil || strings.TrimSpace(string(saved)) != key {
+		return false
+	}
+
+	result.Output = append(result.Output, "Reusing "+output+": "+input+" and build arguments unchanged")
+	return true
+}
+
+// invalidateConfigCache removes the configure step's cache key before the
+// step runs, so a failed or uncached run is never mistaken for a reusable one
+func invalidateConfigCache(extensionDir string) {
+	_ = os.Remove(filepath.Join(extensionDir, configCacheFile))
+}
+
+// saveConfigCache records key once a configure step has succeeded, when
+// config.ReuseConfigCache is set. Failing to write it only means the next
+// build configures again.
+func saveConfigCache(config *BuildConfig, extensionDir, key string) {
+	if !config.ReuseConfigCache {
+		return
+	}
+	_ = os.WriteFile(filepath.Join(extensionDir, configCacheFile), []byte(key+"\n"), 0o600)
+}
@@ -0,0 +1,67 @@
+package rubyext
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReuseConfigCache(t *testing.T) {
+	extDir := t.TempDir()
+	lists := filepath.Join(extDir, "CMakeLists.txt")
+	cache := filepath.Join(extDir, "CMakeCache.txt")
+	for _, file := range []string{lists, cache} {
+		if err := os.WriteFile(file, []byte("# test\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(lists, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &BuildConfig{ReuseConfigCache: true, BuildArgs: []string{"-DFOO=1"}}
+	key := configCacheKey(config, config.BuildArgs)
+	reuse := func(config *BuildConfig, key string) bool {
+		return reuseConfigCache(config, &BuildResult{}, extDir, "CMakeCache.txt", "CMakeLists.txt", key)
+	}
+
+	if reuse(config, key) {
+		t.Fatal("expected no reuse before the cache key is saved")
+	}
+
+	saveConfigCache(config, extDir, key)
+	if !reuse(config, key) {
+		t.Fatal("expected reuse with unchanged inputs")
+	}
+
+	changed := &BuildConfig{ReuseConfigCache: true, BuildArgs: []string{"-DFOO=2"}}
+	if reuse(changed, configCacheKey(changed, changed.BuildArgs)) {
+		t.Fatal("expected changed BuildArgs to invalidate the cache")
+	}
+	withEnv := &BuildConfig{ReuseConfigCache: true, BuildArgs: config.BuildArgs, Env: map[string]string{"CC": "clang"}}
+	if reuse(withEnv, configCacheKey(withEnv, withEnv.BuildArgs)) {
+		t.Fatal("expected changed Env to invalidate the cache")
+	}
+	if reuse(&BuildConfig{ReuseConfigCache: true, CleanFirst: true, BuildArgs: config.BuildArgs}, key) {
+		t.Fatal("expected CleanFirst to configure again")
+	}
+	if reuse(&BuildConfig{BuildArgs: config.BuildArgs}, key) {
+		t.Fatal("expected no reuse without ReuseConfigCache")
+	}
+
+	// An edited CMakeLists.txt makes the cache stale
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(lists, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if reuse(config, key) {
+		t.Fatal("expected CMakeLists.txt newer than CMakeCache.txt to configure again")
+	}
+
+	invalidateConfigCache(extDir)
+	if _, err := os.Stat(filepath.Join(extDir, configCacheFile)); !os.IsNotExist(err) {
+		t.Fatalf("expected the cache key to be removed, got %v", err)
+	}
+}
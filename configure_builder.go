@@ -2,17 +2,29 @@ package rubyext
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
 	"runtime"
-	"strings"
 )
 
 // ConfigureBuilder handles autotools-style configure scripts
 type ConfigureBuilder struct{}
 
+// autotoolsRequirements are the tools autoreconf -i needs to generate a
+// configure script from configure.ac
+var autotoolsRequirements = []ToolRequirement{
+	{Name: "autoreconf", Purpose: "Regenerates configure from configure.ac"},
+	{Name: "autoconf", Purpose: "Generates configure scripts"},
+	{Name: "automake", Purpose: "Generates Makefile.in templates"},
+	{Name: "libtoolize", Alternatives: []string{"glibtoolize"}, Purpose: "Sets up libtool"},
+}
+
+// autoconfInputs are the files autoreconf generates configure from
+var autoconfInputs = []string{"configure.ac", "configure.in"}
+
 // Name returns the builder name
 func (b *ConfigureBuilder) Name() string {
 	return "Configure"
@@ -56,73 +68,98 @@ func (b *ConfigureBuilder) Build(ctx context.Context, config *BuildConfig, exten
 		Output:  []string{},
 	}
 
-	extensionPath := filepath.Join(config.GemDir, extensionFile)
-	extensionDir := filepath.Dir(extensionPath)
+	appendWarningsAsErrorsNote(config, result, b.Name())
+
+	// Calculate extension directory, using a working copy if BuildDir is set
+	extensionDir, err := prepareBuildDir(config, extensionFile)
+	if err != nil {
+		return failBuild(result, err)
+	}
+
+	if err := runPreBuildCommands(ctx, config, extensionDir, result); err != nil {
+		return failBuild(result, err)
+	}
 
 	// Step 1: Run ./configure to generate Makefile
 	if err := b.runConfigure(ctx, config, extensionDir, extensionFile, result); err != nil {
-		result.Error = err
-		return result, err
+		return failBuild(result, err)
 	}
 
 	// Step 2: Run make to compile the extension
 	if err := b.runMake(ctx, config, extensionDir, result); err != nil {
-		result.Error = err
-		return result, err
+		return failBuild(result, err)
 	}
 
 	// Step 3: Find built extensions
-	extensions, err := b.findBuiltExtensions(extensionDir)
+	extensions, err := findExtensions(config, extensionDir, b.findBuiltExtensions)
 	if err != nil {
-		result.Error = err
-		return result, err
+		return failBuild(result, err)
+	}
+
+	if err = runPostBuildCommands(ctx, config, extensionDir, result); err != nil {
+		return failBuild(result, err)
+	}
+
+	if err = checkArtifacts(config, extensionDir, extensions); err != nil {
+		return failBuild(result, err)
+	}
+
+	if err = postProcessExtensions(ctx, config, result, extensionDir, extensions); err != nil {
+		return failBuild(result, err)
 	}
 
-	finalized, err := finalizeNativeExtensions(config, extensionFile, extensionDir, extensions)
+	result.BuiltArtifacts = buildOutputPaths(config, extensionFile, extensionDir, extensions)
+	finalized, err := finalizeNativeExtensions(config, result, extensionFile, extensionDir, extensions)
 	if err != nil {
-		result.Error = err
-		return result, err
+		return failBuild(result, err)
 	}
 
 	result.Extensions = finalized
+
+	if err = recordChecksums(config, result); err != nil {
+		return failBuild(result, err)
+	}
+
 	result.Success = true
 	return result, nil
 }
 
 // Clean removes build artifacts
 func (b *ConfigureBuilder) Clean(ctx context.Context, config *BuildConfig, extensionFile string) error {
-	extensionPath := filepath.Join(config.GemDir, extensionFile)
-	extensionDir := filepath.Dir(extensionPath)
+	if config.CleanArtifactsOnly {
+		return cleanArtifacts(ctx, config, extensionFile, b.findBuiltExtensions)
+	}
+
+	extensionDir := extensionBuildDir(config, extensionFile)
 
 	makefilePath := filepath.Join(extensionDir, "Makefile")
-	if _, err := os.Stat(makefilePath); os.IsNotExist(err) {
+	if _, err := osStat(makefilePath); os.IsNotExist(err) {
 		return nil // Nothing to clean
 	}
 
 	makeProgram := b.getMakeProgram()
 
 	// Try "make distclean" first (autotools standard), then "make clean"
-	distcleanCmd := exec.CommandContext(ctx, makeProgram, "distclean")
+	distcleanCmd := execCommandContext(ctx, makeProgram, "distclean")
 	distcleanCmd.Dir = extensionDir
-	if err := distcleanCmd.Run(); err != nil {
+	if err := distcleanCmd.Run(); err != nil && ctx.Err() == nil {
 		// Fall back to regular clean
-		cleanCmd := exec.CommandContext(ctx, makeProgram, "clean")
+		cleanCmd := execCommandContext(ctx, makeProgram, "clean")
 		cleanCmd.Dir = extensionDir
-		return cleanCmd.Run()
+		return cleanResult(ctx, cleanCmd.Run())
 	}
 
-	return nil
+	return cleanResult(ctx, nil)
 }
 
 // runConfigure executes the configure script
 func (b *ConfigureBuilder) runConfigure(
 	ctx context.Context, config *BuildConfig, extensionDir, extensionFile string, result *BuildResult,
 ) error {
-	configurePath := filepath.Join(extensionDir, filepath.Base(extensionFile))
+	configurePath := filepath.Join(extensionDir, path.Base(normalizeExtensionPath(extensionFile)))
 
-	// Make sure configure script is executable
-	if err := os.Chmod(configurePath, 0755); err != nil {
-		return BuildError("Configure", result.Output, fmt.Errorf("failed to make configure executable: %v", err))
+	if err := b.runAutoreconf(ctx, config, extensionDir, configurePath, result); err != nil {
+		return err
 	}
 
 	// Build configure arguments
@@ -133,32 +170,36 @@ func (b *ConfigureBuilder) runConfigure(
 		args = append(args, fmt.Sprintf("--prefix=%s", config.DestPath))
 	}
 
-	// Add any custom build args
-	args = append(args, config.BuildArgs...)
+	// Add any custom configure args
+	args = append(args, config.ConfigureArgs...)
 
-	cmd := exec.CommandContext(ctx, configurePath, args...)
+	cacheKey := configCacheKey(config, args)
+	if reuseConfigCache(config, result, extensionDir, "Makefile", filepath.Base(configurePath), cacheKey) {
+		return nil
+	}
+	invalidateConfigCache(extensionDir)
+
+	cmdName, cmdArgs, err := b.configureCommand(configurePath, args)
+	if err != nil {
+		return BuildError("Configure", result.Output, err)
+	}
+
+	cmd := execCommandContext(ctx, cmdName, cmdArgs...)
 	cmd.Dir = extensionDir
 
 	// Set environment variables
-	cmd.Env = os.Environ()
-	for key, value := range config.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
-	}
+	cmd.Env = buildCommandEnv(config)
+	cmd.Stdin = commandStdin(config)
 
 	// Common autotools environment variables
 	if config.RubyPath != "" {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("RUBY=%s", config.RubyPath))
 	}
 
-	output, err := cmd.CombinedOutput()
-	outputLines := strings.Split(string(output), "\n")
-	result.Output = append(result.Output, outputLines...)
+	output, err := combinedOutput(cmd)
+	appendCommandOutput(config, result, output)
 
-	if config.Verbose {
-		result.Output = append(result.Output,
-			fmt.Sprintf("Running: %s %s", configurePath, strings.Join(args, " ")),
-			fmt.Sprintf("Working directory: %s", extensionDir))
-	}
+	appendCommandLog(config, result, cmd, err)
 
 	if err != nil {
 		return BuildError("Configure", result.Output, err)
@@ -166,13 +207,92 @@ func (b *ConfigureBuilder) runConfigure(
 
 	// Verify Makefile was created
 	makefilePath := filepath.Join(extensionDir, "Makefile")
-	if _, err := os.Stat(makefilePath); os.IsNotExist(err) {
-		return BuildError("Configure", result.Output, fmt.Errorf("makefile not generated by configure"))
+	if _, err := osStat(makefilePath); os.IsNotExist(err) {
+		return BuildError("Configure", result.Output, fmt.Errorf("%w by configure", ErrMakefileNotGenerated))
+	}
+
+	saveConfigCache(config, extensionDir, cacheKey)
+	return nil
+}
+
+// runAutoreconf generates a missing configure script from configure.ac
+// (or configure.in) with autoreconf -i when config.RunAutoreconf is set,
+// for gems that don't ship the generated script. Missing autotools are
+// listed in result.MissingDependencies and returned as a
+// *MissingToolError. Without RunAutoreconf, the output notes that the
+// script could be generated.
+func (b *ConfigureBuilder) runAutoreconf(
+	ctx context.Context, config *BuildConfig, extensionDir, configurePath string, result *BuildResult,
+) error {
+	input := autoconfInput(configurePath)
+	if input == "" {
+		return nil
 	}
+	if !config.RunAutoreconf {
+		result.Output = append(result.Output,
+			fmt.Sprintf("Note: %s is missing but %s is present; set RunAutoreconf to generate it", filepath.Base(configurePath), input))
+		return nil
+	}
+
+	tools, err := ResolveTools(autotoolsRequirements)
+	if err != nil {
+		var missingErr *MissingToolError
+		if errors.As(err, &missingErr) {
+			result.MissingDependencies = append(result.MissingDependencies, missingErr.Tools...)
+		}
+		return err
+	}
+
+	cmd := execCommandContext(ctx, tools["autoreconf"], "-i")
+	cmd.Dir = extensionDir
+	cmd.Env = buildCommandEnv(config)
+
+	output, err := combinedOutput(cmd)
+	appendCommandOutput(config, result, output)
+
+	appendCommandLog(config, result, cmd, err)
 
+	if err != nil {
+		return BuildError("Autoreconf", result.Output, err)
+	}
 	return nil
 }
 
+// autoconfInput returns the name of the configure.ac or configure.in next
+// to configurePath when the configure script itself doesn't exist, or ""
+func autoconfInput(configurePath string) string {
+	if filepath.Base(configurePath) != "configure" {
+		return ""
+	}
+	if _, err := osStat(configurePath); !os.IsNotExist(err) {
+		return ""
+	}
+
+	for _, name := range autoconfInputs {
+		if _, err := osStat(filepath.Join(filepath.Dir(configurePath), name)); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// configureCommand returns the command used to run the configure script.
+//
+// Executable scripts are run directly; scripts without the executable bit
+// (common in extracted gem archives) are run through sh instead.
+func (b *ConfigureBuilder) configureCommand(configurePath string, args []string) (cmd string, resolvedArgs []string, err error) {
+	info, err := osStat(configurePath)
+	if err != nil {
+		return "", nil, fmt.Errorf("configure script not found: %w", err)
+	}
+
+	if info.Mode().Perm()&0o111 != 0 {
+		return configurePath, append([]string{}, args...), nil
+	}
+
+	return "sh", append([]string{configurePath}, args...), nil
+}
+
 // runMake executes make to compile the extension
 func (b *ConfigureBuilder) runMake(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
 	makeProgram := b.getMakeProgram()
@@ -181,37 +301,33 @@ func (b *ConfigureBuilder) runMake(ctx context.Context, config *BuildConfig, ext
 	args := []string{}
 
 	// Add parallel jobs if specified
-	if config.Parallel > 0 {
-		args = append(args, fmt.Sprintf("-j%d", config.Parallel))
+	if jobs := parallelJobs(config); jobs > 0 {
+		args = append(args, fmt.Sprintf("-j%d", jobs))
 	}
 
+	// Add any custom build args
+	args = append(args, config.BuildArgs...)
+
 	// Clean first if requested
 	if config.CleanFirst {
-		cleanCmd := exec.CommandContext(ctx, makeProgram, "clean")
+		cleanCmd := execCommandContext(ctx, makeProgram, "clean")
 		cleanCmd.Dir = extensionDir
-		cleanOutput, _ := cleanCmd.CombinedOutput()
-		result.Output = append(result.Output, strings.Split(string(cleanOutput), "\n")...)
+		cleanOutput, _ := combinedOutput(cleanCmd)
+		appendCommandOutput(config, result, cleanOutput)
 	}
 
 	// Run make
-	cmd := exec.CommandContext(ctx, makeProgram, args...)
+	cmd := execCommandContext(ctx, makeProgram, args...)
 	cmd.Dir = extensionDir
 
 	// Set environment variables
-	cmd.Env = os.Environ()
-	for key, value := range config.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
-	}
+	cmd.Env = buildCommandEnv(config)
+	cmd.Stdin = commandStdin(config)
 
-	output, err := cmd.CombinedOutput()
-	outputLines := strings.Split(string(output), "\n")
-	result.Output = append(result.Output, outputLines...)
+	output, err := combinedOutput(cmd)
+	appendCommandOutput(config, result, output)
 
-	if config.Verbose {
-		result.Output = append(result.Output,
-			fmt.Sprintf("Running: %s %s", makeProgram, strings.Join(args, " ")),
-			fmt.Sprintf("Working directory: %s", extensionDir))
-	}
+	appendCommandLog(config, result, cmd, err)
 
 	if err != nil {
 		return BuildError("Make", result.Output, err)
@@ -219,16 +335,15 @@ func (b *ConfigureBuilder) runMake(ctx context.Context, config *BuildConfig, ext
 
 	// Run make install if dest path is specified
 	if config.DestPath != "" {
-		installCmd := exec.CommandContext(ctx, makeProgram, "install")
+		installCmd := execCommandContext(ctx, makeProgram, "install")
 		installCmd.Dir = extensionDir
 		installCmd.Env = cmd.Env
 
-		installOutput, err := installCmd.CombinedOutput()
-		installLines := strings.Split(string(installOutput), "\n")
-		result.Output = append(result.Output, installLines...)
+		installOutput, err := combinedOutput(installCmd)
+		appendCommandOutput(config, result, installOutput)
 
 		if err != nil {
-			return BuildError("Make Install", result.Output, err)
+			return installStepError(config, result, "Make Install", err)
 		}
 	}
 
@@ -251,7 +366,7 @@ func (b *ConfigureBuilder) findBuiltExtensions(extensionDir string) ([]string, e
 	}
 
 	for _, pattern := range patterns {
-		matches, err := filepath.Glob(filepath.Join(extensionDir, pattern))
+		matches, err := filepathGlob(filepath.Join(extensionDir, pattern))
 		if err != nil {
 			return nil, fmt.Errorf("failed to glob pattern %s in %s: %v", pattern, extensionDir, err)
 		}
@@ -280,6 +395,6 @@ func (b *ConfigureBuilder) getMakeProgram() string {
 	case platformWindows:
 		return makeProgram // Most Windows autotools use MinGW/MSYS2 make
 	default:
-		return makeProgram
+		return unixMakeProgram(runtime.GOOS)
 	}
 }
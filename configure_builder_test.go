@@ -0,0 +1,146 @@
+package rubyext
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestConfigureCommandRespectsExecutableBit(t *testing.T) {
+	dir := t.TempDir()
+	configurePath := filepath.Join(dir, "configure")
+	if err := os.WriteFile(configurePath, []byte("#!/bin/sh\n"), 0o600); err != nil {
+		t.Fatalf("failed to write configure: %v", err)
+	}
+
+	builder := &ConfigureBuilder{}
+	args := []string{"--prefix=/dest", "--enable-shared"}
+
+	cmd, resolvedArgs, err := builder.configureCommand(configurePath, args)
+	if err != nil {
+		t.Fatalf("configureCommand returned error: %v", err)
+	}
+	if cmd != "sh" || !reflect.DeepEqual(resolvedArgs, append([]string{configurePath}, args...)) {
+		t.Fatalf("expected non-executable script to run via sh, got %s %v", cmd, resolvedArgs)
+	}
+
+	if err := os.Chmod(configurePath, 0o755); err != nil {
+		t.Fatalf("failed to chmod configure: %v", err)
+	}
+
+	cmd, resolvedArgs, err = builder.configureCommand(configurePath, args)
+	if err != nil {
+		t.Fatalf("configureCommand returned error: %v", err)
+	}
+	if cmd != configurePath || !reflect.DeepEqual(resolvedArgs, args) {
+		t.Fatalf("expected executable script to run directly, got %s %v", cmd, resolvedArgs)
+	}
+
+	if _, _, err := builder.configureCommand(filepath.Join(dir, "missing"), nil); err == nil {
+		t.Fatal("expected error for missing configure script")
+	}
+}
+
+func TestConfigureBuilderFeedsStdin(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("shell script toolchain requires a POSIX shell")
+	}
+
+	makePath := filepath.Join(t.TempDir(), "make")
+	writeTestScript(t, makePath, "#!/bin/sh\ntouch myext.so\n")
+	t.Setenv("MAKE", makePath)
+
+	gemDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "myext")
+	if err := os.MkdirAll(extDir, 0o755); err != nil {
+		t.Fatalf("failed to create extension dir: %v", err)
+	}
+	writeTestScript(t, filepath.Join(extDir, "configure"), `#!/bin/sh
+printf 'Use bundled libfoo? [y/n] '
+read answer || { echo 'no answer'; exit 3; }
+echo "$answer" > answer.txt
+echo "all:" > Makefile
+`)
+
+	builder := &ConfigureBuilder{}
+	config := &BuildConfig{GemDir: gemDir}
+
+	result, err := builder.Build(context.Background(), config, "ext/myext/configure")
+	if err == nil || result.ExitCode != 3 {
+		t.Fatalf("expected prompt without stdin to fail, got %v (exit %d)", err, result.ExitCode)
+	}
+
+	config.Stdin = strings.NewReader("y\n")
+	result, err = builder.Build(context.Background(), config, "ext/myext/configure")
+	if err != nil || !result.Success {
+		t.Fatalf("expected build with stdin to succeed, got %v", err)
+	}
+
+	answer, err := os.ReadFile(filepath.Join(extDir, "answer.txt"))
+	if err != nil || string(answer) != "y\n" {
+		t.Fatalf("expected configure to read the answer from stdin, got %q (%v)", answer, err)
+	}
+}
+
+func TestConfigureBuilderRunsAutoreconf(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("shell script autotools require a POSIX shell")
+	}
+
+	toolDir := t.TempDir()
+	writeTestScript(t, filepath.Join(toolDir, "autoreconf"), `#!/bin/sh
+printf '#!/bin/sh\necho "all:" > Makefile\n' > configure
+chmod +x configure
+echo "autoreconf $*"
+`)
+	for _, tool := range []string{"autoconf", "automake"} {
+		writeTestScript(t, filepath.Join(toolDir, tool), "#!/bin/sh\n")
+	}
+	origLookPath := execLookPath
+	defer func() { execLookPath = origLookPath }()
+	execLookPath = func(name string) (string, error) {
+		return exec.LookPath(filepath.Join(toolDir, name))
+	}
+
+	extDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(extDir, "configure.ac"), []byte("AC_INIT([myext], [1.0])\n"), 0o600); err != nil {
+		t.Fatalf("failed to write configure.ac: %v", err)
+	}
+	configurePath := filepath.Join(extDir, "configure")
+	builder := &ConfigureBuilder{}
+
+	// Without RunAutoreconf the script is left missing
+	result := &BuildResult{}
+	if err := builder.runAutoreconf(context.Background(), &BuildConfig{}, extDir, configurePath, result); err != nil {
+		t.Fatalf("runAutoreconf returned error: %v", err)
+	}
+	if _, err := os.Stat(configurePath); !os.IsNotExist(err) || !strings.Contains(strings.Join(result.Output, "\n"), "RunAutoreconf") {
+		t.Fatalf("expected only a note without RunAutoreconf, got %v", result.Output)
+	}
+
+	config := &BuildConfig{RunAutoreconf: true}
+	result = &BuildResult{}
+	err := builder.runAutoreconf(context.Background(), config, extDir, configurePath, result)
+	if !errors.Is(err, ErrMissingTool) || !reflect.DeepEqual(result.MissingDependencies, []string{"libtoolize"}) {
+		t.Fatalf("expected missing libtoolize to be reported, got %v (%v)", result.MissingDependencies, err)
+	}
+
+	writeTestScript(t, filepath.Join(toolDir, "libtoolize"), "#!/bin/sh\n")
+
+	result = &BuildResult{}
+	if err := builder.runConfigure(context.Background(), config, extDir, "ext/myext/configure", result); err != nil {
+		t.Fatalf("runConfigure returned error: %v (%v)", err, result.Output)
+	}
+	if !strings.Contains(strings.Join(result.Output, "\n"), "autoreconf -i") {
+		t.Fatalf("expected autoreconf -i to run, got %v", result.Output)
+	}
+	if _, err := os.Stat(filepath.Join(extDir, "Makefile")); err != nil {
+		t.Fatalf("expected the generated configure to run: %v", err)
+	}
+}
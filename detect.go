@@ -0,0 +1,120 @@
+package rubyext
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// extensionEntryPoints lists the files that start an extension build,
+// in order of preference when a directory contains more than one.
+// For example, rb-sys gems have both extconf.rb and Cargo.toml, and
+// extconf.rb is the entry point RubyGems would use.
+var extensionEntryPoints = []string{
+	"extconf.rb",
+	"mkrf_conf.rb",
+	"configure",
+	"CMakeLists.txt",
+	"Cargo.toml",
+	"Rakefile",
+	"wscript",
+}
+
+// skippedScanDirs are directories that hold installed dependencies rather
+// than the gem's own extensions, and can be large: node_modules and
+// vendor/bundle (matched by their last path elements)
+var skippedScanDirs = []string{"node_modules", filepath.Join("vendor", "bundle")}
+
+// DetectExtensions finds the extensions of an extracted gem.
+//
+// If the gem root contains a .gemspec declaring extensions, those are
+// returned (see ExtensionsFromGemspec). Otherwise the ext/ directory is
+// scanned for build entry points like extconf.rb, CMakeLists.txt and
+// Cargo.toml. Once a directory has an entry point its subdirectories are
+// not scanned, so vendored libraries (e.g. ext/foo/vendor/libyaml/configure)
+// are not mistaken for extensions. Hidden directories, node_modules,
+// vendor/bundle and the default BuildConfig.ExcludeDirs (spec, test,
+// vendor, tmp) are skipped.
+//
+// Returned paths are relative to gemDir and use forward slashes, ready to
+// be passed to BuildAllExtensions.
+func DetectExtensions(gemDir string) ([]string, error) {
+	gemspecs, err := filepathGlob(filepath.Join(gemDir, "*.gemspec"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, gemspec := range gemspecs {
+		extensions, err := ExtensionsFromGemspec(gemspec)
+		if err != nil {
+			return nil, err
+		}
+		if len(extensions) > 0 {
+			return extensions, nil
+		}
+	}
+
+	extRoot := filepath.Join(gemDir, "ext")
+	if _, err := osStat(extRoot); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var extensions []string
+	err = filepath.WalkDir(extRoot, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if rel, relErr := filepath.Rel(extRoot, path); isSkippedScanDir(path) || (relErr == nil && inExcludedDir(rel, defaultExcludeDirs)) {
+			return fs.SkipDir
+		}
+
+		for _, name := range extensionEntryPoints {
+			info, statErr := osStat(filepath.Join(path, name))
+			if statErr != nil || !info.Mode().IsRegular() {
+				continue
+			}
+
+			rel, relErr := filepath.Rel(gemDir, filepath.Join(path, name))
+			if relErr != nil {
+				return relErr
+			}
+			extensions = append(extensions, filepath.ToSlash(rel))
+			return fs.SkipDir
+		}
+
+		if strings.HasPrefix(entry.Name(), ".") && path != extRoot {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return extensions, nil
+}
+
+// isSkippedScanDir reports whether path ends in one of skippedScanDirs
+func isSkippedScanDir(path string) bool {
+	for _, dir := range skippedScanDirs {
+		if path == dir || strings.HasSuffix(path, string(filepath.Separator)+dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasExtensions reports whether an extracted gem has native extensions to
+// build, as found by DetectExtensions. It is a quick check for skipping
+// pure-Ruby gems before setting up a build.
+func HasExtensions(gemDir string) (bool, error) {
+	extensions, err := DetectExtensions(gemDir)
+	if err != nil {
+		return false, err
+	}
+	return len(extensions) > 0, nil
+}
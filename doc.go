@@ -51,6 +51,8 @@
 //
 // # Platform Support
 //
-// Full support on Linux and macOS. Limited Windows support (MinGW/MSYS2).
+// Full support on Linux and macOS. Limited Windows support (MinGW/MSYS2);
+// set BuildConfig.AutoMSVCEnv to build extconf.rb and Makefile extensions
+// with Visual Studio outside a Developer Command Prompt.
 // Cross-compilation is supported with proper toolchain configuration.
 package rubyext
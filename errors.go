@@ -0,0 +1,146 @@
+package rubyext
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Sentinel errors wrapped by the errors this package returns, so callers
+// can use errors.Is instead of matching messages.
+var (
+	// ErrNoBuilder means no registered builder can handle an extension file.
+	ErrNoBuilder = errors.New("no builder found")
+
+	// ErrMissingTool means a required build tool could not be found. The
+	// error is a *MissingToolError naming the tools.
+	ErrMissingTool = errors.New("required tool not found")
+
+	// ErrMakefileNotGenerated means a configure step (extconf.rb,
+	// ./configure) succeeded without writing a Makefile.
+	ErrMakefileNotGenerated = errors.New("makefile not generated")
+
+	// ErrNoArtifacts means a build succeeded without producing any
+	// extension files (see BuildConfig.RequireArtifacts).
+	ErrNoArtifacts = errors.New("no extension artifacts")
+
+	// ErrCompilerMismatch means the C compiler isn't the one required by
+	// BuildConfig.RequireCompiler. The error is a *CompilerMismatchError.
+	ErrCompilerMismatch = errors.New("unexpected C compiler")
+
+	// ErrOutsideGemDir means an extension path resolves to a directory
+	// outside BuildConfig.GemDir, in which case the error is an
+	// *ExtensionPathError, or that BuildConfig.MakeDir leads outside the
+	// extension directory.
+	ErrOutsideGemDir = errors.New("extension path escapes gem directory")
+)
+
+// MissingToolError reports required build tools that could not be found.
+// It matches ErrMissingTool with errors.Is.
+type MissingToolError struct {
+	Tools []string // Names of the missing tools, as in ToolRequirement.Name
+
+	message string
+}
+
+// Error returns the error message
+func (e *MissingToolError) Error() string {
+	if e.message != "" {
+		return e.message
+	}
+	return fmt.Sprintf("%s: %s", ErrMissingTool, strings.Join(e.Tools, ", "))
+}
+
+// Is reports whether target is ErrMissingTool
+func (e *MissingToolError) Is(target error) bool {
+	return target == ErrMissingTool
+}
+
+// ExtensionPathError reports an extension file whose directory is outside
+// the gem directory, e.g. "../../etc/extconf.rb". It matches
+// ErrOutsideGemDir with errors.Is.
+type ExtensionPathError struct {
+	ExtensionFile string // Extension file as given
+	GemDir        string // Gem directory it must stay within
+}
+
+// Error returns the error message
+func (e *ExtensionPathError) Error() string {
+	return fmt.Sprintf("%s: %s is outside %s", ErrOutsideGemDir, e.ExtensionFile, e.GemDir)
+}
+
+// Is reports whether target is ErrOutsideGemDir
+func (e *ExtensionPathError) Is(target error) bool {
+	return target == ErrOutsideGemDir
+}
+
+// CompilerMismatchError reports that the C compiler a build would use is
+// not the one required by BuildConfig.RequireCompiler, or couldn't be
+// identified. It matches ErrCompilerMismatch with errors.Is.
+type CompilerMismatchError struct {
+	Required string // Value of BuildConfig.RequireCompiler
+	Compiler string // Compiler command that was checked ("" if none was found)
+	Identity string // Identified compiler: "gcc", "clang" or "msvc"
+	Err      error  // Why the compiler couldn't be identified (may be nil)
+}
+
+// Error returns the error message
+func (e *CompilerMismatchError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s required: %v", ErrCompilerMismatch, e.Required, e.Err)
+	}
+	return fmt.Sprintf("%s: %s required, but %s is %s", ErrCompilerMismatch, e.Required, e.Compiler, e.Identity)
+}
+
+// Is reports whether target is ErrCompilerMismatch
+func (e *CompilerMismatchError) Is(target error) bool {
+	return target == ErrCompilerMismatch
+}
+
+// Unwrap returns the underlying error
+func (e *CompilerMismatchError) Unwrap() error {
+	return e.Err
+}
+
+// BuildFailure is the error returned when a build step fails. It carries
+// the builder (or step) name, the failed command's exit code and the
+// output captured up to the failure, and wraps the underlying error.
+//
+// Use errors.As to retrieve it:
+//
+//	var failure *rubyext.BuildFailure
+//	if errors.As(err, &failure) {
+//	    log.Printf("%s exited with %d", failure.Builder, failure.ExitCode)
+//	}
+type BuildFailure struct {
+	Builder  string   // Builder or step that failed, e.g. "ExtConf" or "Make Install"
+	ExitCode int      // Exit code of the failed command (0 if none)
+	Output   []string // Build output captured up to the failure
+	Err      error    // Underlying error (may be nil)
+}
+
+// Error formats the failure with its output; see BuildError
+func (e *BuildFailure) Error() string {
+	outputStr := strings.Join(e.Output, "\n")
+
+	prefix := fmt.Sprintf("%s build failed", e.Builder)
+	if e.ExitCode != 0 {
+		prefix = fmt.Sprintf("%s (exit %d)", prefix, e.ExitCode)
+	}
+
+	switch {
+	case e.Err != nil && outputStr != "":
+		return fmt.Sprintf("%s: %v\n\nBuild output:\n%s", prefix, e.Err, outputStr)
+	case e.Err != nil:
+		return fmt.Sprintf("%s: %v", prefix, e.Err)
+	case outputStr != "":
+		return fmt.Sprintf("%s\n\nBuild output:\n%s", prefix, outputStr)
+	default:
+		return prefix
+	}
+}
+
+// Unwrap returns the underlying error
+func (e *BuildFailure) Unwrap() error {
+	return e.Err
+}
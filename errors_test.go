@@ -0,0 +1,50 @@
+package rubyext
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSentinelErrors(t *testing.T) {
+	if _, err := NewBuilderFactory().BuilderFor("ext/myext/unknown.file"); !errors.Is(err, ErrNoBuilder) {
+		t.Errorf("expected ErrNoBuilder, got %v", err)
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	_, err := ResolveTools([]ToolRequirement{{Name: "cmake", Purpose: "CMake build system"}, {Name: "ninja", Optional: true}})
+	var missing *MissingToolError
+	if !errors.Is(err, ErrMissingTool) || !errors.As(err, &missing) || !reflect.DeepEqual(missing.Tools, []string{"cmake"}) {
+		t.Errorf("expected MissingToolError for cmake, got %v", err)
+	}
+	if err.Error() != "cmake (CMake build system) not found in PATH" {
+		t.Errorf("expected message to be kept, got %q", err.Error())
+	}
+
+	if err := checkArtifacts(&BuildConfig{RequireArtifacts: true}, "ext/myext", nil); !errors.Is(err, ErrNoArtifacts) {
+		t.Errorf("expected ErrNoArtifacts, got %v", err)
+	}
+}
+
+func TestBuildFailure(t *testing.T) {
+	err := BuildErrorWithExitCode("ExtConf", []string{"checking for ruby.h... no"}, 2, ErrMakefileNotGenerated)
+
+	var failure *BuildFailure
+	if !errors.As(err, &failure) {
+		t.Fatalf("expected *BuildFailure, got %T", err)
+	}
+	if failure.Builder != "ExtConf" || failure.ExitCode != 2 || len(failure.Output) != 1 {
+		t.Fatalf("unexpected failure fields: %+v", failure)
+	}
+	if !errors.Is(err, ErrMakefileNotGenerated) {
+		t.Fatal("expected the underlying error to be wrapped")
+	}
+	if ExitCode(err) != 2 {
+		t.Fatalf("expected ExitCode to use the failure's exit code, got %d", ExitCode(err))
+	}
+
+	expected := "ExtConf build failed (exit 2): makefile not generated\n\nBuild output:\nchecking for ruby.h... no"
+	if err.Error() != expected {
+		t.Fatalf("expected %q, got %q", expected, err.Error())
+	}
+}
@@ -0,0 +1,18 @@
+package rubyext
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Hooks for the process and filesystem calls builders make. Every builder
+// goes through these rather than calling os/exec, os.Stat or filepath.Glob
+// directly, so tests can swap in fakes and exercise a build without a real
+// toolchain. Replace them only in tests, and restore the originals after.
+var (
+	execLookPath       = exec.LookPath
+	execCommandContext = exec.CommandContext
+	osStat             = os.Stat
+	filepathGlob       = filepath.Glob
+)
@@ -0,0 +1,11 @@
+//go:build !unix && !windows
+
+package rubyext
+
+import "os/exec"
+
+// startProcessGroup starts cmd. Platforms without process groups or job
+// objects only kill the command itself on cancellation.
+func startProcessGroup(cmd *exec.Cmd) (func(), error) {
+	return func() {}, cmd.Start()
+}
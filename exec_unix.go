@@ -0,0 +1,34 @@
+//go:build unix
+
+package rubyext
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// startProcessGroup starts cmd in its own process group. When the
+// command's context is canceled the whole group is killed, so compilers
+// started by make or cargo don't outlive a canceled build. The returned
+// function releases the group's resources once cmd has been waited for.
+func startProcessGroup(cmd *exec.Cmd) (func(), error) {
+	// Only commands created with a context can be canceled
+	if cmd.Cancel != nil {
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Setpgid = true
+		cmd.Cancel = func() error {
+			// A negative pid signals every process in the group
+			err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			if errors.Is(err, syscall.ESRCH) {
+				return os.ErrProcessDone
+			}
+			return err
+		}
+	}
+
+	return func() {}, cmd.Start()
+}
@@ -0,0 +1,86 @@
+//go:build unix
+
+package rubyext
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCombinedOutputKillsProcessGroupOnCancel(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The shell stands in for make, the backgrounded sleep for a compiler
+	// that would keep running (and keep the output pipes open) after make
+	// is killed
+	cmd := exec.CommandContext(ctx, "sh", "-c", `sleep 60 & echo $! > "$0"; echo started; wait`, pidFile)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := combinedOutput(cmd)
+		done <- err
+	}()
+
+	// Wait for the shell to start its child
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if content, err := os.ReadFile(pidFile); err == nil && strings.HasSuffix(string(content), "\n") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Unless the whole group is killed, the orphaned sleep holds the
+	// output pipes open and combinedOutput blocks until it exits
+	cancel()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected the canceled command to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("combinedOutput did not return after cancellation: the child outlived its process group")
+	}
+}
+
+func TestMakefileBuilderKillsInstallProcessGroupOnCancel(t *testing.T) {
+	// make install leaves a backgrounded "compiler" running, as an install
+	// that recompiles would
+	makePath := filepath.Join(t.TempDir(), "make")
+	writeTestScript(t, makePath, "#!/bin/sh\n[ \"$1\" = install ] || { touch myext.so; exit 0; }\nsleep 60 &\nwait\n")
+	t.Setenv("MAKE", makePath)
+
+	gemDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "myext")
+	if err := os.MkdirAll(extDir, 0o755); err != nil {
+		t.Fatalf("failed to create extension dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(extDir, "Makefile"), []byte("all:\n"), 0o600); err != nil {
+		t.Fatalf("failed to write Makefile: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := (&MakefileBuilder{}).Build(ctx, &BuildConfig{GemDir: gemDir, DestPath: t.TempDir()}, "ext/myext/Makefile")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected the canceled install to fail the build")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Build did not return after cancellation: make install's child outlived its process group")
+	}
+}
@@ -0,0 +1,71 @@
+//go:build windows
+
+package rubyext
+
+import (
+	"os/exec"
+	"sync/atomic"
+	"syscall"
+)
+
+// Access rights needed to add a process to a job object
+const (
+	processTerminate = 0x0001
+	processSetQuota  = 0x0100
+)
+
+var (
+	kernel32                     = syscall.NewLazyDLL("kernel32.dll")
+	procCreateJobObjectW         = kernel32.NewProc("CreateJobObjectW")
+	procAssignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject       = kernel32.NewProc("TerminateJobObject")
+)
+
+// startProcessGroup starts cmd and adds it to a job object. When the
+// command's context is canceled every process in the job is terminated,
+// so compilers started by nmake or cargo don't outlive a canceled build.
+// Children started before the command joins the job are not covered, and
+// a job object that can't be created only leaves cmd's default kill in
+// place. The returned function closes the job once cmd has been waited
+// for.
+func startProcessGroup(cmd *exec.Cmd) (func(), error) {
+	var job atomic.Uintptr
+
+	// Only commands created with a context can be canceled
+	if kill := cmd.Cancel; kill != nil {
+		cmd.Cancel = func() error {
+			if handle := job.Load(); handle != 0 {
+				_, _, _ = procTerminateJobObject.Call(handle, 1)
+			}
+			return kill()
+		}
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	handle, _, _ := procCreateJobObjectW.Call(0, 0)
+	if handle == 0 {
+		return func() {}, nil
+	}
+	release := func() {
+		job.Store(0)
+		_ = syscall.CloseHandle(syscall.Handle(handle))
+	}
+
+	process, err := syscall.OpenProcess(processTerminate|processSetQuota, false, uint32(cmd.Process.Pid))
+	if err != nil {
+		release()
+		return func() {}, nil
+	}
+	defer func() { _ = syscall.CloseHandle(process) }()
+
+	if ok, _, _ := procAssignProcessToJobObject.Call(handle, uintptr(process)); ok == 0 {
+		release()
+		return func() {}, nil
+	}
+
+	job.Store(handle)
+	return release, nil
+}
@@ -0,0 +1,60 @@
+package rubyext
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Explainer is an optional interface for builders that can describe the
+// commands a build would run.
+//
+// Explain is meant for display and documentation: it runs nothing and
+// creates nothing, so it's safe to call for any extension at any time.
+// The commands reflect the given config, but steps that depend on build
+// results (such as renaming Cargo outputs or installing libraries into
+// lib/) are not included.
+//
+// ExtConfBuilder, CmakeBuilder and CargoBuilder implement Explainer.
+type Explainer interface {
+	// Explain returns the commands Build would run for extensionFile,
+	// in order, one command line per entry.
+	Explain(config *BuildConfig, extensionFile string) ([]string, error)
+}
+
+// Explain returns the commands the builder for extensionFile would run.
+//
+// config.PreBuildCommands and config.PostBuildCommands are listed before
+// and after the builder's commands. Returns an error if no builder can
+// handle the file or the builder doesn't implement Explainer.
+func (f *BuilderFactory) Explain(config *BuildConfig, extensionFile string) ([]string, error) {
+	builder, err := f.BuilderFor(extensionFile)
+	if err != nil {
+		return nil, err
+	}
+
+	explainer, ok := builder.(Explainer)
+	if !ok {
+		return nil, fmt.Errorf("%s builder cannot explain its build", builder.Name())
+	}
+
+	commands, err := explainer.Explain(config, extensionFile)
+	if err != nil {
+		return nil, err
+	}
+
+	commands = append(hookCommandLines(config.PreBuildCommands), commands...)
+	return append(commands, hookCommandLines(config.PostBuildCommands)...), nil
+}
+
+// formatCommand renders a command and its arguments as a single line,
+// quoting arguments that contain whitespace or quotes
+func formatCommand(name string, args []string) string {
+	parts := make([]string, 0, len(args)+1)
+	for _, arg := range append([]string{name}, args...) {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'") {
+			arg = fmt.Sprintf("%q", arg)
+		}
+		parts = append(parts, arg)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,177 @@
+package rubyext
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	t.Setenv("MAKE", "")
+	t.Setenv("CMAKE_GENERATOR", "Ninja")
+	t.Setenv("CARGO", "")
+	t.Setenv("CARGO_BUILD_TARGET", "")
+
+	gemDir := t.TempDir()
+	config := &BuildConfig{
+		GemDir:     gemDir,
+		DestPath:   "/opt/my gem",
+		RubyPath:   "/usr/bin/ruby",
+		BuildArgs:  []string{"--with-foo"},
+		Parallel:   4,
+		CleanFirst: true,
+	}
+
+	testCases := []struct {
+		builder       Explainer
+		extensionFile string
+		expected      []string
+	}{
+		{
+			builder:       &ExtConfBuilder{},
+			extensionFile: "ext/myext/extconf.rb",
+			expected: []string{
+				"/usr/bin/ruby extconf.rb --with-foo",
+				"make clean",
+				"make -j4",
+				"make install",
+			},
+		},
+		{
+			builder:       &CmakeBuilder{},
+			extensionFile: "ext/myext/CMakeLists.txt",
+			expected: []string{
+				`cmake . "-DCMAKE_INSTALL_PREFIX=/opt/my gem" -DCMAKE_BUILD_TYPE=Release -G Ninja --with-foo`,
+				"cmake --build . --target clean",
+				"cmake --build . --parallel 4 --config Release",
+				"cmake --install . --prefix /",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		commands, err := tc.builder.Explain(config, tc.extensionFile)
+		if err != nil {
+			t.Fatalf("Explain(%s) returned error: %v", tc.extensionFile, err)
+		}
+		if !reflect.DeepEqual(commands, tc.expected) {
+			t.Errorf("Explain(%s) = %q, expected %q", tc.extensionFile, commands, tc.expected)
+		}
+	}
+
+	commands, err := (&CargoBuilder{}).Explain(&BuildConfig{GemDir: gemDir, CargoPackage: "my-ext"}, "ext/myext/Cargo.toml")
+	if err != nil {
+		t.Fatalf("Explain(Cargo.toml) returned error: %v", err)
+	}
+	if len(commands) != 1 || !strings.HasPrefix(commands[0], "cargo rustc -p my-ext --release") {
+		t.Errorf("unexpected cargo commands %q", commands)
+	}
+
+	entries, err := os.ReadDir(gemDir)
+	if err != nil {
+		t.Fatalf("failed to read gem dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected Explain not to touch the filesystem, found %d entries", len(entries))
+	}
+}
+
+func TestExtConfExplainMkmfOptions(t *testing.T) {
+	config := &BuildConfig{
+		BuildArgs: []string{"--with-opt-dir=/usr"},
+		MkmfOptions: map[string]string{
+			"with-opt-dir":           "/opt/local",
+			"--disable-install-rdoc": "",
+		},
+	}
+
+	commands, err := (&ExtConfBuilder{}).Explain(config, "ext/myext/extconf.rb")
+	if err != nil {
+		t.Fatalf("Explain returned error: %v", err)
+	}
+
+	expected := "ruby extconf.rb --with-opt-dir=/usr --disable-install-rdoc --with-opt-dir=/opt/local"
+	if commands[0] != expected {
+		t.Fatalf("expected %q, got %q", expected, commands[0])
+	}
+}
+
+func TestExtConfExplainMakeStep(t *testing.T) {
+	t.Setenv("MAKE", "")
+
+	testCases := []struct {
+		config   BuildConfig
+		expected []string
+	}{
+		{
+			BuildConfig{DestPath: "/opt/gem", InstallTarget: "install-so"},
+			[]string{"ruby extconf.rb", "make", "make install-so"},
+		},
+		{
+			BuildConfig{DestPath: "/opt/gem", SkipInstallTarget: true},
+			[]string{"ruby extconf.rb", "make"},
+		},
+		{
+			BuildConfig{DestPath: "/opt/gem", MakeDir: "build", CleanFirst: true},
+			[]string{"ruby extconf.rb", "make -C build clean", "make -C build", "make -C build install"},
+		},
+	}
+
+	for _, tc := range testCases {
+		commands, err := (&ExtConfBuilder{}).Explain(&tc.config, "ext/myext/extconf.rb")
+		if err != nil {
+			t.Fatalf("Explain returned error: %v", err)
+		}
+		if !reflect.DeepEqual(commands, tc.expected) {
+			t.Errorf("Explain = %q, expected %q", commands, tc.expected)
+		}
+	}
+}
+
+func TestFactoryExplainUnsupportedBuilder(t *testing.T) {
+	if _, err := NewBuilderFactory().Explain(&BuildConfig{}, "ext/myext/go.mod"); err == nil {
+		t.Fatal("expected error for builder without Explain")
+	}
+}
+
+func TestExtConfArgsFeatureToggles(t *testing.T) {
+	config := &BuildConfig{
+		BuildArgs:       []string{"--with-sqlcipher", "--disable-system-libraries"},
+		EnableFeatures:  []string{"fts5", "system-libraries"},
+		DisableFeatures: []string{"debug"},
+		MkmfOptions:     map[string]string{"with-opt-dir": "/opt/local"},
+	}
+
+	got := (&ExtConfBuilder{}).extconfArgs(config)
+	want := []string{
+		"extconf.rb",
+		"--with-sqlcipher",
+		"--disable-system-libraries",
+		"--enable-fts5",
+		"--disable-debug",
+		"--with-opt-dir=/opt/local",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("extconfArgs() = %v, want %v", got, want)
+	}
+}
+
+func TestExtConfArgsRubyIncludePaths(t *testing.T) {
+	config := &BuildConfig{
+		RubyPath:         "/usr/bin/ruby",
+		RubyIncludePaths: []string{"../../lib", "/opt/mkmf"},
+		BuildArgs:        []string{"--with-sodium-dir=/usr/local"},
+	}
+
+	got := (&ExtConfBuilder{}).extconfArgs(config)
+	want := []string{"-I../../lib", "-I/opt/mkmf", "extconf.rb", "--with-sodium-dir=/usr/local"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("extconfArgs() = %v, want %v", got, want)
+	}
+
+	commands, err := (&ExtConfBuilder{}).Explain(config, "ext/rbnacl/extconf.rb")
+	if err != nil || commands[0] != "/usr/bin/ruby -I../../lib -I/opt/mkmf extconf.rb --with-sodium-dir=/usr/local" {
+		t.Fatalf("expected -I flags before extconf.rb, got %v (%v)", commands, err)
+	}
+}
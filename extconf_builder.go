@@ -4,9 +4,9 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 )
 
@@ -35,9 +35,26 @@ func (b *ExtConfBuilder) RequiredTools() []ToolRequirement {
 			Alternatives: []string{"gmake", "nmake"},
 			Purpose:      "Build automation tool",
 		},
+		{
+			Name:         fortranCompiler.Name,
+			Alternatives: fortranCompiler.Alternatives,
+			Optional:     true, // Only needed for extensions with Fortran sources
+			Purpose:      fortranCompiler.Purpose,
+		},
 	}
 }
 
+// fortranCompiler is required by extconf.rb builds with Fortran sources
+var fortranCompiler = ToolRequirement{
+	Name:         "gfortran",
+	Alternatives: []string{"flang", "f95"},
+	Purpose:      "Fortran compiler for .f/.f90 sources",
+}
+
+// fortranSourcePatterns match the Fortran sources that make an extension
+// need a Fortran compiler
+var fortranSourcePatterns = []string{"*.f", "*.f90", "*.f95", "*.f03", "*.f08", "*.F", "*.F90"}
+
 // CheckTools verifies that Ruby and C compiler are available
 func (b *ExtConfBuilder) CheckTools() error {
 	return CheckRequiredTools(b.RequiredTools())
@@ -48,7 +65,19 @@ func (b *ExtConfBuilder) CanBuild(extensionFile string) bool {
 	return MatchesPattern(extensionFile, `extconf\.rb$`)
 }
 
-// Build compiles the extension using the extconf.rb → make workflow
+// Build compiles the extension using the extconf.rb → make workflow.
+//
+// Every step runs in the extension's own directory with its own command
+// environment, so gems with several extconf.rb files can build them
+// concurrently with the same config.
+//
+// mkmf writes the compiler and linker flags into the Makefile, and a
+// Makefile assignment wins over the environment, so CFLAGS and friends
+// only seen by make would be ignored. Flags from config (CFLAGS,
+// CXXFLAGS, CPPFLAGS and LDFLAGS in Env, plus CStandard, WarningsAsErrors
+// and Sanitizers) are therefore passed to extconf.rb in the environment
+// and also appended to the Makefile's own flags at make time, through an
+// extra makefile (see makeFlagsMakefile). Not done for nmake.
 func (b *ExtConfBuilder) Build(ctx context.Context, config *BuildConfig, extensionFile string) (*BuildResult, error) {
 	return runCommonBuild(ctx, config, extensionFile, CommonBuildSteps{
 		ConfigureFunc: b.runExtConf,
@@ -59,60 +88,186 @@ func (b *ExtConfBuilder) Build(ctx context.Context, config *BuildConfig, extensi
 
 // Clean removes build artifacts
 func (b *ExtConfBuilder) Clean(ctx context.Context, config *BuildConfig, extensionFile string) error {
-	extensionPath := filepath.Join(config.GemDir, extensionFile)
-	extensionDir := filepath.Dir(extensionPath)
+	if config.CleanArtifactsOnly {
+		return cleanArtifacts(ctx, config, extensionFile, b.findBuiltExtensions)
+	}
+
+	dir, err := makeDir(config, extensionBuildDir(config, extensionFile))
+	if err != nil {
+		return err
+	}
 
-	makefilePath := filepath.Join(extensionDir, "Makefile")
-	if _, err := os.Stat(makefilePath); os.IsNotExist(err) {
+	makefilePath := filepath.Join(dir, "Makefile")
+	if _, err := osStat(makefilePath); os.IsNotExist(err) {
 		return nil // Nothing to clean
 	}
 
 	makeProgram := b.getMakeProgram()
-	cmd := exec.CommandContext(ctx, makeProgram, "clean")
-	cmd.Dir = extensionDir
+	cmd := execCommandContext(ctx, makeProgram, "clean")
+	cmd.Dir = dir
 
-	return cmd.Run()
+	return cleanResult(ctx, cmd.Run())
 }
 
-// runExtConf executes ruby extconf.rb to generate the Makefile
-func (b *ExtConfBuilder) runExtConf(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
-	rubyPath := config.RubyPath
-	if rubyPath == "" {
-		rubyPath = "ruby"
+// Explain returns the commands Build would run, without running anything.
+// With config.MakeDir set, the make commands change to it with -C.
+func (b *ExtConfBuilder) Explain(config *BuildConfig, _ string) ([]string, error) {
+	makeProgram := b.getMakeProgram()
+
+	var dirArgs []string
+	if config.MakeDir != "" {
+		dirArgs = []string{"-C", config.MakeDir}
 	}
 
-	args := []string{"extconf.rb"}
+	commands := []string{formatCommand(b.rubyPath(config), b.extconfArgs(config))}
+	if config.CleanFirst {
+		commands = append(commands, formatCommand(makeProgram, append(dirArgs, "clean")))
+	}
+	commands = append(commands, formatCommand(makeProgram, append(dirArgs, b.makeArgs(config, config.CompilerCache)...)))
+	if target := installTarget(config); config.DestPath != "" && target != "" {
+		commands = append(commands, formatCommand(makeProgram, append(dirArgs, target)))
+	}
+
+	return commands, nil
+}
+
+// rubyPath returns the Ruby executable to run extconf.rb with
+func (b *ExtConfBuilder) rubyPath(config *BuildConfig) string {
+	if config.RubyPath != "" {
+		return config.RubyPath
+	}
+	return "ruby"
+}
+
+// extconfArgs returns the arguments for running extconf.rb: -I flags for
+// RubyIncludePaths, extconf.rb, then BuildArgs, the feature toggles and
+// MkmfOptions sorted by name
+func (b *ExtConfBuilder) extconfArgs(config *BuildConfig) []string {
+	args := make([]string, 0, len(config.RubyIncludePaths)+1)
+	for _, includePath := range config.RubyIncludePaths {
+		args = append(args, "-I"+includePath)
+	}
+
+	args = append(args, "extconf.rb")
 	args = append(args, config.BuildArgs...)
+	args = append(args, featureToggleArgs(config)...)
 
-	cmd := exec.CommandContext(ctx, rubyPath, args...)
+	names := make([]string, 0, len(config.MkmfOptions))
+	for name := range config.MkmfOptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		option := "--" + strings.TrimLeft(name, "-")
+		if value := config.MkmfOptions[name]; value != "" {
+			option += "=" + value
+		}
+		args = append(args, option)
+	}
+
+	return args
+}
+
+// featureToggleArgs returns --enable-<feature> and --disable-<feature>
+// flags for config.EnableFeatures and config.DisableFeatures, skipping
+// features BuildArgs already enables or disables
+func featureToggleArgs(config *BuildConfig) []string {
+	toggled := make(map[string]bool)
+	for _, arg := range config.BuildArgs {
+		name, _, _ := strings.Cut(arg, "=")
+		for _, prefix := range []string{"--enable-", "--disable-"} {
+			if feature, ok := strings.CutPrefix(name, prefix); ok {
+				toggled[feature] = true
+			}
+		}
+	}
+
+	var args []string
+	for _, toggle := range []struct {
+		prefix   string
+		features []string
+	}{
+		{"--enable-", config.EnableFeatures},
+		{"--disable-", config.DisableFeatures},
+	} {
+		for _, feature := range toggle.features {
+			feature = strings.TrimLeft(feature, "-")
+			if feature == "" || toggled[feature] {
+				continue
+			}
+			args = append(args, toggle.prefix+feature)
+		}
+	}
+
+	return args
+}
+
+// makeArgs returns the arguments for the make step, with cachePath the
+// resolved compiler cache (see makeFlagsMakefile)
+func (b *ExtConfBuilder) makeArgs(config *BuildConfig, cachePath string) []string {
+	args := []string{}
+
+	// Read the flags makefile after mkmf's Makefile so it can append to it
+	if b.makeFlagsMakefile(config, cachePath) != "" {
+		args = append(args, "-f", "Makefile", "-f", makeFlagsFile)
+	}
+
+	// Add parallel jobs if specified
+	if jobs := parallelJobs(config); jobs > 0 {
+		args = append(args, fmt.Sprintf("-j%d", jobs))
+	}
+
+	return args
+}
+
+// runExtConf executes ruby extconf.rb to generate the Makefile
+func (b *ExtConfBuilder) runExtConf(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
+	appendUniversalNote(config, result)
+
+	args := b.extconfArgs(config)
+	cacheKey := configCacheKey(config, args,
+		append(b.compilerFlagsEnv(config), fmt.Sprintf("UseSystemLibraries=%t", config.UseSystemLibraries))...)
+	dir, err := makeDir(config, extensionDir)
+	if err != nil {
+		return BuildError("ExtConf", result.Output, err)
+	}
+	makefile, _ := filepath.Rel(extensionDir, filepath.Join(dir, "Makefile"))
+	if reuseConfigCache(config, result, extensionDir, makefile, "extconf.rb", cacheKey) {
+		return nil
+	}
+	invalidateConfigCache(extensionDir)
+
+	cmd := execCommandContext(ctx, b.rubyPath(config), args...)
 	cmd.Dir = extensionDir
 
 	// Set environment variables
-	cmd.Env = os.Environ()
-	for key, value := range config.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
+	cmd.Env = append(buildCommandEnv(config), b.compilerFlagsEnv(config)...)
+	cmd.Stdin = commandStdin(config)
+	cmd.Env = appendMSVCEnv(ctx, config, result, cmd.Env)
+
+	fortranEnv, err := b.fortranEnv(config, extensionDir, result)
+	if err != nil {
+		return err
 	}
+	cmd.Env = append(cmd.Env, fortranEnv...)
+	cmd.Env = append(cmd.Env, b.systemLibrariesEnv(config, extensionDir, result)...)
 
-	output, err := cmd.CombinedOutput()
-	outputLines := strings.Split(string(output), "\n")
-	result.Output = append(result.Output, outputLines...)
+	output, err := combinedOutput(cmd)
+	appendCommandOutput(config, result, output)
 
-	if config.Verbose {
-		result.Output = append(result.Output,
-			fmt.Sprintf("Running: %s %s", rubyPath, strings.Join(args, " ")),
-			fmt.Sprintf("Working directory: %s", extensionDir))
-	}
+	appendCommandLog(config, result, cmd, err)
 
 	if err != nil {
 		return BuildError("ExtConf", result.Output, err)
 	}
 
 	// Verify Makefile was created
-	makefilePath := filepath.Join(extensionDir, "Makefile")
-	if _, err := os.Stat(makefilePath); os.IsNotExist(err) {
-		return BuildError("ExtConf", result.Output, fmt.Errorf("makefile not generated"))
+	if _, err := osStat(filepath.Join(extensionDir, makefile)); os.IsNotExist(err) {
+		return BuildError("ExtConf", result.Output, ErrMakefileNotGenerated)
 	}
 
+	saveConfigCache(config, extensionDir, cacheKey)
 	return nil
 }
 
@@ -121,70 +276,358 @@ func (b *ExtConfBuilder) runExtConf(ctx context.Context, config *BuildConfig, ex
 //nolint:dupl // Similar to makefile builder runMake but tailored for extconf
 func (b *ExtConfBuilder) runMake(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
 	makeProgram := b.getMakeProgram()
+	cachePath := compilerCachePath(config, result)
+	if cachePath != "" && b.usesNmake() {
+		result.Output = append(result.Output,
+			fmt.Sprintf("Note: compiler cache %s isn't supported with nmake, building without it", config.CompilerCache))
+		cachePath = ""
+	}
+	args := b.makeArgs(config, cachePath)
+	dir, err := makeDir(config, extensionDir)
+	if err != nil {
+		return BuildError("Make", result.Output, err)
+	}
 
-	// Build make arguments
-	args := []string{}
-
-	// Add parallel jobs if specified
-	if config.Parallel > 0 {
-		args = append(args, fmt.Sprintf("-j%d", config.Parallel))
+	// TruffleRuby needs its own LLVM toolchain to produce loadable bitcode
+	toolchainArgs, err := b.truffleRubyToolchainArgs(ctx, config, result)
+	if err != nil {
+		return err
 	}
+	args = append(args, toolchainArgs...)
 
 	// Clean first if requested
 	if config.CleanFirst {
-		cleanCmd := exec.CommandContext(ctx, makeProgram, "clean")
-		cleanCmd.Dir = extensionDir
-		cleanOutput, _ := cleanCmd.CombinedOutput()
-		result.Output = append(result.Output, strings.Split(string(cleanOutput), "\n")...)
+		cleanCmd := execCommandContext(ctx, makeProgram, "clean")
+		cleanCmd.Dir = dir
+		cleanOutput, _ := combinedOutput(cleanCmd)
+		appendCommandOutput(config, result, cleanOutput)
 	}
 
-	// Run make
-	cmd := exec.CommandContext(ctx, makeProgram, args...)
-	cmd.Dir = extensionDir
+	if content := b.makeFlagsMakefile(config, cachePath); content != "" {
+		if err := os.WriteFile(filepath.Join(dir, makeFlagsFile), []byte(content), 0o600); err != nil {
+			return BuildError("Make", result.Output, fmt.Errorf("failed to write %s: %w", makeFlagsFile, err))
+		}
+	}
+
+	// Run make, under bear when exporting compile commands
+	cmdName, cmdArgs := withBear(config, result, makeProgram, args)
+	cmd := execCommandContext(ctx, cmdName, cmdArgs...)
+	cmd.Dir = dir
 
 	// Set environment variables
-	cmd.Env = os.Environ()
-	for key, value := range config.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
+	cmd.Env = append(buildCommandEnv(config), b.compilerFlagsEnv(config)...)
+	cmd.Stdin = commandStdin(config)
+	cmd.Env = appendMSVCEnv(ctx, config, result, cmd.Env)
+
+	fortranEnv, err := b.fortranEnv(config, extensionDir, result)
+	if err != nil {
+		return err
 	}
+	cmd.Env = append(cmd.Env, fortranEnv...)
 
 	// Set DESTDIR if dest path is specified
 	if config.DestPath != "" {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("DESTDIR=%s", config.DestPath))
 	}
 
-	output, err := cmd.CombinedOutput()
-	outputLines := strings.Split(string(output), "\n")
-	result.Output = append(result.Output, outputLines...)
+	output, err := combinedOutput(cmd)
+	appendCommandOutput(config, result, output)
 
-	if config.Verbose {
-		result.Output = append(result.Output,
-			fmt.Sprintf("Running: %s %s", makeProgram, strings.Join(args, " ")),
-			fmt.Sprintf("Working directory: %s", extensionDir))
-	}
+	appendCommandLog(config, result, cmd, err)
 
 	if err != nil {
 		return BuildError("Make", result.Output, err)
 	}
 
-	// Run make install if dest path is specified
-	if config.DestPath != "" {
-		installCmd := exec.CommandContext(ctx, makeProgram, "install")
-		installCmd.Dir = extensionDir
-		installCmd.Env = cmd.Env
-
-		installOutput, err := installCmd.CombinedOutput()
-		installLines := strings.Split(string(installOutput), "\n")
-		result.Output = append(result.Output, installLines...)
+	if err = exportCompileCommands(config, result, dir); err != nil {
+		return BuildError("Make", result.Output, err)
+	}
 
+	// Run make install if dest path is specified
+	if target := installTarget(config); config.DestPath != "" && target != "" {
+		err = stageInstall(config, func(destDir string) error {
+			installCmd := execCommandContext(ctx, makeProgram, target)
+			installCmd.Dir = dir
+			installCmd.Env = cmd.Env
+			installCmd.Env = append(installCmd.Env, fmt.Sprintf("DESTDIR=%s", destDir))
+
+			installOutput, installErr := combinedOutput(installCmd)
+			appendCommandOutput(config, result, installOutput)
+			return installErr
+		})
 		if err != nil {
-			return BuildError("Make Install", result.Output, err)
+			return installStepError(config, result, "Make Install", err)
 		}
 	}
 
 	return nil
 }
 
+// compilerFlagsEnv returns CFLAGS and CXXFLAGS with -std= flags for
+// config.CStandard and config.CXXStandard, -Werror in CFLAGS when
+// config.WarningsAsErrors is set, and -fsanitize= for config.Sanitizers
+// and -arch flags for config.UniversalBinary, which also go to LDFLAGS so
+// the extension links the sanitizer runtime and both architectures. mkmf
+// and make both see them, so they apply to extconf.rb checks as well as
+// the compile.
+func (b *ExtConfBuilder) compilerFlagsEnv(config *BuildConfig) []string {
+	var env []string
+	sanitize := sanitizerFlag(config)
+	archFlags := universalArchFlags(config)
+
+	cflags := append([]string{}, archFlags...)
+	if config.CStandard != "" {
+		cflags = append(cflags, "-std="+config.CStandard)
+	}
+	if config.WarningsAsErrors {
+		cflags = append(cflags, "-Werror")
+	}
+	if sanitize != "" {
+		cflags = append(cflags, sanitize)
+	}
+	if len(cflags) > 0 {
+		flags := append(strings.Fields(envValue(config, "CFLAGS")), cflags...)
+		env = append(env, fmt.Sprintf("CFLAGS=%s", strings.Join(flags, " ")))
+	}
+
+	cxxflags := append([]string{}, archFlags...)
+	if config.CXXStandard != "" {
+		cxxflags = append(cxxflags, "-std="+config.CXXStandard)
+	}
+	if sanitize != "" {
+		cxxflags = append(cxxflags, sanitize)
+	}
+	if len(cxxflags) > 0 {
+		flags := append(strings.Fields(envValue(config, "CXXFLAGS")), cxxflags...)
+		env = append(env, fmt.Sprintf("CXXFLAGS=%s", strings.Join(flags, " ")))
+	}
+
+	var ldflags []string
+	if len(archFlags) > 0 || sanitize != "" {
+		ldflags = append(strings.Fields(envValue(config, "LDFLAGS")), archFlags...)
+	}
+	if sanitize != "" {
+		ldflags = append(ldflags, sanitize)
+	}
+	if len(ldflags) > 0 {
+		env = append(env, fmt.Sprintf("LDFLAGS=%s", strings.Join(ldflags, " ")))
+	}
+
+	return env
+}
+
+// makeFlagsFile is the makefile, written next to mkmf's Makefile, that
+// appends the configured flags to the Makefile's
+const makeFlagsFile = ".rubyext-flags.mk"
+
+// makeFlagsVariables maps the flag variables taken from the config to the
+// mkmf Makefile variables they are appended to. mkmf links extensions
+// with DLDFLAGS, not LDFLAGS.
+var makeFlagsVariables = []struct{ env, makeVar string }{
+	{"CPPFLAGS", "CPPFLAGS"},
+	{"CFLAGS", "CFLAGS"},
+	{"CXXFLAGS", "CXXFLAGS"},
+	{"LDFLAGS", "DLDFLAGS"},
+}
+
+// makeFlagsMakefile returns the contents of makeFlagsFile: a += line for
+// each flag variable set in config.Env or by compilerFlagsEnv, with $ and
+// # escaped, and, when cachePath is set, lines prefixing CC and CXX with
+// the compiler cache. The prefix is an override so that it also wraps a
+// compiler given on the make command line. It returns "" when there is
+// nothing to add, or when building with nmake, which has neither += nor
+// override.
+func (b *ExtConfBuilder) makeFlagsMakefile(config *BuildConfig, cachePath string) string {
+	if b.usesNmake() {
+		return ""
+	}
+
+	configured := make(map[string]string)
+	for _, entry := range b.compilerFlagsEnv(config) {
+		name, value, _ := strings.Cut(entry, "=")
+		configured[name] = value
+	}
+
+	var lines []string
+	for _, variable := range makeFlagsVariables {
+		value, ok := configured[variable.env]
+		if !ok {
+			value = config.Env[variable.env]
+		}
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+
+		value = makeEscaper.Replace(value)
+		lines = append(lines, fmt.Sprintf("%s += %s\n", variable.makeVar, value))
+	}
+
+	// make runs $(CC) through the shell, so the cache path is quoted if needed
+	if cachePath != "" {
+		cache := makeEscaper.Replace(shellQuote(cachePath))
+		for _, name := range []string{"CC", "CXX"} {
+			lines = append(lines, fmt.Sprintf("override %s := %s $(%s)\n", name, cache, name))
+		}
+	}
+
+	return strings.Join(lines, "")
+}
+
+// makeEscaper escapes the characters make would otherwise interpret in a
+// variable value
+var makeEscaper = strings.NewReplacer("$", "$$", "#", `\#`)
+
+// usesNmake reports whether the make step runs nmake
+func (b *ExtConfBuilder) usesNmake() bool {
+	return strings.Contains(strings.ToLower(filepath.Base(b.getMakeProgram())), nmakeProgram)
+}
+
+// fortranEnv returns FC and F90 naming the Fortran compiler when the
+// extension directory has Fortran sources, so extconf.rb and the Makefile
+// can compile them. FC set in config.Env or the environment is left alone.
+// Without gfortran, flang or f95 on PATH the build fails, with the
+// compiler listed in result.MissingDependencies.
+func (b *ExtConfBuilder) fortranEnv(config *BuildConfig, extensionDir string, result *BuildResult) ([]string, error) {
+	if envValue(config, "FC") != "" || !hasFortranSources(extensionDir) {
+		return nil, nil
+	}
+
+	resolved, err := ResolveTools([]ToolRequirement{fortranCompiler})
+	if err != nil {
+		result.MissingDependencies = append(result.MissingDependencies, fortranCompiler.Name)
+		return nil, BuildError("ExtConf", result.Output, err)
+	}
+
+	compiler := resolved[fortranCompiler.Name]
+	return []string{"FC=" + compiler, "F90=" + compiler}, nil
+}
+
+// hasFortranSources reports whether dir contains Fortran source files
+func hasFortranSources(dir string) bool {
+	for _, pattern := range fortranSourcePatterns {
+		if matches, _ := filepathGlob(filepath.Join(dir, pattern)); len(matches) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// systemLibrariesEnv returns the variables that make extconf.rb link
+// system libraries rather than build vendored ones when
+// config.UseSystemLibraries is set: NOKOGIRI_USE_SYSTEM_LIBRARIES and
+// <EXT>_USE_SYSTEM_LIBRARIES for the extension directory, skipping those
+// set in config.Env. An extconf.rb using mini_portile is noted in the
+// output either way.
+func (b *ExtConfBuilder) systemLibrariesEnv(config *BuildConfig, extensionDir string, result *BuildResult) []string {
+	miniPortile := usesMiniPortile(extensionDir)
+	if !config.UseSystemLibraries {
+		if miniPortile {
+			result.Output = append(result.Output,
+				"Note: extconf.rb builds vendored libraries with mini_portile; set UseSystemLibraries to link system libraries instead")
+		}
+		return nil
+	}
+
+	names := []string{"NOKOGIRI_USE_SYSTEM_LIBRARIES"}
+	if name := systemLibrariesEnvName(filepath.Base(extensionDir)); name != "" && name != names[0] {
+		names = append(names, name)
+	}
+
+	var env []string
+	for _, name := range names {
+		if _, ok := config.Env[name]; !ok {
+			env = append(env, name+"=1")
+		}
+	}
+
+	if miniPortile {
+		result.Output = append(result.Output, "Using system libraries instead of vendored mini_portile builds: "+strings.Join(env, " "))
+	}
+
+	return env
+}
+
+// systemLibrariesEnvName returns <EXT>_USE_SYSTEM_LIBRARIES for an
+// extension name, with characters not allowed in variable names replaced
+// by underscores, or "" if the name has no letters or digits
+func systemLibrariesEnvName(extensionName string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, extensionName)
+
+	if strings.Trim(name, "_") == "" {
+		return ""
+	}
+	return name + "_USE_SYSTEM_LIBRARIES"
+}
+
+// usesMiniPortile reports whether the extension's extconf.rb requires
+// mini_portile (or mini_portile2) to build vendored libraries
+func usesMiniPortile(extensionDir string) bool {
+	content, err := os.ReadFile(filepath.Join(extensionDir, "extconf.rb"))
+	return err == nil && strings.Contains(string(content), "mini_portile")
+}
+
+// truffleRubyToolchainArgs returns make command-line assignments of CC and
+// CXX pointing at TruffleRuby's LLVM toolchain when config.RubyEngine is
+// truffleruby. Assignments on the command line take precedence over the
+// compilers the Makefile assigns.
+//
+// TruffleRuby loads extensions compiled to LLVM bitcode by the wrappers it
+// ships, so building with the system compiler produces artifacts it can't
+// load. The wrapper paths are read from RbConfig of config.RubyPath.
+// CC or CXX set in config.Env are left alone.
+func (b *ExtConfBuilder) truffleRubyToolchainArgs(ctx context.Context, config *BuildConfig, result *BuildResult) ([]string, error) {
+	if config.RubyEngine != "truffleruby" {
+		return nil, nil
+	}
+
+	cmd := execCommandContext(ctx, b.rubyPath(config), "-e",
+		`puts "CC=#{RbConfig::CONFIG['CC']}", "CXX=#{RbConfig::CONFIG['CXX']}"`)
+	cmd.Env = buildCommandEnv(config)
+
+	// Lines other than the prefixed ones (e.g. warnings) are ignored
+	output, err := combinedOutput(cmd)
+	compilers := make(map[string]string)
+	for _, line := range strings.Split(string(output), "\n") {
+		for _, name := range []string{"CC", "CXX"} {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(line), name+"="); ok {
+				compilers[name] = value
+			}
+		}
+	}
+	if err == nil && compilers["CC"] == "" {
+		err = fmt.Errorf("no C compiler in RbConfig output %q", strings.TrimSpace(string(output)))
+	}
+	if err != nil {
+		appendCommandOutput(config, result, output)
+		return nil, BuildError("TruffleRuby toolchain", result.Output, err)
+	}
+
+	// An empty CXX (no C++ compiler) leaves the Makefile's alone
+	var args []string
+	for _, name := range []string{"CC", "CXX"} {
+		value := compilers[name]
+		if _, ok := config.Env[name]; ok || value == "" {
+			continue
+		}
+		args = append(args, fmt.Sprintf("%s=%s", name, value))
+	}
+
+	if config.effectiveLogLevel() >= LogLevelVerbose {
+		result.Output = append(result.Output, fmt.Sprintf("Using TruffleRuby toolchain: %s", strings.Join(args, " ")))
+	}
+
+	return args, nil
+}
+
 // findBuiltExtensions locates the compiled extension files
 func (b *ExtConfBuilder) findBuiltExtensions(extensionDir string) ([]string, error) {
 	var extensions []string
@@ -197,7 +640,7 @@ func (b *ExtConfBuilder) findBuiltExtensions(extensionDir string) ([]string, err
 	}
 
 	for _, pattern := range patterns {
-		matches, err := filepath.Glob(filepath.Join(extensionDir, pattern))
+		matches, err := filepathGlob(filepath.Join(extensionDir, pattern))
 		if err != nil {
 			return nil, fmt.Errorf("failed to glob pattern %s in %s: %v", pattern, extensionDir, err)
 		}
@@ -226,6 +669,6 @@ func (b *ExtConfBuilder) getMakeProgram() string {
 	case "windows":
 		return "nmake" // Visual Studio's make
 	default:
-		return "make"
+		return unixMakeProgram(runtime.GOOS)
 	}
 }
@@ -0,0 +1,483 @@
+package rubyext
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeWarningToolchain writes a fake ruby that generates a Makefile and a
+// fake make that emits a compiler warning, failing only if CFLAGS has -Werror
+func writeWarningToolchain(t *testing.T) (rubyPath, gemDir string) {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script toolchain requires a POSIX shell")
+	}
+
+	toolDir := t.TempDir()
+	rubyPath = filepath.Join(toolDir, "ruby")
+	makePath := filepath.Join(toolDir, "make")
+
+	rubyScript := "#!/bin/sh\necho 'all:' > Makefile\n"
+	makeScript := `#!/bin/sh
+echo "myext.c:3:7: warning: unused variable 'x' [-Wunused-variable]" >&2
+case " $CFLAGS " in
+  *" -Werror "*) echo "cc1: all warnings being treated as errors" >&2; exit 1 ;;
+esac
+touch myext.so
+`
+	writeTestScript(t, rubyPath, rubyScript)
+	writeTestScript(t, makePath, makeScript)
+	t.Setenv("MAKE", makePath)
+
+	return rubyPath, writeTestExtconfGem(t)
+}
+
+// writeTestScript writes an executable shell script
+func writeTestScript(t *testing.T, path, script string) {
+	t.Helper()
+
+	//nolint:gosec // Test scripts must be executable
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+// writeTestExtconfGem creates a gem with ext/myext/extconf.rb
+func writeTestExtconfGem(t *testing.T) string {
+	t.Helper()
+
+	gemDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "myext")
+	if err := os.MkdirAll(extDir, 0o755); err != nil {
+		t.Fatalf("failed to create extension dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(extDir, "extconf.rb"), []byte("create_makefile 'myext'\n"), 0o600); err != nil {
+		t.Fatalf("failed to write extconf.rb: %v", err)
+	}
+
+	return gemDir
+}
+
+func TestExtConfBuilderWarningsAsErrors(t *testing.T) {
+	rubyPath, gemDir := writeWarningToolchain(t)
+	builder := &ExtConfBuilder{}
+
+	config := &BuildConfig{GemDir: gemDir, RubyPath: rubyPath}
+	result, err := builder.Build(context.Background(), config, "ext/myext/extconf.rb")
+	if err != nil || !result.Success {
+		t.Fatalf("expected warning to be tolerated by default, got %v", err)
+	}
+
+	config.WarningsAsErrors = true
+	result, err = builder.Build(context.Background(), config, "ext/myext/extconf.rb")
+	if err == nil || result.Success {
+		t.Fatal("expected warning to fail the build with WarningsAsErrors")
+	}
+	if !strings.Contains(strings.Join(result.Output, "\n"), "warnings being treated as errors") {
+		t.Fatalf("expected compiler output in result, got %v", result.Output)
+	}
+}
+
+func TestExtConfBuilderWarningsAsErrorsKeepsCFLAGS(t *testing.T) {
+	builder := &ExtConfBuilder{}
+	config := &BuildConfig{
+		WarningsAsErrors: true,
+		Env:              map[string]string{"CFLAGS": "-O2 -g"},
+	}
+
+	env := builder.compilerFlagsEnv(config)
+	if len(env) != 1 || env[0] != "CFLAGS=-O2 -g -Werror" {
+		t.Fatalf("expected -Werror appended to CFLAGS, got %v", env)
+	}
+}
+
+func TestExtConfBuilderUsesTruffleRubyToolchain(t *testing.T) {
+	makePath, err := exec.LookPath("make")
+	if err != nil || runtime.GOOS == platformWindows {
+		t.Skip("requires make")
+	}
+	t.Setenv("MAKE", makePath)
+
+	// The Makefile assigns the system compilers, as a differing RbConfig would
+	rubyPath := filepath.Join(t.TempDir(), "truffleruby")
+	writeTestScript(t, rubyPath, `#!/bin/sh
+if [ "$1" = "-e" ]; then
+  echo 'warning: toolchain probe' >&2
+  echo CC=/opt/truffleruby/lib/toolchain/clang
+  echo "CXX=$TRUFFLE_CXX"
+else
+  printf 'CC = gcc\nCXX = g++\nall:\n\t@echo "$(CC)|$(CXX)" > toolchain.txt\n\t@touch myext.so\n' > Makefile
+fi
+`)
+
+	gemDir := writeTestExtconfGem(t)
+	config := &BuildConfig{GemDir: gemDir, RubyPath: rubyPath, RubyEngine: "truffleruby"}
+
+	result, err := (&ExtConfBuilder{}).Build(context.Background(), config, "ext/myext/extconf.rb")
+	if err != nil || !result.Success {
+		t.Fatalf("expected build to succeed, got %v", err)
+	}
+
+	toolchain, err := os.ReadFile(filepath.Join(gemDir, "ext", "myext", "toolchain.txt"))
+	if err != nil {
+		t.Fatalf("failed to read toolchain recorded by make: %v", err)
+	}
+	expected := "/opt/truffleruby/lib/toolchain/clang|g++"
+	if strings.TrimSpace(string(toolchain)) != expected {
+		t.Fatalf("expected make to run with %q, got %q", expected, strings.TrimSpace(string(toolchain)))
+	}
+
+	// A C++ compiler in RbConfig replaces the Makefile's too
+	t.Setenv("TRUFFLE_CXX", "/opt/truffleruby/lib/toolchain/clang++")
+	if result, err := (&ExtConfBuilder{}).Build(context.Background(), config, "ext/myext/extconf.rb"); err != nil || !result.Success {
+		t.Fatalf("expected build to succeed, got %v", err)
+	}
+	toolchain, _ = os.ReadFile(filepath.Join(gemDir, "ext", "myext", "toolchain.txt"))
+	expected = "/opt/truffleruby/lib/toolchain/clang|/opt/truffleruby/lib/toolchain/clang++"
+	if strings.TrimSpace(string(toolchain)) != expected {
+		t.Fatalf("expected make to run with %q, got %q", expected, strings.TrimSpace(string(toolchain)))
+	}
+
+	// A failed probe reports what ruby printed
+	failingRuby := filepath.Join(t.TempDir(), "truffleruby")
+	writeTestScript(t, failingRuby, "#!/bin/sh\necho 'RbConfig unavailable' >&2\nexit 1\n")
+	result = &BuildResult{}
+	config.RubyPath = failingRuby
+	if _, err := (&ExtConfBuilder{}).truffleRubyToolchainArgs(context.Background(), config, result); err == nil ||
+		!strings.Contains(err.Error(), "RbConfig unavailable") {
+		t.Fatalf("expected the probe's stderr in the error, got %v", err)
+	}
+}
+
+func TestExtConfBuilderCompilerCache(t *testing.T) {
+	makePath, err := exec.LookPath("make")
+	if err != nil || runtime.GOOS == platformWindows {
+		t.Skip("requires make")
+	}
+	t.Setenv("MAKE", makePath)
+
+	// The fake cache records the compiler it wraps
+	toolDir := t.TempDir()
+	cachePath := filepath.Join(toolDir, "ccache")
+	writeTestScript(t, cachePath, "#!/bin/sh\necho \"$@\" >> cache.log\n")
+
+	origLookPath := execLookPath
+	defer func() { execLookPath = origLookPath }()
+	execLookPath = func(name string) (string, error) {
+		if name == "ccache" {
+			return cachePath, nil
+		}
+		return "", exec.ErrNotFound
+	}
+
+	// mkmf assigns the compilers from RbConfig, overriding any in the environment
+	extDir := t.TempDir()
+	makefile := "CC = rbconfig-cc\nCXX = rbconfig-c++\nall:\n\t$(CC) -c a.c\n\t$(CXX) -c b.cpp\n"
+	if err := os.WriteFile(filepath.Join(extDir, "Makefile"), []byte(makefile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &BuildConfig{CompilerCache: "ccache", Env: map[string]string{"CC": "env-cc"}}
+	result := &BuildResult{}
+	if err := (&ExtConfBuilder{}).runMake(context.Background(), config, extDir, result); err != nil {
+		t.Fatalf("runMake returned error: %v (%v)", err, result.Output)
+	}
+
+	calls, err := os.ReadFile(filepath.Join(extDir, "cache.log"))
+	if err != nil || string(calls) != "rbconfig-cc -c a.c\nrbconfig-c++ -c b.cpp\n" {
+		t.Fatalf("expected make to compile through the cache with the Makefile's compilers, got %q (%v)", calls, err)
+	}
+
+	// A missing cache is noted and the build goes ahead without it
+	config.CompilerCache = "sccache"
+	result = &BuildResult{}
+	if err := (&ExtConfBuilder{}).runMake(context.Background(), config, extDir, result); err == nil {
+		t.Fatal("expected make to run the Makefile's compilers directly")
+	}
+	if !strings.Contains(strings.Join(result.Output, "\n"), "sccache not found") {
+		t.Fatalf("expected note about missing cache, got %v", result.Output)
+	}
+}
+
+func TestExtConfBuilderBuildsExtensionsConcurrently(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script toolchain requires a POSIX shell")
+	}
+
+	toolDir := t.TempDir()
+	rubyPath := filepath.Join(toolDir, "ruby")
+	makePath := filepath.Join(toolDir, "make")
+
+	// Each step records which directory it ran in, so a step that ran in
+	// the other extension's directory shows up as a stray artifact
+	writeTestScript(t, rubyPath, "#!/bin/sh\necho \"# $(basename \"$PWD\")\" > Makefile\n")
+	writeTestScript(t, makePath, `#!/bin/sh
+name=$(basename "$PWD")
+case "$1" in
+  clean) rm -f *.so; echo "cleaned $name" > clean.txt; exit 0 ;;
+  install) mkdir -p "$DESTDIR/$name" && cp "$name.so" "$DESTDIR/$name/"; exit 0 ;;
+esac
+grep -q "# $name" Makefile || exit 1
+touch "$name.so"
+`)
+	t.Setenv("MAKE", makePath)
+
+	gemDir := t.TempDir()
+	names := []string{"alpha", "beta"}
+	for _, name := range names {
+		extDir := filepath.Join(gemDir, "ext", name)
+		if err := os.MkdirAll(extDir, 0o755); err != nil {
+			t.Fatalf("failed to create %s: %v", extDir, err)
+		}
+		if err := os.WriteFile(filepath.Join(extDir, "extconf.rb"), []byte("create_makefile '"+name+"'\n"), 0o600); err != nil {
+			t.Fatalf("failed to write extconf.rb: %v", err)
+		}
+	}
+
+	destDir := t.TempDir()
+	config := &BuildConfig{GemDir: gemDir, RubyPath: rubyPath, DestPath: destDir, CleanFirst: true}
+	builder := &ExtConfBuilder{}
+
+	type buildOutcome struct {
+		result *BuildResult
+		err    error
+	}
+	outcomes := make([]chan buildOutcome, len(names))
+	for i, name := range names {
+		outcomes[i] = make(chan buildOutcome, 1)
+		go func() {
+			result, err := builder.Build(context.Background(), config, filepath.Join("ext", name, "extconf.rb"))
+			outcomes[i] <- buildOutcome{result, err}
+		}()
+	}
+
+	for i, name := range names {
+		outcome := <-outcomes[i]
+		if outcome.err != nil || !outcome.result.Success {
+			t.Fatalf("build of %s failed: %v\n%s", name, outcome.err, strings.Join(outcome.result.Output, "\n"))
+		}
+
+		expected := []string{filepath.ToSlash(filepath.Join(destDir, name+".so"))}
+		if !reflect.DeepEqual(outcome.result.Extensions, expected) {
+			t.Fatalf("expected %s to produce %v, got %v", name, expected, outcome.result.Extensions)
+		}
+
+		extDir := filepath.Join(gemDir, "ext", name)
+		built, err := filepath.Glob(filepath.Join(extDir, "*.so"))
+		if err != nil || !reflect.DeepEqual(built, []string{filepath.Join(extDir, name+".so")}) {
+			t.Fatalf("expected only %s.so in %s, got %v", name, extDir, built)
+		}
+
+		clean, err := os.ReadFile(filepath.Join(extDir, "clean.txt"))
+		if err != nil || strings.TrimSpace(string(clean)) != "cleaned "+name {
+			t.Fatalf("expected make clean to run in %s, got %q (%v)", extDir, clean, err)
+		}
+
+		if _, err := os.Stat(filepath.Join(destDir, name, name+".so")); err != nil {
+			t.Fatalf("expected make install to stage %s under DESTDIR: %v", name, err)
+		}
+	}
+}
+
+func TestExtConfBuilderHandlesSpacesAndUnicodeInPaths(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script toolchain requires a POSIX shell")
+	}
+
+	toolDir := filepath.Join(t.TempDir(), "My Tööls")
+	if err := os.MkdirAll(toolDir, 0o755); err != nil {
+		t.Fatalf("failed to create tool dir: %v", err)
+	}
+	rubyPath := filepath.Join(toolDir, "ruby")
+	makePath := filepath.Join(toolDir, "make")
+
+	// Like mkmf Makefiles, the install step expands DESTDIR unquoted
+	writeTestScript(t, rubyPath, "#!/bin/sh\necho 'all:' > Makefile\n")
+	writeTestScript(t, makePath, `#!/bin/sh
+if [ "$1" = install ]; then
+  mkdir -p $DESTDIR/lib && cp myext.so $DESTDIR/lib/
+  exit
+fi
+touch myext.so
+`)
+	t.Setenv("MAKE", makePath)
+
+	root := filepath.Join(t.TempDir(), "My Name", "gëms")
+	gemDir := filepath.Join(root, "myext-1.0")
+	extDir := filepath.Join(gemDir, "ext", "myext")
+	if err := os.MkdirAll(extDir, 0o755); err != nil {
+		t.Fatalf("failed to create extension dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(extDir, "extconf.rb"), []byte("create_makefile 'myext'\n"), 0o600); err != nil {
+		t.Fatalf("failed to write extconf.rb: %v", err)
+	}
+
+	destDir := filepath.Join(root, "dëst dir")
+	config := &BuildConfig{GemDir: gemDir, DestPath: destDir, RubyPath: rubyPath}
+
+	result, err := (&ExtConfBuilder{}).Build(context.Background(), config, "ext/myext/extconf.rb")
+	if err != nil || !result.Success {
+		t.Fatalf("expected build to succeed, got %v\n%s", err, strings.Join(result.Output, "\n"))
+	}
+
+	if _, err := os.Stat(filepath.Join(destDir, "lib", "myext.so")); err != nil {
+		t.Fatalf("expected make install to reach DestPath: %v", err)
+	}
+	expected := []string{filepath.ToSlash(filepath.Join(destDir, "myext.so"))}
+	if !reflect.DeepEqual(result.Extensions, expected) {
+		t.Fatalf("expected %v, got %v", expected, result.Extensions)
+	}
+}
+
+func TestExtConfBuilderLanguageStandards(t *testing.T) {
+	builder := &ExtConfBuilder{}
+	config := &BuildConfig{
+		CStandard:        "c11",
+		CXXStandard:      "c++17",
+		WarningsAsErrors: true,
+		Env:              map[string]string{"CXXFLAGS": "-O2"},
+	}
+
+	env := builder.compilerFlagsEnv(config)
+	expected := []string{"CFLAGS=-std=c11 -Werror", "CXXFLAGS=-O2 -std=c++17"}
+	if !reflect.DeepEqual(env, expected) {
+		t.Fatalf("expected %v, got %v", expected, env)
+	}
+}
+
+func TestExtConfBuilderFortranEnv(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("fake compiler requires a POSIX shell")
+	}
+
+	extDir := t.TempDir()
+	builder := &ExtConfBuilder{}
+	toolDir := t.TempDir()
+	t.Setenv("PATH", toolDir)
+	t.Setenv("FC", "")
+
+	if env, err := builder.fortranEnv(&BuildConfig{}, extDir, &BuildResult{}); env != nil || err != nil {
+		t.Fatalf("expected no Fortran env without Fortran sources, got %v, %v", env, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(extDir, "solver.f90"), []byte("end program\n"), 0o600); err != nil {
+		t.Fatalf("failed to write solver.f90: %v", err)
+	}
+
+	result := &BuildResult{}
+	if _, err := builder.fortranEnv(&BuildConfig{}, extDir, result); !errors.Is(err, ErrMissingTool) {
+		t.Fatalf("expected missing Fortran compiler error, got %v", err)
+	}
+	if !reflect.DeepEqual(result.MissingDependencies, []string{"gfortran"}) {
+		t.Fatalf("expected gfortran in MissingDependencies, got %v", result.MissingDependencies)
+	}
+
+	flang := filepath.Join(toolDir, "flang")
+	writeTestScript(t, flang, "#!/bin/sh\nexit 0\n")
+	env, err := builder.fortranEnv(&BuildConfig{}, extDir, &BuildResult{})
+	if err != nil || !reflect.DeepEqual(env, []string{"FC=" + flang, "F90=" + flang}) {
+		t.Fatalf("expected FC and F90 set to flang, got %v, %v", env, err)
+	}
+
+	config := &BuildConfig{Env: map[string]string{"FC": "/opt/intel/ifx"}}
+	if env, _ := builder.fortranEnv(config, extDir, &BuildResult{}); env != nil {
+		t.Fatalf("expected configured FC to be left alone, got %v", env)
+	}
+}
+
+func TestExtConfBuilderSystemLibrariesEnv(t *testing.T) {
+	extDir := filepath.Join(t.TempDir(), "sqlite3")
+	if err := os.MkdirAll(extDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	extconf := "require 'mkmf'\nrequire 'mini_portile2'\ncreate_makefile('sqlite3/sqlite3_native')\n"
+	if err := os.WriteFile(filepath.Join(extDir, "extconf.rb"), []byte(extconf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	builder := &ExtConfBuilder{}
+
+	result := &BuildResult{}
+	if env := builder.systemLibrariesEnv(&BuildConfig{}, extDir, result); env != nil {
+		t.Fatalf("expected no env without UseSystemLibraries, got %v", env)
+	}
+	if len(result.Output) != 1 || !strings.Contains(result.Output[0], "mini_portile") {
+		t.Fatalf("expected a note about mini_portile, got %v", result.Output)
+	}
+
+	config := &BuildConfig{UseSystemLibraries: true}
+	env := builder.systemLibrariesEnv(config, extDir, &BuildResult{})
+	expected := []string{"NOKOGIRI_USE_SYSTEM_LIBRARIES=1", "SQLITE3_USE_SYSTEM_LIBRARIES=1"}
+	if !reflect.DeepEqual(env, expected) {
+		t.Fatalf("expected %v, got %v", expected, env)
+	}
+
+	config.Env = map[string]string{"SQLITE3_USE_SYSTEM_LIBRARIES": "0"}
+	if env := builder.systemLibrariesEnv(config, extDir, &BuildResult{}); !reflect.DeepEqual(env, expected[:1]) {
+		t.Fatalf("expected Env to take precedence, got %v", env)
+	}
+
+	if name := systemLibrariesEnvName("nokogiri"); name != "NOKOGIRI_USE_SYSTEM_LIBRARIES" {
+		t.Fatalf("unexpected env name %q", name)
+	}
+	if name := systemLibrariesEnvName("re2-ext"); name != "RE2_EXT_USE_SYSTEM_LIBRARIES" {
+		t.Fatalf("unexpected env name %q", name)
+	}
+}
+
+func TestExtConfBuilderAppendsConfiguredFlagsAtMake(t *testing.T) {
+	makePath, err := exec.LookPath("make")
+	if err != nil || runtime.GOOS == platformWindows {
+		t.Skip("requires make")
+	}
+	t.Setenv("MAKE", makePath)
+	t.Setenv("CFLAGS", "")
+
+	builder := &ExtConfBuilder{}
+	config := &BuildConfig{
+		CStandard: "c11",
+		Env:       map[string]string{"CPPFLAGS": "-DVERSION=\"1#2\"", "LDFLAGS": "-L/opt/lib"},
+	}
+
+	content := builder.makeFlagsMakefile(config, "")
+	expected := "CPPFLAGS += -DVERSION=\"1\\#2\"\nCFLAGS += -std=c11\nDLDFLAGS += -L/opt/lib\n"
+	if content != expected {
+		t.Fatalf("expected %q, got %q", expected, content)
+	}
+
+	// The appended flags extend the ones mkmf wrote instead of replacing them
+	extDir := t.TempDir()
+	makefile := "CPPFLAGS = -DRUBY_EXTCONF_H\nCFLAGS = -fPIC -O3\nDLDFLAGS = -Wl,--no-undefined\n" +
+		"all:\n\t@echo '$(CPPFLAGS)|$(CFLAGS)|$(DLDFLAGS)'\n"
+	if err := os.WriteFile(filepath.Join(extDir, "Makefile"), []byte(makefile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(extDir, makeFlagsFile), []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(makePath, builder.makeArgs(config, "")...)
+	cmd.Dir = extDir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("make failed: %v\n%s", err, output)
+	}
+	if got := strings.TrimSpace(string(output)); got != `-DRUBY_EXTCONF_H -DVERSION="1#2"|-fPIC -O3 -std=c11|-Wl,--no-undefined -L/opt/lib` {
+		t.Fatalf("unexpected make flags %q", got)
+	}
+
+	if content := builder.makeFlagsMakefile(&BuildConfig{}, ""); content != "" {
+		t.Fatalf("expected no flags makefile without configured flags, got %q", content)
+	}
+	if args := builder.makeArgs(&BuildConfig{}, ""); slices.Contains(args, makeFlagsFile) {
+		t.Fatalf("expected plain make arguments without configured flags, got %v", args)
+	}
+}
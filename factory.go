@@ -3,7 +3,13 @@ package rubyext
 import (
 	"context"
 	"fmt"
-	"path/filepath"
+	"os"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 )
 
 // BuilderFactory manages the registration and selection of extension builders.
@@ -39,11 +45,22 @@ import (
 //
 // # Thread Safety
 //
-// BuilderFactory is NOT thread-safe for registration.
-// Register all builders before concurrent use.
-// After registration, Read operations (BuilderFor, BuildAllExtensions) are safe.
+// BuilderFactory is safe for concurrent use. Register and RegisterFirst
+// may be called while other goroutines select builders or build, for
+// example to register builders lazily on first use; a build already in
+// progress keeps the builders it started with. Concurrent BuildWith and
+// BuildAllExtensions calls on the same factory share config.TotalJobs
+// when it is set; BuildAllExtensions itself builds one extension at a
+// time. A BuilderFactory must not be copied after first use.
 type BuilderFactory struct {
+	mu       sync.RWMutex
 	builders []Builder
+
+	// The config.TotalJobs budget shared by the builds in progress
+	jobsMu     sync.Mutex
+	jobsInUse  int           // Jobs held by running builds
+	jobsActive int           // Builds drawing on the budget, running or waiting
+	jobsFreed  chan struct{} // Closed, and replaced, when jobs are returned
 }
 
 // NewBuilderFactory creates a factory with all standard builders registered.
@@ -59,14 +76,16 @@ type BuilderFactory struct {
 //  4. CMakeBuilder - CMakeLists.txt
 //  5. CargoBuilder - Cargo.toml (Rust)
 //  6. MakefileBuilder - Plain Makefile
-//  7. GoBuilder - Go with CGO
-//  8. JavaBuilder - Java/JRuby extensions
+//  7. WafBuilder - waf build scripts (wscript)
+//  8. GoBuilder - Go with CGO
+//  9. JavaBuilder - Java/JRuby extensions
 //
 // Modern languages (generic builders):
-//  9. CrystalBuilder - Crystal language
 //
-// 10. ZigBuilder - Zig language
-// 11. SwiftBuilder - Swift language
+// 10. CrystalBuilder - Crystal language
+// 11. ZigBuilder - Zig language
+// 12. SwiftBuilder - Swift language
+// 13. PythonBuilder - Python setup.py build_ext
 //
 // This is the recommended way to create a BuilderFactory for most use cases.
 // Builders are checked in registration order, so more specific builders
@@ -84,6 +103,7 @@ func NewBuilderFactory() *BuilderFactory {
 	factory.Register(&CmakeBuilder{})
 	factory.Register(&CargoBuilder{})
 	factory.Register(&MakefileBuilder{})
+	factory.Register(&WafBuilder{})
 	factory.Register(&GoBuilder{})
 	factory.Register(&JavaBuilder{})
 
@@ -91,6 +111,7 @@ func NewBuilderFactory() *BuilderFactory {
 	factory.Register(NewCrystalBuilder())
 	factory.Register(NewZigBuilder())
 	factory.Register(NewSwiftBuilder())
+	factory.Register(NewPythonBuilder())
 
 	return factory
 }
@@ -101,11 +122,26 @@ func NewBuilderFactory() *BuilderFactory {
 // If multiple builders can handle the same file type, the first
 // registered builder will be used.
 //
-// Not thread-safe. Register all builders before concurrent use.
+// Safe to call concurrently with other factory methods.
 func (f *BuilderFactory) Register(builder Builder) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	f.builders = append(f.builders, builder)
 }
 
+// RegisterFirst adds a new builder ahead of all registered builders.
+//
+// BuilderFor still returns the first matching builder; this only
+// controls ordering, so a builder registered with RegisterFirst is
+// checked before the standard builders and wins any overlap.
+//
+// Safe to call concurrently with other factory methods.
+func (f *BuilderFactory) RegisterFirst(builder Builder) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.builders = append([]Builder{builder}, f.builders...)
+}
+
 // BuilderFor returns the appropriate builder for the given extension file.
 //
 // The extensionFile can be a full path (e.g., "ext/myext/extconf.rb")
@@ -115,15 +151,260 @@ func (f *BuilderFactory) Register(builder Builder) {
 // Returns the first builder whose CanBuild() method returns true,
 // or an error if no builder can handle the file.
 func (f *BuilderFactory) BuilderFor(extensionFile string) (Builder, error) {
-	filename := filepath.Base(extensionFile)
+	filename := path.Base(normalizeExtensionPath(extensionFile))
 
-	for _, builder := range f.builders {
+	for _, builder := range f.ListBuilders() {
 		if builder.CanBuild(filename) {
 			return builder, nil
 		}
 	}
 
-	return nil, fmt.Errorf("no builder found for extension file: %s", filename)
+	return nil, fmt.Errorf("%w for extension file: %s", ErrNoBuilder, filename)
+}
+
+// BuildersFor returns every builder that can handle the given extension
+// file, in registration order.
+//
+// The first builder is the one BuilderFor would pick. More than one entry
+// means builders overlap for this file; callers can inspect the conflict
+// or pick a different builder and pass it to BuildWith. Returns nil if no
+// builder can handle the file.
+func (f *BuilderFactory) BuildersFor(extensionFile string) []Builder {
+	filename := path.Base(normalizeExtensionPath(extensionFile))
+
+	var builders []Builder
+	for _, builder := range f.ListBuilders() {
+		if builder.CanBuild(filename) {
+			builders = append(builders, builder)
+		}
+	}
+
+	return builders
+}
+
+// BuildExtension builds an extension with the given builder, for callers
+// that already know the build system (e.g. from gemspec metadata) and
+// don't want it picked from the file name. CanBuild is not consulted.
+// Each call uses a fresh factory, so builds started this way don't share
+// config.TotalJobs. See BuilderFactory.BuildWith.
+func BuildExtension(ctx context.Context, config *BuildConfig, builder Builder, extensionFile string) (*BuildResult, error) {
+	return (&BuilderFactory{}).BuildWith(ctx, config, builder, extensionFile)
+}
+
+// BuildWith builds an extension with the given builder, bypassing
+// builder selection.
+//
+// The result is filled in the same way as by BuildAllExtensions:
+// BuilderName, ExtensionFile and Duration are set, and a result is
+// returned even if the builder returned none. The builder doesn't need
+// to be registered with the factory.
+//
+// If the builder implements ToolChecker and a required tool is missing,
+// Build is not called; the result lists the missing tools in
+// MissingDependencies instead. With config.RequireCompiler set, builders
+// that need a C compiler fail the same way, with a *CompilerMismatchError,
+// when the compiler isn't the required one. With config.CaptureToolVersions
+// set, the versions reported by a ToolVersioner are added to the result.
+// With config.PrefixOutput set, each line of result.Output is prefixed
+// with the builder and extension so merged logs stay attributable. With
+// config.TotalJobs set, the build's parallel jobs come from the budget
+// shared with the factory's other builds in progress, waiting for jobs to
+// be returned if the budget is spent.
+func (f *BuilderFactory) BuildWith(ctx context.Context, config *BuildConfig, builder Builder, extensionFile string) (*BuildResult, error) {
+	start := time.Now()
+	extensionFile = normalizeExtensionPath(extensionFile)
+
+	config, release, err := f.reserveJobs(ctx, config)
+	defer release()
+
+	var result *BuildResult
+	if err == nil {
+		var missing []string
+		missing, err = checkPrerequisites(ctx, config, builder)
+		if err != nil {
+			result = &BuildResult{
+				Success:             false,
+				Error:               err,
+				MissingDependencies: missing,
+			}
+		} else {
+			result, err = builder.Build(ctx, config, extensionFile)
+		}
+	}
+
+	duration := time.Since(start)
+
+	// Ensure we have a result even if builder didn't return one
+	if result == nil {
+		result = &BuildResult{
+			Success:  false,
+			Error:    err,
+			ExitCode: ExitCode(err),
+		}
+	}
+
+	result.BuilderName = builder.Name()
+	result.ExtensionFile = extensionFile
+	result.Duration = duration
+	prefixOutput(config, result)
+	captureToolVersions(ctx, config, builder, result)
+
+	return result, err
+}
+
+// reserveJobs takes jobs from the factory's config.TotalJobs budget when
+// it is set and config.Parallel isn't, returning a copy of config with
+// Parallel set to the jobs taken: the build's share of the budget (divided
+// among config.ConcurrentBuilds, or among the builds drawing on it if
+// more, at least 1), or what is left of the budget if less. With the budget spent it waits for running builds to
+// return jobs, or for ctx to be done. The returned func gives the jobs
+// back and must be called even on error.
+//
+// Builds that set Parallel neither draw on the budget nor count toward
+// the builds sharing it.
+func (f *BuilderFactory) reserveJobs(ctx context.Context, config *BuildConfig) (*BuildConfig, func(), error) {
+	if config.TotalJobs <= 0 || config.Parallel > 0 {
+		return config, func() {}, nil
+	}
+
+	f.jobsMu.Lock()
+	f.jobsActive++
+	for {
+		if remaining := config.TotalJobs - f.jobsInUse; remaining > 0 {
+			sharing := max(f.jobsActive, config.ConcurrentBuilds)
+			jobs := min(max(1, config.TotalJobs/sharing), remaining)
+			f.jobsInUse += jobs
+			f.jobsMu.Unlock()
+
+			shared := *config
+			shared.Parallel = jobs
+			return &shared, func() { f.returnJobs(jobs) }, nil
+		}
+
+		if f.jobsFreed == nil {
+			f.jobsFreed = make(chan struct{})
+		}
+		freed := f.jobsFreed
+		f.jobsMu.Unlock()
+
+		select {
+		case <-freed:
+			f.jobsMu.Lock()
+		case <-ctx.Done():
+			f.returnJobs(0)
+			return config, func() {}, ctx.Err()
+		}
+	}
+}
+
+// returnJobs ends a build drawing on the TotalJobs budget, giving back
+// the jobs it held and waking builds waiting for them
+func (f *BuilderFactory) returnJobs(jobs int) {
+	f.jobsMu.Lock()
+	defer f.jobsMu.Unlock()
+
+	f.jobsInUse -= jobs
+	f.jobsActive--
+	if f.jobsFreed != nil {
+		close(f.jobsFreed)
+		f.jobsFreed = nil
+	}
+}
+
+// checkPrerequisites verifies the builder's required tools and, when
+// config.RequireCompiler applies to it, the C compiler. It returns the
+// missing dependencies along with the error.
+func checkPrerequisites(ctx context.Context, config *BuildConfig, builder Builder) ([]string, error) {
+	if missing := missingDependencies(config, builder); len(missing) > 0 {
+		return missing, &MissingToolError{
+			Tools:   missing,
+			message: fmt.Sprintf("%s builder is missing required tools: %s", builder.Name(), strings.Join(missing, ", ")),
+		}
+	}
+
+	if needsCompilerCheck(config, builder) {
+		if err := checkRequiredCompiler(ctx, config); err != nil {
+			return []string{config.RequireCompiler}, err
+		}
+	}
+
+	return nil, nil
+}
+
+// toolEnvOverrides maps tools to the environment variables builders read
+// to locate them, so a tool set there doesn't need to be on PATH
+var toolEnvOverrides = map[string]string{
+	"make":  "MAKE",
+	"cargo": "CARGO",
+}
+
+// missingDependencies returns the names of the builder's required tools
+// that can't be found. Builders that don't implement ToolChecker report
+// nothing.
+//
+// Tools the config points at directly count as available: ruby when
+// config.RubyPath is set, tools overridden by environment variables
+// (MAKE, CARGO), and the MSVC compiler and nmake when config.AutoMSVCEnv
+// will set them up on Windows.
+func missingDependencies(config *BuildConfig, builder Builder) []string {
+	checker, ok := builder.(ToolChecker)
+	if !ok {
+		return nil
+	}
+
+	var missing []string
+	for _, req := range checker.RequiredTools() {
+		if req.Optional || toolProvidedByConfig(config, req) {
+			continue
+		}
+		if resolved, _ := ResolveTools([]ToolRequirement{req}); resolved[req.Name] == "" {
+			missing = append(missing, req.Name)
+		}
+	}
+
+	return missing
+}
+
+// toolProvidedByConfig reports whether the config supplies a tool
+// requirement without it being found on PATH
+func toolProvidedByConfig(config *BuildConfig, req ToolRequirement) bool {
+	if req.Name == rubyCommand && config.RubyPath != "" {
+		return true
+	}
+	if envVar, ok := toolEnvOverrides[req.Name]; ok && os.Getenv(envVar) != "" {
+		return true
+	}
+
+	if config.AutoMSVCEnv && runtime.GOOS == platformWindows {
+		for _, tool := range append([]string{req.Name}, req.Alternatives...) {
+			if tool == "cl" || tool == nmakeProgram {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// RequirementsFor returns the tools needed to build the given extension file.
+//
+// The builder is selected the same way as BuilderFor, and nothing is
+// executed, so this can be used to show what a gem needs before building.
+//
+// Returns nil requirements if the selected builder doesn't implement
+// ToolChecker, or an error if no builder can handle the file.
+func (f *BuilderFactory) RequirementsFor(extensionFile string) ([]ToolRequirement, error) {
+	builder, err := f.BuilderFor(extensionFile)
+	if err != nil {
+		return nil, err
+	}
+
+	checker, ok := builder.(ToolChecker)
+	if !ok {
+		return nil, nil
+	}
+
+	return checker.RequiredTools(), nil
 }
 
 // ListBuilders returns a copy of all registered builders.
@@ -131,6 +412,8 @@ func (f *BuilderFactory) BuilderFor(extensionFile string) (Builder, error) {
 // The returned slice is a copy and can be modified without affecting
 // the factory's internal state.
 func (f *BuilderFactory) ListBuilders() []Builder {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 	return append([]Builder{}, f.builders...)
 }
 
@@ -139,9 +422,10 @@ func (f *BuilderFactory) ListBuilders() []Builder {
 // This method processes each extension in order:
 //  1. Check for context cancellation
 //  2. Find the appropriate builder
-//  3. Build the extension
-//  4. Collect the result
-//  5. Stop on first failure if config.StopOnFailure is true
+//  3. Check the builder's required tools (see BuildWith)
+//  4. Build the extension
+//  5. Collect the result
+//  6. Stop on first failure if config.StopOnFailure is true
 //
 // # Return Values
 //
@@ -152,8 +436,24 @@ func (f *BuilderFactory) ListBuilders() []Builder {
 // Even if an error is returned, the results slice will contain
 // partial results for extensions that were processed.
 //
+// An empty extensions list builds nothing and returns no error, as for a
+// pure-Ruby gem, unless config.ExpectExtensions is set.
+//
+// When config.RubyPath is empty but RubyEngine or RubyVersion is set, the
+// extensions are built with the Ruby found by ResolveRubyPath; if none is
+// found, builders fall back to ruby on PATH as before. The caller's config
+// is not modified.
+//
+// As in RubyGems, an entry may carry build options after " -- "
+// (e.g. "ext/foo/extconf.rb -- --with-foo-dir=/opt/foo"). The file part
+// selects the builder and is reported as the result's ExtensionFile; the
+// options are appended to config.BuildArgs for that extension only.
+//
 // # Error Handling
 //
+// Extension files whose directory is outside config.GemDir fail with an
+// *ExtensionPathError (ErrOutsideGemDir) without being built.
+//
 // If config.StopOnFailure is true (default):
 //   - Processing stops after the first failed extension
 //   - Results slice contains results up to and including the failure
@@ -171,34 +471,56 @@ func (f *BuilderFactory) ListBuilders() []Builder {
 //   - The context error is returned
 func (f *BuilderFactory) BuildAllExtensions(ctx context.Context, config *BuildConfig, extensions []string) ([]*BuildResult, error) {
 	if len(extensions) == 0 {
+		if config.ExpectExtensions {
+			return nil, fmt.Errorf("no extensions to build, but extensions were expected: " +
+				"check the gemspec's extensions list and the ext/ directory")
+		}
 		return nil, nil
 	}
 
+	// Build with the Ruby matching RubyEngine/RubyVersion rather than
+	// whichever ruby is first on PATH
+	if config.RubyPath == "" && (config.RubyEngine != "" || config.RubyVersion != "") {
+		if rubyPath, err := ResolveRubyPath(config); err == nil {
+			resolved := *config
+			resolved.RubyPath = rubyPath
+			config = &resolved
+		}
+	}
+
 	var results []*BuildResult
 	var firstError error
 
-	for _, extension := range extensions {
+	for _, entry := range extensions {
+		extension, extConfig := extensionBuildOptions(config, entry)
+
 		// Check for context cancellation
 		if ctxErr := ctx.Err(); ctxErr != nil {
 			if firstError == nil {
 				firstError = ctxErr
 			}
 			results = append(results, &BuildResult{
-				Success: false,
-				Error:   ctxErr,
+				Success:       false,
+				Error:         ctxErr,
+				ExtensionFile: extension,
 			})
 			break
 		}
 
-		// Find appropriate builder
-		builder, err := f.BuilderFor(extension)
+		// Reject paths escaping the gem, then find the appropriate builder
+		var builder Builder
+		err := validateExtensionPath(config, extension)
+		if err == nil {
+			builder, err = f.BuilderFor(extension)
+		}
 		if err != nil {
 			if firstError == nil {
 				firstError = err
 			}
 			results = append(results, &BuildResult{
-				Success: false,
-				Error:   err,
+				Success:       false,
+				Error:         err,
+				ExtensionFile: extension,
 			})
 			if config.StopOnFailure {
 				break
@@ -207,20 +529,10 @@ func (f *BuilderFactory) BuildAllExtensions(ctx context.Context, config *BuildCo
 		}
 
 		// Build the extension
-		result, err := builder.Build(ctx, config, extension)
-		if err != nil {
-			if firstError == nil {
-				firstError = err
-			}
-			// Ensure we have a result even if builder didn't return one
-			if result == nil {
-				result = &BuildResult{
-					Success: false,
-					Error:   err,
-				}
-			}
+		result, err := f.BuildWith(ctx, extConfig, builder, extension)
+		if err != nil && firstError == nil {
+			firstError = err
 		}
-
 		results = append(results, result)
 
 		// Stop on first failure if configured
@@ -231,3 +543,27 @@ func (f *BuilderFactory) BuildAllExtensions(ctx context.Context, config *BuildCo
 
 	return results, firstError
 }
+
+// extensionBuildOptions splits a gemspec extensions entry of the form
+// "ext/foo/extconf.rb -- --with-opt" into the extension file and a config
+// with the trailing options appended to BuildArgs. Entries without options
+// return config unchanged.
+func extensionBuildOptions(config *BuildConfig, entry string) (string, *BuildConfig) {
+	file, options, found := strings.Cut(entry, " -- ")
+	if !found {
+		if trimmed, ok := strings.CutSuffix(entry, " --"); ok {
+			return strings.TrimSpace(trimmed), config
+		}
+		return entry, config
+	}
+
+	file = strings.TrimSpace(file)
+	args := strings.Fields(options)
+	if len(args) == 0 {
+		return file, config
+	}
+
+	extConfig := *config
+	extConfig.BuildArgs = slices.Concat(config.BuildArgs, args)
+	return file, &extConfig
+}
@@ -0,0 +1,305 @@
+package rubyext
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// defaultExtensionPatterns are used in config.ExtensionSearchDirs when
+// config.ExtensionPatterns is empty
+var defaultExtensionPatterns = []string{"*.so", "*.bundle", "*.dll", "*.dylib"}
+
+// staticLibraryPatterns match the static archives picked up when
+// config.BuildStatic is set
+var staticLibraryPatterns = []string{"*.a", "*.lib"}
+
+// defaultExcludeDirs are the directories whose files are not taken for
+// built extensions when config.ExcludeDirs is nil: test fixtures, vendored
+// dependencies and scratch space
+var defaultExcludeDirs = []string{"spec", "test", "vendor", "tmp"}
+
+// findExtensions runs a builder's find step and adds the files matched by
+// config.ExtensionPatterns and config.ExtensionSearchDirs.
+//
+// Files the builder finds below a directory matching config.ExcludeDirs
+// (see inExcludedDir) are dropped, so a fixture .so under spec/ is not
+// installed as the extension. Files in configured ExtensionSearchDirs
+// are kept, since those directories were asked for explicitly.
+//
+// The configured patterns are globbed in the extension directory and in
+// each search directory (relative to the extension directory). Search
+// directories without configured patterns are searched for native
+// libraries. Files named with the target Ruby's DLEXT are also picked up
+// when it isn't one of the usual extensions, and static archives (*.a,
+// *.lib) when config.BuildStatic is set. The result is the builder's
+// own findings followed by any additional matches, relative to the
+// extension directory and without duplicates, reordered or narrowed by
+// selectPrimaryExtension. With config.MakeDir set, the builder's find step
+// also runs there.
+func findExtensions(config *BuildConfig, extensionDir string, find func(string) ([]string, error)) ([]string, error) {
+	extensions, err := findAllExtensions(config, extensionDir, find)
+	if err != nil {
+		return nil, err
+	}
+	return selectPrimaryExtension(config, extensions)
+}
+
+// findAllExtensions implements findExtensions before the primary extension
+// is selected
+func findAllExtensions(config *BuildConfig, extensionDir string, find func(string) ([]string, error)) ([]string, error) {
+	extensions, err := find(extensionDir)
+	if err != nil {
+		return nil, err
+	}
+	extensions = removeExcluded(config, extensions)
+
+	if config.MakeDir != "" {
+		made, err := findInMakeDir(config, extensionDir, find)
+		if err != nil {
+			return nil, err
+		}
+		extensions = uniqueStrings(append(extensions, made...))
+	}
+
+	if dlextLibraries := findRubyDLExtLibraries(config, extensionDir); len(dlextLibraries) > 0 {
+		extensions = uniqueStrings(append(extensions, dlextLibraries...))
+	}
+
+	if config.BuildStatic {
+		staticLibraries, err := findLibraries(extensionDir, staticLibraryPatterns)
+		if err != nil {
+			return nil, err
+		}
+		extensions = uniqueStrings(append(extensions, removeExcluded(config, staticLibraries)...))
+	}
+
+	if len(config.ExtensionPatterns) == 0 && len(config.ExtensionSearchDirs) == 0 {
+		return extensions, nil
+	}
+
+	patterns := config.ExtensionPatterns
+	searchDirs := append([]string{"."}, config.ExtensionSearchDirs...)
+	if len(patterns) == 0 {
+		patterns = defaultExtensionPatterns
+		searchDirs = config.ExtensionSearchDirs
+	}
+
+	for _, dir := range searchDirs {
+		for _, pattern := range patterns {
+			matches, err := filepathGlob(filepath.Join(extensionDir, dir, pattern))
+			if err != nil {
+				return nil, fmt.Errorf("failed to glob pattern %s in %s: %v", pattern, dir, err)
+			}
+
+			for _, match := range matches {
+				if relPath, err := filepath.Rel(extensionDir, match); err == nil {
+					extensions = append(extensions, relPath)
+				}
+			}
+		}
+	}
+
+	return uniqueStrings(extensions), nil
+}
+
+// findInMakeDir runs a builder's find step in config.MakeDir and returns
+// its findings relative to the extension directory
+func findInMakeDir(config *BuildConfig, extensionDir string, find func(string) ([]string, error)) ([]string, error) {
+	dir, err := makeDir(config, extensionDir)
+	if err != nil {
+		return nil, err
+	}
+	found, err := find(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	extensions := make([]string, 0, len(found))
+	for _, rel := range found {
+		if relPath, err := filepath.Rel(extensionDir, filepath.Join(dir, rel)); err == nil {
+			extensions = append(extensions, relPath)
+		}
+	}
+	return extensions, nil
+}
+
+// excludeDirs returns config.ExcludeDirs, or defaultExcludeDirs when nil
+func excludeDirs(config *BuildConfig) []string {
+	if config.ExcludeDirs != nil {
+		return config.ExcludeDirs
+	}
+	return defaultExcludeDirs
+}
+
+// removeExcluded drops the extensions (relative to the extension
+// directory) that are below an excluded directory. Libraries in
+// rake-compiler's build directories (tmp/<platform>/<name>/<ruby version>)
+// are only dropped by an explicit config.ExcludeDirs, not by the default
+// tmp exclusion.
+func removeExcluded(config *BuildConfig, extensions []string) []string {
+	patterns := excludeDirs(config)
+	if len(patterns) == 0 {
+		return extensions
+	}
+
+	var kept []string
+	for _, extension := range extensions {
+		dir := filepath.Dir(extension)
+		if config.ExcludeDirs == nil && isRakeCompilerTmpDir(dir) {
+			kept = append(kept, extension)
+			continue
+		}
+		if !inExcludedDir(dir, patterns) {
+			kept = append(kept, extension)
+		}
+	}
+	return kept
+}
+
+// isRakeCompilerTmpDir reports whether the relative directory dir is one
+// of rake-compiler's build directories
+func isRakeCompilerTmpDir(dir string) bool {
+	for _, pattern := range rakeCompilerTmpDirs {
+		if matched, _ := filepath.Match(filepath.FromSlash(pattern), dir); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// inExcludedDir reports whether the relative directory dir is, or is
+// below, a directory matching one of patterns. A pattern is a directory
+// name or glob ("spec", "fixtures*") matched against each path element, or
+// a relative path ("ext/vendor") matched against leading elements.
+func inExcludedDir(dir string, patterns []string) bool {
+	dir = filepath.Clean(dir)
+	if dir == "." {
+		return false
+	}
+
+	elements := strings.Split(dir, string(filepath.Separator))
+	for i, element := range elements {
+		prefix := filepath.Join(elements[:i+1]...)
+		for _, pattern := range patterns {
+			pattern = filepath.Clean(filepath.FromSlash(pattern))
+			if matched, _ := filepath.Match(pattern, element); matched {
+				return true
+			}
+			if matched, _ := filepath.Match(pattern, prefix); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selectPrimaryExtension moves the files matching
+// config.PrimaryExtensionName to the front of extensions, or with
+// config.OnlyPrimary keeps only those. Unless OnlyPrimary is set, the
+// extensions are returned as found when none match. With OnlyPrimary, an
+// error wrapping ErrNoArtifacts is returned if files were found but none
+// match.
+func selectPrimaryExtension(config *BuildConfig, extensions []string) ([]string, error) {
+	if config.PrimaryExtensionName == "" || len(extensions) == 0 {
+		return extensions, nil
+	}
+
+	var primary, others []string
+	for _, extension := range extensions {
+		if isPrimaryExtension(config.PrimaryExtensionName, extension) {
+			primary = append(primary, extension)
+		} else {
+			others = append(others, extension)
+		}
+	}
+
+	if !config.OnlyPrimary {
+		return append(primary, others...), nil
+	}
+	if len(primary) == 0 {
+		return nil, fmt.Errorf("%w: primary extension %s not among the built files %v",
+			ErrNoArtifacts, config.PrimaryExtensionName, extensions)
+	}
+	return primary, nil
+}
+
+// isPrimaryExtension reports whether the extension file (relative to the
+// extension directory) is the one named: by relative path, file name, or
+// file name without the extension
+func isPrimaryExtension(name, extension string) bool {
+	name = filepath.Clean(filepath.FromSlash(name))
+	base := filepath.Base(extension)
+	return filepath.Clean(extension) == name || base == name || strings.TrimSuffix(base, filepath.Ext(base)) == name
+}
+
+// extensionFinder is implemented by builders that can locate their outputs
+// in an extension directory without building
+type extensionFinder interface {
+	findBuiltExtensions(extensionDir string) ([]string, error)
+}
+
+// FindExistingExtensions returns the already built extensions for an
+// extension file using the standard builders. See
+// BuilderFactory.FindExistingExtensions.
+func FindExistingExtensions(config *BuildConfig, extensionFile string) ([]string, error) {
+	return NewBuilderFactory().FindExistingExtensions(config, extensionFile)
+}
+
+// FindExistingExtensions returns the extension files already present in
+// the extension's build directory, without building anything.
+//
+// The builder is selected the same way as BuilderFor and searches the
+// directory Build would use (below config.BuildDir if set) for its usual
+// outputs, plus config.ExtensionPatterns and config.ExtensionSearchDirs.
+// Builders that can't search for their outputs without building, such as
+// Cargo, are searched for native libraries. Paths are returned in the same
+// form as BuildResult.BuiltArtifacts; nothing is installed.
+//
+// Returns no paths if nothing has been built, or an error if no
+// builder can handle the file.
+func (f *BuilderFactory) FindExistingExtensions(config *BuildConfig, extensionFile string) ([]string, error) {
+	builder, err := f.BuilderFor(extensionFile)
+	if err != nil {
+		return nil, err
+	}
+
+	find := findNativeLibraries
+	if finder, ok := builder.(extensionFinder); ok {
+		find = finder.findBuiltExtensions
+	}
+
+	extensionDir := extensionBuildDir(config, extensionFile)
+	extensions, err := findExtensions(config, extensionDir, find)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildOutputPaths(config, extensionFile, extensionDir, extensions), nil
+}
+
+// findNativeLibraries returns the native libraries in extensionDir,
+// relative to it
+func findNativeLibraries(extensionDir string) ([]string, error) {
+	return findLibraries(extensionDir, defaultExtensionPatterns)
+}
+
+// findLibraries returns the files in extensionDir matching patterns,
+// relative to it
+func findLibraries(extensionDir string, patterns []string) ([]string, error) {
+	var extensions []string
+	for _, pattern := range patterns {
+		matches, err := filepathGlob(filepath.Join(extensionDir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("failed to glob pattern %s in %s: %v", pattern, extensionDir, err)
+		}
+
+		for _, match := range matches {
+			if relPath, err := filepath.Rel(extensionDir, match); err == nil {
+				extensions = append(extensions, relPath)
+			}
+		}
+	}
+
+	return extensions, nil
+}
@@ -0,0 +1,195 @@
+package rubyext
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindExtensionsAddsConfiguredLocations(t *testing.T) {
+	extDir := t.TempDir()
+	for _, rel := range []string{"myext.so", "out/lib/other.so", "out/lib/readme.txt", "plugin.rbx"} {
+		path := filepath.Join(extDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte("binary"), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	defaultFind := func(string) ([]string, error) { return []string{"myext.so"}, nil }
+
+	extensions, err := findExtensions(&BuildConfig{}, extDir, defaultFind)
+	if err != nil {
+		t.Fatalf("findExtensions returned error: %v", err)
+	}
+	if !reflect.DeepEqual(extensions, []string{"myext.so"}) {
+		t.Fatalf("expected builder defaults only, got %v", extensions)
+	}
+
+	config := &BuildConfig{ExtensionSearchDirs: []string{"out/lib"}}
+	extensions, err = findExtensions(config, extDir, defaultFind)
+	if err != nil {
+		t.Fatalf("findExtensions returned error: %v", err)
+	}
+	expected := []string{"myext.so", filepath.Join("out", "lib", "other.so")}
+	if !reflect.DeepEqual(extensions, expected) {
+		t.Fatalf("expected %v, got %v", expected, extensions)
+	}
+
+	config.ExtensionPatterns = []string{"*.rbx", "*.so"}
+	extensions, err = findExtensions(config, extDir, defaultFind)
+	if err != nil {
+		t.Fatalf("findExtensions returned error: %v", err)
+	}
+	expected = []string{"myext.so", "plugin.rbx", filepath.Join("out", "lib", "other.so")}
+	if !reflect.DeepEqual(extensions, expected) {
+		t.Fatalf("expected %v, got %v", expected, extensions)
+	}
+}
+
+func TestFindExistingExtensions(t *testing.T) {
+	gemDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "myext")
+	if err := os.MkdirAll(extDir, 0o755); err != nil {
+		t.Fatalf("failed to create extension directory: %v", err)
+	}
+
+	factory := NewBuilderFactory()
+	config := &BuildConfig{GemDir: gemDir}
+
+	existing, err := factory.FindExistingExtensions(config, "ext/myext/extconf.rb")
+	if err != nil || len(existing) != 0 {
+		t.Fatalf("expected nothing before building, got %v (%v)", existing, err)
+	}
+
+	for _, name := range []string{"myext.so", "rusty.so"} {
+		if err := os.WriteFile(filepath.Join(extDir, name), []byte("binary"), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	existing, err = factory.FindExistingExtensions(config, "ext/myext/extconf.rb")
+	if err != nil {
+		t.Fatalf("FindExistingExtensions returned error: %v", err)
+	}
+	expected := []string{"ext/myext/myext.so", "ext/myext/rusty.so"}
+	if !reflect.DeepEqual(existing, expected) {
+		t.Fatalf("expected %v, got %v", expected, existing)
+	}
+
+	// Cargo has no find step of its own and falls back to native libraries
+	existing, err = factory.FindExistingExtensions(config, "ext/myext/Cargo.toml")
+	if err != nil || !reflect.DeepEqual(existing, expected) {
+		t.Fatalf("expected %v for Cargo, got %v (%v)", expected, existing, err)
+	}
+
+	if _, err = factory.FindExistingExtensions(config, "ext/myext/unknown.txt"); err == nil {
+		t.Fatal("expected error for a file no builder handles")
+	}
+}
+
+func TestFindExtensionsBuildStatic(t *testing.T) {
+	extDir := t.TempDir()
+	for _, name := range []string{"myext.so", "libmyext.a", "myext.lib"} {
+		if err := os.WriteFile(filepath.Join(extDir, name), []byte("binary"), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	extensions, err := findExtensions(&BuildConfig{}, extDir, findNativeLibraries)
+	if err != nil || !reflect.DeepEqual(extensions, []string{"myext.so"}) {
+		t.Fatalf("expected static archives to be ignored by default, got %v (%v)", extensions, err)
+	}
+
+	config := &BuildConfig{BuildStatic: true}
+	extensions, err = findExtensions(config, extDir, findNativeLibraries)
+	if err != nil || !reflect.DeepEqual(extensions, []string{"myext.so", "libmyext.a", "myext.lib"}) {
+		t.Fatalf("expected static archives with BuildStatic, got %v (%v)", extensions, err)
+	}
+
+	if isExtensionLibrary(&BuildConfig{}, "libmyext.a") || !isExtensionLibrary(config, "libmyext.a") {
+		t.Fatal("expected .a to be installed only with BuildStatic")
+	}
+}
+
+func TestFindExtensionsPrimaryExtension(t *testing.T) {
+	extDir := t.TempDir()
+	find := func(string) ([]string, error) {
+		return []string{"fixture.so", filepath.Join("deps", "libz.so"), "mygem_ext.so"}, nil
+	}
+
+	config := &BuildConfig{PrimaryExtensionName: "mygem_ext"}
+	extensions, err := findExtensions(config, extDir, find)
+	if err != nil {
+		t.Fatalf("findExtensions returned error: %v", err)
+	}
+	expected := []string{"mygem_ext.so", "fixture.so", filepath.Join("deps", "libz.so")}
+	if !reflect.DeepEqual(extensions, expected) {
+		t.Fatalf("expected the primary extension first, got %v", extensions)
+	}
+
+	config.OnlyPrimary = true
+	extensions, err = findExtensions(config, extDir, find)
+	if err != nil || !reflect.DeepEqual(extensions, []string{"mygem_ext.so"}) {
+		t.Fatalf("expected only the primary extension, got %v (%v)", extensions, err)
+	}
+
+	config.PrimaryExtensionName = "deps/libz.so"
+	extensions, err = findExtensions(config, extDir, find)
+	if err != nil || !reflect.DeepEqual(extensions, []string{filepath.Join("deps", "libz.so")}) {
+		t.Fatalf("expected a relative path to match, got %v (%v)", extensions, err)
+	}
+
+	config.PrimaryExtensionName = "missing"
+	if _, err := findExtensions(config, extDir, find); !errors.Is(err, ErrNoArtifacts) {
+		t.Fatalf("expected ErrNoArtifacts when the primary extension wasn't built, got %v", err)
+	}
+	if extensions, err := findExtensions(config, extDir, func(string) ([]string, error) { return nil, nil }); err != nil || extensions != nil {
+		t.Fatalf("expected nothing built to find nothing, got %v (%v)", extensions, err)
+	}
+}
+
+func TestFindExtensionsExcludeDirs(t *testing.T) {
+	extDir := t.TempDir()
+	for _, rel := range []string{"myext.so", "spec/fixtures/decoy.so", "out/myext.so"} {
+		path := filepath.Join(extDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte("binary"), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	find := func(string) ([]string, error) {
+		return []string{filepath.Join("spec", "fixtures", "decoy.so"), "myext.so"}, nil
+	}
+
+	extensions, err := findExtensions(&BuildConfig{}, extDir, find)
+	if err != nil || !reflect.DeepEqual(extensions, []string{"myext.so"}) {
+		t.Fatalf("expected the decoy under spec/ to be excluded, got %v (%v)", extensions, err)
+	}
+
+	config := &BuildConfig{ExcludeDirs: []string{}}
+	extensions, _ = findExtensions(config, extDir, find)
+	if len(extensions) != 2 {
+		t.Fatalf("expected an empty ExcludeDirs to exclude nothing, got %v", extensions)
+	}
+
+	config.ExcludeDirs = []string{"fix*"}
+	extensions, _ = findExtensions(config, extDir, find)
+	if !reflect.DeepEqual(extensions, []string{"myext.so"}) {
+		t.Fatalf("expected a glob to match the fixtures directory, got %v", extensions)
+	}
+
+	// Explicitly configured search directories are still searched
+	config = &BuildConfig{ExcludeDirs: []string{"out"}, ExtensionSearchDirs: []string{"out"}}
+	extensions, _ = findExtensions(config, extDir, func(string) ([]string, error) { return nil, nil })
+	if !reflect.DeepEqual(extensions, []string{filepath.Join("out", "myext.so")}) {
+		t.Fatalf("expected ExtensionSearchDirs to be searched despite ExcludeDirs, got %v", extensions)
+	}
+}
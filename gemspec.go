@@ -0,0 +1,103 @@
+package rubyext
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// gemspecExtensionsAssign matches `spec.extensions = ...` with a %w[] list,
+	// an array literal, or a single string literal
+	gemspecExtensionsAssign = regexp.MustCompile(
+		`(?s)\.extensions\s*=\s*(?:%[wW]\s*[\[\(\{<](.*?)[\]\)\}>]|\[(.*?)\]|["']([^"']+)["'])`)
+
+	// gemspecExtensionsAppend matches `spec.extensions << "..."`
+	gemspecExtensionsAppend = regexp.MustCompile(`\.extensions\s*<<\s*["']([^"']+)["']`)
+
+	// gemspecStringLiteral matches quoted strings inside an array literal
+	gemspecStringLiteral = regexp.MustCompile(`["']([^"']+)["']`)
+)
+
+// ExtensionsFromGemspec reads the extensions declared in a .gemspec file.
+//
+// Gemspecs are Ruby code, so this uses simple pattern matching rather than
+// evaluating the file. The following forms are recognized:
+//
+//	spec.extensions = %w[ext/foo/extconf.rb ext/bar/extconf.rb]
+//	spec.extensions = ["ext/foo/extconf.rb"]
+//	spec.extensions << "ext/foo/extconf.rb"
+//
+// An assignment replaces any previously declared extensions, while << appends.
+// The returned paths are relative to the gemspec's directory, which is assumed
+// to be the gem root, and can be passed straight to BuildAllExtensions.
+// Extensions that don't exist on disk are skipped.
+//
+// # Example
+//
+//	extensions, err := rubyext.ExtensionsFromGemspec("/path/to/gem/mygem.gemspec")
+//	if err != nil {
+//	    return err
+//	}
+//	results, err := factory.BuildAllExtensions(ctx, config, extensions)
+func ExtensionsFromGemspec(gemspecPath string) ([]string, error) {
+	content, err := os.ReadFile(gemspecPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gemspec %s: %w", gemspecPath, err)
+	}
+
+	gemDir := filepath.Dir(gemspecPath)
+	source := string(content)
+
+	type declaration struct {
+		offset int
+		assign bool
+		values []string
+	}
+
+	var declarations []declaration
+
+	for _, match := range gemspecExtensionsAssign.FindAllStringSubmatchIndex(source, -1) {
+		var values []string
+		switch {
+		case match[2] >= 0: // %w[] list
+			values = strings.Fields(source[match[2]:match[3]])
+		case match[4] >= 0: // array literal
+			for _, literal := range gemspecStringLiteral.FindAllStringSubmatch(source[match[4]:match[5]], -1) {
+				values = append(values, literal[1])
+			}
+		case match[6] >= 0: // single string
+			values = []string{source[match[6]:match[7]]}
+		}
+		declarations = append(declarations, declaration{offset: match[0], assign: true, values: values})
+	}
+
+	for _, match := range gemspecExtensionsAppend.FindAllStringSubmatchIndex(source, -1) {
+		declarations = append(declarations, declaration{offset: match[0], values: []string{source[match[2]:match[3]]}})
+	}
+
+	sort.Slice(declarations, func(i, j int) bool {
+		return declarations[i].offset < declarations[j].offset
+	})
+
+	var declared []string
+	for _, decl := range declarations {
+		if decl.assign {
+			declared = nil
+		}
+		declared = append(declared, decl.values...)
+	}
+
+	var extensions []string
+	for _, extension := range uniqueStrings(declared) {
+		if _, err := osStat(filepath.Join(gemDir, filepath.FromSlash(extension))); err != nil {
+			continue
+		}
+		extensions = append(extensions, extension)
+	}
+
+	return extensions, nil
+}
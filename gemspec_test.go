@@ -0,0 +1,85 @@
+package rubyext
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExtensionsFromGemspec(t *testing.T) {
+	gemDir := t.TempDir()
+
+	for _, rel := range []string{"ext/foo/extconf.rb", "ext/bar/extconf.rb", "ext/baz/Cargo.toml"} {
+		path := filepath.Join(gemDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(""), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		gemspec  string
+		expected []string
+	}{
+		{
+			name: "percent-w list",
+			gemspec: `Gem::Specification.new do |spec|
+  spec.name = "mygem"
+  spec.extensions = %w[
+    ext/foo/extconf.rb
+    ext/bar/extconf.rb
+  ]
+end
+`,
+			expected: []string{"ext/foo/extconf.rb", "ext/bar/extconf.rb"},
+		},
+		{
+			name: "array literal with missing file",
+			gemspec: `Gem::Specification.new do |s|
+  s.extensions = ["ext/foo/extconf.rb", 'ext/missing/extconf.rb']
+end
+`,
+			expected: []string{"ext/foo/extconf.rb"},
+		},
+		{
+			name: "append",
+			gemspec: `Gem::Specification.new do |spec|
+  spec.extensions << "ext/foo/extconf.rb"
+  spec.extensions << "ext/baz/Cargo.toml"
+end
+`,
+			expected: []string{"ext/foo/extconf.rb", "ext/baz/Cargo.toml"},
+		},
+		{
+			name:     "no extensions",
+			gemspec:  "Gem::Specification.new do |spec|\n  spec.name = \"pure\"\nend\n",
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gemspecPath := filepath.Join(gemDir, "mygem.gemspec")
+			if err := os.WriteFile(gemspecPath, []byte(tc.gemspec), 0o600); err != nil {
+				t.Fatalf("failed to write gemspec: %v", err)
+			}
+
+			extensions, err := ExtensionsFromGemspec(gemspecPath)
+			if err != nil {
+				t.Fatalf("ExtensionsFromGemspec returned error: %v", err)
+			}
+
+			if !reflect.DeepEqual(extensions, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, extensions)
+			}
+		})
+	}
+
+	if _, err := ExtensionsFromGemspec(filepath.Join(gemDir, "missing.gemspec")); err == nil {
+		t.Fatal("expected error for missing gemspec")
+	}
+}
@@ -3,8 +3,6 @@ package rubyext
 import (
 	"context"
 	"fmt"
-	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 )
@@ -125,26 +123,30 @@ func (b *GenericBuilder) Build(ctx context.Context, config *BuildConfig, extensi
 
 // Clean removes build artifacts using the configured clean command
 func (b *GenericBuilder) Clean(ctx context.Context, config *BuildConfig, extensionFile string) error {
+	if config.CleanArtifactsOnly {
+		return cleanArtifacts(ctx, config, extensionFile, b.findBuiltExtensions)
+	}
+
 	if len(b.cleanCommand) == 0 {
 		return nil // No clean command configured
 	}
 
-	extensionPath := filepath.Join(config.GemDir, extensionFile)
-	extensionDir := filepath.Dir(extensionPath)
+	extensionDir := extensionBuildDir(config, extensionFile)
 
 	// Execute clean command
 	//nolint:gosec // Command is from trusted builder configuration
-	cmd := exec.CommandContext(ctx, b.cleanCommand[0], b.cleanCommand[1:]...)
+	cmd := execCommandContext(ctx, b.cleanCommand[0], b.cleanCommand[1:]...)
 	cmd.Dir = extensionDir
 
 	// Ignore errors - clean may not be necessary
 	_ = cmd.Run()
-	return nil
+	return cleanResult(ctx, nil)
 }
 
 // noConfigure is a no-op since generic builders don't need configuration
 func (b *GenericBuilder) noConfigure(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
-	if config.Verbose {
+	appendWarningsAsErrorsNote(config, result, b.name)
+	if config.effectiveLogLevel() >= LogLevelVerbose {
 		result.Output = append(result.Output, fmt.Sprintf("%s builder, no configuration needed", b.name))
 	}
 	return nil
@@ -179,24 +181,16 @@ func (b *GenericBuilder) runBuild(ctx context.Context, config *BuildConfig, exte
 
 	// Execute build command
 	//nolint:gosec // Command is from trusted builder configuration
-	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd := execCommandContext(ctx, args[0], args[1:]...)
 	cmd.Dir = extensionDir
 
 	// Set environment variables
-	cmd.Env = os.Environ()
-	for key, value := range config.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
-	}
+	cmd.Env = buildCommandEnv(config)
 
-	output, err := cmd.CombinedOutput()
-	outputLines := strings.Split(string(output), "\n")
-	result.Output = append(result.Output, outputLines...)
+	output, err := combinedOutput(cmd)
+	appendCommandOutput(config, result, output)
 
-	if config.Verbose {
-		result.Output = append(result.Output,
-			fmt.Sprintf("Running: %s", strings.Join(args, " ")),
-			fmt.Sprintf("Working directory: %s", extensionDir))
-	}
+	appendCommandLog(config, result, cmd, err)
 
 	if err != nil {
 		return BuildError(b.name, result.Output, err)
@@ -210,7 +204,7 @@ func (b *GenericBuilder) findBuiltExtensions(extensionDir string) ([]string, err
 	var extensions []string
 
 	for _, pattern := range b.outputPatterns {
-		matches, err := filepath.Glob(filepath.Join(extensionDir, pattern))
+		matches, err := filepathGlob(filepath.Join(extensionDir, pattern))
 		if err != nil {
 			return nil, fmt.Errorf("failed to glob pattern %s in %s: %v", pattern, extensionDir, err)
 		}
@@ -261,6 +255,29 @@ func NewZigBuilder() *GenericBuilder {
 	})
 }
 
+// NewPythonBuilder creates a builder for native libraries built with a
+// Python setup.py, as vendored by some scientific gems.
+//
+// build_ext --inplace places outputs next to their sources; builds that
+// ignore --inplace leave them under build/lib.<platform>/ instead.
+func NewPythonBuilder() *GenericBuilder {
+	return NewGenericBuilder(&GenericBuilderConfig{
+		Name:     "Python",
+		Patterns: []string{"setup.py"},
+		Tools: []ToolRequirement{
+			{Name: "python3", Alternatives: []string{"python"}, Purpose: "Python interpreter for setup.py"},
+		},
+		BuildCommand: []string{
+			"python3", "setup.py", "build_ext", "--inplace",
+		},
+		CleanCommand: []string{"python3", "setup.py", "clean", "--all"},
+		OutputPatterns: []string{
+			"*.so", "*.dylib", "*.dll",
+			"build/lib*/*.so", "build/lib*/*.dylib", "build/lib*/*.dll",
+		},
+	})
+}
+
 // NewSwiftBuilder creates a builder for Swift extensions.
 func NewSwiftBuilder() *GenericBuilder {
 	return NewGenericBuilder(&GenericBuilderConfig{
@@ -3,8 +3,6 @@ package rubyext
 import (
 	"context"
 	"fmt"
-	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 )
@@ -68,20 +66,23 @@ func (b *GoBuilder) Build(ctx context.Context, config *BuildConfig, extensionFil
 
 // Clean removes build artifacts
 func (b *GoBuilder) Clean(ctx context.Context, config *BuildConfig, extensionFile string) error {
-	extensionPath := filepath.Join(config.GemDir, extensionFile)
-	extensionDir := filepath.Dir(extensionPath)
+	if config.CleanArtifactsOnly {
+		return cleanArtifacts(ctx, config, extensionFile, b.findBuiltExtensions)
+	}
+
+	extensionDir := extensionBuildDir(config, extensionFile)
 
-	cleanCmd := exec.CommandContext(ctx, "go", "clean")
+	cleanCmd := execCommandContext(ctx, "go", "clean")
 	cleanCmd.Dir = extensionDir
 
 	// Ignore errors - clean may not be necessary
 	_ = cleanCmd.Run()
-	return nil
+	return cleanResult(ctx, nil)
 }
 
 // noConfigure is a no-op since Go doesn't need configuration
 func (b *GoBuilder) noConfigure(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
-	if config.Verbose {
+	if config.effectiveLogLevel() >= LogLevelVerbose {
 		result.Output = append(result.Output, "Go modules, no configuration needed")
 	}
 	return nil
@@ -89,44 +90,41 @@ func (b *GoBuilder) noConfigure(ctx context.Context, config *BuildConfig, extens
 
 const (
 	defaultExtensionName = "extension.so"
+	staticExtensionName  = "extension.a"
 )
 
 // runGoBuild executes go build to compile the shared library
 func (b *GoBuilder) runGoBuild(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
 	// Determine output filename
 	outputName := defaultExtensionName
+	if config.BuildStatic {
+		outputName = staticExtensionName
+	}
 	if config.DestPath != "" {
 		outputName = filepath.Join(config.DestPath, outputName)
 	}
 
-	// Build go build arguments
-	args := []string{"build", "-buildmode=c-shared", "-o", outputName}
-
-	// Add any additional build args
-	args = append(args, config.BuildArgs...)
+	if config.WarningsAsErrors {
+		if err := b.runGoVet(ctx, config, extensionDir, result); err != nil {
+			return err
+		}
+	}
 
 	// Run go build
-	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd := execCommandContext(ctx, "go", b.goBuildArgs(config, outputName)...)
 	cmd.Dir = extensionDir
 
 	// Set environment variables
-	cmd.Env = os.Environ()
-	for key, value := range config.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
-	}
+	cmd.Env = buildCommandEnv(config)
 
 	// Enable CGO
 	cmd.Env = append(cmd.Env, "CGO_ENABLED=1")
+	cmd.Env = append(cmd.Env, b.warningsEnv(config)...)
 
-	output, err := cmd.CombinedOutput()
-	outputLines := strings.Split(string(output), "\n")
-	result.Output = append(result.Output, outputLines...)
+	output, err := combinedOutput(cmd)
+	appendCommandOutput(config, result, output)
 
-	if config.Verbose {
-		result.Output = append(result.Output,
-			fmt.Sprintf("Running: go %s", strings.Join(args, " ")),
-			fmt.Sprintf("Working directory: %s", extensionDir))
-	}
+	appendCommandLog(config, result, cmd, err)
 
 	if err != nil {
 		return BuildError("Go", result.Output, err)
@@ -135,6 +133,57 @@ func (b *GoBuilder) runGoBuild(ctx context.Context, config *BuildConfig, extensi
 	return nil
 }
 
+// goBuildArgs returns the arguments for go build
+func (b *GoBuilder) goBuildArgs(config *BuildConfig, outputName string) []string {
+	buildMode := "c-shared"
+	if config.BuildStatic {
+		buildMode = "c-archive"
+	}
+	args := []string{"build", "-buildmode=" + buildMode, "-o", outputName}
+
+	// Limit the number of packages built in parallel if specified
+	if jobs := parallelJobs(config); jobs > 0 {
+		args = append(args, "-p", fmt.Sprintf("%d", jobs))
+	}
+
+	// Add any additional build args
+	return append(args, config.BuildArgs...)
+}
+
+// runGoVet runs go vet before the build when config.WarningsAsErrors is
+// set. go build has no -vet flag (only go test does), so vet findings are
+// surfaced as a separate step that fails the build.
+func (b *GoBuilder) runGoVet(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
+	cmd := execCommandContext(ctx, "go", "vet", "./...")
+	cmd.Dir = extensionDir
+	cmd.Env = append(buildCommandEnv(config), "CGO_ENABLED=1")
+	cmd.Env = append(cmd.Env, b.warningsEnv(config)...)
+
+	output, err := combinedOutput(cmd)
+	appendCommandOutput(config, result, output)
+
+	appendCommandLog(config, result, cmd, err)
+
+	if err != nil {
+		return BuildError("Go Vet", result.Output, err)
+	}
+
+	return nil
+}
+
+// warningsEnv returns CGO_CFLAGS with -Werror appended when
+// config.WarningsAsErrors is set, so warnings in cgo C code fail the build
+func (b *GoBuilder) warningsEnv(config *BuildConfig) []string {
+	if !config.WarningsAsErrors {
+		return nil
+	}
+
+	flags := strings.Fields(envValue(config, "CGO_CFLAGS"))
+	flags = append(flags, "-Werror")
+
+	return []string{fmt.Sprintf("CGO_CFLAGS=%s", strings.Join(flags, " "))}
+}
+
 // findBuiltExtensions locates the compiled shared library files
 func (b *GoBuilder) findBuiltExtensions(extensionDir string) ([]string, error) {
 	var extensions []string
@@ -147,7 +196,7 @@ func (b *GoBuilder) findBuiltExtensions(extensionDir string) ([]string, error) {
 	}
 
 	for _, pattern := range patterns {
-		matches, err := filepath.Glob(filepath.Join(extensionDir, pattern))
+		matches, err := filepathGlob(filepath.Join(extensionDir, pattern))
 		if err != nil {
 			return nil, fmt.Errorf("failed to glob pattern %s in %s: %v", pattern, extensionDir, err)
 		}
@@ -1,9 +1,11 @@
 package rubyext
 
 import (
-	"fmt"
+	"errors"
+	"os/exec"
 	"regexp"
 	"strings"
+	"sync"
 )
 
 // MatchesPattern checks if a filename matches any of the given regex patterns.
@@ -13,7 +15,8 @@ import (
 //
 // # Parameters
 //
-//   - filename: The file to check (typically just the base name)
+//   - filename: The file to check (typically just the base name), with
+//     Windows separators converted to forward slashes before matching
 //   - patterns: One or more regex patterns to match against
 //
 // # Returns
@@ -36,15 +39,36 @@ import (
 // # Thread Safety
 //
 // This function is thread-safe and can be called concurrently.
+// Patterns are compiled once and cached for the life of the process.
 func MatchesPattern(filename string, patterns ...string) bool {
+	filename = normalizeExtensionPath(filename)
 	for _, pattern := range patterns {
-		if matched, _ := regexp.MatchString(pattern, filename); matched {
+		if re := compiledPattern(pattern); re != nil && re.MatchString(filename) {
 			return true
 		}
 	}
 	return false
 }
 
+// patternCache maps pattern strings to their compiled *regexp.Regexp,
+// or to a nil *regexp.Regexp for patterns that failed to compile
+var patternCache sync.Map
+
+// compiledPattern returns the cached compiled form of pattern,
+// compiling it on first use. Returns nil for invalid patterns.
+func compiledPattern(pattern string) *regexp.Regexp {
+	if cached, ok := patternCache.Load(pattern); ok {
+		return cached.(*regexp.Regexp)
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		re = nil
+	}
+	cached, _ := patternCache.LoadOrStore(pattern, re)
+	return cached.(*regexp.Regexp)
+}
+
 // MatchesExtension checks if a filename has any of the given extensions.
 //
 // This is a case-insensitive check for file extensions.
@@ -98,14 +122,18 @@ func MatchesExtension(filename string, extensions ...string) bool {
 //
 // A formatted error message containing:
 //   - The builder name
+//   - The process exit code (if err carries one)
 //   - The underlying error message (if provided)
 //   - The full build output (if available)
 //
+// The result is a *BuildFailure wrapping the underlying error, so
+// errors.Is and errors.As can be used on it.
+//
 // # Format
 //
 // With error and output:
 //
-//	ExtConf build failed: make: *** [target] Error 1
+//	ExtConf build failed (exit 2): exit status 2
 //
 //	Build output:
 //	gcc -o extension.o -c extension.c
@@ -113,7 +141,7 @@ func MatchesExtension(filename string, extensions ...string) bool {
 //
 // With error but no output:
 //
-//	ExtConf build failed: make: *** [target] Error 1
+//	ExtConf build failed: makefile not generated
 //
 // With output but no error:
 //
@@ -132,18 +160,69 @@ func MatchesExtension(filename string, extensions ...string) bool {
 //
 // This function is thread-safe and can be called concurrently.
 func BuildError(builder string, output []string, err error) error {
-	outputStr := strings.Join(output, "\n")
+	return BuildErrorWithExitCode(builder, output, ExitCode(err), err)
+}
 
-	var prefix string
-	if err != nil {
-		prefix = fmt.Sprintf("%s build failed: %v", builder, err)
-	} else {
-		prefix = fmt.Sprintf("%s build failed", builder)
+// BuildErrorWithExitCode is like BuildError but takes the exit code explicitly.
+//
+// A non-zero exitCode is included in the message as "(exit N)". This is
+// useful when the exit code is known but err doesn't carry an *exec.ExitError.
+func BuildErrorWithExitCode(builder string, output []string, exitCode int, err error) error {
+	return &BuildFailure{
+		Builder:  builder,
+		ExitCode: exitCode,
+		Output:   output,
+		Err:      err,
 	}
+}
 
-	if outputStr != "" {
-		return fmt.Errorf("%s\n\nBuild output:\n%s", prefix, outputStr)
+// ExitCode returns the process exit code carried by err.
+//
+// The exit code of a *BuildFailure is preferred. Otherwise returns 0 if
+// err is nil or doesn't wrap an *exec.ExitError, and -1 if the process was
+// terminated by a signal.
+func ExitCode(err error) int {
+	var failure *BuildFailure
+	if errors.As(err, &failure) && failure.ExitCode != 0 {
+		return failure.ExitCode
 	}
 
-	return fmt.Errorf("%s", prefix)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return 0
+}
+
+// unixMakeProgram returns the make program to use on a Unix-like goos.
+//
+// The BSDs ship BSD make as "make", which can't process the GNU Makefiles
+// most extensions assume. When GNU make is installed there as "gmake",
+// it is used instead.
+func unixMakeProgram(goos string) string {
+	switch goos {
+	case "freebsd", "openbsd", "netbsd", "dragonfly":
+		if _, err := execLookPath("gmake"); err == nil {
+			return "gmake"
+		}
+	}
+	return makeProgram
+}
+
+// shellQuote quotes s for use as a single word in a POSIX shell command,
+// such as the value of a make variable like CC that recipes expand
+// unquoted. Words without special characters are returned unchanged.
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\$`;&|<>()*?[]#~{}!") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// normalizeExtensionPath returns an extension file path with forward
+// slashes, the form used for matching and in results, so paths given with
+// Windows separators (ext\myext\extconf.rb) are handled like any other.
+// Use filepath.FromSlash on the result for filesystem operations.
+func normalizeExtensionPath(extensionFile string) string {
+	return strings.ReplaceAll(extensionFile, `\`, "/")
 }
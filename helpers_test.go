@@ -0,0 +1,121 @@
+package rubyext
+
+import (
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestMatchesPatternSkipsInvalidPatterns(t *testing.T) {
+	if !MatchesPattern("ext/extconf.rb", `[invalid`, `extconf\.rb$`) {
+		t.Fatal("expected valid pattern to match after an invalid one")
+	}
+	if MatchesPattern("ext/extconf.rb", `[invalid`) {
+		t.Fatal("expected invalid pattern not to match")
+	}
+}
+
+var benchmarkFiles = []string{
+	"ext/myext/extconf.rb",
+	"ext/myext/configure",
+	"ext/myext/Rakefile",
+	"ext/myext/CMakeLists.txt",
+	"ext/myext/Cargo.toml",
+}
+
+func BenchmarkMatchesPattern(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		for _, file := range benchmarkFiles {
+			MatchesPattern(file, `^configure$`, `^configure\.sh$`, `extconf\.rb$`)
+		}
+	}
+}
+
+// BenchmarkMatchesPatternUncached compiles patterns on every call, as
+// MatchesPattern did before patterns were cached
+func BenchmarkMatchesPatternUncached(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		for _, file := range benchmarkFiles {
+			for _, pattern := range []string{`^configure$`, `^configure\.sh$`, `extconf\.rb$`} {
+				if matched, _ := regexp.MatchString(pattern, file); matched {
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestUnixMakeProgramPrefersGmakeOnBSD(t *testing.T) {
+	origLookPath := execLookPath
+	defer func() { execLookPath = origLookPath }()
+
+	gmakeInstalled := true
+	execLookPath = func(name string) (string, error) {
+		if name == "gmake" && gmakeInstalled {
+			return "/usr/local/bin/gmake", nil
+		}
+		return "", exec.ErrNotFound
+	}
+
+	testCases := []struct {
+		goos     string
+		gmake    bool
+		expected string
+	}{
+		{"freebsd", true, "gmake"},
+		{"openbsd", true, "gmake"},
+		{"netbsd", false, "make"},
+		{"linux", true, "make"},
+		{"darwin", true, "make"},
+	}
+
+	for _, tc := range testCases {
+		gmakeInstalled = tc.gmake
+		if got := unixMakeProgram(tc.goos); got != tc.expected {
+			t.Errorf("unixMakeProgram(%s) with gmake=%v = %q, expected %q", tc.goos, tc.gmake, got, tc.expected)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	testCases := map[string]string{
+		"/usr/bin/ccache":           "/usr/bin/ccache",
+		"/Users/My Name/bin/ccache": "'/Users/My Name/bin/ccache'",
+		"/opt/it's/ccache":          `'/opt/it'\''s/ccache'`,
+		"/opt/gëms/ccache":          "/opt/gëms/ccache",
+		"":                          "''",
+	}
+
+	for input, expected := range testCases {
+		if got := shellQuote(input); got != expected {
+			t.Errorf("shellQuote(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+func TestWindowsExtensionPaths(t *testing.T) {
+	const windowsPath = `ext\mygem\native\extconf.rb`
+
+	if got := normalizeExtensionPath(windowsPath); got != "ext/mygem/native/extconf.rb" {
+		t.Fatalf("normalizeExtensionPath(%q) = %q", windowsPath, got)
+	}
+
+	builder, err := NewBuilderFactory().BuilderFor(windowsPath)
+	if err != nil || builder.Name() != "ExtConf" {
+		t.Fatalf("expected ExtConf builder for %s, got %v, %v", windowsPath, builder, err)
+	}
+
+	config := &BuildConfig{GemDir: t.TempDir()}
+	if got, want := extensionBuildDir(config, windowsPath), filepath.Join(config.GemDir, "ext", "mygem", "native"); got != want {
+		t.Fatalf("extensionBuildDir() = %q, want %q", got, want)
+	}
+
+	if got, want := determineInstallRelativePath(config, windowsPath, "native.so"), filepath.Join("mygem", "native.so"); got != want {
+		t.Fatalf("determineInstallRelativePath() = %q, want %q", got, want)
+	}
+
+	if got := makeGemRelative(config.GemDir, windowsPath, []string{"native.so"}); got[0] != "ext/mygem/native/native.so" {
+		t.Fatalf("makeGemRelative() = %v", got)
+	}
+}
@@ -0,0 +1,105 @@
+package rubyext
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+)
+
+// runPreBuildCommands runs config.PreBuildCommands in the extension
+// directory before the build is configured, for code generation steps
+// such as ragel, bison or protoc. The first failing command fails the
+// build.
+func runPreBuildCommands(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
+	if err := runHookCommands(ctx, config, extensionDir, result, config.PreBuildCommands); err != nil {
+		return BuildError("Pre-build Command", result.Output, err)
+	}
+	return nil
+}
+
+// runPostBuildCommands runs config.PostBuildCommands in the extension
+// directory once the built extensions have been found. A failing command
+// fails the build unless config.IgnorePostBuildErrors is set, in which
+// case the failure is noted in the output and the remaining commands are
+// skipped.
+func runPostBuildCommands(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
+	err := runHookCommands(ctx, config, extensionDir, result, config.PostBuildCommands)
+	if err == nil {
+		return nil
+	}
+
+	if !config.IgnorePostBuildErrors || ctx.Err() != nil {
+		return BuildError("Post-build Command", result.Output, err)
+	}
+
+	result.Output = append(result.Output,
+		fmt.Sprintf("Note: Post-build Command failed, continuing because IgnorePostBuildErrors is set: %v", err))
+	return nil
+}
+
+// runHookCommands runs commands in order with the build environment,
+// capturing their output into the result
+func runHookCommands(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult, commands [][]string) error {
+	for _, command := range commands {
+		if len(command) == 0 {
+			return fmt.Errorf("empty command")
+		}
+
+		//nolint:gosec // Commands are from trusted build configuration
+		cmd := execCommandContext(ctx, command[0], command[1:]...)
+		cmd.Dir = extensionDir
+		cmd.Env = buildCommandEnv(config)
+		cmd.Stdin = commandStdin(config)
+
+		output, err := combinedOutput(cmd)
+		appendCommandOutput(config, result, output)
+
+		appendCommandLog(config, result, cmd, err)
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", formatCommand(command[0], command[1:]), err)
+		}
+	}
+
+	return nil
+}
+
+// hookCommandLines formats hook commands for Explain
+func hookCommandLines(commands [][]string) []string {
+	lines := make([]string, 0, len(commands))
+	for _, command := range commands {
+		if len(command) > 0 {
+			lines = append(lines, formatCommand(command[0], command[1:]))
+		}
+	}
+	return lines
+}
+
+// runPostProcess calls config.PostProcess for each built native library
+// (and static archive with config.BuildStatic), stopping at the first
+// error or when the context ends
+func runPostProcess(ctx context.Context, config *BuildConfig, result *BuildResult, extensionDir string, extensions []string) error {
+	if config.PostProcess == nil {
+		return nil
+	}
+
+	for _, extension := range extensions {
+		if !isExtensionLibrary(config, extension) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return BuildError("Post-process", result.Output, err)
+		}
+
+		path := extension
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(extensionDir, path)
+		}
+
+		if err := config.PostProcess(ctx, path); err != nil {
+			return BuildError("Post-process", result.Output, fmt.Errorf("%s: %w", path, err))
+		}
+	}
+
+	return nil
+}
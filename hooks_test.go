@@ -0,0 +1,136 @@
+package rubyext
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRunCommonBuildRunsHookCommands(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("hook commands use a POSIX shell")
+	}
+
+	gemDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "myext")
+	if err := os.MkdirAll(extDir, 0o755); err != nil {
+		t.Fatalf("failed to create extension dir: %v", err)
+	}
+
+	var steps []string
+	record := func(step string) {
+		log, _ := os.ReadFile(filepath.Join(extDir, "hooks.log"))
+		steps = append(steps, step+":"+strings.TrimSpace(string(log)))
+	}
+	buildSteps := CommonBuildSteps{
+		ConfigureFunc: func(context.Context, *BuildConfig, string, *BuildResult) error {
+			record("configure")
+			return nil
+		},
+		BuildFunc: func(_ context.Context, _ *BuildConfig, dir string, _ *BuildResult) error {
+			record("build")
+			return os.WriteFile(filepath.Join(dir, "myext.so"), []byte("binary"), 0o600)
+		},
+		FindFunc: func(string) ([]string, error) { return []string{"myext.so"}, nil },
+	}
+
+	config := &BuildConfig{
+		GemDir:            gemDir,
+		PreBuildCommands:  [][]string{{"sh", "-c", "echo generated > hooks.log"}},
+		PostBuildCommands: [][]string{{"sh", "-c", "echo packaged; echo packaged >> hooks.log"}},
+	}
+
+	result, err := runCommonBuild(context.Background(), config, "ext/myext/extconf.rb", buildSteps)
+	if err != nil {
+		t.Fatalf("runCommonBuild returned error: %v", err)
+	}
+	if expected := []string{"configure:generated", "build:generated"}; !reflect.DeepEqual(steps, expected) {
+		t.Fatalf("expected pre-build commands before configure, got %v", steps)
+	}
+	if !strings.Contains(strings.Join(result.Output, "\n"), "packaged") {
+		t.Fatalf("expected post-build output to be captured, got %q", result.Output)
+	}
+
+	config.PostBuildCommands = [][]string{{"sh", "-c", "exit 4"}}
+	if _, err := runCommonBuild(context.Background(), config, "ext/myext/extconf.rb", buildSteps); err == nil {
+		t.Fatal("expected failing post-build command to fail the build")
+	}
+
+	config.IgnorePostBuildErrors = true
+	result, err = runCommonBuild(context.Background(), config, "ext/myext/extconf.rb", buildSteps)
+	if err != nil || !result.Success {
+		t.Fatalf("expected failing post-build command to be ignored, got %v", err)
+	}
+
+	steps = nil
+	config.PreBuildCommands = [][]string{{"sh", "-c", "exit 3"}}
+	result, err = runCommonBuild(context.Background(), config, "ext/myext/extconf.rb", buildSteps)
+	if err == nil || result.ExitCode != 3 {
+		t.Fatalf("expected failing pre-build command to fail the build with exit 3, got %v", err)
+	}
+	if len(steps) != 0 {
+		t.Fatalf("expected the build not to run after a failed pre-build command, got %v", steps)
+	}
+}
+
+func TestExplainIncludesHookCommands(t *testing.T) {
+	config := &BuildConfig{
+		PreBuildCommands:  [][]string{{"ragel", "-G2", "parser.rl"}},
+		PostBuildCommands: [][]string{{"./package.sh"}},
+	}
+
+	commands, err := NewBuilderFactory().Explain(config, "ext/myext/extconf.rb")
+	if err != nil {
+		t.Fatalf("Explain returned error: %v", err)
+	}
+	if commands[0] != "ragel -G2 parser.rl" || commands[len(commands)-1] != "./package.sh" {
+		t.Fatalf("expected hook commands around the build commands, got %q", commands)
+	}
+}
+
+func TestRunCommonBuildPostProcess(t *testing.T) {
+	gemDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "myext")
+	if err := os.MkdirAll(extDir, 0o755); err != nil {
+		t.Fatalf("failed to create extension dir: %v", err)
+	}
+
+	buildSteps := CommonBuildSteps{
+		ConfigureFunc: func(context.Context, *BuildConfig, string, *BuildResult) error { return nil },
+		BuildFunc: func(_ context.Context, _ *BuildConfig, dir string, _ *BuildResult) error {
+			if err := os.WriteFile(filepath.Join(dir, "myext.so"), []byte("binary"), 0o600); err != nil {
+				return err
+			}
+			return os.WriteFile(filepath.Join(dir, "myext.jar"), []byte("jar"), 0o600)
+		},
+		FindFunc: func(string) ([]string, error) { return []string{"myext.so", "myext.jar"}, nil },
+	}
+
+	var processed []string
+	config := &BuildConfig{
+		GemDir: gemDir,
+		PostProcess: func(_ context.Context, artifactPath string) error {
+			processed = append(processed, artifactPath)
+			return nil
+		},
+	}
+
+	if _, err := runCommonBuild(context.Background(), config, "ext/myext/extconf.rb", buildSteps); err != nil {
+		t.Fatalf("runCommonBuild returned error: %v", err)
+	}
+	if expected := []string{filepath.Join(extDir, "myext.so")}; !reflect.DeepEqual(processed, expected) {
+		t.Fatalf("expected PostProcess for the native library only, got %v", processed)
+	}
+
+	errSign := errors.New("codesign failed")
+	config.PostProcess = func(context.Context, string) error { return errSign }
+	_, err := runCommonBuild(context.Background(), config, "ext/myext/extconf.rb", buildSteps)
+	if !errors.Is(err, errSign) || !strings.Contains(err.Error(), filepath.Join(extDir, "myext.so")) {
+		t.Fatalf("expected PostProcess error wrapped with the artifact path, got %v", err)
+	}
+}
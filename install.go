@@ -4,12 +4,42 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 )
 
+// InstallLayout controls where native libraries are placed below the
+// install directory.
+type InstallLayout int
+
+// Supported install layouts.
+const (
+	// InstallLayoutNested installs libraries at their require path, taken
+	// from create_makefile or the extension's location under ext/
+	// (e.g. lib/json/ext/parser.so). This is the default.
+	InstallLayoutNested InstallLayout = iota
+
+	// InstallLayoutFlat installs every library directly in the install
+	// directory (e.g. lib/parser.so).
+	InstallLayoutFlat
+)
+
+// String returns the name of the install layout
+func (l InstallLayout) String() string {
+	switch l {
+	case InstallLayoutNested:
+		return "nested"
+	case InstallLayoutFlat:
+		return "flat"
+	default:
+		return fmt.Sprintf("InstallLayout(%d)", int(l))
+	}
+}
+
 var nativeLibraryExtensions = map[string]struct{}{
 	".so":     {},
 	".bundle": {},
@@ -17,71 +47,179 @@ var nativeLibraryExtensions = map[string]struct{}{
 	".dylib":  {},
 }
 
+// staticLibraryExtensions are installed alongside native libraries when
+// config.BuildStatic is set
+var staticLibraryExtensions = map[string]struct{}{
+	".a":   {},
+	".lib": {},
+}
+
 // finalizeNativeExtensions copies compiled native libraries into the gem's lib directory structure
 // and returns their paths relative to the gem root. If no native libraries are present, the original
 // build outputs are returned relative to the gem root.
-func finalizeNativeExtensions(config *BuildConfig, extensionFile, extensionDir string, built []string) ([]string, error) {
+//
+// Files matching config.ExtraInstallGlobs are installed next to the first native library, or listed
+// with the build outputs when nothing is installed. Files whose installed copy is already up to date
+// are left untouched and noted in result.Output.
+func finalizeNativeExtensions(config *BuildConfig, result *BuildResult, extensionFile, extensionDir string, built []string) ([]string, error) {
 	if len(built) == 0 {
 		return nil, nil
 	}
 
+	extras, err := extraInstallFiles(config, extensionDir, built)
+	if err != nil {
+		return nil, err
+	}
+
 	var hasNative bool
 	for _, rel := range built {
-		if isNativeLibrary(rel) {
+		if isExtensionLibrary(config, rel) {
 			hasNative = true
 			break
 		}
 	}
 
 	if !hasNative {
-		return makeGemRelative(config.GemDir, extensionFile, built), nil
+		return buildOutputPaths(config, extensionFile, extensionDir, slices.Concat(built, extras)), nil
 	}
 
 	primaryDest, extraDests := installTargets(config)
 	if primaryDest == "" {
-		return makeGemRelative(config.GemDir, extensionFile, built), nil
+		return buildOutputPaths(config, extensionFile, extensionDir, slices.Concat(built, extras)), nil
 	}
 
 	var installed []string
+	var extrasDir string
 
 	for _, rel := range built {
-		if !isNativeLibrary(rel) {
+		if !isExtensionLibrary(config, rel) {
 			continue
 		}
 
 		srcPath := filepath.Join(extensionDir, rel)
-		if info, err := os.Stat(srcPath); err != nil || !info.Mode().IsRegular() {
+		if info, err := osStat(srcPath); err != nil || !info.Mode().IsRegular() {
 			continue
 		}
 
-		relDest := determineInstallRelativePath(config.GemDir, extensionFile, rel)
+		relDest := determineInstallRelativePath(config, extensionFile, rel)
 		if relDest == "" {
 			relDest = filepath.Base(rel)
 		}
+		if installed == nil {
+			extrasDir = filepath.Dir(relDest)
+		}
 
-		if err := copyFile(srcPath, filepath.Join(primaryDest, relDest)); err != nil {
+		destPath, err := installFile(config, result, srcPath, relDest, primaryDest, extraDests, config.ArtifactMode)
+		if err != nil {
 			return nil, err
 		}
+		installed = append(installed, destPath)
+	}
+
+	if installed == nil {
+		return nil, nil
+	}
+
+	for _, rel := range extras {
+		relDest := filepath.Join(extrasDir, filepath.Base(rel))
+		destPath, err := installFile(config, result, filepath.Join(extensionDir, rel), relDest, primaryDest, extraDests, 0)
+		if err != nil {
+			return nil, err
+		}
+		installed = append(installed, destPath)
+	}
+
+	return installed, nil
+}
+
+// installFile copies srcPath to relDest below the primary and any extra
+// install directories, and returns the path it was installed to. A
+// non-zero mode is set on every copy; otherwise the source mode is kept.
+// Copies that are already up to date are skipped, so re-running a build
+// doesn't touch their mtimes.
+func installFile(config *BuildConfig, result *BuildResult, srcPath, relDest, primaryDest string, extraDests []string, mode os.FileMode) (string, error) {
+	for _, dest := range append([]string{primaryDest}, extraDests...) {
+		destPath := filepath.Join(dest, relDest)
+		upToDate, err := installedUpToDate(config, srcPath, destPath)
+		if err != nil {
+			return "", err
+		}
+		if upToDate {
+			result.Output = append(result.Output, fmt.Sprintf("Already installed: %s is up to date", destPath))
+		} else if err := copyFile(srcPath, destPath); err != nil {
+			return "", err
+		}
 
-		for _, dest := range extraDests {
-			if err := copyFile(srcPath, filepath.Join(dest, relDest)); err != nil {
-				return nil, err
+		// Chmod rather than relying on the create mode, which the umask
+		// narrows and which doesn't apply to an existing file
+		if mode != 0 {
+			if err := os.Chmod(destPath, mode); err != nil {
+				return "", err
 			}
 		}
+	}
 
-		if relPath, err := filepath.Rel(config.GemDir, filepath.Join(primaryDest, relDest)); err == nil {
-			installed = append(installed, filepath.ToSlash(relPath))
-		} else {
-			installed = append(installed, filepath.ToSlash(filepath.Join(primaryDest, relDest)))
+	// Paths outside the gem (e.g. an absolute DestPath) are reported as absolute
+	destPath := filepath.Join(primaryDest, relDest)
+	if relPath, err := filepath.Rel(config.GemDir, destPath); err == nil && isWithinDir(config.GemDir, destPath) {
+		return filepath.ToSlash(relPath), nil
+	}
+	return filepath.ToSlash(destPath), nil
+}
+
+// extraInstallFiles returns the regular files in extensionDir matching
+// config.ExtraInstallGlobs, relative to extensionDir, leaving out files
+// already among the built outputs
+func extraInstallFiles(config *BuildConfig, extensionDir string, built []string) ([]string, error) {
+	seen := make(map[string]struct{}, len(built))
+	for _, rel := range built {
+		seen[filepath.Clean(rel)] = struct{}{}
+	}
+
+	var extras []string
+	for _, pattern := range config.ExtraInstallGlobs {
+		matches, err := filepathGlob(filepath.Join(extensionDir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("failed to glob pattern %s in %s: %v", pattern, extensionDir, err)
+		}
+
+		for _, match := range matches {
+			rel, err := filepath.Rel(extensionDir, match)
+			if err != nil {
+				continue
+			}
+			if _, ok := seen[rel]; ok {
+				continue
+			}
+			if info, err := osStat(match); err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			seen[rel] = struct{}{}
+			extras = append(extras, rel)
 		}
 	}
 
-	return installed, nil
+	return extras, nil
+}
+
+// buildOutputPaths returns the paths of build outputs that are not installed.
+// Outputs of out-of-tree builds don't live in the gem, so their absolute
+// paths in the build directory are returned instead of gem-relative ones.
+func buildOutputPaths(config *BuildConfig, extensionFile, extensionDir string, built []string) []string {
+	if config.BuildDir == "" {
+		return makeGemRelative(config.GemDir, extensionFile, built)
+	}
+
+	paths := make([]string, 0, len(built))
+	for _, rel := range built {
+		paths = append(paths, filepath.ToSlash(filepath.Join(extensionDir, rel)))
+	}
+	return paths
 }
 
 func makeGemRelative(gemDir, extensionFile string, built []string) []string {
 	var relPaths []string
-	baseDir := filepath.Dir(extensionFile)
+	baseDir := filepath.Dir(filepath.FromSlash(normalizeExtensionPath(extensionFile)))
 
 	for _, rel := range built {
 		full := filepath.Join(baseDir, rel)
@@ -103,13 +241,26 @@ func isNativeLibrary(path string) bool {
 	return ok
 }
 
+// isStaticLibrary reports whether path is a static archive (.a, .lib)
+func isStaticLibrary(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	_, ok := staticLibraryExtensions[ext]
+	return ok
+}
+
+// isExtensionLibrary reports whether path is a built extension library:
+// a native library, or a static archive when config.BuildStatic is set
+func isExtensionLibrary(config *BuildConfig, path string) bool {
+	return isNativeLibrary(path) || (config.BuildStatic && isStaticLibrary(path))
+}
+
 func installTargets(config *BuildConfig) (primary string, additional []string) {
 	baseDirs := gatherBaseDirectories(config)
 	if len(baseDirs) == 0 {
 		return "", nil
 	}
 
-	versionDir, useVersion := rubyVersionDirectory(config.RubyVersion)
+	versionDir, useVersion := installVersionDirectory(config)
 
 	for i, base := range baseDirs {
 		target := base
@@ -123,8 +274,8 @@ func installTargets(config *BuildConfig) (primary string, additional []string) {
 			additional = append(additional, target)
 		}
 
-		// Also copy to unversioned base for compatibility
-		if useVersion {
+		// Also copy to unversioned base for compatibility, unless disabled
+		if useVersion && !config.VersionedOnly {
 			additional = append(additional, base)
 		}
 	}
@@ -156,6 +307,16 @@ func gatherBaseDirectories(config *BuildConfig) []string {
 	return uniqueStrings(dirs)
 }
 
+// installVersionDirectory returns the versioned directory below each
+// install base: config.ABIVersion when set, otherwise the major.minor
+// directory Ruby >= 3.4 uses (see rubyVersionDirectory)
+func installVersionDirectory(config *BuildConfig) (string, bool) {
+	if config.ABIVersion != "" {
+		return safeRelativePath(config.ABIVersion), true
+	}
+	return rubyVersionDirectory(config.RubyVersion)
+}
+
 func rubyVersionDirectory(version string) (string, bool) {
 	major, minor, ok := parseRubyVersion(version)
 	if !ok {
@@ -169,11 +330,18 @@ func rubyVersionDirectory(version string) (string, bool) {
 	return "", false
 }
 
-func determineInstallRelativePath(gemDir, extensionFile, builtRel string) string {
+// determineInstallRelativePath returns the path of a built library relative
+// to the install directory, according to config.InstallLayout
+func determineInstallRelativePath(config *BuildConfig, extensionFile, builtRel string) string {
+	extensionFile = normalizeExtensionPath(extensionFile)
+	if config.InstallLayout == InstallLayoutFlat {
+		return filepath.Base(builtRel)
+	}
+
 	suffix := filepath.Ext(builtRel)
 	baseName := strings.TrimSuffix(filepath.Base(builtRel), suffix)
 
-	if module := moduleFromCreateMakefile(gemDir, extensionFile); module != "" {
+	if module := selectModule(extensionModules(config, extensionFile), baseName); module != "" {
 		modulePath := filepath.FromSlash(module)
 		if suffix != "" && !strings.HasSuffix(modulePath, suffix) {
 			modulePath += suffix
@@ -184,11 +352,11 @@ func determineInstallRelativePath(gemDir, extensionFile, builtRel string) string
 	if strings.HasSuffix(extensionFile, "extconf.rb") {
 		relPath := strings.TrimPrefix(extensionFile, "ext/")
 		relPath = strings.TrimSuffix(relPath, "/extconf.rb")
-		relPath = strings.TrimSuffix(relPath, filepath.Ext(relPath))
+		relPath = strings.TrimSuffix(relPath, path.Ext(relPath))
 		relPath = strings.Trim(relPath, "/\\")
 
 		if relPath != "" && !strings.HasSuffix(relPath, baseName) {
-			relPath = filepath.Join(relPath, baseName)
+			relPath = path.Join(relPath, baseName)
 		}
 
 		if relPath == "" {
@@ -199,51 +367,153 @@ func determineInstallRelativePath(gemDir, extensionFile, builtRel string) string
 			relPath += suffix
 		}
 
-		return safeRelativePath(relPath)
+		return safeRelativePath(filepath.FromSlash(relPath))
 	}
 
-	relDir := strings.TrimPrefix(filepath.Dir(extensionFile), "ext/")
+	relDir := strings.TrimPrefix(path.Dir(extensionFile), "ext/")
 	if relDir == "" {
 		relDir = baseName
 	} else if !strings.HasSuffix(relDir, baseName) {
-		relDir = filepath.Join(relDir, baseName)
+		relDir = path.Join(relDir, baseName)
 	}
 
 	if suffix != "" && !strings.HasSuffix(relDir, suffix) {
 		relDir += suffix
 	}
 
-	return safeRelativePath(relDir)
+	return safeRelativePath(filepath.FromSlash(relDir))
 }
 
-func moduleFromCreateMakefile(gemDir, extensionFile string) string {
-	if !strings.HasSuffix(extensionFile, "extconf.rb") {
-		return ""
+// extensionModules returns the modules declared for an extension,
+// falling back to config.GemModule
+func extensionModules(config *BuildConfig, extensionFile string) []string {
+	if modules := modulesFromCreateMakefile(config.GemDir, extensionFile); len(modules) > 0 {
+		return modules
+	}
+	if config.GemModule != "" {
+		return []string{config.GemModule}
 	}
+	return nil
+}
+
+func modulesFromCreateMakefile(gemDir, extensionFile string) []string {
+	extensionFile = filepath.FromSlash(normalizeExtensionPath(extensionFile))
+	switch {
+	case strings.HasSuffix(extensionFile, "extconf.rb"):
+		return modulesFromExtconf(filepath.Join(gemDir, extensionFile))
+	case strings.HasSuffix(extensionFile, "Cargo.toml"):
+		// rb-sys gems keep an extconf.rb calling create_rust_makefile next to Cargo.toml
+		return modulesFromExtconf(filepath.Join(gemDir, filepath.Dir(extensionFile), "extconf.rb"))
+	default:
+		return nil
+	}
+}
+
+// createMakefilePattern matches create_makefile and create_rust_makefile calls,
+// with or without parentheses
+var createMakefilePattern = regexp.MustCompile(`create_(?:rust_)?makefile\s*\(?\s*['"]([^'"]+)['"]`)
 
-	extconfPath := filepath.Join(gemDir, extensionFile)
+// modulesFromExtconf returns every module name passed to create_makefile in an
+// extconf.rb, in order of appearance. Extconf files may call create_makefile
+// conditionally, so more than one name can be declared.
+func modulesFromExtconf(extconfPath string) []string {
 	content, err := os.ReadFile(extconfPath)
 	if err != nil {
-		return ""
+		return nil
+	}
+
+	var modules []string
+	for _, matches := range createMakefilePattern.FindAllStringSubmatch(string(content), -1) {
+		modules = append(modules, matches[1])
 	}
 
-	patterns := []string{
-		`create_makefile\s*\(\s*['"]([^'"]+)['"]`,
-		`create_makefile\s+['"]([^'"]+)['"]`,
+	return uniqueStrings(modules)
+}
+
+// moduleFromExtconf returns the first module name declared in an extconf.rb
+func moduleFromExtconf(extconfPath string) string {
+	if modules := modulesFromExtconf(extconfPath); len(modules) > 0 {
+		return modules[0]
 	}
+	return ""
+}
 
-	for _, pattern := range patterns {
-		re := regexp.MustCompile(pattern)
-		if matches := re.FindStringSubmatch(string(content)); len(matches) > 1 {
-			return matches[1]
+// selectModule picks the module whose base name matches the built artifact,
+// falling back to the first declared module
+func selectModule(modules []string, baseName string) string {
+	for _, module := range modules {
+		if path.Base(module) == baseName {
+			return module
 		}
 	}
 
+	if len(modules) > 0 {
+		return modules[0]
+	}
 	return ""
 }
 
+// stageInstall runs install with the DESTDIR to install into.
+//
+// Makefiles expand $(DESTDIR) into recipes unquoted, so a config.DestPath
+// containing whitespace is split into several arguments by the shell. In
+// that case install runs against a temporary directory and what it
+// installed is copied into DestPath afterwards.
+func stageInstall(config *BuildConfig, install func(destDir string) error) error {
+	if !strings.ContainsAny(config.DestPath, " \t\n") {
+		return install(config.DestPath)
+	}
+
+	stageDir, err := newTempDir(config, "rubyext-install-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stageDir)
+
+	if err = install(stageDir); err != nil {
+		return err
+	}
+
+	if err = copyTree(stageDir, config.DestPath); err != nil {
+		return fmt.Errorf("failed to copy staged install into %s: %w", config.DestPath, err)
+	}
+	return nil
+}
+
+// installedUpToDate reports whether destPath already holds the contents of
+// srcPath. With config.Checksum the SHA-256 of both files is compared;
+// otherwise the copy is current when the sizes match and it isn't older
+// than the source.
+func installedUpToDate(config *BuildConfig, srcPath, destPath string) (bool, error) {
+	destInfo, err := osStat(destPath)
+	if err != nil || !destInfo.Mode().IsRegular() {
+		return false, nil
+	}
+	srcInfo, err := osStat(srcPath)
+	if err != nil {
+		return false, err
+	}
+	if srcInfo.Size() != destInfo.Size() {
+		return false, nil
+	}
+
+	if !config.Checksum {
+		return !destInfo.ModTime().Before(srcInfo.ModTime()), nil
+	}
+
+	srcSum, err := fileSHA256(srcPath)
+	if err != nil {
+		return false, err
+	}
+	destSum, err := fileSHA256(destPath)
+	if err != nil {
+		return false, err
+	}
+	return srcSum == destSum, nil
+}
+
 func copyFile(srcPath, destPath string) error {
-	info, err := os.Stat(srcPath)
+	info, err := osStat(srcPath)
 	if err != nil {
 		return err
 	}
@@ -1,90 +1,409 @@
 package rubyext
 
 import (
+	"context"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestFinalizeNativeExtensionsInstallsToVersionedLib(t *testing.T) {
+	testCases := []struct {
+		name          string
+		versionedOnly bool
+	}{
+		{"with unversioned copy", false},
+		{"versioned only", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gemDir := t.TempDir()
+			extDir := filepath.Join(gemDir, "ext", "json")
+
+			if err := os.MkdirAll(extDir, 0o755); err != nil {
+				t.Fatalf("failed to create extension directory: %v", err)
+			}
+
+			extconf := "require 'mkmf'\ncreate_makefile 'json/ext/parser'\n"
+			extconfPath := filepath.Join(extDir, "extconf.rb")
+			if err := os.WriteFile(extconfPath, []byte(extconf), 0o600); err != nil {
+				t.Fatalf("failed to write extconf.rb: %v", err)
+			}
+			if err := os.Chmod(extconfPath, 0o755); err != nil {
+				t.Fatalf("failed to chmod extconf.rb: %v", err)
+			}
+
+			bundlePath := filepath.Join(extDir, "parser.bundle")
+			if err := os.WriteFile(bundlePath, []byte("binary"), 0o600); err != nil {
+				t.Fatalf("failed to write bundle: %v", err)
+			}
+			if err := os.Chmod(bundlePath, 0o755); err != nil {
+				t.Fatalf("failed to chmod bundle: %v", err)
+			}
+
+			config := &BuildConfig{
+				GemDir:        gemDir,
+				RubyVersion:   "3.4.2",
+				VersionedOnly: tc.versionedOnly,
+			}
+
+			installed, err := finalizeNativeExtensions(config, &BuildResult{}, "ext/json/extconf.rb", extDir, []string{"parser.bundle"})
+			if err != nil {
+				t.Fatalf("finalizeNativeExtensions returned error: %v", err)
+			}
+
+			expected := filepath.ToSlash(filepath.Join("lib", "3.4", "json", "ext", "parser.bundle"))
+			if len(installed) != 1 || installed[0] != expected {
+				t.Fatalf("expected installed paths [%s], got %v", expected, installed)
+			}
+
+			versioned := filepath.Join(gemDir, "lib", "3.4", "json", "ext", "parser.bundle")
+			if _, err := os.Stat(versioned); err != nil {
+				t.Fatalf("expected bundle copied to %s: %v", versioned, err)
+			}
+
+			unversioned := filepath.Join(gemDir, "lib", "json", "ext", "parser.bundle")
+			_, err = os.Stat(unversioned)
+			if tc.versionedOnly && !os.IsNotExist(err) {
+				t.Fatalf("expected no unversioned copy at %s with VersionedOnly, got %v", unversioned, err)
+			}
+			if !tc.versionedOnly && err != nil {
+				t.Fatalf("expected bundle copied to %s: %v", unversioned, err)
+			}
+		})
+	}
+}
+
+func TestFinalizeNativeExtensionsReturnsOriginalPathsForNonNative(t *testing.T) {
 	gemDir := t.TempDir()
-	extDir := filepath.Join(gemDir, "ext", "json")
+	extDir := filepath.Join(gemDir, "ext", "pkg")
 
 	if err := os.MkdirAll(extDir, 0o755); err != nil {
 		t.Fatalf("failed to create extension directory: %v", err)
 	}
 
-	extconf := "require 'mkmf'\ncreate_makefile 'json/ext/parser'\n"
-	extconfPath := filepath.Join(extDir, "extconf.rb")
-	if err := os.WriteFile(extconfPath, []byte(extconf), 0o600); err != nil {
-		t.Fatalf("failed to write extconf.rb: %v", err)
+	if err := os.WriteFile(filepath.Join(extDir, "artifact.txt"), []byte("data"), 0o600); err != nil {
+		t.Fatalf("failed to write artifact: %v", err)
 	}
-	if err := os.Chmod(extconfPath, 0o755); err != nil {
-		t.Fatalf("failed to chmod extconf.rb: %v", err)
+
+	config := &BuildConfig{
+		GemDir:      gemDir,
+		RubyVersion: "3.3.0",
 	}
 
-	bundlePath := filepath.Join(extDir, "parser.bundle")
-	if err := os.WriteFile(bundlePath, []byte("binary"), 0o600); err != nil {
-		t.Fatalf("failed to write bundle: %v", err)
+	installed, err := finalizeNativeExtensions(config, &BuildResult{}, "ext/pkg/Makefile", extDir, []string{"artifact.txt"})
+	if err != nil {
+		t.Fatalf("finalizeNativeExtensions returned error: %v", err)
 	}
-	if err := os.Chmod(bundlePath, 0o755); err != nil {
-		t.Fatalf("failed to chmod bundle: %v", err)
+
+	expected := "ext/pkg/artifact.txt"
+	if len(installed) != 1 || installed[0] != expected {
+		t.Fatalf("expected installed paths [%s], got %v", expected, installed)
 	}
 
-	config := &BuildConfig{
-		GemDir:      gemDir,
-		RubyVersion: "3.4.2",
+	if _, err := os.Stat(filepath.Join(extDir, "artifact.txt")); err != nil {
+		t.Fatalf("expected artifact to remain in place: %v", err)
 	}
+}
 
-	installed, err := finalizeNativeExtensions(config, "ext/json/extconf.rb", extDir, []string{"parser.bundle"})
+func TestFinalizeNativeExtensionsMatchesConditionalCreateMakefile(t *testing.T) {
+	gemDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "nokogiri")
+
+	if err := os.MkdirAll(extDir, 0o755); err != nil {
+		t.Fatalf("failed to create extension directory: %v", err)
+	}
+
+	extconf := `require 'mkmf'
+if enable_config("gumbo")
+  create_makefile("nokogiri/gumbo")
+else
+  create_makefile "nokogiri/nokogiri"
+end
+`
+	if err := os.WriteFile(filepath.Join(extDir, "extconf.rb"), []byte(extconf), 0o600); err != nil {
+		t.Fatalf("failed to write extconf.rb: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(extDir, "nokogiri.so"), []byte("binary"), 0o600); err != nil {
+		t.Fatalf("failed to write shared library: %v", err)
+	}
+
+	config := &BuildConfig{GemDir: gemDir}
+
+	installed, err := finalizeNativeExtensions(config, &BuildResult{}, "ext/nokogiri/extconf.rb", extDir, []string{"nokogiri.so"})
 	if err != nil {
 		t.Fatalf("finalizeNativeExtensions returned error: %v", err)
 	}
 
-	expected := filepath.ToSlash(filepath.Join("lib", "3.4", "json", "ext", "parser.bundle"))
+	expected := "lib/nokogiri/nokogiri.so"
 	if len(installed) != 1 || installed[0] != expected {
 		t.Fatalf("expected installed paths [%s], got %v", expected, installed)
 	}
+}
 
-	versioned := filepath.Join(gemDir, "lib", "3.4", "json", "ext", "parser.bundle")
-	if _, err := os.Stat(versioned); err != nil {
-		t.Fatalf("expected bundle copied to %s: %v", versioned, err)
+func TestFinalizeNativeExtensionsInstallLayout(t *testing.T) {
+	testCases := []struct {
+		layout   InstallLayout
+		expected string
+	}{
+		{InstallLayoutNested, "lib/3.4/json/ext/parser.so"},
+		{InstallLayoutFlat, "lib/3.4/parser.so"},
 	}
 
-	unversioned := filepath.Join(gemDir, "lib", "json", "ext", "parser.bundle")
-	if _, err := os.Stat(unversioned); err != nil {
-		t.Fatalf("expected bundle copied to %s: %v", unversioned, err)
+	for _, tc := range testCases {
+		t.Run(tc.layout.String(), func(t *testing.T) {
+			gemDir := t.TempDir()
+			extDir := filepath.Join(gemDir, "ext", "json")
+			if err := os.MkdirAll(extDir, 0o755); err != nil {
+				t.Fatalf("failed to create extension directory: %v", err)
+			}
+
+			extconf := "create_makefile 'json/ext/parser'\n"
+			if err := os.WriteFile(filepath.Join(extDir, "extconf.rb"), []byte(extconf), 0o600); err != nil {
+				t.Fatalf("failed to write extconf.rb: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(extDir, "parser.so"), []byte("binary"), 0o600); err != nil {
+				t.Fatalf("failed to write library: %v", err)
+			}
+
+			config := &BuildConfig{GemDir: gemDir, RubyVersion: "3.4.2", InstallLayout: tc.layout}
+
+			installed, err := finalizeNativeExtensions(config, &BuildResult{}, "ext/json/extconf.rb", extDir, []string{"parser.so"})
+			if err != nil {
+				t.Fatalf("finalizeNativeExtensions returned error: %v", err)
+			}
+			if len(installed) != 1 || installed[0] != tc.expected {
+				t.Fatalf("expected installed paths [%s], got %v", tc.expected, installed)
+			}
+
+			unversioned := filepath.Join(gemDir, "lib", strings.TrimPrefix(tc.expected, "lib/3.4/"))
+			if _, err := os.Stat(unversioned); err != nil {
+				t.Fatalf("expected library copied to %s: %v", unversioned, err)
+			}
+		})
 	}
 }
 
-func TestFinalizeNativeExtensionsReturnsOriginalPathsForNonNative(t *testing.T) {
+func TestFinalizeNativeExtensionsArtifactMode(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("file modes are not meaningful on Windows")
+	}
+
 	gemDir := t.TempDir()
-	extDir := filepath.Join(gemDir, "ext", "pkg")
+	extDir := filepath.Join(gemDir, "ext", "json")
+	if err := os.MkdirAll(extDir, 0o755); err != nil {
+		t.Fatalf("failed to create extension directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(extDir, "extconf.rb"), []byte("create_makefile 'json/ext/parser'\n"), 0o600); err != nil {
+		t.Fatalf("failed to write extconf.rb: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(extDir, "parser.so"), []byte("binary"), 0o600); err != nil {
+		t.Fatalf("failed to write library: %v", err)
+	}
+
+	// A copy left by an earlier install keeps its mode unless one is set
+	unversioned := filepath.Join(gemDir, "lib", "json", "ext", "parser.so")
+	if err := os.MkdirAll(filepath.Dir(unversioned), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(unversioned, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &BuildConfig{GemDir: gemDir, RubyVersion: "3.4.2", ArtifactMode: 0o755}
+	if _, err := finalizeNativeExtensions(config, &BuildResult{}, "ext/json/extconf.rb", extDir, []string{"parser.so"}); err != nil {
+		t.Fatalf("finalizeNativeExtensions returned error: %v", err)
+	}
+
+	for _, path := range []string{unversioned, filepath.Join(gemDir, "lib", "3.4", "json", "ext", "parser.so")} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("expected library installed to %s: %v", path, err)
+		}
+		if info.Mode().Perm() != 0o755 {
+			t.Errorf("%s: expected mode 0755, got %o", path, info.Mode().Perm())
+		}
+	}
+}
+
+func TestFinalizeNativeExtensionsSkipsUpToDateCopies(t *testing.T) {
+	for _, checksum := range []bool{false, true} {
+		gemDir := t.TempDir()
+		extDir := filepath.Join(gemDir, "ext", "myext")
+		if err := os.MkdirAll(extDir, 0o755); err != nil {
+			t.Fatalf("failed to create extension directory: %v", err)
+		}
+		srcPath := filepath.Join(extDir, "myext.so")
+		if err := os.WriteFile(srcPath, []byte("binary"), 0o600); err != nil {
+			t.Fatalf("failed to write library: %v", err)
+		}
 
+		config := &BuildConfig{GemDir: gemDir, Checksum: checksum}
+		install := func() *BuildResult {
+			result := &BuildResult{}
+			if _, err := finalizeNativeExtensions(config, result, "ext/myext/extconf.rb", extDir, []string{"myext.so"}); err != nil {
+				t.Fatalf("finalizeNativeExtensions returned error: %v", err)
+			}
+			return result
+		}
+
+		install()
+		destPath := filepath.Join(gemDir, "lib", "myext.so")
+		installedAt := time.Now().Add(time.Hour)
+		if err := os.Chtimes(destPath, installedAt, installedAt); err != nil {
+			t.Fatal(err)
+		}
+
+		// An identical copy is left alone
+		result := install()
+		info, err := os.Stat(destPath)
+		if err != nil {
+			t.Fatalf("expected library installed to %s: %v", destPath, err)
+		}
+		if !info.ModTime().Equal(installedAt) || !strings.Contains(strings.Join(result.Output, "\n"), "Already installed") {
+			t.Fatalf("checksum=%v: expected the up to date copy to be skipped, got mtime %v, output %v", checksum, info.ModTime(), result.Output)
+		}
+
+		// Changed content of the same size is copied again; the checksum
+		// catches it even though the installed copy is newer
+		if err := os.WriteFile(srcPath, []byte("BINARY"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if !checksum {
+			rebuiltAt := installedAt.Add(time.Hour)
+			if err := os.Chtimes(srcPath, rebuiltAt, rebuiltAt); err != nil {
+				t.Fatal(err)
+			}
+		}
+		install()
+		if data, err := os.ReadFile(destPath); err != nil || string(data) != "BINARY" {
+			t.Fatalf("checksum=%v: expected changed library to be reinstalled, got %q (%v)", checksum, data, err)
+		}
+	}
+}
+
+func TestRunCommonBuildRecordsBuiltArtifacts(t *testing.T) {
+	gemDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "myext")
 	if err := os.MkdirAll(extDir, 0o755); err != nil {
 		t.Fatalf("failed to create extension directory: %v", err)
 	}
 
-	if err := os.WriteFile(filepath.Join(extDir, "artifact.txt"), []byte("data"), 0o600); err != nil {
-		t.Fatalf("failed to write artifact: %v", err)
+	steps := CommonBuildSteps{
+		ConfigureFunc: func(context.Context, *BuildConfig, string, *BuildResult) error { return nil },
+		BuildFunc: func(_ context.Context, _ *BuildConfig, dir string, _ *BuildResult) error {
+			return os.WriteFile(filepath.Join(dir, "myext.so"), []byte("binary"), 0o600)
+		},
+		FindFunc: func(string) ([]string, error) { return []string{"myext.so"}, nil },
 	}
 
-	config := &BuildConfig{
-		GemDir:      gemDir,
-		RubyVersion: "3.3.0",
+	config := &BuildConfig{GemDir: gemDir, DestPath: t.TempDir()}
+	result, err := runCommonBuild(context.Background(), config, "ext/myext/extconf.rb", steps)
+	if err != nil {
+		t.Fatalf("runCommonBuild returned error: %v", err)
 	}
 
-	installed, err := finalizeNativeExtensions(config, "ext/pkg/Makefile", extDir, []string{"artifact.txt"})
+	if !reflect.DeepEqual(result.BuiltArtifacts, []string{"ext/myext/myext.so"}) {
+		t.Fatalf("expected built artifact relative to the gem, got %v", result.BuiltArtifacts)
+	}
+	installed := filepath.ToSlash(filepath.Join(config.DestPath, "myext.so"))
+	if !reflect.DeepEqual(result.Extensions, []string{installed}) {
+		t.Fatalf("expected installed extension %s, got %v", installed, result.Extensions)
+	}
+
+}
+
+func TestFinalizeNativeExtensionsUsesABIVersion(t *testing.T) {
+	gemDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "myext")
+	if err := os.MkdirAll(extDir, 0o755); err != nil {
+		t.Fatalf("failed to create extension directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(extDir, "myext.so"), []byte("binary"), 0o600); err != nil {
+		t.Fatalf("failed to write library: %v", err)
+	}
+
+	config := &BuildConfig{GemDir: gemDir, RubyVersion: "3.4.2", ABIVersion: "3.4.0", VersionedOnly: true}
+
+	installed, err := finalizeNativeExtensions(config, &BuildResult{}, "ext/myext/extconf.rb", extDir, []string{"myext.so"})
 	if err != nil {
 		t.Fatalf("finalizeNativeExtensions returned error: %v", err)
 	}
 
-	expected := "ext/pkg/artifact.txt"
+	expected := "lib/3.4.0/myext.so"
 	if len(installed) != 1 || installed[0] != expected {
 		t.Fatalf("expected installed paths [%s], got %v", expected, installed)
 	}
+	if _, err := os.Stat(filepath.Join(gemDir, "lib", "3.4")); !os.IsNotExist(err) {
+		t.Fatalf("expected no directory derived from RubyVersion, got %v", err)
+	}
+}
 
-	if _, err := os.Stat(filepath.Join(extDir, "artifact.txt")); err != nil {
-		t.Fatalf("expected artifact to remain in place: %v", err)
+func TestFinalizeNativeExtensionsInstallsExtraFiles(t *testing.T) {
+	gemDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "json")
+	if err := os.MkdirAll(extDir, 0o755); err != nil {
+		t.Fatalf("failed to create extension directory: %v", err)
+	}
+
+	extconf := "create_makefile 'json/ext/parser'\n"
+	if err := os.WriteFile(filepath.Join(extDir, "extconf.rb"), []byte(extconf), 0o600); err != nil {
+		t.Fatalf("failed to write extconf.rb: %v", err)
+	}
+	for _, name := range []string{"parser.so", "libyajl.so.2", "parser.o"} {
+		if err := os.WriteFile(filepath.Join(extDir, name), []byte("binary"), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	config := &BuildConfig{GemDir: gemDir, ExtraInstallGlobs: []string{"libyajl.so.*", "*.so"}}
+
+	installed, err := finalizeNativeExtensions(config, &BuildResult{}, "ext/json/extconf.rb", extDir, []string{"parser.so"})
+	if err != nil {
+		t.Fatalf("finalizeNativeExtensions returned error: %v", err)
+	}
+
+	expected := []string{"lib/json/ext/parser.so", "lib/json/ext/libyajl.so.2"}
+	if !reflect.DeepEqual(installed, expected) {
+		t.Fatalf("expected %v, got %v", expected, installed)
+	}
+	if _, err := os.Stat(filepath.Join(gemDir, "lib", "json", "ext", "libyajl.so.2")); err != nil {
+		t.Fatalf("expected extra file next to the extension: %v", err)
+	}
+	if isNativeLibrary("libyajl.so.2") {
+		t.Fatal("expected versioned shared library not to be treated as an extension")
+	}
+}
+
+func TestDetermineInstallRelativePathFallsBackToGemModule(t *testing.T) {
+	gemDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "dynamic")
+	if err := os.MkdirAll(extDir, 0o755); err != nil {
+		t.Fatalf("failed to create extension directory: %v", err)
+	}
+
+	extconf := "require 'mkmf'\ncreate_makefile(File.join(NAME, 'native'))\n"
+	if err := os.WriteFile(filepath.Join(extDir, "extconf.rb"), []byte(extconf), 0o600); err != nil {
+		t.Fatalf("failed to write extconf.rb: %v", err)
+	}
+
+	config := &BuildConfig{GemDir: gemDir, GemModule: "mygem/native"}
+	got := determineInstallRelativePath(config, "ext/dynamic/extconf.rb", "native.so")
+	if want := filepath.Join("mygem", "native.so"); got != want {
+		t.Fatalf("determineInstallRelativePath() = %q, want %q", got, want)
+	}
+
+	if err := os.WriteFile(filepath.Join(extDir, "extconf.rb"), []byte("create_makefile 'other/native'\n"), 0o600); err != nil {
+		t.Fatalf("failed to write extconf.rb: %v", err)
+	}
+	got = determineInstallRelativePath(config, "ext/dynamic/extconf.rb", "native.so")
+	if want := filepath.Join("other", "native.so"); got != want {
+		t.Fatalf("determineInstallRelativePath() = %q, want %q (create_makefile should win)", got, want)
 	}
 }
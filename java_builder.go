@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
 	"strings"
 )
@@ -64,7 +64,7 @@ func (b *JavaBuilder) CanBuild(extensionFile string) bool {
 // Build compiles the Java extension
 func (b *JavaBuilder) Build(ctx context.Context, config *BuildConfig, extensionFile string) (*BuildResult, error) {
 	// Check if this is a Maven project
-	if strings.ToLower(filepath.Base(extensionFile)) == pomXMLFile {
+	if strings.ToLower(path.Base(normalizeExtensionPath(extensionFile))) == pomXMLFile {
 		return runCommonBuild(ctx, config, extensionFile, CommonBuildSteps{
 			ConfigureFunc: b.noConfigure,
 			BuildFunc:     b.runMavenBuild,
@@ -82,21 +82,28 @@ func (b *JavaBuilder) Build(ctx context.Context, config *BuildConfig, extensionF
 
 // Clean removes build artifacts
 func (b *JavaBuilder) Clean(ctx context.Context, config *BuildConfig, extensionFile string) error {
-	extensionPath := filepath.Join(config.GemDir, extensionFile)
-	extensionDir := filepath.Dir(extensionPath)
+	if config.CleanArtifactsOnly {
+		return cleanArtifacts(ctx, config, extensionFile, b.findBuiltExtensions)
+	}
+
+	extensionDir := extensionBuildDir(config, extensionFile)
 
 	// If Maven project, use mvn clean
-	if strings.ToLower(filepath.Base(extensionFile)) == "pom.xml" {
-		cleanCmd := exec.CommandContext(ctx, "mvn", "clean")
+	if strings.ToLower(path.Base(normalizeExtensionPath(extensionFile))) == pomXMLFile {
+		cleanCmd := execCommandContext(ctx, "mvn", "clean")
 		cleanCmd.Dir = extensionDir
 		_ = cleanCmd.Run()
-		return nil
+		return cleanResult(ctx, nil)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
 	// Otherwise, just remove .class and .jar files
 	patterns := []string{"*.class", "*.jar"}
 	for _, pattern := range patterns {
-		matches, _ := filepath.Glob(filepath.Join(extensionDir, pattern))
+		matches, _ := filepathGlob(filepath.Join(extensionDir, pattern))
 		for _, match := range matches {
 			_ = os.Remove(match)
 		}
@@ -107,7 +114,8 @@ func (b *JavaBuilder) Clean(ctx context.Context, config *BuildConfig, extensionF
 
 // noConfigure is a no-op since Java doesn't need configuration
 func (b *JavaBuilder) noConfigure(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
-	if config.Verbose {
+	appendWarningsAsErrorsNote(config, result, b.Name())
+	if config.effectiveLogLevel() >= LogLevelVerbose {
 		result.Output = append(result.Output, "Java project, no configuration needed")
 	}
 	return nil
@@ -115,30 +123,17 @@ func (b *JavaBuilder) noConfigure(ctx context.Context, config *BuildConfig, exte
 
 // runMavenBuild executes mvn package for Maven projects
 func (b *JavaBuilder) runMavenBuild(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
-	args := []string{"package"}
-
-	// Add any additional build args
-	args = append(args, config.BuildArgs...)
-
 	// Run mvn package
-	cmd := exec.CommandContext(ctx, "mvn", args...)
+	cmd := execCommandContext(ctx, "mvn", b.mavenArgs(config)...)
 	cmd.Dir = extensionDir
 
 	// Set environment variables
-	cmd.Env = os.Environ()
-	for key, value := range config.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
-	}
+	cmd.Env = buildCommandEnv(config)
 
-	output, err := cmd.CombinedOutput()
-	outputLines := strings.Split(string(output), "\n")
-	result.Output = append(result.Output, outputLines...)
+	output, err := combinedOutput(cmd)
+	appendCommandOutput(config, result, output)
 
-	if config.Verbose {
-		result.Output = append(result.Output,
-			fmt.Sprintf("Running: mvn %s", strings.Join(args, " ")),
-			fmt.Sprintf("Working directory: %s", extensionDir))
-	}
+	appendCommandLog(config, result, cmd, err)
 
 	if err != nil {
 		return BuildError("Maven", result.Output, err)
@@ -147,10 +142,23 @@ func (b *JavaBuilder) runMavenBuild(ctx context.Context, config *BuildConfig, ex
 	return nil
 }
 
+// mavenArgs returns the arguments for mvn package
+func (b *JavaBuilder) mavenArgs(config *BuildConfig) []string {
+	args := []string{"package"}
+
+	// Build modules in parallel threads if specified
+	if jobs := parallelJobs(config); jobs > 0 {
+		args = append(args, "-T", fmt.Sprintf("%d", jobs))
+	}
+
+	// Add any additional build args
+	return append(args, config.BuildArgs...)
+}
+
 // runJavacBuild executes javac for direct Java compilation
 func (b *JavaBuilder) runJavacBuild(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
 	// Find all .java files in the directory
-	javaFiles, err := filepath.Glob(filepath.Join(extensionDir, "*.java"))
+	javaFiles, err := filepathGlob(filepath.Join(extensionDir, "*.java"))
 	if err != nil || len(javaFiles) == 0 {
 		return fmt.Errorf("no Java source files found in %s", extensionDir)
 	}
@@ -165,24 +173,16 @@ func (b *JavaBuilder) runJavacBuild(ctx context.Context, config *BuildConfig, ex
 	}
 
 	// Run javac
-	cmd := exec.CommandContext(ctx, "javac", args...)
+	cmd := execCommandContext(ctx, "javac", args...)
 	cmd.Dir = extensionDir
 
 	// Set environment variables
-	cmd.Env = os.Environ()
-	for key, value := range config.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
-	}
+	cmd.Env = buildCommandEnv(config)
 
-	output, err := cmd.CombinedOutput()
-	outputLines := strings.Split(string(output), "\n")
-	result.Output = append(result.Output, outputLines...)
+	output, err := combinedOutput(cmd)
+	appendCommandOutput(config, result, output)
 
-	if config.Verbose {
-		result.Output = append(result.Output,
-			fmt.Sprintf("Running: javac %s", strings.Join(args, " ")),
-			fmt.Sprintf("Working directory: %s", extensionDir))
-	}
+	appendCommandLog(config, result, cmd, err)
 
 	if err != nil {
 		return BuildError("Javac", result.Output, err)
@@ -194,9 +194,9 @@ func (b *JavaBuilder) runJavacBuild(ctx context.Context, config *BuildConfig, ex
 		jarName = filepath.Join(config.DestPath, jarName)
 	}
 
-	jarCmd := exec.CommandContext(ctx, "jar", "cf", jarName, "-C", extensionDir, ".")
-	jarOutput, jarErr := jarCmd.CombinedOutput()
-	result.Output = append(result.Output, strings.Split(string(jarOutput), "\n")...)
+	jarCmd := execCommandContext(ctx, "jar", "cf", jarName, "-C", extensionDir, ".")
+	jarOutput, jarErr := combinedOutput(jarCmd)
+	appendCommandOutput(config, result, jarOutput)
 
 	if jarErr != nil {
 		return BuildError("Jar", result.Output, jarErr)
@@ -216,7 +216,7 @@ func (b *JavaBuilder) findBuiltExtensions(extensionDir string) ([]string, error)
 	}
 
 	for _, pattern := range patterns {
-		matches, err := filepath.Glob(filepath.Join(extensionDir, pattern))
+		matches, err := filepathGlob(filepath.Join(extensionDir, pattern))
 		if err != nil {
 			return nil, fmt.Errorf("failed to glob pattern %s in %s: %v", pattern, extensionDir, err)
 		}
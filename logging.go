@@ -0,0 +1,190 @@
+package rubyext
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// LogLevel controls how much build context is added to BuildResult.Output.
+//
+// Command output itself is always captured. The log level only decides
+// which extra lines (the command that ran, its working directory and
+// environment) are recorded alongside it:
+//   - LogLevelQuiet: no extra context
+//   - LogLevelNormal: command context only when a command fails
+//   - LogLevelVerbose: command context for every command
+//   - LogLevelDebug: like Verbose, plus the allowlisted environment
+//
+// The zero value defers to BuildConfig.Verbose for backward compatibility:
+// Verbose=true behaves like LogLevelVerbose, otherwise LogLevelNormal.
+type LogLevel int
+
+// Supported log levels, from least to most detailed.
+const (
+	LogLevelQuiet LogLevel = iota + 1
+	LogLevelNormal
+	LogLevelVerbose
+	LogLevelDebug
+)
+
+// String returns the name of the log level
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelQuiet:
+		return "quiet"
+	case LogLevelNormal:
+		return "normal"
+	case LogLevelVerbose:
+		return "verbose"
+	case LogLevelDebug:
+		return "debug"
+	default:
+		return "default"
+	}
+}
+
+// debugEnvAllowlist lists environment variables that are safe to include
+// in debug output. Anything else may carry credentials and is omitted.
+var debugEnvAllowlist = map[string]struct{}{
+	"PATH":            {},
+	"CC":              {},
+	"CXX":             {},
+	"CPP":             {},
+	"LD":              {},
+	"AR":              {},
+	"CFLAGS":          {},
+	"CXXFLAGS":        {},
+	"CPPFLAGS":        {},
+	"LDFLAGS":         {},
+	"MAKE":            {},
+	"MAKEFLAGS":       {},
+	"DESTDIR":         {},
+	"PKG_CONFIG_PATH": {},
+	"RUSTFLAGS":       {},
+	"CARGO":           {},
+	"GOOS":            {},
+	"GOARCH":          {},
+	"TERM":            {},
+	"LANG":            {},
+	"RUBYOPT":         {},
+	"RUBYLIB":         {},
+	"RUBY_ROOT":       {},
+	"RUBY_ENGINE":     {},
+	"RUBY_VERSION":    {},
+}
+
+// debugEnvPrefixAllowlist lists environment variable prefixes that are safe
+// to include in debug output. Ruby variables are listed by name instead,
+// since RUBYGEMS_API_KEY and the like share their prefix.
+var debugEnvPrefixAllowlist = []string{
+	"CARGO_BUILD_",
+	"CARGO_TERM_",
+	"CMAKE_",
+	"CGO_",
+}
+
+// CommandRecord describes a command run during a build, recorded in
+// BuildResult.Commands when config.RecordCommandEnv is set.
+//
+// Env holds the variables the builder set or changed relative to the
+// process environment, plus inherited variables from the debug allowlist
+// (PATH, CC, CFLAGS, ...). Other inherited variables are left out so
+// credentials in the environment don't end up in audit logs.
+type CommandRecord struct {
+	Args []string          `json:"args"`
+	Dir  string            `json:"dir"`
+	Env  map[string]string `json:"env"`
+}
+
+// newCommandRecord returns the record of cmd's arguments, working
+// directory and environment
+func newCommandRecord(cmd *exec.Cmd) CommandRecord {
+	inherited := os.Environ()
+	env := cmd.Env
+	if env == nil {
+		env = inherited
+	}
+
+	parent := make(map[string]string, len(inherited))
+	for _, entry := range inherited {
+		key, value, _ := strings.Cut(entry, "=")
+		parent[key] = value
+	}
+
+	recorded := make(map[string]string)
+	for _, entry := range env {
+		key, value, _ := strings.Cut(entry, "=")
+		if parentValue, ok := parent[key]; !ok || parentValue != value || isDebugEnvAllowed(key) {
+			recorded[key] = value
+		} else {
+			// A later entry restoring the inherited value overrides an earlier change
+			delete(recorded, key)
+		}
+	}
+
+	return CommandRecord{
+		Args: append([]string{}, cmd.Args...),
+		Dir:  cmd.Dir,
+		Env:  recorded,
+	}
+}
+
+// effectiveLogLevel resolves the configured log level, falling back to Verbose
+func (c *BuildConfig) effectiveLogLevel() LogLevel {
+	if c.LogLevel != 0 {
+		return c.LogLevel
+	}
+	if c.Verbose {
+		return LogLevelVerbose
+	}
+	return LogLevelNormal
+}
+
+// appendCommandLog records the command that ran according to the log level,
+// and in result.Commands when config.RecordCommandEnv is set.
+//
+// This should be called after the command's output has been appended and
+// before a failure is turned into a BuildError, so the context ends up
+// in the error's build output.
+func appendCommandLog(config *BuildConfig, result *BuildResult, cmd *exec.Cmd, cmdErr error) {
+	if config.RecordCommandEnv {
+		result.Commands = append(result.Commands, newCommandRecord(cmd))
+	}
+
+	level := config.effectiveLogLevel()
+
+	switch {
+	case level >= LogLevelVerbose:
+	case level == LogLevelNormal && cmdErr != nil:
+	default:
+		return
+	}
+
+	result.Output = append(result.Output,
+		fmt.Sprintf("Running: %s", strings.Join(cmd.Args, " ")),
+		fmt.Sprintf("Working directory: %s", cmd.Dir))
+
+	if level >= LogLevelDebug {
+		result.Output = append(result.Output, "Environment:")
+		for _, entry := range cmd.Env {
+			if key, _, _ := strings.Cut(entry, "="); isDebugEnvAllowed(key) {
+				result.Output = append(result.Output, "  "+entry)
+			}
+		}
+	}
+}
+
+// isDebugEnvAllowed reports whether an environment variable may be logged
+func isDebugEnvAllowed(key string) bool {
+	if _, ok := debugEnvAllowlist[key]; ok {
+		return true
+	}
+	for _, prefix := range debugEnvPrefixAllowlist {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package rubyext
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAppendCommandLogLevels(t *testing.T) {
+	cmd := exec.Command("make", "-j4")
+	cmd.Dir = "/tmp/ext"
+	cmd.Env = []string{"PATH=/usr/bin", "CFLAGS=-O2", "GEM_HOST_API_KEY=secret", "RUBYOPT=-W0", "RUBYGEMS_API_KEY=secret"}
+
+	cmdErr := errors.New("exit status 2")
+
+	testCases := []struct {
+		name     string
+		config   *BuildConfig
+		err      error
+		expected []string
+	}{
+		{"quiet failure", &BuildConfig{LogLevel: LogLevelQuiet}, cmdErr, nil},
+		{"normal success", &BuildConfig{}, nil, nil},
+		{
+			"normal failure", &BuildConfig{}, cmdErr,
+			[]string{"Running: make -j4", "Working directory: /tmp/ext"},
+		},
+		{
+			"verbose flag", &BuildConfig{Verbose: true}, nil,
+			[]string{"Running: make -j4", "Working directory: /tmp/ext"},
+		},
+		{
+			"debug", &BuildConfig{LogLevel: LogLevelDebug}, nil,
+			[]string{"Running: make -j4", "Working directory: /tmp/ext", "Environment:", "  PATH=/usr/bin", "  CFLAGS=-O2", "  RUBYOPT=-W0"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := &BuildResult{}
+			appendCommandLog(tc.config, result, cmd, tc.err)
+
+			if strings.Join(result.Output, "\n") != strings.Join(tc.expected, "\n") {
+				t.Fatalf("expected output %q, got %q", tc.expected, result.Output)
+			}
+		})
+	}
+}
+
+func TestAppendCommandLogRecordsCommandEnv(t *testing.T) {
+	t.Setenv("PATH", "/usr/bin")
+	t.Setenv("CFLAGS", "-O2")
+	t.Setenv("GEM_HOST_API_KEY", "secret")
+	t.Setenv("RUBYGEMS_API_KEY", "secret")
+	t.Setenv("RUBYOPT", "-W0")
+	t.Setenv("HOME", "/home/builder")
+
+	cmd := exec.Command("make", "install")
+	cmd.Dir = "/tmp/ext"
+	cmd.Env = append(os.Environ(), "DESTDIR=/out", "HOME=/sandbox", "GEM_HOST_API_KEY=secret")
+
+	result := &BuildResult{}
+	appendCommandLog(&BuildConfig{}, result, cmd, nil)
+	if result.Commands != nil {
+		t.Fatalf("expected no command records by default, got %v", result.Commands)
+	}
+
+	appendCommandLog(&BuildConfig{RecordCommandEnv: true}, result, cmd, nil)
+	if len(result.Commands) != 1 {
+		t.Fatalf("expected one command record, got %v", result.Commands)
+	}
+
+	record := result.Commands[0]
+	if !reflect.DeepEqual(record.Args, []string{"make", "install"}) || record.Dir != "/tmp/ext" {
+		t.Fatalf("unexpected command record %+v", record)
+	}
+
+	expected := map[string]string{
+		"PATH":    "/usr/bin",
+		"CFLAGS":  "-O2",
+		"DESTDIR": "/out",
+		"HOME":    "/sandbox",
+		"RUBYOPT": "-W0",
+	}
+	for key, value := range expected {
+		if record.Env[key] != value {
+			t.Errorf("expected %s=%s in recorded env, got %q", key, value, record.Env[key])
+		}
+	}
+	for _, secret := range []string{"GEM_HOST_API_KEY", "RUBYGEMS_API_KEY"} {
+		if _, ok := record.Env[secret]; ok {
+			t.Errorf("expected unchanged inherited %s to be left out", secret)
+		}
+	}
+}
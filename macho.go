@@ -0,0 +1,70 @@
+package rubyext
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// fixMachOInstallNames rewrites the install name of built libraries on
+// macOS when config.FixMachOInstallName is set.
+//
+// Linkers record the absolute build path as a library's install name
+// (LC_ID_DYLIB), which breaks anything linking against it once the gem is
+// installed elsewhere. Each .bundle and .dylib gets @rpath/<file name> as
+// its id instead, so it resolves relative to whatever loads it. Changes
+// are recorded in the output. If install_name_tool isn't available, a
+// note is added and the libraries are installed unchanged.
+func fixMachOInstallNames(ctx context.Context, config *BuildConfig, result *BuildResult, extensionDir string, extensions []string) error {
+	if !config.FixMachOInstallName || runtime.GOOS != platformDarwin {
+		return nil
+	}
+
+	toolPath, err := execLookPath("install_name_tool")
+	if err != nil {
+		result.Output = append(result.Output, "Note: install_name_tool not found, install names left unchanged")
+		return nil
+	}
+
+	for _, extension := range extensions {
+		if !isMachOLibrary(extension) {
+			continue
+		}
+
+		path := extension
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(extensionDir, path)
+		}
+
+		args := installNameArgs(path)
+		cmd := execCommandContext(ctx, toolPath, args...)
+		cmd.Dir = extensionDir
+
+		output, err := cmd.CombinedOutput()
+		appendCommandOutput(config, result, output)
+
+		appendCommandLog(config, result, cmd, err)
+
+		if err != nil {
+			return BuildError("install_name_tool", result.Output, fmt.Errorf("failed to set install name of %s: %w", extension, err))
+		}
+
+		result.Output = append(result.Output, fmt.Sprintf("Set install name of %s to %s", extension, args[1]))
+	}
+
+	return nil
+}
+
+// isMachOLibrary reports whether path is a macOS bundle or dynamic library
+func isMachOLibrary(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".bundle" || ext == ".dylib"
+}
+
+// installNameArgs returns the install_name_tool arguments that give a
+// library an @rpath-relative id
+func installNameArgs(path string) []string {
+	return []string{"-id", "@rpath/" + filepath.Base(path), path}
+}
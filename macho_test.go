@@ -0,0 +1,18 @@
+package rubyext
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInstallNameArgs(t *testing.T) {
+	args := installNameArgs("/tmp/build/ext/myext/myext.bundle")
+	expected := []string{"-id", "@rpath/myext.bundle", "/tmp/build/ext/myext/myext.bundle"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("expected %v, got %v", expected, args)
+	}
+
+	if isMachOLibrary("myext.so") || !isMachOLibrary("libfoo.dylib") || !isMachOLibrary("myext.bundle") {
+		t.Fatal("expected only .bundle and .dylib to be treated as Mach-O libraries")
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -65,21 +64,28 @@ func (b *MakefileBuilder) Build(ctx context.Context, config *BuildConfig, extens
 
 // Clean removes build artifacts
 func (b *MakefileBuilder) Clean(ctx context.Context, config *BuildConfig, extensionFile string) error {
-	extensionPath := filepath.Join(config.GemDir, extensionFile)
-	extensionDir := filepath.Dir(extensionPath)
+	if config.CleanArtifactsOnly {
+		return cleanArtifacts(ctx, config, extensionFile, b.findBuiltExtensions)
+	}
+
+	dir, err := makeDir(config, extensionBuildDir(config, extensionFile))
+	if err != nil {
+		return err
+	}
 
 	makeProgram := b.getMakeProgram()
-	cleanCmd := exec.CommandContext(ctx, makeProgram, "clean")
-	cleanCmd.Dir = extensionDir
+	cleanCmd := execCommandContext(ctx, makeProgram, "clean")
+	cleanCmd.Dir = dir
 
 	// Ignore errors - clean target may not exist
 	_ = cleanCmd.Run()
-	return nil
+	return cleanResult(ctx, nil)
 }
 
 // noConfigure is a no-op since Makefile doesn't need configuration
 func (b *MakefileBuilder) noConfigure(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
-	if config.Verbose {
+	appendWarningsAsErrorsNote(config, result, b.Name())
+	if config.effectiveLogLevel() >= LogLevelVerbose {
 		result.Output = append(result.Output, "Using existing Makefile, no configuration needed")
 	}
 	return nil
@@ -90,64 +96,63 @@ func (b *MakefileBuilder) noConfigure(ctx context.Context, config *BuildConfig,
 //nolint:dupl // Similar to extconf_builder but with different context
 func (b *MakefileBuilder) runMake(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
 	makeProgram := b.getMakeProgram()
+	dir, err := makeDir(config, extensionDir)
+	if err != nil {
+		return BuildError("Make", result.Output, err)
+	}
 
 	// Build make arguments
 	args := []string{}
 
 	// Add parallel jobs if specified
-	if config.Parallel > 0 {
-		args = append(args, fmt.Sprintf("-j%d", config.Parallel))
+	if jobs := parallelJobs(config); jobs > 0 {
+		args = append(args, fmt.Sprintf("-j%d", jobs))
 	}
 
 	// Clean first if requested
 	if config.CleanFirst {
-		cleanCmd := exec.CommandContext(ctx, makeProgram, "clean")
-		cleanCmd.Dir = extensionDir
-		cleanOutput, _ := cleanCmd.CombinedOutput()
-		result.Output = append(result.Output, strings.Split(string(cleanOutput), "\n")...)
+		cleanCmd := execCommandContext(ctx, makeProgram, "clean")
+		cleanCmd.Dir = dir
+		cleanOutput, _ := combinedOutput(cleanCmd)
+		appendCommandOutput(config, result, cleanOutput)
 	}
 
 	// Run make
-	cmd := exec.CommandContext(ctx, makeProgram, args...)
-	cmd.Dir = extensionDir
+	cmd := execCommandContext(ctx, makeProgram, args...)
+	cmd.Dir = dir
 
 	// Set environment variables
-	cmd.Env = os.Environ()
-	for key, value := range config.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
-	}
+	cmd.Env = buildCommandEnv(config)
+	cmd.Env = appendMSVCEnv(ctx, config, result, cmd.Env)
 
 	// Set DESTDIR if dest path is specified
 	if config.DestPath != "" {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("DESTDIR=%s", config.DestPath))
 	}
 
-	output, err := cmd.CombinedOutput()
-	outputLines := strings.Split(string(output), "\n")
-	result.Output = append(result.Output, outputLines...)
+	output, err := combinedOutput(cmd)
+	appendCommandOutput(config, result, output)
 
-	if config.Verbose {
-		result.Output = append(result.Output,
-			fmt.Sprintf("Running: %s %s", makeProgram, strings.Join(args, " ")),
-			fmt.Sprintf("Working directory: %s", extensionDir))
-	}
+	appendCommandLog(config, result, cmd, err)
 
 	if err != nil {
 		return BuildError("Make", result.Output, err)
 	}
 
 	// Run make install if dest path is specified
-	if config.DestPath != "" {
-		installCmd := exec.CommandContext(ctx, makeProgram, "install")
-		installCmd.Dir = extensionDir
-		installCmd.Env = cmd.Env
-
-		installOutput, err := installCmd.CombinedOutput()
-		installLines := strings.Split(string(installOutput), "\n")
-		result.Output = append(result.Output, installLines...)
-
+	if target := installTarget(config); config.DestPath != "" && target != "" {
+		err = stageInstall(config, func(destDir string) error {
+			installCmd := execCommandContext(ctx, makeProgram, target)
+			installCmd.Dir = dir
+			installCmd.Env = cmd.Env
+			installCmd.Env = append(installCmd.Env, fmt.Sprintf("DESTDIR=%s", destDir))
+
+			installOutput, installErr := combinedOutput(installCmd)
+			appendCommandOutput(config, result, installOutput)
+			return installErr
+		})
 		if err != nil {
-			return BuildError("Make Install", result.Output, err)
+			return installStepError(config, result, "Make Install", err)
 		}
 	}
 
@@ -166,7 +171,7 @@ func (b *MakefileBuilder) findBuiltExtensions(extensionDir string) ([]string, er
 	}
 
 	for _, pattern := range patterns {
-		matches, err := filepath.Glob(filepath.Join(extensionDir, pattern))
+		matches, err := filepathGlob(filepath.Join(extensionDir, pattern))
 		if err != nil {
 			return nil, fmt.Errorf("failed to glob pattern %s in %s: %v", pattern, extensionDir, err)
 		}
@@ -195,6 +200,6 @@ func (b *MakefileBuilder) getMakeProgram() string {
 	case platformWindows:
 		return nmakeProgram
 	default:
-		return makeProgram
+		return unixMakeProgram(runtime.GOOS)
 	}
 }
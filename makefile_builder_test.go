@@ -0,0 +1,114 @@
+package rubyext
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestMakefileBuilderNestedMakefile(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("shell script make requires a POSIX shell")
+	}
+
+	// Builds only where the Makefile is
+	makePath := filepath.Join(t.TempDir(), "make")
+	writeTestScript(t, makePath, "#!/bin/sh\n[ -f Makefile ] || { echo 'no Makefile' >&2; exit 2; }\n[ \"$1\" = clean ] && rm -f myext.so || touch myext.so\n")
+	t.Setenv("MAKE", makePath)
+
+	gemDir := t.TempDir()
+	buildDir := filepath.Join(gemDir, "ext", "myext", "build")
+	if err := os.MkdirAll(buildDir, 0o755); err != nil {
+		t.Fatalf("failed to create build dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(buildDir, "Makefile"), []byte("all:\n"), 0o600); err != nil {
+		t.Fatalf("failed to write Makefile: %v", err)
+	}
+
+	builder := &MakefileBuilder{}
+	config := &BuildConfig{GemDir: gemDir}
+	if _, err := builder.Build(context.Background(), config, "ext/myext/Makefile"); err == nil {
+		t.Fatal("expected make to fail without MakeDir")
+	}
+
+	config.MakeDir = "build"
+	result, err := builder.Build(context.Background(), config, "ext/myext/Makefile")
+	if err != nil {
+		t.Fatalf("Build returned error: %v (%v)", err, result.Output)
+	}
+	if !reflect.DeepEqual(result.Extensions, []string{"lib/myext.so"}) {
+		t.Fatalf("expected the library built in build/ to be installed, got %v", result.Extensions)
+	}
+
+	if err := builder.Clean(context.Background(), config, "ext/myext/Makefile"); err != nil {
+		t.Fatalf("Clean returned error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(buildDir, "myext.so")); !os.IsNotExist(err) {
+		t.Fatalf("expected make clean to run in build/, got %v", err)
+	}
+}
+
+func TestMakefileBuilderInstallTarget(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("shell script make requires a POSIX shell")
+	}
+
+	makePath := filepath.Join(t.TempDir(), "make")
+	writeTestScript(t, makePath, "#!/bin/sh\necho \"$*\" >> make.log\n")
+	t.Setenv("MAKE", makePath)
+
+	testCases := []struct {
+		config   BuildConfig
+		expected string
+	}{
+		{BuildConfig{}, "\ninstall\n"},
+		{BuildConfig{InstallTarget: "install-exec"}, "\ninstall-exec\n"},
+		{BuildConfig{InstallTarget: "install-exec", SkipInstallTarget: true}, "\n"},
+	}
+	for _, tc := range testCases {
+		extDir := t.TempDir()
+		tc.config.DestPath = t.TempDir()
+		if err := (&MakefileBuilder{}).runMake(context.Background(), &tc.config, extDir, &BuildResult{}); err != nil {
+			t.Fatalf("runMake returned error: %v", err)
+		}
+
+		calls, err := os.ReadFile(filepath.Join(extDir, "make.log"))
+		if err != nil || string(calls) != tc.expected {
+			t.Errorf("InstallTarget %q, skip %t: expected make calls %q, got %q (%v)",
+				tc.config.InstallTarget, tc.config.SkipInstallTarget, tc.expected, calls, err)
+		}
+	}
+}
+
+func TestMakefileBuilderRejectsMakeDirOutsideExtension(t *testing.T) {
+	gemDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "myext")
+	if err := os.MkdirAll(extDir, 0o755); err != nil {
+		t.Fatalf("failed to create extension dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(extDir, "Makefile"), []byte("all:\n"), 0o600); err != nil {
+		t.Fatalf("failed to write Makefile: %v", err)
+	}
+
+	builder := &MakefileBuilder{}
+	for _, dir := range []string{"..", "build/../../other", "/tmp", filepath.Join(gemDir, "ext")} {
+		config := &BuildConfig{GemDir: gemDir, MakeDir: dir}
+
+		_, err := builder.Build(context.Background(), config, "ext/myext/Makefile")
+		if !errors.Is(err, ErrOutsideGemDir) || !strings.Contains(err.Error(), "MakeDir "+dir+" escapes") {
+			t.Errorf("MakeDir %q: expected it to be rejected, got %v", dir, err)
+		}
+		var pathErr *ExtensionPathError
+		if errors.As(err, &pathErr) {
+			t.Errorf("MakeDir %q: expected an error other than *ExtensionPathError, got %v", dir, err)
+		}
+		if err := builder.Clean(context.Background(), config, "ext/myext/Makefile"); !errors.Is(err, ErrOutsideGemDir) {
+			t.Errorf("MakeDir %q: expected Clean to reject it, got %v", dir, err)
+		}
+	}
+}
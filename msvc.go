@@ -0,0 +1,151 @@
+package rubyext
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// msvcEnvCache holds the MSVC environment once it has been detected.
+// Running vcvarsall.bat takes seconds, so it's done once per process.
+var (
+	msvcEnvMu    sync.Mutex
+	msvcEnvCache []string
+)
+
+// appendMSVCEnv adds the Visual Studio developer environment to env when
+// config.AutoMSVCEnv is set on Windows.
+//
+// This is what running vcvarsall.bat in a "Developer Command Prompt" does:
+// it puts cl.exe, link.exe and nmake on PATH and sets INCLUDE and LIB.
+// Nothing is added if the process already runs in such a prompt
+// (VCINSTALLDIR is set). If Visual Studio can't be found, a note is added
+// to the output and env is returned unchanged.
+func appendMSVCEnv(ctx context.Context, config *BuildConfig, result *BuildResult, env []string) []string {
+	if !config.AutoMSVCEnv || runtime.GOOS != platformWindows || os.Getenv("VCINSTALLDIR") != "" {
+		return env
+	}
+
+	msvcEnv, err := msvcEnvironment(ctx, config)
+	if err != nil {
+		result.Output = append(result.Output, fmt.Sprintf("Note: MSVC environment not set up: %v", err))
+		return env
+	}
+
+	return append(env, msvcEnv...)
+}
+
+// msvcEnvironment locates Visual Studio with vswhere and returns the
+// variables vcvarsall.bat sets or changes
+func msvcEnvironment(ctx context.Context, config *BuildConfig) ([]string, error) {
+	msvcEnvMu.Lock()
+	defer msvcEnvMu.Unlock()
+
+	if msvcEnvCache != nil {
+		return msvcEnvCache, nil
+	}
+
+	arch := vcvarsArch(runtime.GOARCH)
+	if arch == "" {
+		return nil, fmt.Errorf("unsupported architecture %s", runtime.GOARCH)
+	}
+
+	installPath, err := findVisualStudio(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	vcvarsall := filepath.Join(installPath, "VC", "Auxiliary", "Build", "vcvarsall.bat")
+	if _, err = osStat(vcvarsall); err != nil {
+		return nil, fmt.Errorf("vcvarsall.bat not found: %w", err)
+	}
+
+	// cmd.exe doesn't understand Go's argument quoting, so the call goes
+	// through a batch file rather than a "cmd /c" command line
+	scriptDir, err := newTempDir(config, "rubyext-vcvars-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(scriptDir)
+
+	script := filepath.Join(scriptDir, "vcvars.bat")
+	content := fmt.Sprintf("@call \"%s\" %s >nul || exit /b 1\r\n@set\r\n", vcvarsall, arch)
+	if err = os.WriteFile(script, []byte(content), 0o600); err != nil {
+		return nil, err
+	}
+
+	output, err := execCommandContext(ctx, "cmd", "/c", script).Output()
+	if err != nil {
+		return nil, fmt.Errorf("vcvarsall.bat %s failed: %w", arch, err)
+	}
+
+	msvcEnvCache = parseMSVCEnv(output, os.Getenv)
+	return msvcEnvCache, nil
+}
+
+// findVisualStudio returns the installation path of the latest Visual
+// Studio with the C++ toolset, as reported by vswhere
+func findVisualStudio(ctx context.Context) (string, error) {
+	vswhere := filepath.Join(os.Getenv("ProgramFiles(x86)"), "Microsoft Visual Studio", "Installer", "vswhere.exe")
+	if _, err := osStat(vswhere); err != nil {
+		if vswhere, err = execLookPath("vswhere"); err != nil {
+			return "", fmt.Errorf("vswhere not found, is Visual Studio installed?")
+		}
+	}
+
+	cmd := execCommandContext(ctx, vswhere, "-latest", "-products", "*",
+		"-requires", "Microsoft.VisualStudio.Component.VC.Tools.x86.x64",
+		"-property", "installationPath")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("vswhere failed: %w", err)
+	}
+
+	installPath := strings.TrimSpace(string(output))
+	if installPath == "" {
+		return "", fmt.Errorf("no Visual Studio installation with C++ tools found")
+	}
+
+	return installPath, nil
+}
+
+// vcvarsArch returns the vcvarsall.bat argument for building native
+// binaries on the given Go architecture
+func vcvarsArch(goarch string) string {
+	switch goarch {
+	case "amd64":
+		return "x64"
+	case "386":
+		return "x86"
+	case "arm64":
+		return "arm64"
+	default:
+		return ""
+	}
+}
+
+// parseMSVCEnv parses the output of "set" after vcvarsall.bat, keeping
+// only variables that differ from the current environment so build
+// settings from config.Env aren't overridden by stale copies
+func parseMSVCEnv(output []byte, getenv func(string) string) []string {
+	var env []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimRight(scanner.Text(), "\r"), "=")
+		if !ok || key == "" {
+			continue
+		}
+		if getenv(key) != value {
+			env = append(env, key+"="+value)
+		}
+	}
+
+	return env
+}
@@ -0,0 +1,47 @@
+package rubyext
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestParseMSVCEnvKeepsChangedVariables(t *testing.T) {
+	current := map[string]string{
+		"PATH":    `C:\Windows`,
+		"CFLAGS":  "/O2",
+		"OS":      "Windows_NT",
+		"INCLUDE": "",
+	}
+	output := []byte("CFLAGS=/O2\r\nINCLUDE=C:\\VS\\include\r\nOS=Windows_NT\r\nPATH=C:\\VS\\bin;C:\\Windows\r\nnot a variable\r\n")
+
+	env := parseMSVCEnv(output, func(key string) string { return current[key] })
+
+	expected := []string{`INCLUDE=C:\VS\include`, `PATH=C:\VS\bin;C:\Windows`}
+	if !reflect.DeepEqual(env, expected) {
+		t.Fatalf("expected %v, got %v", expected, env)
+	}
+}
+
+func TestVcvarsArch(t *testing.T) {
+	for goarch, expected := range map[string]string{"amd64": "x64", "386": "x86", "arm64": "arm64", "riscv64": ""} {
+		if got := vcvarsArch(goarch); got != expected {
+			t.Errorf("vcvarsArch(%s) = %q, expected %q", goarch, got, expected)
+		}
+	}
+}
+
+func TestAppendMSVCEnvOnlyOnWindows(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("checks the non-Windows behavior")
+	}
+
+	env := []string{"PATH=/usr/bin"}
+	result := &BuildResult{}
+
+	got := appendMSVCEnv(context.Background(), &BuildConfig{AutoMSVCEnv: true}, result, env)
+	if !reflect.DeepEqual(got, env) || len(result.Output) != 0 {
+		t.Fatalf("expected environment unchanged outside Windows, got %v (output %v)", got, result.Output)
+	}
+}
@@ -0,0 +1,133 @@
+package rubyext
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// PreflightReport describes what a build of a gem's extensions would use,
+// as returned by BuilderFactory.Preflight.
+type PreflightReport struct {
+	Extensions []PreflightExtension // One entry per extension, in the order given
+}
+
+// PreflightExtension describes the builder selected for one extension and
+// the tools it would build with.
+type PreflightExtension struct {
+	ExtensionFile       string            // Extension file, without any " -- " build options
+	BuilderName         string            // Name of the selected builder, empty if none can build it
+	Tools               map[string]string // Absolute path of each tool found, keyed by requirement name
+	ToolVersions        map[string]string // Version of each tool, when the builder implements ToolVersioner
+	MissingDependencies []string          // Required tools that can't be found
+	Error               error             // Why the extension can't be built, if it can't
+}
+
+// Preflight selects a builder for each extension and checks its tools
+// without building anything.
+//
+// For every extension, the builder's required tools are checked as by
+// BuildWith, the paths of the tools found are resolved with ResolveTools,
+// and, when all required tools are present, the versions reported by a
+// ToolVersioner are captured. Tool versions that can't be determined are
+// left out. The report covers every extension; the first error found
+// (no builder, a path outside the gem, or missing tools) is returned along
+// with it.
+//
+// Use PreflightReport.Summary for a one-line description of the run.
+func (f *BuilderFactory) Preflight(ctx context.Context, config *BuildConfig, extensions []string) (PreflightReport, error) {
+	var report PreflightReport
+	var firstError error
+
+	for _, entry := range extensions {
+		extension, _ := extensionBuildOptions(config, entry)
+		extension = normalizeExtensionPath(extension)
+
+		info := f.preflightExtension(ctx, config, extension)
+		if info.Error != nil && firstError == nil {
+			firstError = info.Error
+		}
+		report.Extensions = append(report.Extensions, info)
+	}
+
+	return report, firstError
+}
+
+// preflightExtension fills in the report entry for a single extension
+func (f *BuilderFactory) preflightExtension(ctx context.Context, config *BuildConfig, extension string) PreflightExtension {
+	info := PreflightExtension{ExtensionFile: extension}
+
+	var builder Builder
+	err := validateExtensionPath(config, extension)
+	if err == nil {
+		builder, err = f.BuilderFor(extension)
+	}
+	if err != nil {
+		info.Error = err
+		return info
+	}
+	info.BuilderName = builder.Name()
+
+	if checker, ok := builder.(ToolChecker); ok {
+		info.Tools, _ = ResolveTools(checker.RequiredTools())
+	}
+
+	info.MissingDependencies, info.Error = checkPrerequisites(ctx, config, builder)
+	if info.Error != nil {
+		return info
+	}
+
+	if versioner, ok := builder.(ToolVersioner); ok {
+		info.ToolVersions, _ = versioner.ToolVersions(ctx)
+	}
+
+	return info
+}
+
+// Summary describes the run in one line, grouping extensions by builder,
+// e.g. "Building 3 extensions: 2 ExtConf (cc 13.2.0, ruby 3.4.1), 1 Cargo
+// (cargo 1.82.0, rustc 1.82.0)". Each builder lists the tool versions of
+// its first extension that reported any.
+func (r PreflightReport) Summary() string {
+	var order []string
+	counts := make(map[string]int)
+	versions := make(map[string]map[string]string)
+
+	for _, ext := range r.Extensions {
+		name := ext.BuilderName
+		if name == "" {
+			name = "unbuildable"
+		}
+		if _, seen := counts[name]; !seen {
+			order = append(order, name)
+		}
+		counts[name]++
+		if versions[name] == nil && len(ext.ToolVersions) > 0 {
+			versions[name] = ext.ToolVersions
+		}
+	}
+
+	groups := make([]string, 0, len(order))
+	for _, name := range order {
+		group := fmt.Sprintf("%d %s", counts[name], name)
+		if toolVersions := versions[name]; toolVersions != nil {
+			var tools []string
+			for _, tool := range slices.Sorted(maps.Keys(toolVersions)) {
+				tools = append(tools, tool+" "+toolVersions[tool])
+			}
+			group += " (" + strings.Join(tools, ", ") + ")"
+		}
+		groups = append(groups, group)
+	}
+
+	if len(groups) == 0 {
+		return "Building 0 extensions"
+	}
+	noun := "extensions"
+	if len(r.Extensions) == 1 {
+		noun = "extension"
+	}
+	return fmt.Sprintf("Building %d %s: %s", len(r.Extensions), noun, strings.Join(groups, ", "))
+}
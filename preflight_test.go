@@ -0,0 +1,54 @@
+package rubyext
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPreflight(t *testing.T) {
+	versioned := &versionedMockBuilder{mockBuilder{
+		name:       "Mock",
+		canBuildFn: func(ext string) bool { return strings.HasSuffix(ext, "extconf.rb") },
+	}}
+	plain := &mockBuilder{
+		name:       "Plain",
+		canBuildFn: func(ext string) bool { return strings.HasSuffix(ext, "Makefile") },
+	}
+	factory := &BuilderFactory{}
+	factory.Register(versioned)
+	factory.Register(plain)
+
+	config := &BuildConfig{GemDir: t.TempDir()}
+	extensions := []string{"ext/a/extconf.rb -- --with-a", `ext\b\extconf.rb`, "ext/c/Makefile"}
+	report, err := factory.Preflight(context.Background(), config, extensions)
+	if err != nil {
+		t.Fatalf("Preflight returned error: %v", err)
+	}
+	if versioned.buildCalls != 0 || plain.buildCalls != 0 {
+		t.Fatal("expected Preflight not to build anything")
+	}
+
+	if len(report.Extensions) != 3 || report.Extensions[0].ExtensionFile != "ext/a/extconf.rb" || report.Extensions[1].ExtensionFile != "ext/b/extconf.rb" {
+		t.Fatalf("expected an entry per extension file, got %+v", report.Extensions)
+	}
+	if !reflect.DeepEqual(report.Extensions[0].ToolVersions, map[string]string{"mock": "1.2.3"}) {
+		t.Errorf("expected tool versions to be captured, got %v", report.Extensions[0].ToolVersions)
+	}
+
+	expected := "Building 3 extensions: 2 Mock (mock 1.2.3), 1 Plain"
+	if summary := report.Summary(); summary != expected {
+		t.Errorf("expected summary %q, got %q", expected, summary)
+	}
+
+	// Unbuildable extensions are reported without stopping the check
+	report, err = factory.Preflight(context.Background(), config, []string{"ext/d/unknown", "ext/a/extconf.rb"})
+	if !errors.Is(err, ErrNoBuilder) || len(report.Extensions) != 2 || report.Extensions[0].Error == nil {
+		t.Fatalf("expected the missing builder to be reported, got %+v (%v)", report.Extensions, err)
+	}
+	if summary := report.Summary(); summary != "Building 2 extensions: 1 unbuildable, 1 Mock (mock 1.2.3)" {
+		t.Errorf("unexpected summary %q", summary)
+	}
+}
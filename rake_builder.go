@@ -6,13 +6,12 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
-var execLookPath = exec.LookPath
-var execCommandContext = exec.CommandContext
-
 // Ruby command constant
 const (
 	rubyCommand = "ruby"
@@ -38,6 +37,11 @@ func (b *RakeBuilder) RequiredTools() []ToolRequirement {
 			Optional: true,
 			Purpose:  "Ruby build tool (usually bundled with Ruby)",
 		},
+		{
+			Name:     "bundle",
+			Optional: true,
+			Purpose:  "Installs Gemfile dependencies (when UseBundler is set)",
+		},
 	}
 }
 
@@ -63,66 +67,104 @@ func (b *RakeBuilder) Build(ctx context.Context, config *BuildConfig, extensionF
 		MissingDependencies: nil,
 	}
 
-	extensionPath := filepath.Join(config.GemDir, extensionFile)
-	extensionDir := filepath.Dir(extensionPath)
+	appendWarningsAsErrorsNote(config, result, b.Name())
+
+	// Calculate extension directory, using a working copy if BuildDir is set
+	extensionDir, err := prepareBuildDir(config, extensionFile)
+	if err != nil {
+		return failBuild(result, err)
+	}
+
+	if err := runPreBuildCommands(ctx, config, extensionDir, result); err != nil {
+		return failBuild(result, err)
+	}
 
 	// Handle mkrf_conf files differently - they generate Rakefiles
 	if b.isMkrfConf(extensionFile) {
 		if err := b.runMkrfConf(ctx, config, extensionDir, extensionFile, result); err != nil {
-			result.Error = err
-			return result, err
+			return failBuild(result, err)
 		}
 	}
 
-	if missingDeps, err := b.ensureRakeAvailable(ctx, config); err != nil {
+	bundlePath, missingDeps, err := b.bundlerCommand(config, extensionDir)
+	if err != nil {
+		result.MissingDependencies = missingDeps
+		return failBuild(result, err)
+	}
+
+	if bundlePath != "" {
+		if err := b.runBundleInstall(ctx, config, extensionDir, bundlePath, result); err != nil {
+			return failBuild(result, err)
+		}
+	} else if missingDeps, err := b.ensureRakeAvailable(ctx, config); err != nil {
 		result.MissingDependencies = missingDeps
-		result.Error = err
-		return result, err
+		return failBuild(result, err)
 	}
 
 	// Run rake to build the extension
-	if err := b.runRake(ctx, config, extensionDir, result); err != nil {
-		result.Error = err
-		return result, err
+	if err := b.runRake(ctx, config, extensionDir, bundlePath, result); err != nil {
+		return failBuild(result, err)
 	}
 
 	// Find built extensions
-	extensions, err := b.findBuiltExtensions(extensionDir)
+	extensions, err := findExtensions(config, extensionDir, b.findBuiltExtensions)
 	if err != nil {
-		result.Error = err
-		return result, err
+		return failBuild(result, err)
 	}
 
-	finalized, err := finalizeNativeExtensions(config, extensionFile, extensionDir, extensions)
+	if err = runPostBuildCommands(ctx, config, extensionDir, result); err != nil {
+		return failBuild(result, err)
+	}
+
+	if err = checkArtifacts(config, extensionDir, extensions); err != nil {
+		return failBuild(result, err)
+	}
+
+	if err = postProcessExtensions(ctx, config, result, extensionDir, extensions); err != nil {
+		return failBuild(result, err)
+	}
+
+	result.BuiltArtifacts = buildOutputPaths(config, extensionFile, extensionDir, extensions)
+	finalized, err := finalizeNativeExtensions(config, result, extensionFile, extensionDir, extensions)
 	if err != nil {
-		result.Error = err
-		return result, err
+		return failBuild(result, err)
 	}
 
 	result.Extensions = finalized
+
+	if err = recordChecksums(config, result); err != nil {
+		return failBuild(result, err)
+	}
+
 	result.Success = true
 	return result, nil
 }
 
 // Clean removes build artifacts
 func (b *RakeBuilder) Clean(ctx context.Context, config *BuildConfig, extensionFile string) error {
-	extensionPath := filepath.Join(config.GemDir, extensionFile)
-	extensionDir := filepath.Dir(extensionPath)
+	if config.CleanArtifactsOnly {
+		return cleanArtifacts(ctx, config, extensionFile, b.findBuiltExtensions)
+	}
+
+	extensionDir := extensionBuildDir(config, extensionFile)
 
 	// Try rake clean task
-	cmdName, cmdArgs := b.determineRakeCommand(config, []string{"clean"})
-	cmd := exec.CommandContext(ctx, cmdName, cmdArgs...)
+	cmdName, cmdArgs, err := b.determineRakeCommand(config, []string{"clean"})
+	if err != nil {
+		return err
+	}
+	cmd := execCommandContext(ctx, cmdName, cmdArgs...)
 	cmd.Dir = extensionDir
 
 	// Set environment for Ruby/rake
-	cmd.Env = os.Environ()
+	cmd.Env = inheritedEnv(config)
 	if config.RubyPath != "" {
 		// Ensure rake uses the correct Ruby
 		rubyDir := filepath.Dir(config.RubyPath)
 		cmd.Env = append(cmd.Env, fmt.Sprintf("PATH=%s:%s", rubyDir, os.Getenv("PATH")))
 	}
 
-	return cmd.Run() // Ignore errors, clean is best-effort
+	return cleanResult(ctx, cmd.Run())
 }
 
 // isMkrfConf checks if this is an mkrf_conf file
@@ -138,26 +180,18 @@ func (b *RakeBuilder) runMkrfConf(ctx context.Context, config *BuildConfig, exte
 		rubyPath = rubyCommand
 	}
 
-	mkrfPath := filepath.Join(extensionDir, filepath.Base(extensionFile))
+	mkrfPath := filepath.Join(extensionDir, path.Base(normalizeExtensionPath(extensionFile)))
 
-	cmd := exec.CommandContext(ctx, rubyPath, mkrfPath)
+	cmd := execCommandContext(ctx, rubyPath, mkrfPath)
 	cmd.Dir = extensionDir
 
 	// Set environment variables
-	cmd.Env = os.Environ()
-	for key, value := range config.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
-	}
+	cmd.Env = buildCommandEnv(config)
 
-	output, err := cmd.CombinedOutput()
-	outputLines := strings.Split(string(output), "\n")
-	result.Output = append(result.Output, outputLines...)
+	output, err := combinedOutput(cmd)
+	appendCommandOutput(config, result, output)
 
-	if config.Verbose {
-		result.Output = append(result.Output,
-			fmt.Sprintf("Running: %s %s", rubyPath, mkrfPath),
-			fmt.Sprintf("Working directory: %s", extensionDir))
-	}
+	appendCommandLog(config, result, cmd, err)
 
 	if err != nil {
 		return BuildError("mkrf_conf", result.Output, err)
@@ -165,42 +199,99 @@ func (b *RakeBuilder) runMkrfConf(ctx context.Context, config *BuildConfig, exte
 
 	// Verify Rakefile was created
 	rakefilePath := filepath.Join(extensionDir, "Rakefile")
-	if _, err := os.Stat(rakefilePath); os.IsNotExist(err) {
+	if _, err := osStat(rakefilePath); os.IsNotExist(err) {
 		return BuildError("mkrf_conf", result.Output, fmt.Errorf("rakefile not generated by mkrf_conf"))
 	}
 
 	return nil
 }
 
-// runRake executes rake to build the extension
-func (b *RakeBuilder) runRake(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
-	// Build rake arguments
-	args := []string{}
+// bundlerCommand returns the bundle executable to build with when
+// config.UseBundler is set and the extension directory has a Gemfile, or
+// "" to run rake directly. A missing bundle is reported as a missing
+// dependency.
+func (b *RakeBuilder) bundlerCommand(config *BuildConfig, extensionDir string) (bundlePath string, missingDeps []string, err error) {
+	if !config.UseBundler {
+		return "", nil, nil
+	}
+	if _, statErr := osStat(filepath.Join(extensionDir, "Gemfile")); statErr != nil {
+		return "", nil, nil
+	}
 
-	// Add parallel jobs if specified and rake supports it
-	if config.Parallel > 0 {
-		args = append(args, fmt.Sprintf("--jobs=%d", config.Parallel))
+	bundlePath, lookErr := execLookPath("bundle")
+	if lookErr != nil {
+		return "", []string{"bundler"}, &MissingToolError{
+			Tools:   []string{"bundler"},
+			message: "extension has a Gemfile but bundle was not found in PATH",
+		}
+	}
+
+	return bundlePath, nil, nil
+}
+
+// runBundleInstall installs the gems from the extension's Gemfile
+func (b *RakeBuilder) runBundleInstall(
+	ctx context.Context, config *BuildConfig, extensionDir, bundlePath string, result *BuildResult,
+) error {
+	args := []string{"install"}
+	if config.Offline {
+		args = append(args, "--local") // Only use gems already installed or cached in vendor/cache
+	}
+
+	cmd := execCommandContext(ctx, bundlePath, args...)
+	cmd.Dir = extensionDir
+	cmd.Env = append(buildCommandEnv(config), b.bundlerEnv(extensionDir)...)
+
+	output, err := combinedOutput(cmd)
+	appendCommandOutput(config, result, output)
+
+	appendCommandLog(config, result, cmd, err)
+
+	if err != nil {
+		return BuildError("Bundler", result.Output, err)
+	}
+
+	return nil
+}
+
+// bundlerEnv points bundler at the extension's Gemfile, so a Gemfile in a
+// parent directory isn't picked up instead
+func (b *RakeBuilder) bundlerEnv(extensionDir string) []string {
+	return []string{fmt.Sprintf("BUNDLE_GEMFILE=%s", filepath.Join(extensionDir, "Gemfile"))}
+}
+
+// runRake executes rake to build the extension, through bundle exec when
+// bundlePath is set
+func (b *RakeBuilder) runRake(ctx context.Context, config *BuildConfig, extensionDir, bundlePath string, result *BuildResult) error {
+	args, err := rakeArgs(config)
+	if err != nil {
+		return BuildError("Rake", result.Output, err)
 	}
 
 	// Clean first if requested
 	if config.CleanFirst {
-		cleanCmd := exec.CommandContext(ctx, "rake", "clean")
-		cleanCmd.Dir = extensionDir
-		cleanOutput, _ := cleanCmd.CombinedOutput()
-		result.Output = append(result.Output, strings.Split(string(cleanOutput), "\n")...)
+		if cleanName, cleanArgs, err := b.determineRakeCommand(config, []string{"clean"}); err == nil {
+			cleanCmd := execCommandContext(ctx, cleanName, cleanArgs...)
+			cleanCmd.Dir = extensionDir
+			cleanOutput, _ := combinedOutput(cleanCmd)
+			appendCommandOutput(config, result, cleanOutput)
+		}
 	}
 
-	// Add any custom build args
-	args = append(args, config.BuildArgs...)
-
-	cmdName, cmdArgs := b.determineRakeCommand(config, args)
-	cmd := exec.CommandContext(ctx, cmdName, cmdArgs...)
+	cmdName, cmdArgs := bundlePath, append([]string{"exec", "rake"}, args...)
+	if bundlePath == "" {
+		cmdName, cmdArgs, err = b.determineRakeCommand(config, args)
+		if err != nil {
+			return BuildError("Rake", result.Output, err)
+		}
+	}
+	cmd := execCommandContext(ctx, cmdName, cmdArgs...)
 	cmd.Dir = extensionDir
 
 	// Set environment variables
-	cmd.Env = os.Environ()
-	for key, value := range config.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
+	cmd.Env = buildCommandEnv(config)
+	if bundlePath != "" {
+		cmd.Env = append(cmd.Env, b.bundlerEnv(extensionDir)...)
 	}
 
 	// Ensure rake uses the correct Ruby
@@ -222,15 +313,10 @@ func (b *RakeBuilder) runRake(ctx context.Context, config *BuildConfig, extensio
 		cmd.Env = append(cmd.Env, fmt.Sprintf("RUBY_VERSION=%s", config.RubyVersion))
 	}
 
-	output, err := cmd.CombinedOutput()
-	outputLines := strings.Split(string(output), "\n")
-	result.Output = append(result.Output, outputLines...)
+	output, err := combinedOutput(cmd)
+	appendCommandOutput(config, result, output)
 
-	if config.Verbose {
-		result.Output = append(result.Output,
-			fmt.Sprintf("Running: rake %s", strings.Join(args, " ")),
-			fmt.Sprintf("Working directory: %s", extensionDir))
-	}
+	appendCommandLog(config, result, cmd, err)
 
 	if err != nil {
 		return BuildError("Rake", result.Output, err)
@@ -239,14 +325,50 @@ func (b *RakeBuilder) runRake(ctx context.Context, config *BuildConfig, extensio
 	return nil
 }
 
-func (b *RakeBuilder) determineRakeCommand(config *BuildConfig, args []string) (cmd string, resolvedArgs []string) {
-	if rakePath, err := execLookPath("rake"); err == nil {
-		return rakePath, append([]string{}, args...)
+// rakeTaskPattern matches task names rake accepts on the command line:
+// namespaced names like compile:all, optionally with bracketed arguments
+// (native[x86_64-linux]). Shell metacharacters and whitespace are rejected.
+var rakeTaskPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_:.\-]*(\[[A-Za-z0-9_:.,=/+\-]*\])?$`)
+
+// rakeArgs returns the arguments passed to rake: the job count, then
+// config.RakeTask if set (rake's default task runs otherwise), then
+// config.BuildArgs.
+func rakeArgs(config *BuildConfig) ([]string, error) {
+	args := []string{}
+
+	// Add parallel jobs if specified and rake supports it
+	if jobs := parallelJobs(config); jobs > 0 {
+		args = append(args, fmt.Sprintf("--jobs=%d", jobs))
+	}
+
+	if config.RakeTask != "" {
+		if !rakeTaskPattern.MatchString(config.RakeTask) {
+			return nil, fmt.Errorf("invalid rake task name %q", config.RakeTask)
+		}
+		args = append(args, config.RakeTask)
+	}
+
+	// Add any custom build args
+	return append(args, config.BuildArgs...), nil
+}
+
+// determineRakeCommand returns the command used to run rake.
+//
+// rake from PATH is preferred. Otherwise rake is loaded through RubyGems
+// using config.RubyPath, or the ruby found in PATH when RubyPath is unset.
+// An error is returned if neither rake nor ruby can be found.
+func (b *RakeBuilder) determineRakeCommand(config *BuildConfig, args []string) (cmd string, resolvedArgs []string, err error) {
+	if rakePath, lookErr := execLookPath("rake"); lookErr == nil {
+		return rakePath, append([]string{}, args...), nil
 	}
 
 	rubyPath := config.RubyPath
 	if rubyPath == "" {
-		rubyPath = rubyCommand
+		resolved, lookErr := execLookPath(rubyCommand)
+		if lookErr != nil {
+			return "", nil, fmt.Errorf("neither rake nor ruby found in PATH; set RubyPath to the Ruby used for this gem")
+		}
+		rubyPath = resolved
 	}
 
 	rubyArgs := []string{
@@ -255,37 +377,53 @@ func (b *RakeBuilder) determineRakeCommand(config *BuildConfig, args []string) (
 		"--",
 	}
 	rubyArgs = append(rubyArgs, args...)
-	return rubyPath, rubyArgs
+	return rubyPath, rubyArgs, nil
 }
 
-// findBuiltExtensions locates the compiled extension files
+// rakeLibraryExtensions are the native library extensions rake builds
+var rakeLibraryExtensions = []string{".so", ".bundle", ".dll"}
+
+// rakeOutputDirs are where Rakefiles leave compiled extensions: the
+// extension directory itself, ext/, and lib/ as laid out by rake-compiler,
+// which copies libraries to lib/<name>/ or, for fat gems, to
+// lib/<name>/<ruby api version>/
+var rakeOutputDirs = []string{".", "lib", "lib/*", "lib/*/*", "ext"}
+
+// rakeCompilerTmpDirs are rake-compiler's build directories,
+// tmp/<platform>/<name>/<ruby version>/. Libraries there are only used
+// when none were copied into lib/.
+var rakeCompilerTmpDirs = []string{"tmp/*/*/*"}
+
+// findBuiltExtensions locates the compiled extension files, including
+// those rake-compiler places under lib/ and tmp/
 func (b *RakeBuilder) findBuiltExtensions(extensionDir string) ([]string, error) {
+	extensions, err := globRakeOutputs(extensionDir, rakeOutputDirs)
+	if err != nil || len(extensions) > 0 {
+		return extensions, err
+	}
+
+	return globRakeOutputs(extensionDir, rakeCompilerTmpDirs)
+}
+
+// globRakeOutputs returns the native libraries in the given directory
+// patterns, relative to extensionDir
+func globRakeOutputs(extensionDir string, dirs []string) ([]string, error) {
 	var extensions []string
 
-	// Common extension file patterns
-	patterns := []string{
-		"*.so",     // Linux/Unix shared libraries
-		"*.bundle", // macOS bundles
-		"*.dll",    // Windows dynamic libraries
-		"lib/*.so", // Extensions might be in lib subdirectory
-		"lib/*.bundle",
-		"lib/*.dll",
-		"ext/*.so", // Or in ext subdirectory
-		"ext/*.bundle",
-		"ext/*.dll",
-	}
-
-	for _, pattern := range patterns {
-		matches, err := filepath.Glob(filepath.Join(extensionDir, pattern))
-		if err != nil {
-			return nil, fmt.Errorf("failed to glob pattern %s in %s: %v", pattern, extensionDir, err)
-		}
+	for _, dir := range dirs {
+		for _, ext := range rakeLibraryExtensions {
+			pattern := path.Join(dir, "*"+ext)
+			matches, err := filepathGlob(filepath.Join(extensionDir, filepath.FromSlash(pattern)))
+			if err != nil {
+				return nil, fmt.Errorf("failed to glob pattern %s in %s: %v", pattern, extensionDir, err)
+			}
 
-		for _, match := range matches {
-			// Convert to relative path
-			relPath, err := filepath.Rel(extensionDir, match)
-			if err == nil {
-				extensions = append(extensions, relPath)
+			for _, match := range matches {
+				// Convert to relative path
+				relPath, err := filepath.Rel(extensionDir, match)
+				if err == nil {
+					extensions = append(extensions, relPath)
+				}
 			}
 		}
 	}
@@ -319,7 +457,7 @@ func (b *RakeBuilder) ensureRakeAvailable(ctx context.Context, config *BuildConf
 	cmd := execCommandContext(ctx, rubyPath, "-rrubygems", "-e", script)
 	env := append([]string{}, cmd.Env...)
 	if len(env) == 0 {
-		env = os.Environ()
+		env = inheritedEnv(config)
 	}
 
 	for key, value := range config.Env {
@@ -336,7 +474,7 @@ func (b *RakeBuilder) ensureRakeAvailable(ctx context.Context, config *BuildConf
 	if err := cmd.Run(); err != nil {
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
-			return []string{"rake"}, fmt.Errorf("rake not found")
+			return []string{"rake"}, &MissingToolError{Tools: []string{"rake"}, message: "rake not found"}
 		}
 		return nil, fmt.Errorf("failed to verify rake availability: %w", err)
 	}
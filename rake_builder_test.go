@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"reflect"
+	"runtime"
 	"strconv"
 	"testing"
 )
@@ -31,7 +33,10 @@ func TestDetermineRakeCommandUsesSystemRake(t *testing.T) {
 	builder := &RakeBuilder{}
 	args := []string{"compile"}
 
-	cmd, resolvedArgs := builder.determineRakeCommand(&BuildConfig{}, args)
+	cmd, resolvedArgs, err := builder.determineRakeCommand(&BuildConfig{}, args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 
 	if cmd != testSystemRakePath {
 		t.Fatalf("expected rake command to be /usr/bin/rake, got %q", cmd)
@@ -58,7 +63,10 @@ func TestDetermineRakeCommandFallsBackToRuby(t *testing.T) {
 	config := &BuildConfig{RubyPath: "/opt/ruby/bin/ruby"}
 	args := []string{"compile", "--jobs=4"}
 
-	cmd, resolvedArgs := builder.determineRakeCommand(config, args)
+	cmd, resolvedArgs, err := builder.determineRakeCommand(config, args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 
 	if cmd != "/opt/ruby/bin/ruby" {
 		t.Fatalf("expected ruby fallback, got %q", cmd)
@@ -81,6 +89,87 @@ func TestDetermineRakeCommandFallsBackToRuby(t *testing.T) {
 	}
 }
 
+func TestDetermineRakeCommandResolvesRubyFromPath(t *testing.T) {
+	origLookPath := execLookPath
+	defer func() { execLookPath = origLookPath }()
+
+	execLookPath = func(name string) (string, error) {
+		if name == rubyCommand {
+			return testSystemRubyPath, nil
+		}
+		return "", errors.New("not found")
+	}
+
+	builder := &RakeBuilder{}
+	cmd, resolvedArgs, err := builder.determineRakeCommand(&BuildConfig{}, []string{"compile"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cmd != testSystemRubyPath {
+		t.Fatalf("expected ruby resolved from PATH, got %q", cmd)
+	}
+
+	if resolvedArgs[len(resolvedArgs)-1] != "compile" {
+		t.Fatalf("expected rake args to be forwarded, got %v", resolvedArgs)
+	}
+}
+
+func TestDetermineRakeCommandErrorsWithoutRakeOrRuby(t *testing.T) {
+	origLookPath := execLookPath
+	defer func() { execLookPath = origLookPath }()
+
+	execLookPath = func(string) (string, error) {
+		return "", errors.New("not found")
+	}
+
+	builder := &RakeBuilder{}
+	cmd, _, err := builder.determineRakeCommand(&BuildConfig{}, []string{"compile"})
+	if err == nil {
+		t.Fatalf("expected error when neither rake nor ruby is available, got command %q", cmd)
+	}
+}
+
+func TestDetermineRakeCommandWithCustomTask(t *testing.T) {
+	origLookPath := execLookPath
+	defer func() { execLookPath = origLookPath }()
+
+	execLookPath = func(string) (string, error) {
+		return testSystemRakePath, nil
+	}
+
+	config := &BuildConfig{RakeTask: "compile:all", Parallel: 2, BuildArgs: []string{"--trace"}}
+	args, err := rakeArgs(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	builder := &RakeBuilder{}
+	cmd, resolvedArgs, err := builder.determineRakeCommand(config, args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cmd != testSystemRakePath {
+		t.Fatalf("expected system rake, got %q", cmd)
+	}
+
+	expected := []string{"--jobs=2", "compile:all", "--trace"}
+	if !reflect.DeepEqual(resolvedArgs, expected) {
+		t.Fatalf("expected %v, got %v", expected, resolvedArgs)
+	}
+
+	for _, task := range []string{"compile; rm -rf /", "build $(id)", "-e", "native[x86 64]"} {
+		if _, err := rakeArgs(&BuildConfig{RakeTask: task}); err == nil {
+			t.Errorf("expected task %q to be rejected", task)
+		}
+	}
+
+	if _, err := rakeArgs(&BuildConfig{RakeTask: "native[x86_64-linux]"}); err != nil {
+		t.Errorf("expected task with arguments to be accepted: %v", err)
+	}
+}
+
 func TestEnsureRakeAvailableMissingRake(t *testing.T) {
 	origLookPath := execLookPath
 	origCmdCtx := execCommandContext
@@ -176,3 +265,103 @@ func TestHelperProcess(t *testing.T) {
 
 	os.Exit(0)
 }
+
+func TestRakeBuilderUsesBundler(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("shell script bundle requires a POSIX shell")
+	}
+
+	bundlePath := filepath.Join(t.TempDir(), "bundle")
+	writeTestScript(t, bundlePath, `#!/bin/sh
+echo "$* $(basename "$BUNDLE_GEMFILE")" >> bundle.log
+if [ "$1" = exec ]; then touch myext.so; fi
+`)
+
+	origLookPath := execLookPath
+	defer func() { execLookPath = origLookPath }()
+	execLookPath = func(file string) (string, error) {
+		if file == "bundle" {
+			return bundlePath, nil
+		}
+		return "", errors.New("not found")
+	}
+
+	gemDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "myext")
+	if err := os.MkdirAll(extDir, 0o755); err != nil {
+		t.Fatalf("failed to create extension dir: %v", err)
+	}
+	for _, name := range []string{"Rakefile", "Gemfile"} {
+		if err := os.WriteFile(filepath.Join(extDir, name), []byte("\n"), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	config := &BuildConfig{GemDir: gemDir, UseBundler: true, RakeTask: "compile"}
+	result, err := (&RakeBuilder{}).Build(context.Background(), config, "ext/myext/Rakefile")
+	if err != nil || !result.Success {
+		t.Fatalf("expected bundler build to succeed, got %v", err)
+	}
+
+	calls, err := os.ReadFile(filepath.Join(extDir, "bundle.log"))
+	if err != nil {
+		t.Fatalf("expected bundle to be run: %v", err)
+	}
+	expected := "install Gemfile\nexec rake compile Gemfile\n"
+	if string(calls) != expected {
+		t.Fatalf("expected bundle calls %q, got %q", expected, calls)
+	}
+
+	execLookPath = func(string) (string, error) { return "", errors.New("not found") }
+	result, err = (&RakeBuilder{}).Build(context.Background(), config, "ext/myext/Rakefile")
+	if err == nil || !reflect.DeepEqual(result.MissingDependencies, []string{"bundler"}) {
+		t.Fatalf("expected missing bundler to be reported, got %v (%v)", result.MissingDependencies, err)
+	}
+}
+
+func TestRakeBuilderFindsRakeCompilerOutputs(t *testing.T) {
+	writeFixture := func(t *testing.T, dir string, files ...string) {
+		t.Helper()
+		for _, file := range files {
+			path := filepath.Join(dir, filepath.FromSlash(file))
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte("binary"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	find := func(t *testing.T, dir string) []string {
+		t.Helper()
+		extensions, err := findExtensions(&BuildConfig{}, dir, (&RakeBuilder{}).findBuiltExtensions)
+		if err != nil {
+			t.Fatalf("findExtensions returned error: %v", err)
+		}
+		slashed := make([]string, 0, len(extensions))
+		for _, extension := range extensions {
+			slashed = append(slashed, filepath.ToSlash(extension))
+		}
+		return slashed
+	}
+
+	// rake compile copies libraries from tmp/ into lib/; lib/ wins
+	gemDir := t.TempDir()
+	writeFixture(t, gemDir,
+		"tmp/x86_64-linux/fast_json/3.4.1/fast_json.so",
+		"lib/fast_json/fast_json.so",
+		"lib/fast_json/3.3/fast_json.so",
+		"spec/fixtures/fake.so")
+	expected := []string{"lib/fast_json/fast_json.so", "lib/fast_json/3.3/fast_json.so"}
+	if extensions := find(t, gemDir); !reflect.DeepEqual(extensions, expected) {
+		t.Fatalf("expected %v, got %v", expected, extensions)
+	}
+
+	// Without copies in lib/, the libraries in tmp/ are used
+	gemDir = t.TempDir()
+	writeFixture(t, gemDir, "tmp/arm64-darwin/fast_json/3.4.1/fast_json.bundle", "tmp/scratch.so")
+	expected = []string{"tmp/arm64-darwin/fast_json/3.4.1/fast_json.bundle"}
+	if extensions := find(t, gemDir); !reflect.DeepEqual(extensions, expected) {
+		t.Fatalf("expected %v, got %v", expected, extensions)
+	}
+}
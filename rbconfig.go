@@ -0,0 +1,95 @@
+package rubyext
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rbconfigQueryTimeout bounds how long querying a Ruby's RbConfig may take
+const rbconfigQueryTimeout = 30 * time.Second
+
+// dlextCache maps Ruby executables to their RbConfig DLEXT, or to "" for
+// executables that couldn't be queried
+var dlextCache sync.Map
+
+// dlextPattern matches plausible DLEXT values, guarding against noise
+// printed by a Ruby wrapper script
+var dlextPattern = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// rubyExtensionSuffix returns the file extension, with the dot, that the
+// target Ruby loads native extensions from.
+//
+// With config.RubyPath set this is RbConfig::CONFIG["DLEXT"] of that Ruby,
+// which can differ from the platform default (e.g. embedded Rubies on
+// macOS using .so). It is queried once per executable. Without RubyPath,
+// or if the query fails, the suffix is derived from runtime.GOOS.
+func rubyExtensionSuffix(config *BuildConfig) string {
+	if config.RubyPath != "" {
+		if dlext := rubyDLExt(config.RubyPath); dlext != "" {
+			return "." + dlext
+		}
+	}
+	return platformExtensionSuffix(runtime.GOOS)
+}
+
+// platformExtensionSuffix returns the extension Ruby conventionally uses
+// for native extensions on goos
+func platformExtensionSuffix(goos string) string {
+	switch goos {
+	case platformDarwin:
+		return ".bundle"
+	case platformWindows:
+		return ".dll"
+	default:
+		return ".so"
+	}
+}
+
+// rubyDLExt returns RbConfig::CONFIG["DLEXT"] of the Ruby at rubyPath, or
+// "" if it can't be determined
+func rubyDLExt(rubyPath string) string {
+	if cached, ok := dlextCache.Load(rubyPath); ok {
+		return cached.(string)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rbconfigQueryTimeout)
+	defer cancel()
+
+	var dlext string
+	output, err := execCommandContext(ctx, rubyPath, "-e", "puts RbConfig::CONFIG['DLEXT']").Output()
+	if err == nil {
+		value := strings.TrimPrefix(strings.TrimSpace(string(output)), ".")
+		if dlextPattern.MatchString(value) {
+			dlext = value
+		}
+	}
+
+	dlextCache.Store(rubyPath, dlext)
+	return dlext
+}
+
+// findRubyDLExtLibraries returns the files in extensionDir named with the
+// target Ruby's extension when it isn't one of the usual ones, relative to
+// extensionDir
+func findRubyDLExtLibraries(config *BuildConfig, extensionDir string) []string {
+	pattern := "*" + rubyExtensionSuffix(config)
+	for _, known := range defaultExtensionPatterns {
+		if pattern == known {
+			return nil
+		}
+	}
+
+	matches, _ := filepathGlob(filepath.Join(extensionDir, pattern))
+	var extensions []string
+	for _, match := range matches {
+		if relPath, err := filepath.Rel(extensionDir, match); err == nil {
+			extensions = append(extensions, relPath)
+		}
+	}
+	return extensions
+}
@@ -0,0 +1,32 @@
+package rubyext
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestRubyExtensionSuffixUsesRbConfigDLExt(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("fake ruby requires a POSIX shell")
+	}
+
+	rubyPath := filepath.Join(t.TempDir(), "ruby")
+	writeTestScript(t, rubyPath, "#!/bin/sh\necho bundle\n")
+
+	config := &BuildConfig{RubyPath: rubyPath}
+	if got := rubyExtensionSuffix(config); got != ".bundle" {
+		t.Fatalf("expected DLEXT of the configured Ruby, got %q", got)
+	}
+
+	name := (&CargoBuilder{}).getRubyExtensionName(config, "/target/release/libmy_ext.so", "my_ext", "")
+	if name != "my_ext.bundle" {
+		t.Fatalf("expected Cargo output named with DLEXT, got %q", name)
+	}
+
+	brokenRuby := filepath.Join(t.TempDir(), "ruby")
+	writeTestScript(t, brokenRuby, "#!/bin/sh\necho 'cannot load rbconfig' >&2\nexit 1\n")
+	if got := rubyExtensionSuffix(&BuildConfig{RubyPath: brokenRuby}); got != platformExtensionSuffix(runtime.GOOS) {
+		t.Fatalf("expected platform suffix when RbConfig can't be read, got %q", got)
+	}
+}
@@ -0,0 +1,114 @@
+package rubyext
+
+import "encoding/json"
+
+// buildReport is the JSON representation of a single BuildResult.
+type buildReport struct {
+	Success             bool              `json:"success"`
+	Builder             string            `json:"builder"`
+	ExtensionFile       string            `json:"extension_file"`
+	Output              []string          `json:"output"`
+	OutputTruncated     bool              `json:"output_truncated"`
+	Warnings            []string          `json:"warnings"`
+	Extensions          []string          `json:"extensions"`
+	Checksums           map[string]string `json:"checksums"`
+	MissingDependencies []string          `json:"missing_dependencies"`
+	Commands            []CommandRecord   `json:"commands"`
+	DurationMS          int64             `json:"duration_ms"`
+	ExitCode            int               `json:"exit_code"`
+	Error               *string           `json:"error"`
+}
+
+// MarshalResults encodes the results of a build run as JSON.
+//
+// The output is a JSON array with one object per result, suitable for
+// feeding into other tools. Every object has the same set of keys:
+//
+//	[
+//	  {
+//	    "success": false,
+//	    "builder": "ExtConf",
+//	    "extension_file": "ext/myext/extconf.rb",
+//	    "output": ["checking for ruby.h... yes", "..."],
+//	    "output_truncated": false,
+//	    "warnings": ["myext.c:3:7: warning: unused variable 'x'"],
+//	    "extensions": [],
+//	    "checksums": {},
+//	    "missing_dependencies": [],
+//	    "commands": [
+//	      {"args": ["make", "-j4"], "dir": "/gems/myext/ext/myext", "env": {"DESTDIR": "/out", "PATH": "/usr/bin"}}
+//	    ],
+//	    "duration_ms": 1520,
+//	    "exit_code": 2,
+//	    "error": "Make build failed (exit 2): exit status 2"
+//	  }
+//	]
+//
+// Because BuildResult.Error is not serializable, it is emitted as its
+// Error() string, or null when the build succeeded.
+//
+// # Thread Safety
+//
+// This function is thread-safe and can be called concurrently.
+func MarshalResults(results []*BuildResult) ([]byte, error) {
+	return MarshalResultsWithOutputLimit(results, 0)
+}
+
+// MarshalResultsWithOutputLimit is like MarshalResults but keeps only the
+// last maxOutputLines lines of each result's output.
+//
+// The tail of the output is kept since that is where build failures are
+// reported. A maxOutputLines of 0 or less keeps the full output.
+func MarshalResultsWithOutputLimit(results []*BuildResult, maxOutputLines int) ([]byte, error) {
+	reports := make([]buildReport, 0, len(results))
+
+	for _, result := range results {
+		if result == nil {
+			continue
+		}
+
+		report := buildReport{
+			Success:             result.Success,
+			Builder:             result.BuilderName,
+			ExtensionFile:       result.ExtensionFile,
+			Output:              nonNilStrings(result.Output),
+			Warnings:            nonNilStrings(result.Warnings),
+			Extensions:          nonNilStrings(result.Extensions),
+			Checksums:           result.Checksums,
+			MissingDependencies: nonNilStrings(result.MissingDependencies),
+			Commands:            result.Commands,
+			DurationMS:          result.Duration.Milliseconds(),
+			ExitCode:            result.ExitCode,
+		}
+
+		if maxOutputLines > 0 && len(report.Output) > maxOutputLines {
+			report.Output = report.Output[len(report.Output)-maxOutputLines:]
+			report.OutputTruncated = true
+		}
+
+		if report.Checksums == nil {
+			report.Checksums = map[string]string{}
+		}
+		if report.Commands == nil {
+			report.Commands = []CommandRecord{}
+		}
+
+		if result.Error != nil {
+			message := result.Error.Error()
+			report.Error = &message
+		}
+
+		reports = append(reports, report)
+	}
+
+	return json.MarshalIndent(reports, "", "  ")
+}
+
+// nonNilStrings returns values, or an empty slice if values is nil,
+// so that JSON output contains [] instead of null.
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,85 @@
+package rubyext
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMarshalResults(t *testing.T) {
+	results := []*BuildResult{
+		{
+			Success:       true,
+			Output:        []string{"compiling", "linking"},
+			Extensions:    []string{"lib/myext.so"},
+			BuilderName:   "ExtConf",
+			ExtensionFile: "ext/myext/extconf.rb",
+			Duration:      1500 * time.Millisecond,
+		},
+		{
+			Success:       false,
+			Error:         errors.New("make failed"),
+			ExtensionFile: "ext/other/extconf.rb",
+		},
+	}
+
+	data, err := MarshalResults(results)
+	if err != nil {
+		t.Fatalf("MarshalResults returned error: %v", err)
+	}
+
+	var decoded []map[string]any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+
+	if len(decoded) != 2 {
+		t.Fatalf("expected 2 reports, got %d", len(decoded))
+	}
+
+	keys := []string{
+		"success", "builder", "extension_file", "output", "output_truncated", "warnings",
+		"extensions", "checksums", "missing_dependencies", "commands", "duration_ms", "exit_code", "error",
+	}
+	for i, report := range decoded {
+		for _, key := range keys {
+			if _, ok := report[key]; !ok {
+				t.Errorf("report %d missing key %q", i, key)
+			}
+		}
+	}
+
+	if decoded[0]["error"] != nil {
+		t.Errorf("expected null error for successful build, got %v", decoded[0]["error"])
+	}
+	if decoded[0]["duration_ms"] != float64(1500) {
+		t.Errorf("expected duration_ms 1500, got %v", decoded[0]["duration_ms"])
+	}
+	if decoded[1]["error"] != "make failed" {
+		t.Errorf("expected error string, got %v", decoded[1]["error"])
+	}
+}
+
+func TestMarshalResultsWithOutputLimit(t *testing.T) {
+	results := []*BuildResult{
+		{Output: []string{"one", "two", "three"}},
+	}
+
+	data, err := MarshalResultsWithOutputLimit(results, 2)
+	if err != nil {
+		t.Fatalf("MarshalResultsWithOutputLimit returned error: %v", err)
+	}
+
+	var decoded []struct {
+		Output          []string `json:"output"`
+		OutputTruncated bool     `json:"output_truncated"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+
+	if len(decoded[0].Output) != 2 || decoded[0].Output[1] != "three" || !decoded[0].OutputTruncated {
+		t.Fatalf("expected last two output lines with truncation flag, got %+v", decoded[0])
+	}
+}
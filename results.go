@@ -0,0 +1,26 @@
+package rubyext
+
+// CollectExtensions returns the extensions of all results in order,
+// without duplicates, e.g. every library installed by BuildAllExtensions.
+// Nil results are skipped.
+func CollectExtensions(results []*BuildResult) []string {
+	var extensions []string
+	for _, result := range results {
+		if result != nil {
+			extensions = append(extensions, result.Extensions...)
+		}
+	}
+	return uniqueStrings(extensions)
+}
+
+// AllSucceeded reports whether every result is successful. It is true for
+// no results, as returned for a gem without extensions, and false if any
+// result is nil.
+func AllSucceeded(results []*BuildResult) bool {
+	for _, result := range results {
+		if result == nil || !result.Success {
+			return false
+		}
+	}
+	return true
+}
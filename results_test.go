@@ -0,0 +1,38 @@
+package rubyext
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCollectExtensions(t *testing.T) {
+	results := []*BuildResult{
+		{Success: true, Extensions: []string{"lib/a/a.so", "lib/3.4/a/a.so"}},
+		nil,
+		{Success: true, Extensions: []string{"lib/b.so", "lib/a/a.so"}},
+	}
+
+	expected := []string{"lib/a/a.so", "lib/3.4/a/a.so", "lib/b.so"}
+	if got := CollectExtensions(results); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	if got := CollectExtensions(nil); len(got) != 0 {
+		t.Fatalf("expected no extensions, got %v", got)
+	}
+}
+
+func TestAllSucceeded(t *testing.T) {
+	if !AllSucceeded(nil) {
+		t.Error("expected no results to count as success")
+	}
+	if !AllSucceeded([]*BuildResult{{Success: true}, {Success: true}}) {
+		t.Error("expected successful results to count as success")
+	}
+	if AllSucceeded([]*BuildResult{{Success: true}, {Success: false}}) {
+		t.Error("expected a failed result to fail")
+	}
+	if AllSucceeded([]*BuildResult{nil}) {
+		t.Error("expected a nil result to fail")
+	}
+}
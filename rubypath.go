@@ -0,0 +1,169 @@
+package rubyext
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// rubyInstallDirs returns the directories version managers install Rubies
+// into, one subdirectory per installed version. It is a variable so tests
+// can replace it.
+var rubyInstallDirs = defaultRubyInstallDirs
+
+// defaultRubyInstallDirs returns the install directories of rbenv, asdf,
+// mise, rvm and chruby, honoring the environment variables that relocate
+// them
+func defaultRubyInstallDirs() []string {
+	home, _ := os.UserHomeDir()
+	envDir := func(name, fallback string) string {
+		if dir := os.Getenv(name); dir != "" {
+			return dir
+		}
+		return filepath.Join(home, fallback)
+	}
+
+	return []string{
+		filepath.Join(envDir("RBENV_ROOT", ".rbenv"), "versions"),
+		filepath.Join(envDir("ASDF_DATA_DIR", ".asdf"), "installs", "ruby"),
+		filepath.Join(envDir("MISE_DATA_DIR", filepath.Join(".local", "share", "mise")), "installs", "ruby"),
+		filepath.Join(envDir("rvm_path", ".rvm"), "rubies"),
+		filepath.Join(home, ".rubies"),
+		"/opt/rubies",
+	}
+}
+
+// ResolveRubyPath returns the Ruby executable matching config.RubyEngine
+// and config.RubyVersion.
+//
+// config.RubyPath is returned as is when set. Otherwise Rubies installed
+// by rbenv, asdf, mise, rvm and chruby are searched (RBENV_ROOT,
+// ASDF_DATA_DIR, MISE_DATA_DIR and rvm_path are honored), then the ruby on
+// PATH. The engine defaults to "ruby". A version matches exactly or as a
+// prefix, so "3.4" selects the newest installed 3.4.x; without a version
+// any Ruby of the engine matches.
+//
+// Returns a *MissingToolError if no matching Ruby is found.
+func ResolveRubyPath(config *BuildConfig) (string, error) {
+	if config.RubyPath != "" {
+		return config.RubyPath, nil
+	}
+
+	engine := config.RubyEngine
+	if engine == "" {
+		engine = rubyCommand
+	}
+
+	var best, bestVersion string
+	for _, dir := range rubyInstallDirs() {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			installEngine, installVersion := parseRubyInstallName(entry.Name())
+			if installEngine != engine || !rubyVersionMatches(installVersion, config.RubyVersion) {
+				continue
+			}
+
+			rubyPath := filepath.Join(dir, entry.Name(), "bin", rubyExecutableName())
+			if _, err := osStat(rubyPath); err != nil {
+				continue
+			}
+			if best == "" || compareRubyVersions(installVersion, bestVersion) > 0 {
+				best, bestVersion = rubyPath, installVersion
+			}
+		}
+	}
+	if best != "" {
+		return best, nil
+	}
+
+	if rubyPath, err := execLookPath(rubyCommand); err == nil && rubyOnPathMatches(rubyPath, engine, config.RubyVersion) {
+		return rubyPath, nil
+	}
+
+	wanted := strings.TrimSpace(engine + " " + config.RubyVersion)
+	return "", &MissingToolError{
+		Tools:   []string{rubyCommand},
+		message: fmt.Sprintf("no %s found in rbenv, asdf, mise, rvm, chruby or PATH", wanted),
+	}
+}
+
+// rubyExecutableName returns the name of the Ruby executable in an
+// install's bin directory
+func rubyExecutableName() string {
+	if runtime.GOOS == platformWindows {
+		return "ruby.exe"
+	}
+	return rubyCommand
+}
+
+// parseRubyInstallName splits a version manager directory name into engine
+// and version: "3.4.1" and "ruby-3.4.1" are CRuby, "jruby-9.4.5.0" is
+// JRuby 9.4.5.0 and "truffleruby+graalvm-24.1.0" is TruffleRuby 24.1.0
+func parseRubyInstallName(name string) (engine, version string) {
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		return rubyCommand, name
+	}
+
+	engine, version, _ = strings.Cut(name, "-")
+	engine, _, _ = strings.Cut(engine, "+")
+	return engine, version
+}
+
+// rubyVersionMatches reports whether an installed version satisfies the
+// wanted one: an empty wanted version, an exact match, or a prefix ending
+// at a dot ("3.4" matches "3.4.1" but not "3.40.0")
+func rubyVersionMatches(installed, wanted string) bool {
+	return wanted == "" || installed == wanted || strings.HasPrefix(installed, wanted+".")
+}
+
+// compareRubyVersions compares dotted versions numerically, returning a
+// negative number, zero or a positive number as a is older, equal to or
+// newer than b
+func compareRubyVersions(a, b string) int {
+	aParts, bParts := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		aNum, aErr := strconv.Atoi(aParts[i])
+		bNum, bErr := strconv.Atoi(bParts[i])
+		if aErr != nil || bErr != nil {
+			if c := strings.Compare(aParts[i], bParts[i]); c != 0 {
+				return c
+			}
+			continue
+		}
+		if aNum != bNum {
+			return aNum - bNum
+		}
+	}
+	return len(aParts) - len(bParts)
+}
+
+// rubyOnPathMatches asks the Ruby at rubyPath for its engine and versions
+// and reports whether they match. The wanted version may be the language
+// version (RUBY_VERSION) or the engine's own (RUBY_ENGINE_VERSION).
+func rubyOnPathMatches(rubyPath, engine, version string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), rbconfigQueryTimeout)
+	defer cancel()
+
+	output, err := execCommandContext(ctx, rubyPath, "-e",
+		`puts [RUBY_ENGINE, RUBY_VERSION, RUBY_ENGINE_VERSION].join(" ")`).Output()
+	if err != nil {
+		return false
+	}
+
+	fields := strings.Fields(string(output))
+	if len(fields) != 3 || fields[0] != engine {
+		return false
+	}
+	return slices.ContainsFunc(fields[1:], func(installed string) bool {
+		return rubyVersionMatches(installed, version)
+	})
+}
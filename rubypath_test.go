@@ -0,0 +1,83 @@
+package rubyext
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestResolveRubyPath(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("fake rubies require a POSIX shell")
+	}
+
+	rbenvDir := t.TempDir()
+	chrubyDir := t.TempDir()
+	origDirs := rubyInstallDirs
+	defer func() { rubyInstallDirs = origDirs }()
+	rubyInstallDirs = func() []string { return []string{rbenvDir, filepath.Join(t.TempDir(), "missing"), chrubyDir} }
+
+	installRuby := func(dir, name string) string {
+		rubyPath := filepath.Join(dir, name, "bin", "ruby")
+		if err := os.MkdirAll(filepath.Dir(rubyPath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestScript(t, rubyPath, "#!/bin/sh\nexit 0\n")
+		return rubyPath
+	}
+	installRuby(rbenvDir, "3.3.6")
+	installRuby(rbenvDir, "3.4.1")
+	ruby342 := installRuby(chrubyDir, "ruby-3.4.2")
+	jruby := installRuby(chrubyDir, "jruby-9.4.5.0")
+	installRuby(chrubyDir, "ruby-3.40.0")
+
+	pathDir := t.TempDir()
+	pathRuby := filepath.Join(pathDir, "ruby")
+	writeTestScript(t, pathRuby, "#!/bin/sh\necho 'ruby 3.2.5 3.2.5'\n")
+	t.Setenv("PATH", pathDir)
+
+	testCases := []struct {
+		config   BuildConfig
+		expected string
+	}{
+		{BuildConfig{RubyVersion: "3.4"}, ruby342},
+		{BuildConfig{RubyVersion: "3.4.2", RubyEngine: "ruby"}, ruby342},
+		{BuildConfig{RubyEngine: "jruby"}, jruby},
+		{BuildConfig{RubyVersion: "3.2"}, pathRuby},
+		{BuildConfig{RubyPath: "/usr/local/bin/ruby", RubyVersion: "3.4"}, "/usr/local/bin/ruby"},
+	}
+	for _, tc := range testCases {
+		rubyPath, err := ResolveRubyPath(&tc.config)
+		if err != nil || rubyPath != tc.expected {
+			t.Errorf("%s %s: expected %s, got %s (%v)", tc.config.RubyEngine, tc.config.RubyVersion, tc.expected, rubyPath, err)
+		}
+	}
+
+	if _, err := ResolveRubyPath(&BuildConfig{RubyEngine: "truffleruby", RubyVersion: "24.1"}); !errors.Is(err, ErrMissingTool) {
+		t.Fatalf("expected ErrMissingTool for an uninstalled Ruby, got %v", err)
+	}
+
+	// BuildAllExtensions builds with the resolved Ruby
+	var builtWith string
+	builder := &mockBuilder{
+		name:       "Mock",
+		canBuildFn: func(string) bool { return true },
+		buildFn: func(_ context.Context, config *BuildConfig, _ string) (*BuildResult, error) {
+			builtWith = config.RubyPath
+			return &BuildResult{Success: true}, nil
+		},
+	}
+	factory := &BuilderFactory{}
+	factory.Register(builder)
+
+	config := &BuildConfig{GemDir: t.TempDir(), RubyVersion: "3.4"}
+	if _, err := factory.BuildAllExtensions(context.Background(), config, []string{"ext/myext/extconf.rb"}); err != nil {
+		t.Fatalf("BuildAllExtensions returned error: %v", err)
+	}
+	if builtWith != ruby342 || config.RubyPath != "" {
+		t.Fatalf("expected build with %s and the caller's config untouched, got %q / %q", ruby342, builtWith, config.RubyPath)
+	}
+}
@@ -0,0 +1,118 @@
+package rubyext
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rustToolchainFiles are the files rustup reads a toolchain pin from, in
+// the order they are checked in each directory
+var rustToolchainFiles = []string{"rust-toolchain.toml", "rust-toolchain"}
+
+// rustToolchainPin is a toolchain channel pinned by a crate
+type rustToolchainPin struct {
+	channel string // Toolchain channel, e.g. "1.75.0" or "nightly-2024-01-01"
+	file    string // rust-toolchain.toml or rust-toolchain file that pins it
+}
+
+// findRustToolchainPin looks for a rust-toolchain.toml or rust-toolchain
+// file in the extension directory and its parents up to config.GemDir, as
+// rustup does. Pins without a channel (such as a custom toolchain path)
+// are ignored.
+func findRustToolchainPin(config *BuildConfig, extensionDir string) (rustToolchainPin, bool) {
+	gemDir := filepath.Clean(config.GemDir)
+	dir := filepath.Clean(extensionDir)
+
+	for {
+		for _, name := range rustToolchainFiles {
+			file := filepath.Join(dir, name)
+			if channel := rustToolchainChannel(file); channel != "" {
+				return rustToolchainPin{channel: channel, file: file}, true
+			}
+		}
+
+		// Stop at the gem directory, or right away for a working copy
+		// outside it (config.BuildDir)
+		rel, err := filepath.Rel(gemDir, dir)
+		if config.GemDir == "" || err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return rustToolchainPin{}, false
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// rustToolchainChannel returns the channel pinned by a toolchain file:
+// [toolchain] channel for the TOML format, or the first line of a legacy
+// rust-toolchain file. It returns "" if the file is missing or pins none.
+func rustToolchainChannel(file string) string {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+
+	if strings.HasSuffix(file, ".toml") || strings.Contains(string(content), "[toolchain]") {
+		return cargoManifestValue(file, "toolchain", "channel")
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(content)))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// cargoCommand returns the program and leading arguments used to run
+// cargo for the extension. When the crate pins a toolchain and rustup is
+// available, cargo runs through "rustup run <channel> cargo" so the pin is
+// honored even if CARGO points at another installation.
+func (b *CargoBuilder) cargoCommand(config *BuildConfig, extensionDir string) (string, []string) {
+	if pin, ok := findRustToolchainPin(config, extensionDir); ok {
+		if rustupPath, err := execLookPath("rustup"); err == nil {
+			return rustupPath, []string{"run", pin.channel, "cargo"}
+		}
+	}
+	return b.getCargoPath(), nil
+}
+
+// checkRustToolchain logs the toolchain pinned by the crate, if any, and
+// verifies rustup has it installed. A missing toolchain is listed in
+// result.MissingDependencies and returned as a *MissingToolError.
+func (b *CargoBuilder) checkRustToolchain(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
+	pin, ok := findRustToolchainPin(config, extensionDir)
+	if !ok {
+		return nil
+	}
+
+	rustupPath, err := execLookPath("rustup")
+	if err != nil {
+		result.Output = append(result.Output, fmt.Sprintf(
+			"Note: %s pins Rust toolchain %s, but rustup was not found; building with the default cargo", pin.file, pin.channel))
+		return nil
+	}
+
+	result.Output = append(result.Output, fmt.Sprintf("Using Rust toolchain %s pinned by %s", pin.channel, pin.file))
+
+	output, err := execCommandContext(ctx, rustupPath, "toolchain", "list").Output()
+	if err != nil {
+		return BuildError("Cargo", result.Output, fmt.Errorf("failed to list rustup toolchains: %w", err))
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && (fields[0] == pin.channel || strings.HasPrefix(fields[0], pin.channel+"-")) {
+			return nil
+		}
+	}
+
+	missing := "rust toolchain " + pin.channel
+	message := fmt.Sprintf("Rust toolchain %s pinned by %s is not installed: run rustup toolchain install %s",
+		pin.channel, pin.file, pin.channel)
+	result.MissingDependencies = append(result.MissingDependencies, missing)
+	return &MissingToolError{Tools: []string{missing}, message: message}
+}
@@ -0,0 +1,96 @@
+package rubyext
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestFindRustToolchainPin(t *testing.T) {
+	root := t.TempDir()
+	gemDir := filepath.Join(root, "gem")
+	extDir := filepath.Join(gemDir, "ext", "myext")
+	writeCargoManifest(t, extDir, "[package]\nname = \"my-ext\"\n")
+
+	// A pin above the gem directory is not the gem's
+	if err := os.WriteFile(filepath.Join(root, "rust-toolchain"), []byte("nightly\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config := &BuildConfig{GemDir: gemDir}
+	if pin, ok := findRustToolchainPin(config, extDir); ok {
+		t.Fatalf("expected no pin inside the gem, got %+v", pin)
+	}
+
+	toolchainFile := filepath.Join(gemDir, "rust-toolchain.toml")
+	content := "[toolchain]\nchannel = \"1.75.0\"\ncomponents = [\"clippy\"]\n"
+	if err := os.WriteFile(toolchainFile, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pin, ok := findRustToolchainPin(config, extDir)
+	if !ok || pin.channel != "1.75.0" || pin.file != toolchainFile {
+		t.Fatalf("expected workspace pin 1.75.0, got %+v", pin)
+	}
+
+	legacyFile := filepath.Join(extDir, "rust-toolchain")
+	if err := os.WriteFile(legacyFile, []byte("\nnightly-2024-01-01\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if pin, _ := findRustToolchainPin(config, extDir); pin.channel != "nightly-2024-01-01" || pin.file != legacyFile {
+		t.Fatalf("expected the nearest legacy pin, got %+v", pin)
+	}
+}
+
+func TestCargoBuilderChecksPinnedToolchain(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("fake rustup requires a POSIX shell")
+	}
+
+	toolDir := t.TempDir()
+	rustupPath := filepath.Join(toolDir, "rustup")
+	writeTestScript(t, rustupPath, "#!/bin/sh\necho 'stable-x86_64-unknown-linux-gnu (default)'\necho '1.75.0-x86_64-unknown-linux-gnu'\n")
+	t.Setenv("PATH", toolDir)
+	t.Setenv("CARGO", "/opt/other/cargo")
+
+	gemDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "myext")
+	writeCargoManifest(t, extDir, "[package]\nname = \"my-ext\"\n")
+	if err := os.WriteFile(filepath.Join(extDir, "rust-toolchain"), []byte("1.75.0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	builder := &CargoBuilder{}
+	config := &BuildConfig{GemDir: gemDir}
+	result := &BuildResult{}
+	if err := builder.checkRustToolchain(context.Background(), config, extDir, result); err != nil {
+		t.Fatalf("expected installed toolchain to pass, got %v", err)
+	}
+	if len(result.Output) != 1 || result.Output[0] != "Using Rust toolchain 1.75.0 pinned by "+filepath.Join(extDir, "rust-toolchain") {
+		t.Fatalf("expected the pinned toolchain to be logged, got %v", result.Output)
+	}
+
+	name, prefix := builder.cargoCommand(config, extDir)
+	if name != rustupPath || !reflect.DeepEqual(prefix, []string{"run", "1.75.0", "cargo"}) {
+		t.Fatalf("expected cargo through rustup run, got %s %v", name, prefix)
+	}
+
+	if err := os.WriteFile(filepath.Join(extDir, "rust-toolchain"), []byte("1.80.0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	result = &BuildResult{}
+	err := builder.checkRustToolchain(context.Background(), config, extDir, result)
+	if !errors.Is(err, ErrMissingTool) || !reflect.DeepEqual(result.MissingDependencies, []string{"rust toolchain 1.80.0"}) {
+		t.Fatalf("expected missing toolchain to be reported, got %v, %v", err, result.MissingDependencies)
+	}
+
+	// Without a pin the configured cargo is used
+	if err := os.Remove(filepath.Join(extDir, "rust-toolchain")); err != nil {
+		t.Fatal(err)
+	}
+	if name, prefix := builder.cargoCommand(config, extDir); name != "/opt/other/cargo" || prefix != nil {
+		t.Fatalf("expected CARGO without a pin, got %s %v", name, prefix)
+	}
+}
@@ -0,0 +1,66 @@
+package rubyext
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// rustSanitizers are the sanitizers rustc accepts with -Z sanitizer
+var rustSanitizers = []string{
+	"address", "cfi", "hwaddress", "kcfi", "leak", "memory", "memtag", "safestack", "shadow-call-stack", "thread",
+}
+
+// sanitizerFlag returns -fsanitize= with config.Sanitizers, or "" if none
+// are requested. Compilers need it when linking as well as compiling, so
+// the sanitizer runtime is linked in.
+func sanitizerFlag(config *BuildConfig) string {
+	if len(config.Sanitizers) == 0 {
+		return ""
+	}
+	return "-fsanitize=" + strings.Join(config.Sanitizers, ",")
+}
+
+// withSanitizerFlag returns the value of the environment variable name
+// (CFLAGS, LDFLAGS, ...) with sanitizerFlag appended, and whether there
+// is a sanitizer flag to add
+func withSanitizerFlag(config *BuildConfig, name string) (string, bool) {
+	flag := sanitizerFlag(config)
+	if flag == "" {
+		return "", false
+	}
+	return strings.Join(append(strings.Fields(envValue(config, name)), flag), " "), true
+}
+
+// rustSanitizerFlags returns -Z sanitizer=<name> rustc flags for the
+// sanitizers in config.Sanitizers that rustc supports
+func rustSanitizerFlags(config *BuildConfig) []string {
+	var flags []string
+	for _, sanitizer := range config.Sanitizers {
+		if slices.Contains(rustSanitizers, sanitizer) {
+			flags = append(flags, "-Z", "sanitizer="+sanitizer)
+		}
+	}
+	return flags
+}
+
+// appendRustSanitizerNote records that Rust sanitizers need a nightly
+// toolchain, and which requested sanitizers rustc doesn't support and
+// were left out
+func appendRustSanitizerNote(config *BuildConfig, result *BuildResult) {
+	if len(config.Sanitizers) == 0 {
+		return
+	}
+
+	note := "Note: Rust sanitizers (-Z sanitizer) require a nightly toolchain, e.g. pinned with rust-toolchain.toml"
+	var unsupported []string
+	for _, sanitizer := range config.Sanitizers {
+		if !slices.Contains(rustSanitizers, sanitizer) {
+			unsupported = append(unsupported, sanitizer)
+		}
+	}
+	if len(unsupported) > 0 {
+		note += fmt.Sprintf("; not supported by rustc and skipped: %s", strings.Join(unsupported, ", "))
+	}
+	result.Output = append(result.Output, note)
+}
@@ -0,0 +1,55 @@
+package rubyext
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSanitizerFlags(t *testing.T) {
+	t.Setenv("CFLAGS", "")
+	t.Setenv("CXXFLAGS", "")
+	t.Setenv("RUSTFLAGS", "")
+	config := &BuildConfig{
+		Sanitizers: []string{"address", "undefined"},
+		Env:        map[string]string{"LDFLAGS": "-L/opt/lib"},
+	}
+
+	env := (&ExtConfBuilder{}).compilerFlagsEnv(config)
+	expected := []string{
+		"CFLAGS=-fsanitize=address,undefined",
+		"CXXFLAGS=-fsanitize=address,undefined",
+		"LDFLAGS=-L/opt/lib -fsanitize=address,undefined",
+	}
+	if !reflect.DeepEqual(env, expected) {
+		t.Fatalf("expected %v, got %v", expected, env)
+	}
+
+	args := (&CmakeBuilder{}).configureArgs(config)
+	for _, define := range []string{
+		"-DCMAKE_C_FLAGS=-fsanitize=address,undefined",
+		"-DCMAKE_CXX_FLAGS=-fsanitize=address,undefined",
+		"-DCMAKE_SHARED_LINKER_FLAGS=-L/opt/lib -fsanitize=address,undefined",
+		"-DCMAKE_MODULE_LINKER_FLAGS=-L/opt/lib -fsanitize=address,undefined",
+	} {
+		if !slices.Contains(args, define) {
+			t.Fatalf("expected %s among the cmake arguments, got %v", define, args)
+		}
+	}
+
+	rustEnv := (&CargoBuilder{}).getRubyEnv(config)
+	if !slices.Contains(rustEnv, "RUSTFLAGS=--cfg=rb_sys_gem --cfg=rubygems -Z sanitizer=address") {
+		t.Fatalf("expected only the rustc-supported sanitizer in RUSTFLAGS, got %v", rustEnv)
+	}
+
+	result := &BuildResult{}
+	appendRustSanitizerNote(config, result)
+	if len(result.Output) != 1 || !strings.Contains(result.Output[0], "nightly") || !strings.HasSuffix(result.Output[0], "skipped: undefined") {
+		t.Fatalf("expected a nightly note naming the skipped sanitizer, got %v", result.Output)
+	}
+
+	if env := (&ExtConfBuilder{}).compilerFlagsEnv(&BuildConfig{}); env != nil {
+		t.Fatalf("expected no flags without sanitizers, got %v", env)
+	}
+}
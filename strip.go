@@ -0,0 +1,63 @@
+package rubyext
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+)
+
+// stripExtensions removes debug symbols from built native libraries when
+// config.Strip is set.
+//
+// Only native libraries (.so, .bundle, .dll, .dylib) are stripped; other
+// build outputs such as .jar files are left alone. Stripping happens
+// before the libraries are installed, so every installed copy is
+// stripped. If strip isn't available, a note is added to the output and
+// the libraries are installed as built.
+func stripExtensions(ctx context.Context, config *BuildConfig, result *BuildResult, extensionDir string, extensions []string) error {
+	if !config.Strip {
+		return nil
+	}
+
+	stripPath, err := execLookPath("strip")
+	if err != nil {
+		result.Output = append(result.Output, "Note: strip not found, extensions keep their debug symbols")
+		return nil
+	}
+
+	for _, extension := range extensions {
+		if !isNativeLibrary(extension) {
+			continue
+		}
+
+		path := extension
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(extensionDir, path)
+		}
+
+		cmd := execCommandContext(ctx, stripPath, stripArgs(runtime.GOOS, path)...)
+		cmd.Dir = extensionDir
+
+		output, err := cmd.CombinedOutput()
+		appendCommandOutput(config, result, output)
+
+		appendCommandLog(config, result, cmd, err)
+
+		if err != nil {
+			return BuildError("Strip", result.Output, fmt.Errorf("failed to strip %s: %w", extension, err))
+		}
+	}
+
+	return nil
+}
+
+// stripArgs returns the strip arguments for a library. On macOS only
+// local symbols are removed (-x): a full strip would also remove the
+// global symbols Ruby looks up when loading a bundle.
+func stripArgs(goos, path string) []string {
+	if goos == platformDarwin {
+		return []string{"-x", path}
+	}
+	return []string{path}
+}
@@ -0,0 +1,71 @@
+package rubyext
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestStripExtensionsOnlyStripsNativeLibraries(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("shell script strip requires a POSIX shell")
+	}
+
+	toolDir := t.TempDir()
+	stripPath := filepath.Join(toolDir, "strip")
+	writeTestScript(t, stripPath, "#!/bin/sh\nfor last; do :; done\nbasename \"$last\" >> stripped.txt\n")
+
+	origLookPath := execLookPath
+	defer func() { execLookPath = origLookPath }()
+	execLookPath = func(name string) (string, error) {
+		if name == "strip" {
+			return stripPath, nil
+		}
+		return "", exec.ErrNotFound
+	}
+
+	extDir := t.TempDir()
+	config := &BuildConfig{Strip: true}
+	result := &BuildResult{}
+
+	err := stripExtensions(context.Background(), config, result, extDir, []string{"myext.so", "myext.jar", "classes/Foo.class"})
+	if err != nil {
+		t.Fatalf("stripExtensions returned error: %v", err)
+	}
+
+	stripped, err := os.ReadFile(filepath.Join(extDir, "stripped.txt"))
+	if err != nil {
+		t.Fatalf("expected strip to run: %v", err)
+	}
+	if strings.TrimSpace(string(stripped)) != "myext.so" {
+		t.Fatalf("expected only myext.so stripped, got %q", stripped)
+	}
+}
+
+func TestStripExtensionsSkipsWhenStripMissing(t *testing.T) {
+	origLookPath := execLookPath
+	defer func() { execLookPath = origLookPath }()
+	execLookPath = func(string) (string, error) { return "", exec.ErrNotFound }
+
+	result := &BuildResult{}
+	if err := stripExtensions(context.Background(), &BuildConfig{Strip: true}, result, t.TempDir(), []string{"myext.so"}); err != nil {
+		t.Fatalf("expected missing strip to be skipped, got %v", err)
+	}
+	if len(result.Output) != 1 || !strings.Contains(result.Output[0], "strip not found") {
+		t.Fatalf("expected note about missing strip, got %v", result.Output)
+	}
+}
+
+func TestStripArgs(t *testing.T) {
+	if args := stripArgs(platformDarwin, "myext.bundle"); !reflect.DeepEqual(args, []string{"-x", "myext.bundle"}) {
+		t.Errorf("expected -x on macOS, got %v", args)
+	}
+	if args := stripArgs("linux", "myext.so"); !reflect.DeepEqual(args, []string{"myext.so"}) {
+		t.Errorf("expected plain strip on Linux, got %v", args)
+	}
+}
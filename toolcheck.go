@@ -2,7 +2,7 @@ package rubyext
 
 import (
 	"fmt"
-	"os/exec"
+	"path/filepath"
 	"strings"
 )
 
@@ -138,9 +138,9 @@ type ToolRequirement struct {
 //
 // This function is thread-safe and can be called concurrently.
 func CheckToolAvailable(tool string) error {
-	_, err := exec.LookPath(tool)
+	_, err := execLookPath(tool)
 	if err != nil {
-		return fmt.Errorf("%s not found in PATH", tool)
+		return &MissingToolError{Tools: []string{tool}, message: fmt.Sprintf("%s not found in PATH", tool)}
 	}
 	return nil
 }
@@ -190,24 +190,48 @@ func CheckToolAvailable(tool string) error {
 //
 // This function is thread-safe and can be called concurrently.
 func CheckRequiredTools(requirements []ToolRequirement) error {
-	var missingTools []string
+	_, err := ResolveTools(requirements)
+	return err
+}
+
+// ResolveTools locates the binaries satisfying each ToolRequirement.
+//
+// Tools are searched like CheckRequiredTools does: the primary name first,
+// then each alternative in order. The returned map is keyed by requirement
+// Name and holds the absolute path of the binary that was found, so callers
+// can record e.g. that clang satisfied the gcc requirement. Optional tools
+// that are missing are left out of the map.
+//
+// On error, the map still holds every tool that was found and the error
+// matches CheckRequiredTools.
+//
+// # Example
+//
+//	tools, err := ResolveTools(builder.RequiredTools())
+//	if err != nil {
+//	    return err
+//	}
+//	log.Printf("using %s for gcc requirement", tools["gcc"])
+//
+// # Thread Safety
+//
+// This function is thread-safe and can be called concurrently.
+func ResolveTools(requirements []ToolRequirement) (map[string]string, error) {
+	resolved := make(map[string]string)
+	var missingTools, missingNames []string
 
 	for _, req := range requirements {
-		// Try the primary tool
-		found := CheckToolAvailable(req.Name) == nil
-
-		// If not found, try alternatives
-		if !found && len(req.Alternatives) > 0 {
-			for _, alt := range req.Alternatives {
-				if CheckToolAvailable(alt) == nil {
-					found = true
-					break
-				}
+		// Try the primary tool, then alternatives
+		for _, tool := range append([]string{req.Name}, req.Alternatives...) {
+			if path, err := resolveTool(tool); err == nil {
+				resolved[req.Name] = path
+				break
 			}
 		}
 
-		// If still not found and not optional, record it
-		if !found && !req.Optional {
+		// If not found and not optional, record it
+		if _, found := resolved[req.Name]; !found && !req.Optional {
+			missingNames = append(missingNames, req.Name)
 			if req.Purpose != "" {
 				missingTools = append(missingTools, fmt.Sprintf("%s (%s)", req.Name, req.Purpose))
 			} else {
@@ -217,12 +241,22 @@ func CheckRequiredTools(requirements []ToolRequirement) error {
 	}
 
 	if len(missingTools) == 0 {
-		return nil
+		return resolved, nil
 	}
 
+	message := fmt.Sprintf("missing required tools: %s", strings.Join(missingTools, ", "))
 	if len(missingTools) == 1 {
-		return fmt.Errorf("%s not found in PATH", missingTools[0])
+		message = fmt.Sprintf("%s not found in PATH", missingTools[0])
 	}
 
-	return fmt.Errorf("missing required tools: %s", strings.Join(missingTools, ", "))
+	return resolved, &MissingToolError{Tools: missingNames, message: message}
+}
+
+// resolveTool returns the absolute path of a tool found in PATH
+func resolveTool(tool string) (string, error) {
+	path, err := execLookPath(tool)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(path)
 }
@@ -0,0 +1,47 @@
+package rubyext
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestResolveToolsReportsAlternative(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("shell script tools require a POSIX shell")
+	}
+
+	toolDir := t.TempDir()
+	writeTestScript(t, filepath.Join(toolDir, "clang"), "#!/bin/sh\n")
+	writeTestScript(t, filepath.Join(toolDir, "make"), "#!/bin/sh\n")
+	t.Setenv("PATH", toolDir)
+
+	requirements := []ToolRequirement{
+		{Name: "gcc", Alternatives: []string{"clang", "cc"}},
+		{Name: "make"},
+		{Name: "ninja", Optional: true},
+	}
+
+	tools, err := ResolveTools(requirements)
+	if err != nil {
+		t.Fatalf("ResolveTools returned error: %v", err)
+	}
+
+	if tools["gcc"] != filepath.Join(toolDir, "clang") {
+		t.Errorf("expected clang to satisfy gcc, got %q", tools["gcc"])
+	}
+	if tools["make"] != filepath.Join(toolDir, "make") {
+		t.Errorf("expected make resolved, got %q", tools["make"])
+	}
+	if _, ok := tools["ninja"]; ok {
+		t.Errorf("expected missing optional tool to be omitted, got %q", tools["ninja"])
+	}
+
+	requirements = append(requirements, ToolRequirement{Name: "cmake", Purpose: "CMake build system"})
+	if _, err := ResolveTools(requirements); err == nil || err.Error() != "cmake (CMake build system) not found in PATH" {
+		t.Fatalf("expected missing cmake error, got %v", err)
+	}
+	if err := CheckRequiredTools(requirements); err == nil {
+		t.Fatal("expected CheckRequiredTools to fail for missing cmake")
+	}
+}
@@ -0,0 +1,103 @@
+package rubyext
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ToolVersioner is an optional interface for builders that can report the
+// versions of the tools they build with, for build provenance.
+//
+// When config.CaptureToolVersions is set, BuilderFactory.BuildWith records
+// the versions in BuildResult.ToolVersions.
+//
+// ExtConfBuilder, CmakeBuilder, CargoBuilder and GoBuilder implement
+// ToolVersioner.
+type ToolVersioner interface {
+	// ToolVersions returns the version of each tool, keyed by tool name
+	// (e.g. "cargo": "1.82.0"). Tools whose version can't be determined
+	// are left out and reported in the error.
+	ToolVersions(ctx context.Context) (map[string]string, error)
+}
+
+// toolVersionPattern matches the first dotted version number in a tool's
+// version output, e.g. 3.4.1 in "ruby 3.4.1 (2024-12-25 revision ...)"
+// or 1.23.2 in "go version go1.23.2 linux/amd64"
+var toolVersionPattern = regexp.MustCompile(`\d+(?:\.\d+)+`)
+
+// toolVersions runs each tool with its version arguments and parses the
+// version from the first line of output. The tools that succeeded are
+// returned along with an error describing those that failed.
+func toolVersions(ctx context.Context, commands map[string][]string) (map[string]string, error) {
+	versions := make(map[string]string, len(commands))
+	var errs []error
+
+	for tool, command := range commands {
+		output, err := execCommandContext(ctx, command[0], command[1:]...).Output()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", tool, err))
+			continue
+		}
+
+		firstLine, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+		version := toolVersionPattern.FindString(firstLine)
+		if version == "" {
+			errs = append(errs, fmt.Errorf("%s: no version in %q", tool, firstLine))
+			continue
+		}
+		versions[tool] = version
+	}
+
+	return versions, errors.Join(errs...)
+}
+
+// captureToolVersions records the builder's tool versions on the result
+// when config.CaptureToolVersions is set. Versions that can't be
+// determined are noted in the output.
+func captureToolVersions(ctx context.Context, config *BuildConfig, builder Builder, result *BuildResult) {
+	versioner, ok := builder.(ToolVersioner)
+	if !config.CaptureToolVersions || !ok {
+		return
+	}
+
+	versions, err := versioner.ToolVersions(ctx)
+	result.ToolVersions = versions
+	if err != nil {
+		result.Output = append(result.Output, fmt.Sprintf("Note: could not determine tool versions: %v", err))
+	}
+}
+
+// ToolVersions returns the versions of ruby and the C compiler ($CC or cc)
+func (b *ExtConfBuilder) ToolVersions(ctx context.Context) (map[string]string, error) {
+	compiler := os.Getenv("CC")
+	if compiler == "" {
+		compiler = "cc"
+	}
+
+	return toolVersions(ctx, map[string][]string{
+		rubyCommand: {rubyCommand, "-v"},
+		"cc":        {compiler, "--version"},
+	})
+}
+
+// ToolVersions returns the version of cmake
+func (b *CmakeBuilder) ToolVersions(ctx context.Context) (map[string]string, error) {
+	return toolVersions(ctx, map[string][]string{"cmake": {"cmake", "--version"}})
+}
+
+// ToolVersions returns the versions of cargo and rustc
+func (b *CargoBuilder) ToolVersions(ctx context.Context) (map[string]string, error) {
+	return toolVersions(ctx, map[string][]string{
+		"cargo": {"cargo", "--version"},
+		"rustc": {"rustc", "--version"},
+	})
+}
+
+// ToolVersions returns the version of go
+func (b *GoBuilder) ToolVersions(ctx context.Context) (map[string]string, error) {
+	return toolVersions(ctx, map[string][]string{"go": {"go", "version"}})
+}
@@ -0,0 +1,92 @@
+package rubyext
+
+import (
+	"context"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestToolVersions(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("fake tools use printf")
+	}
+
+	outputs := map[string]string{
+		"ruby":  "ruby 3.4.1 (2024-12-25 revision 48d4efcb85) +PRISM [x86_64-linux]",
+		"cc":    "cc (Ubuntu 13.2.0-23ubuntu4) 13.2.0\nCopyright (C) 2023 Free Software Foundation, Inc.",
+		"cargo": "cargo 1.82.0 (8f40fc59f 2024-08-21)",
+		"rustc": "rustc 1.82.0 (f6e511eec 2024-10-15)",
+		"cmake": "cmake version 3.30.2\n\nCMake suite maintained and supported by Kitware (kitware.com/cmake).",
+		"go":    "go version go1.23.2 linux/amd64",
+	}
+
+	origCmdCtx := execCommandContext
+	defer func() { execCommandContext = origCmdCtx }()
+	execCommandContext = func(ctx context.Context, name string, _ ...string) *exec.Cmd {
+		output, ok := outputs[name]
+		if !ok {
+			return exec.CommandContext(ctx, "false")
+		}
+		return exec.CommandContext(ctx, "printf", "%s\n", output)
+	}
+	t.Setenv("CC", "")
+
+	testCases := []struct {
+		builder  ToolVersioner
+		expected map[string]string
+	}{
+		{&ExtConfBuilder{}, map[string]string{"ruby": "3.4.1", "cc": "13.2.0"}},
+		{&CargoBuilder{}, map[string]string{"cargo": "1.82.0", "rustc": "1.82.0"}},
+		{&CmakeBuilder{}, map[string]string{"cmake": "3.30.2"}},
+		{&GoBuilder{}, map[string]string{"go": "1.23.2"}},
+	}
+
+	for _, tc := range testCases {
+		versions, err := tc.builder.ToolVersions(context.Background())
+		if err != nil {
+			t.Fatalf("%T: unexpected error: %v", tc.builder, err)
+		}
+		if !reflect.DeepEqual(versions, tc.expected) {
+			t.Errorf("%T: expected %v, got %v", tc.builder, tc.expected, versions)
+		}
+	}
+
+	delete(outputs, "rustc")
+	versions, err := (&CargoBuilder{}).ToolVersions(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "rustc") {
+		t.Fatalf("expected error naming rustc, got %v", err)
+	}
+	if !reflect.DeepEqual(versions, map[string]string{"cargo": "1.82.0"}) {
+		t.Fatalf("expected remaining versions to be returned, got %v", versions)
+	}
+}
+
+// versionedMockBuilder is a mockBuilder that reports tool versions
+type versionedMockBuilder struct {
+	mockBuilder
+}
+
+func (m *versionedMockBuilder) ToolVersions(context.Context) (map[string]string, error) {
+	return map[string]string{"mock": "1.2.3"}, nil
+}
+
+func TestBuildWithCapturesToolVersions(t *testing.T) {
+	factory := &BuilderFactory{}
+	builder := &versionedMockBuilder{mockBuilder{name: "mock"}}
+
+	result, err := factory.BuildWith(context.Background(), &BuildConfig{}, builder, "ext/mock/build")
+	if err != nil || result.ToolVersions != nil {
+		t.Fatalf("expected no versions without CaptureToolVersions, got %v (%v)", result.ToolVersions, err)
+	}
+
+	result, err = factory.BuildWith(context.Background(), &BuildConfig{CaptureToolVersions: true}, builder, "ext/mock/build")
+	if err != nil {
+		t.Fatalf("BuildWith returned error: %v", err)
+	}
+	if !reflect.DeepEqual(result.ToolVersions, map[string]string{"mock": "1.2.3"}) {
+		t.Fatalf("expected captured versions, got %v", result.ToolVersions)
+	}
+}
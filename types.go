@@ -1,6 +1,11 @@
 package rubyext
 
-import "context"
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
 
 // BuildResult contains the output and status of a build operation.
 //
@@ -8,13 +13,28 @@ import "context"
 //   - Success status indicating if the build completed without errors
 //   - Output lines captured from the build process (stdout/stderr)
 //   - Extensions list of compiled extension files (.so/.bundle/.dll)
-//   - Error information if the build failed
+//   - Error information and the failed command's exit code if the build failed
+//
+// BuilderName, ExtensionFile, Duration and ToolVersions are filled in by
+// BuilderFactory.BuildAllExtensions.
 type BuildResult struct {
-	Success             bool     // True if build completed successfully
-	Output              []string // Lines of output from the build process
-	Extensions          []string // Paths to built extension files
-	Error               error    // Error if build failed, nil otherwise
-	MissingDependencies []string // Names of build-time dependencies that were missing
+	Success             bool              // True if build completed successfully
+	Output              []string          // Lines of output from the build process
+	Warnings            []string          // Compiler and build system warnings found in Output (when config.CollectWarnings is set)
+	Extensions          []string          // Paths to built extension files, after installation into the lib directory
+	BuiltArtifacts      []string          // Paths to extension files where the build produced them, before installation
+	Checksums           map[string]string // SHA-256 of each extension, keyed by path (when config.Checksum is set)
+	Error               error             // Error if build failed, nil otherwise
+	ExitCode            int               // Exit code of the failed command (0 if none)
+	MissingDependencies []string          // Names of build-time dependencies that were missing
+	ToolVersions        map[string]string // Versions of the tools used, keyed by tool (when config.CaptureToolVersions is set)
+	Commands            []CommandRecord   // Commands that ran and their environment (when config.RecordCommandEnv is set)
+	CompileCommands     string            // Path to the build's compile_commands.json (when config.ExportCompileCommands is set)
+	BuilderName         string            // Name of the builder that handled the extension
+	ExtensionFile       string            // Extension file that was built (relative to GemDir)
+	Duration            time.Duration     // Wall-clock time spent building the extension
+
+	truncatedLines int // Output lines dropped to stay within config.MaxOutputLines
 }
 
 // BuildConfig contains configuration for the build process.
@@ -26,44 +46,367 @@ type BuildResult struct {
 //   - ExtensionDir: Directory containing extension source files
 //   - DestPath: Destination directory for compiled extensions
 //   - LibDir: Optional lib directory for extension installation
+//   - BuildDir: Optional directory for out-of-tree builds (see prepareBuildDir)
+//   - KeepWorkDir: Keep the extracted gem after BuildFromArchive
+//   - TempDir: Where temporary files and directories are created
+//   - InstallLayout: Nested (require path, default) or Flat library placement
+//   - VersionedOnly: Skip the unversioned copy made alongside lib/<ruby version>/
+//   - ArtifactMode: Permissions for installed native libraries, for reproducible packaging
+//
+// Gem metadata, when the caller has the gemspec:
+//   - GemName: Name of the gem
+//   - GemModule: Require path of the extension, used when create_makefile can't be parsed
 //
 // Build configuration:
 //   - BuildArgs: Additional arguments passed to the build system
+//   - ConfigureArgs: Arguments passed to ./configure (autotools builds)
+//   - RunAutoreconf: Generate a missing configure script from configure.ac
+//   - MakeDir: Subdirectory of the extension directory holding the Makefile
+//   - MkmfOptions: Options passed to extconf.rb as --name=value
+//   - EnableFeatures/DisableFeatures: --enable-<feature>/--disable-<feature> for extconf.rb
+//   - RubyIncludePaths: -I load paths for running extconf.rb
+//   - UseSystemLibraries: Link system libraries instead of building vendored ones (mini_portile)
 //   - Env: Environment variables set during build
-//   - Parallel: Number of parallel jobs for make -j (0 = default)
+//   - CleanEnv/EnvAllowlist: Build from a minimal environment instead of the parent's
+//   - Stdin: Answers for configure scripts and extconf.rb files that prompt
+//   - Parallel: Number of parallel jobs for the build tool (0 = default)
+//   - TotalJobs/ConcurrentBuilds: Job budget divided among a factory's concurrent builds that don't set Parallel
+//   - ExtensionPatterns/ExtensionSearchDirs: Extra places to look for built extensions
+//   - ExtraInstallGlobs: Runtime files installed alongside the extension
+//   - PrimaryExtensionName/OnlyPrimary: The built file that is the extension, when there are several
+//   - ExcludeDirs: Directories whose files are never taken for built extensions (spec, test, vendor, tmp)
+//   - CargoPackage: Cargo workspace member to build (cargo rustc -p)
+//   - CargoTarget: Target triple for Cargo builds (overrides CARGO_BUILD_TARGET)
+//   - RustFlags: Extra rustc flags merged into RUSTFLAGS
+//   - CargoLockPolicy: Whether Cargo may update Cargo.lock (--locked, --frozen)
+//   - ExtNameTemplate: File naming for Cargo outputs
+//   - RakeTask: Rake task to run instead of the default task
+//   - UseBundler: Install the Gemfile's gems and build with bundle exec rake
 //
 // Ruby environment:
 //   - RubyEngine: Ruby implementation (ruby, jruby, truffleruby)
 //   - RubyVersion: Ruby version string (e.g., "3.4.0")
-//   - RubyPath: Path to Ruby executable
+//   - RubyPath: Path to Ruby executable (see ResolveRubyPath to find one)
+//   - ABIVersion: lib/ subdirectory the target Ruby loads extensions from
 //
 // Build behavior:
 //   - Verbose: Enable detailed build output
+//   - LogLevel: Amount of build context to record (overrides Verbose when set)
+//   - RecordCommandEnv: Record commands and their environment for auditing
+//   - StripANSI: Remove color codes from captured output
+//   - CollectWarnings: List compiler and build system warnings separately
+//   - MaxOutputLines: Cap on the command output kept in BuildResult.Output
+//   - PrefixOutput: Prefix output lines with the builder and extension they came from
+//   - Checksum: Record SHA-256 checksums of built extensions
+//   - CaptureToolVersions: Record the versions of the build tools used
+//   - ExportCompileCommands: Generate compile_commands.json for editors and clang tooling
 //   - CleanFirst: Run clean target before building
+//   - CleanArtifactsOnly: Have Clean delete only the built extension files
+//   - ReuseConfigCache: Skip configuring again when the last configuration still applies
+//   - RequireArtifacts: Fail builds that produce no extension files
+//   - Strip: Strip debug symbols from built native libraries
+//   - Offline: Use only vendored or cached dependencies
+//   - BuildStatic: Build and install static archives instead of shared libraries
+//   - UniversalBinary: Build x86_64 + arm64 universal binaries on macOS
+//   - IgnoreInstallErrors: Continue past a failed make install or cmake --install
+//   - InstallTarget/SkipInstallTarget: The make target run for DestPath, or none
+//   - PreBuildCommands/PostBuildCommands: Commands run before and after the build
+//   - IgnorePostBuildErrors: Continue past a failed post-build command
+//   - PostProcess: Callback run on each built native library before install
+//   - FixMachOInstallName: Give macOS libraries an @rpath install name
+//   - WarningsAsErrors: Fail the build when the compiler emits warnings
+//   - CStandard/CXXStandard: Language standards to compile with (c11, c++17)
+//   - Sanitizers: Compiler sanitizers to build with (address, undefined)
+//   - RequireCompiler: Fail early unless the C compiler is gcc, clang or msvc
+//   - CompilerCache: Compiler cache to build through (ccache, sccache)
+//   - AutoMSVCEnv: Set up the Visual Studio environment on Windows
 //   - StopOnFailure: Stop after first failed extension (default behavior)
+//   - ExpectExtensions: Report a gem without extensions as an error
 type BuildConfig struct {
 	// Source paths
 	GemDir       string // Root directory of the extracted gem
 	ExtensionDir string // Directory containing the extension files
 	DestPath     string // Destination for compiled extensions
 	LibDir       string // Optional lib directory for extension installation
+	BuildDir     string // Optional working directory; extensions are copied and built here
+	KeepWorkDir  bool   // Keep the temporary directory used by BuildFromArchive
+	TempDir      string // Directory for temporary files (empty = os.TempDir())
+
+	InstallLayout InstallLayout // Placement of installed libraries (nested by default)
+	VersionedOnly bool          // Only install to lib/<ruby version>/ on Ruby >= 3.4, skipping the unversioned copy
+	ArtifactMode  os.FileMode   // Permissions set on installed native libraries, e.g. 0o755 (0 = keep the built file's mode)
+
+	// Gem metadata. GemModule is the extension's require path (e.g.
+	// "mygem/mygem_ext"); builders use it to name and place outputs when
+	// the module can't be read from create_makefile or
+	// create_rust_makefile, as with extconf.rb files that compute it.
+	GemName   string // Name of the gem (set from the gemspec by BuildFromArchive)
+	GemModule string // Extension require path, used when extconf.rb doesn't declare one
 
 	// Build arguments
-	BuildArgs []string          // Additional build arguments
-	Env       map[string]string // Environment variables for build
+	BuildArgs     []string          // Additional build arguments
+	ConfigureArgs []string          // Arguments for ./configure (BuildArgs go to make)
+	Env           map[string]string // Environment variables for build
+	Stdin         io.Reader         // Input for configure/extconf.rb and make, for scripts that prompt (nil = empty input)
+
+	// RunAutoreconf generates a missing configure script with
+	// autoreconf -i when configure.ac or configure.in is present, for gems
+	// that don't commit the generated script. It needs autoconf, automake
+	// and libtool.
+	RunAutoreconf bool
+
+	// MakeDir is the directory, relative to the extension directory, that
+	// holds the Makefile, for extconf.rb files that generate it in a
+	// subdirectory such as build/ and gems whose Makefile is one level
+	// down. ExtConf and Makefile builds run make there, and built
+	// extensions are looked for there as well as in the extension
+	// directory. A MakeDir that is absolute or leads outside the extension
+	// directory fails the build with an error matching ErrOutsideGemDir.
+	MakeDir string
+
+	// CleanEnv keeps the parent process's environment from leaking into
+	// builds (MAKEFLAGS, RUBYOPT, BUNDLE_GEMFILE, DESTDIR, ...). Commands
+	// then inherit only PATH, HOME, LANG and TMPDIR (plus SystemRoot,
+	// ComSpec, PATHEXT, TEMP, TMP and USERPROFILE on Windows, which
+	// programs need to start) and the variables named in EnvAllowlist;
+	// Env is applied on top as usual.
+	CleanEnv     bool
+	EnvAllowlist []string
+
+	// MkmfOptions are passed to extconf.rb as --name=value, or --name for
+	// an empty value (e.g. "with-opt-dir": "/opt/local"). They follow
+	// BuildArgs on the command line, sorted by name; mkmf lets later
+	// options win, so these override the same option in BuildArgs.
+	MkmfOptions map[string]string
+
+	// EnableFeatures and DisableFeatures are passed to extconf.rb as
+	// --enable-<feature> and --disable-<feature>, for gems that gate
+	// optional functionality on enable_config. They follow BuildArgs and
+	// precede MkmfOptions; a feature already toggled in BuildArgs is left
+	// to BuildArgs.
+	EnableFeatures  []string
+	DisableFeatures []string
+
+	// RubyIncludePaths are added to Ruby's load path with -I when running
+	// extconf.rb, for gems that bundle their own mkmf or require build
+	// helpers from elsewhere in the gem. Relative paths are resolved from
+	// the extension directory.
+	RubyIncludePaths []string
+
+	// UseSystemLibraries asks extconf.rb builds to link the system's
+	// libraries instead of downloading and compiling vendored copies with
+	// mini_portile, which can take minutes and needs network access. It
+	// sets NOKOGIRI_USE_SYSTEM_LIBRARIES=1 and <EXT>_USE_SYSTEM_LIBRARIES=1,
+	// where <EXT> is the extension directory name in upper case (e.g.
+	// SQLITE3_USE_SYSTEM_LIBRARIES for ext/sqlite3). Variables set in Env
+	// take precedence.
+	UseSystemLibraries bool
+
+	// ExtensionPatterns and ExtensionSearchDirs add to the files each
+	// builder finds after building, for gems that put their outputs in
+	// unusual places. Patterns are globs searched in the extension
+	// directory and each search directory; search directories (relative to
+	// the extension directory) without patterns are searched for native
+	// libraries. Not used by the Cargo builder, which locates its outputs
+	// from the crate name.
+	ExtensionPatterns   []string
+	ExtensionSearchDirs []string
+
+	// ExtraInstallGlobs match runtime files in the extension directory,
+	// such as a bundled libfoo.so.1, that are installed next to the
+	// extension and listed in BuildResult.Extensions with it.
+	ExtraInstallGlobs []string
+
+	// PrimaryExtensionName picks the real extension when a build leaves
+	// several native libraries behind, such as test fixtures or vendored
+	// libraries. It matches a found file by its path relative to the
+	// extension directory, its file name, or its file name without the
+	// extension ("mygem_ext" matches mygem_ext.so and mygem_ext.bundle).
+	// Matching files are listed first; with OnlyPrimary set the others
+	// are left out, and a build whose files don't include it fails.
+	PrimaryExtensionName string
+	OnlyPrimary          bool
+
+	// ExcludeDirs keeps files below matching directories from being taken
+	// for built extensions, such as a fixture .so under spec/. Entries are
+	// directory names or globs matched against each element of a found
+	// file's directory, or relative paths matched from the extension
+	// directory. nil excludes spec, test, vendor and tmp; an empty,
+	// non-nil slice excludes nothing. ExtensionSearchDirs are not subject
+	// to it.
+	ExcludeDirs []string
 
 	// Ruby configuration
 	RubyEngine  string // Ruby engine (ruby, jruby, truffleruby)
 	RubyVersion string // Ruby version (3.4.0, etc.)
 	RubyPath    string // Path to Ruby executable
+	ABIVersion  string // Versioned lib directory name (e.g. "3.4.0"), overriding the one derived from RubyVersion
 
 	// Build options
-	Verbose    bool // Enable verbose output
-	CleanFirst bool // Run clean before build
-	Parallel   int  // Number of parallel jobs (for make -j)
+	Verbose          bool     // Enable verbose output
+	LogLevel         LogLevel // Build context detail (zero value defers to Verbose)
+	RecordCommandEnv bool     // Record each command and the environment the builder gave it in BuildResult.Commands
+	StripANSI        bool     // Strip ANSI escape codes from output and ask tools not to emit them
+	CollectWarnings  bool     // Copy warning lines from the output into BuildResult.Warnings
+	MaxOutputLines   int      // Keep at most this many lines of command output, dropping the middle (0 = unlimited)
+	PrefixOutput     bool     // Prefix each BuildResult.Output line with "[<builder>:<extension>]"
+	Checksum         bool     // Record SHA-256 checksums of built extensions in BuildResult.Checksums
+	CleanFirst       bool     // Run clean before build
+	RequireArtifacts bool     // Fail a build that succeeds without producing any extension files
+	Strip            bool     // Run strip on built native libraries before installing them
+	Offline          bool     // Build without network access: cargo --offline, CARGO_NET_OFFLINE and bundle install --local
+	BuildStatic      bool     // Build static archives (.a, .lib) for static Ruby: cargo staticlib, go -buildmode=c-archive
+	UniversalBinary  bool     // On macOS, build x86_64 + arm64 universal binaries (-arch flags, CMAKE_OSX_ARCHITECTURES, cargo + lipo)
+
+	// CleanArtifactsOnly makes Clean delete only the extension files found
+	// in the extension's build directory, instead of running the build
+	// system's clean target. Useful when the Makefile is already gone.
+	CleanArtifactsOnly bool
+
+	// ReuseConfigCache skips the configure step of CMake, extconf.rb and
+	// ./configure builds when its output (CMakeCache.txt or Makefile) is
+	// newer than CMakeLists.txt, extconf.rb or configure and was produced
+	// with the same arguments, Ruby, compiler cache and Env. A hash of
+	// these is kept in a .rubyext-config-cache file in the build
+	// directory. CleanFirst always configures again.
+	ReuseConfigCache bool
+
+	// IgnoreInstallErrors keeps a build going when its install step (make
+	// install, cmake --install) fails after compiling. The failure is noted
+	// in the output and the compiled extensions are still collected and
+	// installed by the library.
+	IgnoreInstallErrors bool
+
+	// InstallTarget is the make target ExtConf and Makefile builds run
+	// when DestPath is set, for gems whose Makefile installs with another
+	// target such as install-exec (default "install"). SkipInstallTarget
+	// skips that step for Makefiles without an install target; the built
+	// extensions are still installed by the library.
+	InstallTarget     string
+	SkipInstallTarget bool
+
+	// PreBuildCommands run in the extension directory before the build is
+	// configured, for code generation (ragel, bison, protoc); a failure
+	// fails the build. PostBuildCommands run once the built extensions have
+	// been found, for packaging steps; a failure fails the build unless
+	// IgnorePostBuildErrors is set. Each command is a program and its
+	// arguments, run with the build environment.
+	PreBuildCommands      [][]string
+	PostBuildCommands     [][]string
+	IgnorePostBuildErrors bool
+
+	// PostProcess is called with the absolute path of each built native
+	// library after stripping and before installation, e.g. to codesign
+	// on macOS or run patchelf on Linux. An error fails the build.
+	PostProcess func(ctx context.Context, artifactPath string) error
+
+	// CaptureToolVersions records the versions of the builder's tools
+	// (compiler, cargo, cmake, ...) in BuildResult.ToolVersions, for
+	// builders implementing ToolVersioner.
+	CaptureToolVersions bool
+
+	// ExportCompileCommands generates a compile_commands.json compilation
+	// database for clangd and clang-tidy. CMake builds set
+	// CMAKE_EXPORT_COMPILE_COMMANDS; extconf.rb builds run make under bear
+	// when it is installed. The file is copied to DestPath when set (the
+	// last extension built wins) and its path is recorded in
+	// BuildResult.CompileCommands.
+	ExportCompileCommands bool
+
+	// FixMachOInstallName sets the install name of built .bundle and
+	// .dylib files to @rpath/<file name> on macOS, replacing the absolute
+	// build path the linker records.
+	FixMachOInstallName bool
+	Parallel            int // Number of parallel jobs: make -j, cmake --parallel, cargo --jobs, go build -p, mvn -T
+
+	// TotalJobs is a job budget shared by the builds a BuilderFactory runs
+	// at the same time, e.g. BuildAllExtensions called from several
+	// goroutines (each call builds its extensions one at a time). A build
+	// without its own Parallel takes TotalJobs divided by the number of
+	// builds sharing the budget (at least 1), or what is left of it if
+	// less, and waits while none is left, so concurrent builds never run
+	// more than TotalJobs jobs together. Jobs are returned when a build
+	// ends. Builds that set Parallel run outside the budget. Builds started
+	// with BuildExtension don't share a factory, so don't share the budget.
+	//
+	// Builds that start one after another can't know how many more are
+	// coming, so the first would take the whole budget and the rest would
+	// wait for it. ConcurrentBuilds declares how many builds run at once,
+	// e.g. the number of goroutines calling BuildAllExtensions, so each
+	// takes TotalJobs/ConcurrentBuilds from the start.
+	TotalJobs        int
+	ConcurrentBuilds int
+
+	// WarningsAsErrors makes compiler warnings fail the build: -Werror in
+	// CFLAGS for extconf.rb builds, -D warnings in RUSTFLAGS for Cargo, and
+	// go vet plus -Werror in CGO_CFLAGS for Go. Builders without a portable
+	// way to express this note it in the output and build normally.
+	WarningsAsErrors bool
+
+	// CStandard and CXXStandard select the C and C++ language standards,
+	// written as for -std= (e.g. "c11", "gnu99", "c++17"). extconf.rb
+	// builds add -std= to CFLAGS/CXXFLAGS; CMake builds set
+	// CMAKE_C_STANDARD/CMAKE_CXX_STANDARD.
+	CStandard   string
+	CXXStandard string
+
+	// Sanitizers enables compiler sanitizers such as "address" and
+	// "undefined". extconf.rb builds add -fsanitize= to CFLAGS, CXXFLAGS
+	// and LDFLAGS, and CMake builds to CMAKE_C_FLAGS, CMAKE_CXX_FLAGS and
+	// the shared and module linker flags, since the sanitizer runtime must
+	// be linked in too. Cargo builds add -Z sanitizer= to RUSTFLAGS for
+	// the sanitizers rustc supports, which needs a nightly toolchain.
+	Sanitizers []string
+
+	// RequireCompiler fails builds that compile C unless the effective C
+	// compiler (CC, or the first of gcc, clang, cc and cl on PATH)
+	// identifies as this one: "gcc", "clang" or "msvc". The check runs
+	// before the build and reports a *CompilerMismatchError, with the
+	// compiler listed in BuildResult.MissingDependencies.
+	RequireCompiler string
+
+	// CompilerCache names a compiler cache such as "ccache" or "sccache".
+	// It prefixes CC/CXX in the Makefile of extconf.rb builds (except with
+	// nmake), is set as the compiler
+	// launcher for CMake and as RUSTC_WRAPPER for Cargo. If it can't be
+	// found on PATH, a note is added to the output and the build proceeds
+	// without it.
+	CompilerCache string
+
+	// AutoMSVCEnv sets up the Visual Studio developer environment (as
+	// vcvarsall.bat does) for extconf.rb and Makefile builds on Windows,
+	// so cl.exe and nmake work without a Developer Command Prompt.
+	AutoMSVCEnv bool
+
+	// RakeTask is the task run by the Rake builder, such as "compile:all"
+	// or "build". Empty runs the Rakefile's default task.
+	RakeTask string
+
+	// UseBundler makes the Rake builder run bundle install and then
+	// bundle exec rake when the extension directory has a Gemfile, for
+	// Rakefiles that load the gem's development dependencies.
+	UseBundler bool
+
+	// Cargo options
+	CargoPackage string   // Workspace member to build with cargo -p (empty = manifest's own package)
+	CargoTarget  string   // Target triple for cargo --target (empty = CARGO_BUILD_TARGET, then the host)
+	RustFlags    []string // Extra rustc flags appended to RUSTFLAGS (e.g. "-C", "target-cpu=native")
+
+	// CargoLockPolicy selects --locked, --frozen or neither for Cargo
+	// builds; see the CargoLockPolicy constants. The default passes
+	// --locked only when Cargo.lock exists.
+	CargoLockPolicy CargoLockPolicy
+
+	// ExtNameTemplate names the files Cargo outputs are copied to, relative
+	// to the extension directory, e.g. "{{module}}.{{ext}}". Placeholders:
+	// {{crate}} (library name without the lib prefix), {{name}} (the
+	// default Ruby name), {{module}} (the create_rust_makefile path) and
+	// {{ext}} (so, bundle or dll). Empty keeps the default naming.
+	ExtNameTemplate string
 
 	// Failure handling
-	StopOnFailure bool // Stop after the first failed extension build
+	StopOnFailure    bool // Stop after the first failed extension build
+	ExpectExtensions bool // Fail BuildAllExtensions when given no extensions, instead of treating the gem as pure Ruby
 }
 
 // CommonBuildSteps defines the standard 3-step build pattern used by multiple builders.
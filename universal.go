@@ -0,0 +1,72 @@
+package rubyext
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// universalTargets are the Rust targets combined into a macOS universal
+// binary, and universalTargetDir the directory below target/ the combined
+// libraries are written to
+var universalTargets = []string{"x86_64-apple-darwin", "aarch64-apple-darwin"}
+
+const universalTargetDir = "universal-apple-darwin"
+
+// buildsUniversal reports whether config.UniversalBinary applies, which it
+// only does when building on macOS
+func buildsUniversal(config *BuildConfig) bool {
+	return config.UniversalBinary && runtime.GOOS == platformDarwin
+}
+
+// universalArchFlags returns the compiler and linker flags that make clang
+// build x86_64 and arm64 slices into one file, or nil when not building
+// universal binaries
+func universalArchFlags(config *BuildConfig) []string {
+	if !buildsUniversal(config) {
+		return nil
+	}
+	return []string{"-arch", "x86_64", "-arch", "arm64"}
+}
+
+// appendUniversalNote records that config.UniversalBinary is ignored when
+// not building on macOS
+func appendUniversalNote(config *BuildConfig, result *BuildResult) {
+	if config.UniversalBinary && !buildsUniversal(config) {
+		result.Output = append(result.Output,
+			fmt.Sprintf("Note: UniversalBinary only applies to macOS builds, building for %s/%s only", runtime.GOOS, runtime.GOARCH))
+	}
+}
+
+// checkLipo returns the path to lipo, which combines per-architecture
+// libraries into a universal one. A missing lipo is listed in
+// result.MissingDependencies and returned as a *MissingToolError.
+func checkLipo(result *BuildResult) (string, error) {
+	lipoPath, err := execLookPath("lipo")
+	if err != nil {
+		result.MissingDependencies = append(result.MissingDependencies, "lipo")
+		return "", &MissingToolError{
+			Tools:   []string{"lipo"},
+			message: "lipo not found: UniversalBinary needs the Xcode command line tools (xcode-select --install)",
+		}
+	}
+	return lipoPath, nil
+}
+
+// runLipo combines the per-architecture libraries in inputs into the
+// universal library output
+func runLipo(ctx context.Context, config *BuildConfig, result *BuildResult, lipoPath, output string, inputs []string) error {
+	if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+		return err
+	}
+
+	args := append([]string{"-create", "-output", output}, inputs...)
+	cmd := execCommandContext(ctx, lipoPath, args...)
+	out, err := combinedOutput(cmd)
+	appendCommandOutput(config, result, out)
+	appendCommandLog(config, result, cmd, err)
+
+	return err
+}
@@ -0,0 +1,82 @@
+package rubyext
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestUniversalBinaryIgnoredOffMacOS(t *testing.T) {
+	if runtime.GOOS == platformDarwin {
+		t.Skip("UniversalBinary applies on macOS")
+	}
+
+	config := &BuildConfig{UniversalBinary: true}
+	if flags := universalArchFlags(config); flags != nil {
+		t.Fatalf("expected no -arch flags off macOS, got %v", flags)
+	}
+	if args := (&CmakeBuilder{}).configureArgs(config); slices.Contains(args, "-DCMAKE_OSX_ARCHITECTURES=x86_64;arm64") {
+		t.Fatalf("expected no CMAKE_OSX_ARCHITECTURES off macOS, got %v", args)
+	}
+
+	result := &BuildResult{}
+	appendUniversalNote(config, result)
+	if len(result.Output) != 1 || !strings.Contains(result.Output[0], "only applies to macOS") {
+		t.Fatalf("expected a note that UniversalBinary is ignored, got %v", result.Output)
+	}
+}
+
+func TestCargoBuilderUniversalBinary(t *testing.T) {
+	if runtime.GOOS != platformDarwin {
+		t.Skip("UniversalBinary applies on macOS")
+	}
+	t.Setenv("CFLAGS", "")
+	t.Setenv("LDFLAGS", "")
+
+	config := &BuildConfig{UniversalBinary: true}
+	env := (&ExtConfBuilder{}).compilerFlagsEnv(config)
+	if !slices.Contains(env, "LDFLAGS=-arch x86_64 -arch arm64") {
+		t.Fatalf("expected -arch flags at link time, got %v", env)
+	}
+
+	extDir := t.TempDir()
+	builder := &CargoBuilder{}
+	var targets []string
+	for _, targetConfig := range builder.targetConfigs(config) {
+		targets = append(targets, targetConfig.CargoTarget)
+		sliceDir := builder.releaseDir(targetConfig, extDir)
+		if err := os.MkdirAll(sliceDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(sliceDir, "libmy_ext.dylib"), []byte("slice"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(targets, universalTargets) {
+		t.Fatalf("expected a cargo build per architecture, got %v", targets)
+	}
+
+	toolDir := t.TempDir()
+	t.Setenv("PATH", toolDir)
+	if _, err := checkLipo(&BuildResult{}); !errors.Is(err, ErrMissingTool) {
+		t.Fatalf("expected a missing lipo to be reported, got %v", err)
+	}
+
+	// The fake lipo concatenates the slices into the -output file
+	lipoPath := filepath.Join(toolDir, "lipo")
+	writeTestScript(t, lipoPath, "#!/bin/sh\nout=$3\nshift 3\ncat \"$@\" > \"$out\"\n")
+	if err := builder.combineUniversal(context.Background(), config, extDir, lipoPath, &BuildResult{}); err != nil {
+		t.Fatalf("combineUniversal returned error: %v", err)
+	}
+
+	universal, err := os.ReadFile(filepath.Join(builder.releaseDir(config, extDir), "libmy_ext.dylib"))
+	if err != nil || string(universal) != "sliceslice" {
+		t.Fatalf("expected both slices in the universal library, got %q (%v)", universal, err)
+	}
+}
@@ -0,0 +1,196 @@
+package rubyext
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// WafBuilder handles extensions built with the waf meta build tool.
+//
+// Gems using waf vendor the waf script next to a wscript describing the
+// build. The builder runs:
+//
+//	./waf configure
+//	./waf build
+//
+// and collects the native libraries waf writes below build/.
+type WafBuilder struct{}
+
+// Name returns the builder name
+func (b *WafBuilder) Name() string {
+	return "Waf"
+}
+
+// RequiredTools returns the tools needed for waf builds
+func (b *WafBuilder) RequiredTools() []ToolRequirement {
+	return []ToolRequirement{
+		{
+			Name:         "python3",
+			Alternatives: []string{"python"},
+			Purpose:      "Python interpreter to run waf",
+		},
+		{
+			Name:         "gcc",
+			Alternatives: []string{"clang", "cc", "cl"},
+			Purpose:      "C/C++ compiler",
+		},
+	}
+}
+
+// CheckTools verifies that Python and a compiler are available
+func (b *WafBuilder) CheckTools() error {
+	return CheckRequiredTools(b.RequiredTools())
+}
+
+// CanBuild checks if this builder can handle the extension file
+func (b *WafBuilder) CanBuild(extensionFile string) bool {
+	filename := filepath.Base(extensionFile)
+	return filename == "wscript" || filename == "waf"
+}
+
+// Build compiles the extension using waf configure and waf build
+func (b *WafBuilder) Build(ctx context.Context, config *BuildConfig, extensionFile string) (*BuildResult, error) {
+	return runCommonBuild(ctx, config, extensionFile, CommonBuildSteps{
+		ConfigureFunc: b.runWafConfigure,
+		BuildFunc:     b.runWafBuild,
+		FindFunc:      b.findBuiltExtensions,
+	})
+}
+
+// Clean removes build artifacts
+func (b *WafBuilder) Clean(ctx context.Context, config *BuildConfig, extensionFile string) error {
+	if config.CleanArtifactsOnly {
+		return cleanArtifacts(ctx, config, extensionFile, b.findBuiltExtensions)
+	}
+
+	extensionDir := extensionBuildDir(config, extensionFile)
+
+	cmdName, cmdArgs, err := b.wafCommand(filepath.Join(extensionDir, "waf"), []string{"clean"})
+	if err != nil {
+		return nil // Nothing to clean without the waf script
+	}
+
+	cleanCmd := execCommandContext(ctx, cmdName, cmdArgs...)
+	cleanCmd.Dir = extensionDir
+
+	// Ignore errors - the project may not be configured yet
+	_ = cleanCmd.Run()
+	return cleanResult(ctx, nil)
+}
+
+// runWafConfigure executes waf configure
+func (b *WafBuilder) runWafConfigure(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
+	args := []string{"configure"}
+
+	// Add prefix if dest path is specified
+	if config.DestPath != "" {
+		args = append(args, fmt.Sprintf("--prefix=%s", config.DestPath))
+	}
+
+	args = append(args, config.ConfigureArgs...)
+
+	return b.runWaf(ctx, config, extensionDir, result, "Waf Configure", args)
+}
+
+// runWafBuild executes waf build
+func (b *WafBuilder) runWafBuild(ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult) error {
+	args := []string{"build"}
+
+	// Add parallel jobs if specified
+	if jobs := parallelJobs(config); jobs > 0 {
+		args = append(args, fmt.Sprintf("-j%d", jobs))
+	}
+
+	args = append(args, config.BuildArgs...)
+
+	return b.runWaf(ctx, config, extensionDir, result, "Waf Build", args)
+}
+
+// runWaf runs the waf script in extensionDir with args
+func (b *WafBuilder) runWaf(
+	ctx context.Context, config *BuildConfig, extensionDir string, result *BuildResult, step string, args []string,
+) error {
+	cmdName, cmdArgs, err := b.wafCommand(filepath.Join(extensionDir, "waf"), args)
+	if err != nil {
+		return BuildError(step, result.Output, err)
+	}
+
+	cmd := execCommandContext(ctx, cmdName, cmdArgs...)
+	cmd.Dir = extensionDir
+
+	// Set environment variables
+	cmd.Env = buildCommandEnv(config)
+	cmd.Env = appendMSVCEnv(ctx, config, result, cmd.Env)
+	cmd.Stdin = commandStdin(config)
+
+	output, err := combinedOutput(cmd)
+	appendCommandOutput(config, result, output)
+
+	appendCommandLog(config, result, cmd, err)
+
+	if err != nil {
+		return BuildError(step, result.Output, err)
+	}
+
+	return nil
+}
+
+// wafCommand returns the command used to run the waf script.
+//
+// Executable scripts are run directly; scripts without the executable bit
+// (common in extracted gem archives), and any script on Windows, are run
+// through python3 instead.
+func (b *WafBuilder) wafCommand(wafPath string, args []string) (cmd string, resolvedArgs []string, err error) {
+	info, err := osStat(wafPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("waf script not found: %w", err)
+	}
+
+	if runtime.GOOS != platformWindows && info.Mode().Perm()&0o111 != 0 {
+		return wafPath, append([]string{}, args...), nil
+	}
+
+	return "python3", append([]string{wafPath}, args...), nil
+}
+
+// findBuiltExtensions locates the compiled extension files below build/
+func (b *WafBuilder) findBuiltExtensions(extensionDir string) ([]string, error) {
+	var extensions []string
+
+	buildDir := filepath.Join(extensionDir, "build")
+	if _, err := osStat(buildDir); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	err := filepath.WalkDir(buildDir, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if entry.IsDir() {
+			// waf keeps its configuration and cache in build/c4che
+			if entry.Name() == "c4che" {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".so", ".bundle", ".dll", ".dylib":
+			relPath, err := filepath.Rel(extensionDir, path)
+			if err == nil {
+				extensions = append(extensions, relPath)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search %s: %v", buildDir, err)
+	}
+
+	return extensions, nil
+}
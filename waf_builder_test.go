@@ -0,0 +1,83 @@
+package rubyext
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestWafBuilderBuild(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("shell script toolchain requires a POSIX shell")
+	}
+
+	gemDir := t.TempDir()
+	extDir := filepath.Join(gemDir, "ext", "myext")
+	if err := os.MkdirAll(extDir, 0o755); err != nil {
+		t.Fatalf("failed to create extension dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(extDir, "wscript"), []byte("def build(bld): pass\n"), 0o600); err != nil {
+		t.Fatalf("failed to write wscript: %v", err)
+	}
+	writeTestScript(t, filepath.Join(extDir, "waf"), `#!/bin/sh
+echo "$@" >> waf.log
+case "$1" in
+configure) mkdir -p build/c4che && touch build/c4che/cache.so ;;
+build) mkdir -p build/src && touch build/src/myext.so ;;
+esac
+`)
+
+	config := &BuildConfig{GemDir: gemDir, Parallel: 2, ConfigureArgs: []string{"--with-foo"}}
+	result, err := (&WafBuilder{}).Build(context.Background(), config, "ext/myext/wscript")
+	if err != nil {
+		t.Fatalf("Build returned error: %v\n%v", err, result.Output)
+	}
+
+	log, err := os.ReadFile(filepath.Join(extDir, "waf.log"))
+	if err != nil {
+		t.Fatalf("failed to read waf log: %v", err)
+	}
+	if expected := "configure --with-foo\nbuild -j2\n"; string(log) != expected {
+		t.Fatalf("expected waf invocations %q, got %q", expected, string(log))
+	}
+
+	expected := []string{"lib/myext.so"}
+	if !reflect.DeepEqual(result.Extensions, expected) {
+		t.Fatalf("expected extensions %v, got %v", expected, result.Extensions)
+	}
+}
+
+func TestWafCommandRespectsExecutableBit(t *testing.T) {
+	if runtime.GOOS == platformWindows {
+		t.Skip("waf always runs through python on Windows")
+	}
+
+	wafPath := filepath.Join(t.TempDir(), "waf")
+	if err := os.WriteFile(wafPath, []byte("#!/usr/bin/env python3\n"), 0o600); err != nil {
+		t.Fatalf("failed to write waf: %v", err)
+	}
+
+	builder := &WafBuilder{}
+	cmd, args, err := builder.wafCommand(wafPath, []string{"build"})
+	if err != nil {
+		t.Fatalf("wafCommand returned error: %v", err)
+	}
+	if cmd != "python3" || !reflect.DeepEqual(args, []string{wafPath, "build"}) {
+		t.Fatalf("expected non-executable waf to run via python3, got %s %v", cmd, args)
+	}
+
+	if err := os.Chmod(wafPath, 0o755); err != nil {
+		t.Fatalf("failed to chmod waf: %v", err)
+	}
+
+	cmd, args, err = builder.wafCommand(wafPath, []string{"build"})
+	if err != nil {
+		t.Fatalf("wafCommand returned error: %v", err)
+	}
+	if cmd != wafPath || !reflect.DeepEqual(args, []string{"build"}) {
+		t.Fatalf("expected executable waf to run directly, got %s %v", cmd, args)
+	}
+}
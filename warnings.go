@@ -0,0 +1,24 @@
+package rubyext
+
+import (
+	"regexp"
+	"strings"
+)
+
+// warningPattern matches diagnostic lines reported as warnings:
+//   - GCC/Clang: "myext.c:3:7: warning: unused variable 'x'"
+//   - Cargo/rustc: "warning: unused import: `std::io`"
+//   - CMake: "CMake Warning at CMakeLists.txt:12 (message):"
+//   - CMake deprecations: "CMake Deprecation Warning at ..."
+var warningPattern = regexp.MustCompile(`(^|[\s:])warning:\s|^CMake (Deprecation |Developer )?Warning\b`)
+
+// collectWarnings adds the warning lines in lines to result.Warnings.
+// Color codes are removed first, since compilers color the "warning:" tag.
+func collectWarnings(result *BuildResult, lines []string) {
+	for _, line := range lines {
+		line = strings.TrimSpace(stripANSI(line))
+		if warningPattern.MatchString(line) {
+			result.Warnings = append(result.Warnings, line)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package rubyext
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAppendCommandOutputCollectsWarnings(t *testing.T) {
+	output := []byte(`compiling myext.c
+myext.c:3:7: warning: unused variable 'x' [-Wunused-variable]
+` + "\x1b[1mmyext.c:9:1: \x1b[35mwarning:\x1b[0m control reaches end of non-void function" + `
+cc1: all warnings being treated as errors
+warning: unused import: ` + "`std::io`" + `
+   Compiling myext v0.1.0
+CMake Warning at CMakeLists.txt:12 (message):
+CMake Deprecation Warning at CMakeLists.txt:1 (cmake_minimum_required):
+linking shared-object myext.so
+`)
+
+	result := &BuildResult{}
+	appendCommandOutput(&BuildConfig{}, result, output)
+	if result.Warnings != nil {
+		t.Fatalf("expected no warnings collected by default, got %v", result.Warnings)
+	}
+
+	appendCommandOutput(&BuildConfig{CollectWarnings: true}, result, output)
+
+	expected := []string{
+		"myext.c:3:7: warning: unused variable 'x' [-Wunused-variable]",
+		"myext.c:9:1: warning: control reaches end of non-void function",
+		"warning: unused import: `std::io`",
+		"CMake Warning at CMakeLists.txt:12 (message):",
+		"CMake Deprecation Warning at CMakeLists.txt:1 (cmake_minimum_required):",
+	}
+	if !reflect.DeepEqual(result.Warnings, expected) {
+		t.Fatalf("expected warnings %q, got %q", expected, result.Warnings)
+	}
+}